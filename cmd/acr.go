@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+const (
+	// acrScope requests an Entra ID token accepted by the ACR exchange endpoint
+	acrScope = "https://containerregistry.azure.net/.default"
+	// acrRefreshTokenUsername is the fixed user name for ACR refresh token logins
+	acrRefreshTokenUsername = "00000000-0000-0000-0000-000000000000"
+	// acrRefreshTokenTTL is how long an exchanged refresh token is reused; ACR
+	// issues them for about three hours
+	acrRefreshTokenTTL = time.Hour
+)
+
+// acrTokens caches ACR refresh tokens per registry
+var acrTokens = &acrTokenCache{tokens: map[string]acrToken{}}
+
+type acrToken struct {
+	refreshToken string
+	expires      time.Time
+}
+
+type acrTokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]acrToken
+}
+
+var (
+	azureCredentialOnce sync.Once
+	azureCred           azcore.TokenCredential
+	azureCredErr        error
+)
+
+// azureToken returns an Entra ID token for scope from the workload identity,
+// managed identity or environment credentials of the process
+func azureToken(ctx context.Context, scope string) (azcore.AccessToken, error) {
+	azureCredentialOnce.Do(func() {
+		azureCred, azureCredErr = azidentity.NewDefaultAzureCredential(nil)
+	})
+	if azureCredErr != nil {
+		return azcore.AccessToken{}, fmt.Errorf("finding Azure credentials: %w", azureCredErr)
+	}
+	return azureCred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{scope}})
+}
+
+// isACRHost reports whether host is an Azure Container Registry
+func isACRHost(host string) bool {
+	return strings.HasSuffix(host, ".azurecr.io") ||
+		strings.HasSuffix(host, ".azurecr.cn") ||
+		strings.HasSuffix(host, ".azurecr.us")
+}
+
+// credentials exchanges an Entra ID token of the workload or managed
+// identity for an ACR refresh token, used as the password of the registry's
+// token flow
+func (c *acrTokenCache) credentials(ctx context.Context, registry string) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tokens[registry]; ok && time.Now().Before(t.expires) {
+		return acrRefreshTokenUsername, t.refreshToken, nil
+	}
+
+	aad, err := azureToken(ctx, acrScope)
+	if err != nil {
+		return "", "", fmt.Errorf("getting Entra ID token for %s: %w", registry, err)
+	}
+
+	refreshToken, err := exchangeACRToken(ctx, registry, aad.Token)
+	if err != nil {
+		return "", "", err
+	}
+	expires := time.Now().Add(acrRefreshTokenTTL)
+	if aad.ExpiresOn.Before(expires) {
+		expires = aad.ExpiresOn
+	}
+	c.tokens[registry] = acrToken{refreshToken: refreshToken, expires: expires}
+	return acrRefreshTokenUsername, refreshToken, nil
+}
+
+// exchangeACRToken trades an Entra ID access token for an ACR refresh token
+func exchangeACRToken(ctx context.Context, registry, accessToken string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"access_token": {accessToken},
+	}
+	endpoint := "https://" + registry + "/oauth2/exchange"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := repoDo("oci://"+registry, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ACR token exchange with %s failed: %s", registry, resp.Status)
+	}
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding ACR token exchange response: %w", err)
+	}
+	if body.RefreshToken == "" {
+		return "", fmt.Errorf("ACR token exchange with %s returned no refresh token", registry)
+	}
+	return body.RefreshToken, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// alertmanagerAlertName is the alertname of the alerts posted to Alertmanager
+const alertmanagerAlertName = "HelmChartOutdated"
+
+// alertmanagerAlert is an alert of the Alertmanager API v2
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// push posts the current results matching the policy as firing alerts and
+// those that stopped matching as resolved. Firing alerts are posted every
+// time and end a few intervals ahead, so Alertmanager resolves them by itself
+// when the exporter stops.
+func (a alertmanagerConfig) push(previous, current []chartResult) error {
+	now := time.Now().UTC()
+	endsAt := now.Add(3 * currentConfig().Interval)
+	var alerts []alertmanagerAlert
+	for _, r := range current {
+		if a.Policy.matches(r) {
+			alerts = append(alerts, a.alert(r, endsAt))
+		}
+	}
+	_, resolve := incidentChanges(a.Policy, previous, current)
+	for _, r := range resolve {
+		alerts = append(alerts, a.alert(r, now))
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	hook := webhookConfig{URL: strings.TrimSuffix(a.URL, "/") + "/api/v2/alerts"}
+	if a.BasicAuth.Username != "" {
+		username, password, err := a.BasicAuth.credentials()
+		if err != nil {
+			return err
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		hook.Headers = map[string]string{"Authorization": "Basic " + credentials}
+	}
+	return postWebhook(hook, body)
+}
+
+// alert identifies a chart by its labels; versions are annotations so a new
+// latest version updates the alert instead of firing another one
+func (a alertmanagerConfig) alert(r chartResult, endsAt time.Time) alertmanagerAlert {
+	labels := map[string]string{
+		"alertname":   alertmanagerAlertName,
+		"application": r.Application,
+		"chart":       r.Chart,
+		"repo_url":    r.RepoURL,
+	}
+	if r.Cluster != "" {
+		labels["cluster"] = r.Cluster
+	}
+	if r.Instance != "" {
+		labels["argocd_instance"] = r.Instance
+	}
+	if r.DestinationNamespace != "" {
+		labels["destination_namespace"] = r.DestinationNamespace
+	}
+	if r.Source != "" {
+		labels["source"] = r.Source
+	}
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	return alertmanagerAlert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":         incidentSummary(r),
+			"current_version": r.CurrentVersion,
+			"latest_version":  r.LatestVersion,
+		},
+		EndsAt: endsAt,
+	}
+}
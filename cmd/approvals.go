@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// approvalsKey is the key of the approvals ConfigMap listing approved
+// versions
+const approvalsKey = "approvals.yaml"
+
+var (
+	approvedVersionGauge = metrics.NewExpiringGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_chart_version_approved",
+			Help: "Whether the deployed version of a chart subject to approval is approved (1 = approved, 0 = not approved)",
+		},
+		[]string{"application", "chart", "repo_url", "current_version", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source"},
+		15*time.Minute,
+	)
+	publishedVersionGauge = metrics.NewExpiringGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_chart_published_version_status",
+			Help: "Whether a chart subject to approval runs the latest published version, approved or not (1 = up to date, 0 = outdated)",
+		},
+		[]string{"application", "chart", "repo_url", "current_version", "latest_version", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source"},
+		15*time.Minute,
+	)
+)
+
+func init() {
+	prometheus.MustRegister(approvedVersionGauge)
+	prometheus.MustRegister(publishedVersionGauge)
+}
+
+// chartApproval lists the approved versions of a chart, of any repository
+// unless RepoURL is set
+type chartApproval struct {
+	Chart    string   `json:"chart"`
+	RepoURL  string   `json:"repoURL,omitempty"`
+	Versions []string `json:"versions"`
+}
+
+// approvalStatus compares a chart subject to approval, whose latest version
+// is the latest approved one, with the latest published version
+type approvalStatus struct {
+	// Approved reports whether the deployed version is approved
+	Approved          bool   `json:"approved"`
+	LatestPublished   string `json:"latestPublished"`
+	PublishedUpToDate bool   `json:"publishedUpToDate"`
+}
+
+// approvalStore holds the approvals read at the start of the latest cycle
+type approvalStore struct {
+	mu        sync.RWMutex
+	approvals []chartApproval
+}
+
+var chartApprovals = &approvalStore{}
+
+// refresh reads the approvals ConfigMap of the first cluster, keeping the
+// approvals read before when it cannot be read
+func (s *approvalStore) refresh(ctx context.Context, cluster clusterClient, cfg approvalsConfig) {
+	var approvals []chartApproval
+	if cfg.ConfigMap != "" {
+		var err error
+		approvals, err = readApprovals(ctx, cluster, cfg)
+		if err != nil {
+			slog.Error("Error reading approved versions, keeping the previous ones", "configmap", cfg.ConfigMap, "error", err)
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.approvals = approvals
+}
+
+// versions returns the approved versions of a chart and whether it is
+// subject to approval, the versions of its repository taking precedence
+func (s *approvalStore) versions(repoURL, chartName string) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var versions []string
+	found := false
+	for _, a := range s.approvals {
+		if a.Chart != chartName {
+			continue
+		}
+		if a.RepoURL != "" && strings.TrimSuffix(a.RepoURL, "/") == strings.TrimSuffix(repoURL, "/") {
+			return a.Versions, true
+		}
+		if a.RepoURL == "" {
+			versions, found = a.Versions, true
+		}
+	}
+	return versions, found
+}
+
+// readApprovals reads the approved versions under approvals.yaml of the
+// approvals ConfigMap
+func readApprovals(ctx context.Context, cluster clusterClient, cfg approvalsConfig) ([]chartApproval, error) {
+	namespace := cfg.Namespace
+	if namespace == "" {
+		var err error
+		if namespace, err = podNamespace(); err != nil {
+			return nil, err
+		}
+	}
+	obj, err := cluster.client.Resource(configMapsGVR).Namespace(namespace).Get(ctx, cfg.ConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := unstructured.NestedString(obj.Object, "data", approvalsKey)
+	if err != nil {
+		return nil, err
+	}
+	var approvals []chartApproval
+	if err := yaml.UnmarshalStrict([]byte(content), &approvals); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", approvalsKey, err)
+	}
+	for i, a := range approvals {
+		if a.Chart == "" {
+			return nil, fmt.Errorf("%s[%d]: chart is required", approvalsKey, i)
+		}
+	}
+	return approvals, nil
+}
+
+type approvedVersionsKey struct{}
+
+// withApprovedVersions makes the latest version found with ctx the latest of
+// versions, even when an unapproved version is deployed
+func withApprovedVersions(ctx context.Context, versions []string) context.Context {
+	return context.WithValue(ctx, approvedVersionsKey{}, versions)
+}
+
+// contextApprovedVersions returns the versions set with withApprovedVersions
+// and whether they are
+func contextApprovedVersions(ctx context.Context) ([]string, bool) {
+	versions, ok := ctx.Value(approvedVersionsKey{}).([]string)
+	return versions, ok
+}
+
+// approvedEntries keeps the approved versions of a chart when approved
+// versions are set with ctx
+func approvedEntries(ctx context.Context, chartName string, versions []repository.Entry) ([]repository.Entry, error) {
+	approved, ok := contextApprovedVersions(ctx)
+	if !ok {
+		return versions, nil
+	}
+	versions = slices.DeleteFunc(slices.Clone(versions), func(v repository.Entry) bool { return !isApproved(approved, v.Version) })
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("chart %s has no approved versions", chartName)
+	}
+	return versions, nil
+}
+
+// isApproved reports whether version is one of approved
+func isApproved(approved []string, version string) bool {
+	return slices.ContainsFunc(approved, func(v string) bool { return check.Same(v, version) })
+}
+
+// recordApproval sets the gauges of a chart subject to approval
+func recordApproval(r chartResult) {
+	a := r.Approval
+	if a == nil {
+		return
+	}
+	approvedVersionGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.CurrentVersion, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source).Set(boolValue(a.Approved))
+	publishedVersionGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.CurrentVersion, a.LatestPublished, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source).Set(boolValue(a.PublishedUpToDate))
+}
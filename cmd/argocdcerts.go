@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConfigMaps in which Argo CD keeps the certificates of repository servers,
+// by server name, and the SSH known hosts of git servers
+const (
+	argoCDTLSCertsConfigMap   = "argocd-tls-certs-cm"
+	argoCDKnownHostsConfigMap = "argocd-ssh-known-hosts-cm"
+)
+
+// argoCDTrust holds the repository certificates and SSH known hosts Argo CD
+// trusts in a namespace
+type argoCDTrust struct {
+	certs      map[string]string
+	knownHosts string
+	err        error
+	fetched    time.Time
+}
+
+// argoCDTrustStores caches the trusted certificates and known hosts per
+// cluster and namespace for argoCDSecretsTTL, like repository secrets
+var argoCDTrustStores = &argoCDTrustCache{entries: map[string]argoCDTrust{}}
+
+type argoCDTrustCache struct {
+	mu      sync.Mutex
+	entries map[string]argoCDTrust
+	// fetches reads the ConfigMaps of a namespace once for concurrent
+	// requests
+	fetches singleflight.Group
+}
+
+// argoCDTrustFor returns what Argo CD trusts in the secrets namespace of ctx,
+// nothing outside a cluster or without a secrets namespace
+func argoCDTrustFor(ctx context.Context) argoCDTrust {
+	cluster := contextCluster(ctx)
+	namespace := contextConfig(ctx).GitSources.SecretsNamespace
+	if cluster.client == nil || namespace == "" {
+		return argoCDTrust{}
+	}
+	trust := argoCDTrustStores.get(ctx, cluster, namespace)
+	if trust.err != nil {
+		slog.Debug("Skipping Argo CD certificates and known hosts", "namespace", namespace, "error", trust.err)
+	}
+	return trust
+}
+
+// get reads both ConfigMaps of namespace, again once argoCDSecretsTTL has
+// passed. A missing ConfigMap trusts nothing more; failures are cached so a
+// missing permission is not retried for every request.
+func (c *argoCDTrustCache) get(ctx context.Context, cluster clusterClient, namespace string) argoCDTrust {
+	key := cluster.name + "/" + namespace
+	c.mu.Lock()
+	trust, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(trust.fetched) < argoCDSecretsTTL {
+		return trust
+	}
+	fetched, _, _ := c.fetches.Do(key, func() (interface{}, error) {
+		trust := readArgoCDTrust(ctx, cluster, namespace)
+		c.mu.Lock()
+		c.entries[key] = trust
+		c.mu.Unlock()
+		return trust, nil
+	})
+	return fetched.(argoCDTrust)
+}
+
+// readArgoCDTrust reads the certificates and known hosts ConfigMaps of
+// namespace
+func readArgoCDTrust(ctx context.Context, cluster clusterClient, namespace string) argoCDTrust {
+	trust := argoCDTrust{certs: map[string]string{}, fetched: time.Now()}
+	for _, name := range []string{argoCDTLSCertsConfigMap, argoCDKnownHostsConfigMap} {
+		obj, err := cluster.client.Resource(configMapsGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			trust.err = fmt.Errorf("reading ConfigMap %s: %w", name, err)
+			break
+		}
+		data, _ := obj.Object["data"].(map[string]interface{})
+		for k, v := range data {
+			value, _ := v.(string)
+			if name == argoCDTLSCertsConfigMap {
+				trust.certs[k] = value
+			} else if k == "ssh_known_hosts" {
+				trust.knownHosts = value
+			}
+		}
+	}
+	return trust
+}
+
+// argoCDTLSCerts returns the PEM certificates Argo CD trusts for a server,
+// by host and port or by host name, or an empty string
+func argoCDTLSCerts(ctx context.Context, hostport string) string {
+	trust := argoCDTrustFor(ctx)
+	if certs, ok := trust.certs[hostport]; ok {
+		return certs
+	}
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return trust.certs[host]
+	}
+	return ""
+}
+
+// knownHostsFiles holds the Argo CD known hosts written to disk, by digest,
+// as SSH host key callbacks are built from files
+var knownHostsFiles = struct {
+	sync.Mutex
+	paths map[string]string
+}{paths: map[string]string{}}
+
+// argoCDKnownHostsFile returns a file with the SSH known hosts Argo CD
+// trusts, or an empty path when it has none
+func argoCDKnownHostsFile(ctx context.Context) (string, error) {
+	hosts := argoCDTrustFor(ctx).knownHosts
+	if hosts == "" {
+		return "", nil
+	}
+	sum := sha256.Sum256([]byte(hosts))
+	digest := hex.EncodeToString(sum[:8])
+	knownHostsFiles.Lock()
+	defer knownHostsFiles.Unlock()
+	if path, ok := knownHostsFiles.paths[digest]; ok {
+		return path, nil
+	}
+	path := filepath.Join(os.TempDir(), "helm-version-check-known-hosts-"+digest)
+	if err := os.WriteFile(path, []byte(hosts), 0o600); err != nil {
+		return "", fmt.Errorf("writing Argo CD known hosts: %w", err)
+	}
+	knownHostsFiles.paths[digest] = path
+	return path, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var secretsGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// argoCDRepoSelector selects the secrets of Argo CD repositories and of
+// credential templates shared by repositories under a URL prefix
+const argoCDRepoSelector = "argocd.argoproj.io/secret-type in (repository,repo-creds)"
+
+// argoCDRepo holds the credentials of an Argo CD repository or repo-creds
+// secret
+type argoCDRepo struct {
+	url           string
+	username      string
+	password      string
+	sshPrivateKey string
+	insecure      bool
+}
+
+// argoCDSecretsTTL is how long the repository secrets of a namespace are
+// reused, as credentials are looked up for every repository request
+const argoCDSecretsTTL = time.Minute
+
+// argoCDSecrets caches the repository secrets per cluster and namespace
+var argoCDSecrets = &argoCDSecretCache{entries: map[string]argoCDSecretList{}}
+
+type argoCDSecretList struct {
+	repos   []argoCDRepo
+	creds   []argoCDRepo
+	err     error
+	fetched time.Time
+}
+
+type argoCDSecretCache struct {
+	mu      sync.Mutex
+	entries map[string]argoCDSecretList
+	// fetches lists the secrets of a namespace once for concurrent requests
+	fetches singleflight.Group
+}
+
+// argoCDRepoCredentials returns the credentials Argo CD uses for a git, Helm
+// or OCI repository: the repository secret of its URL, or else the
+// repo-creds secret with the longest URL prefix of it, which covers every
+// repository of a host or registry. It returns nil outside a cluster or
+// without a secrets namespace.
+func argoCDRepoCredentials(ctx context.Context, repoURL string) (*argoCDRepo, error) {
+	cluster := contextCluster(ctx)
+	namespace := contextConfig(ctx).GitSources.SecretsNamespace
+	if cluster.client == nil || namespace == "" {
+		return nil, nil
+	}
+	list := argoCDSecrets.get(ctx, cluster, namespace)
+	if list.err != nil {
+		return nil, list.err
+	}
+	want := normalizeRepoURL(repoURL)
+	// Requests to a Helm repository are for its index and charts below it
+	if repo := longestPrefix(list.repos, func(prefix string) bool {
+		return want == prefix || strings.HasPrefix(want, prefix+"/")
+	}); repo != nil {
+		return repo, nil
+	}
+	return longestPrefix(list.creds, func(prefix string) bool {
+		return strings.HasPrefix(want, prefix)
+	}), nil
+}
+
+// longestPrefix returns the secret with the longest URL that matches
+func longestPrefix(secrets []argoCDRepo, matches func(prefix string) bool) *argoCDRepo {
+	var found *argoCDRepo
+	for i, repo := range secrets {
+		prefix := normalizeRepoURL(repo.url)
+		if matches(prefix) && (found == nil || len(prefix) > len(normalizeRepoURL(found.url))) {
+			found = &secrets[i]
+		}
+	}
+	return found
+}
+
+// get returns the repository and repo-creds secrets of namespace, listing
+// them again once argoCDSecretsTTL has passed. Failures are cached as well,
+// so a missing permission is not retried for every request.
+func (c *argoCDSecretCache) get(ctx context.Context, cluster clusterClient, namespace string) argoCDSecretList {
+	key := cluster.name + "/" + namespace
+	c.mu.Lock()
+	list, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Since(list.fetched) < argoCDSecretsTTL {
+		return list
+	}
+	fetched, _, _ := c.fetches.Do(key, func() (interface{}, error) {
+		list := listArgoCDSecrets(ctx, cluster, namespace)
+		c.mu.Lock()
+		c.entries[key] = list
+		c.mu.Unlock()
+		return list, nil
+	})
+	return fetched.(argoCDSecretList)
+}
+
+// listArgoCDSecrets lists the repository and repo-creds secrets of namespace
+func listArgoCDSecrets(ctx context.Context, cluster clusterClient, namespace string) argoCDSecretList {
+	list := argoCDSecretList{fetched: time.Now()}
+	secrets, err := cluster.client.Resource(secretsGVR).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: argoCDRepoSelector})
+	if err != nil {
+		list.err = fmt.Errorf("listing Argo CD repository secrets: %w", err)
+		return list
+	}
+	for _, secret := range secrets.Items {
+		data, _ := secret.Object["data"].(map[string]interface{})
+		repo := argoCDRepo{
+			url:           secretValue(data, "url"),
+			username:      secretValue(data, "username"),
+			password:      secretValue(data, "password"),
+			sshPrivateKey: secretValue(data, "sshPrivateKey"),
+			insecure:      secretValue(data, "insecure") == "true",
+		}
+		if repo.url == "" {
+			continue
+		}
+		if secret.GetLabels()["argocd.argoproj.io/secret-type"] == "repository" {
+			list.repos = append(list.repos, repo)
+		} else {
+			list.creds = append(list.creds, repo)
+		}
+	}
+	return list
+}
+
+// secretValue decodes a key of the data of a secret
+func secretValue(data map[string]interface{}, key string) string {
+	encoded, _ := data[key].(string)
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	return string(decoded)
+}
+
+// normalizeRepoURL makes URLs Argo CD treats as the same repository equal.
+// Argo CD stores OCI repositories without the oci:// scheme.
+func normalizeRepoURL(repoURL string) string {
+	repoURL = strings.TrimPrefix(strings.ToLower(repoURL), "oci://")
+	return strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git")
+}
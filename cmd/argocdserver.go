@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/caseyrobb/helm-version-check/pkg/argocd"
+	"github.com/caseyrobb/helm-version-check/pkg/source"
+)
+
+// argoCDServerTLS is the TLS setting of an Argo CD API server client
+type argoCDServerTLS struct {
+	caFile   string
+	insecure bool
+}
+
+// argoCDServerClients caches one HTTP client per TLS setting
+var argoCDServerClients = struct {
+	mu      sync.Mutex
+	clients map[argoCDServerTLS]*http.Client
+}{clients: map[argoCDServerTLS]*http.Client{}}
+
+// argoCDServerClient returns the client for the API server of cfg, trusting
+// its CA file in addition to the system roots
+func argoCDServerClient(cfg argoCDServerConfig) (*http.Client, error) {
+	key := argoCDServerTLS{caFile: cfg.CAFile, insecure: cfg.InsecureSkipVerify}
+	argoCDServerClients.mu.Lock()
+	defer argoCDServerClients.mu.Unlock()
+	if client, ok := argoCDServerClients.clients[key]; ok {
+		return client, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		data, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading Argo CD server CA: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{Transport: transport, Timeout: time.Minute}
+	argoCDServerClients.clients[key] = client
+	return client, nil
+}
+
+// applicationProvider lists the Applications of a namespace through the Argo
+// CD API server when one is configured, or else the Kubernetes API of cluster
+func applicationProvider(cluster clusterClient, scope checkScope, namespace string, cfg *config) (source.Provider, error) {
+	server := cfg.ArgoCDServer
+	if server.URL == "" {
+		return &argocd.Provider{
+			Client:    cluster.client,
+			Namespace: namespace,
+			Selector:  scope.cfg.AppSelector,
+			PageSize:  cfg.ListPageSize,
+			Filter:    scope.cfg.StatusFilter.matches,
+			Skipped:   skipCheck,
+		}, nil
+	}
+	client, err := argoCDServerClient(server)
+	if err != nil {
+		return nil, err
+	}
+	return &argocd.ServerProvider{
+		URL:       server.URL,
+		Token:     server.token,
+		Client:    client,
+		Namespace: namespace,
+		Selector:  scope.cfg.AppSelector,
+		Filter:    scope.cfg.StatusFilter.matches,
+		Skipped:   skipCheck,
+	}, nil
+}
+
+// getApplication reads an Application of a namespace through the Argo CD API
+// server when one is configured, or else the Kubernetes API of cluster
+func getApplication(ctx context.Context, cluster clusterClient, namespace, name string, cfg *config) (*unstructured.Unstructured, error) {
+	if cfg.ArgoCDServer.URL == "" {
+		return cluster.client.Resource(argocd.ApplicationsGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	client, err := argoCDServerClient(cfg.ArgoCDServer)
+	if err != nil {
+		return nil, err
+	}
+	provider := &argocd.ServerProvider{URL: cfg.ArgoCDServer.URL, Token: cfg.ArgoCDServer.token, Client: client, Namespace: namespace}
+	return provider.Get(ctx, name)
+}
+
+// validate checks the server URL and that a token is set
+func (c argoCDServerConfig) validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL, got %q", c.URL)
+	}
+	if c.Token == "" && c.TokenFile == "" {
+		return errors.New("token or tokenFile is required with url")
+	}
+	return nil
+}
+
+// token returns the Argo CD account token, reading the token file if set
+func (c argoCDServerConfig) token() (string, error) {
+	if c.TokenFile == "" {
+		return c.Token, nil
+	}
+	data, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
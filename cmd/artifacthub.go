@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const artifactHubBaseURL = "https://artifacthub.io"
+
+// artifactHubPackage is the chart metadata published on ArtifactHub
+type artifactHubPackage struct {
+	URL                string         `json:"url"`
+	Repository         string         `json:"repository"`
+	VerifiedPublisher  bool           `json:"verifiedPublisher"`
+	Official           bool           `json:"official"`
+	Signed             bool           `json:"signed"`
+	SecurityReport     map[string]int `json:"securityReport,omitempty"`
+	SecurityReportDate *time.Time     `json:"securityReportDate,omitempty"`
+}
+
+// artifactHubClient looks up charts on ArtifactHub, rate limiting requests
+// and caching results (including misses) for ttl
+type artifactHubClient struct {
+	httpClient *http.Client
+	limiter    *rate.Limiter
+	ttl        time.Duration
+	apiKeyID   string
+	apiSecret  string
+	mu         sync.Mutex
+	cache      map[string]artifactHubCacheEntry
+}
+
+type artifactHubCacheEntry struct {
+	pkg     *artifactHubPackage
+	expires time.Time
+}
+
+// newArtifactHubClient returns a client when the integration is enabled
+func newArtifactHubClient(cfg artifactHubConfig) *artifactHubClient {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &artifactHubClient{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		// ArtifactHub allows a few requests per second for anonymous clients
+		limiter:   rate.NewLimiter(rate.Every(time.Second), 1),
+		ttl:       cfg.CacheTTL,
+		apiKeyID:  cfg.APIKeyID,
+		apiSecret: cfg.APIKeySecret,
+		cache:     make(map[string]artifactHubCacheEntry),
+	}
+}
+
+// lookup returns ArtifactHub metadata for the chart published from repoURL,
+// or nil when the chart is not listed
+func (c *artifactHubClient) lookup(ctx context.Context, repoURL, chartName string) (*artifactHubPackage, error) {
+	key := repoURL + "|" + chartName
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.pkg, nil
+	}
+
+	pkg, err := c.fetch(ctx, repoURL, chartName)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.cache[key] = artifactHubCacheEntry{pkg: pkg, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return pkg, nil
+}
+
+func (c *artifactHubClient) fetch(ctx context.Context, repoURL, chartName string) (*artifactHubPackage, error) {
+	var search struct {
+		Packages []struct {
+			Name       string `json:"name"`
+			Repository struct {
+				Name string `json:"name"`
+				URL  string `json:"url"`
+			} `json:"repository"`
+		} `json:"packages"`
+	}
+	query := url.Values{"ts_query_web": {chartName}, "kind": {"0"}, "limit": {"60"}}
+	if err := c.get(ctx, "/api/v1/packages/search?"+query.Encode(), &search); err != nil {
+		return nil, err
+	}
+
+	repoName := ""
+	for _, p := range search.Packages {
+		if p.Name == chartName && sameRepoURL(p.Repository.URL, repoURL) {
+			repoName = p.Repository.Name
+			break
+		}
+	}
+	if repoName == "" {
+		slog.Debug("Chart not found on ArtifactHub", "chart", chartName, "repo_url", repoURL)
+		return nil, nil
+	}
+
+	var details struct {
+		Signed                bool           `json:"signed"`
+		Official              bool           `json:"official"`
+		SecurityReportSummary map[string]int `json:"security_report_summary"`
+		SecurityReportCreated int64          `json:"security_report_created_at"`
+		Repository            struct {
+			VerifiedPublisher bool `json:"verified_publisher"`
+			Official          bool `json:"official"`
+		} `json:"repository"`
+	}
+	path := fmt.Sprintf("/api/v1/packages/helm/%s/%s", url.PathEscape(repoName), url.PathEscape(chartName))
+	if err := c.get(ctx, path, &details); err != nil {
+		return nil, err
+	}
+
+	pkg := &artifactHubPackage{
+		URL:               fmt.Sprintf("%s/packages/helm/%s/%s", artifactHubBaseURL, repoName, chartName),
+		Repository:        repoName,
+		VerifiedPublisher: details.Repository.VerifiedPublisher,
+		Official:          details.Official || details.Repository.Official,
+		Signed:            details.Signed,
+		SecurityReport:    details.SecurityReportSummary,
+	}
+	if details.SecurityReportCreated > 0 {
+		created := time.Unix(details.SecurityReportCreated, 0).UTC()
+		pkg.SecurityReportDate = &created
+	}
+	slog.Debug("Found chart on ArtifactHub", "chart", chartName, "url", pkg.URL)
+	return pkg, nil
+}
+
+// get performs a rate-limited ArtifactHub API request and decodes the JSON response
+func (c *artifactHubClient) get(ctx context.Context, path string, out interface{}) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, artifactHubBaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKeyID != "" {
+		req.Header.Set("X-API-KEY-ID", c.apiKeyID)
+		req.Header.Set("X-API-KEY-SECRET", c.apiSecret)
+	}
+	resp, err := tracedDo(c.httpClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ArtifactHub request %s failed: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// sameRepoURL compares repository URLs ignoring case and trailing slashes
+func sameRepoURL(a, b string) bool {
+	return strings.EqualFold(strings.TrimRight(a, "/"), strings.TrimRight(b, "/"))
+}
+
+// formatSecurityReport renders a security report summary like "critical=1 high=3"
+func formatSecurityReport(summary map[string]int) string {
+	if summary == nil {
+		return "not available"
+	}
+	var parts []string
+	for _, severity := range []string{"critical", "high", "medium", "low", "unknown"} {
+		if n := summary[severity]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", severity, n))
+		}
+	}
+	if len(parts) == 0 {
+		return "no known vulnerabilities"
+	}
+	return strings.Join(parts, " ")
+}
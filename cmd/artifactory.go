@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// artifactoryVersions lists the versions of chartName in an Artifactory Helm
+// repository with an AQL query on the chart.* properties Artifactory records
+// for each chart, instead of generating and downloading the index.yaml. The
+// repository URL must have the https://host/artifactory/api/helm/<repo>/ form.
+func artifactoryVersions(ctx context.Context, repoURL, chartName string) ([]repository.Entry, error) {
+	base, repoKey, ok := strings.Cut(repoURL, "/api/helm/")
+	repoKey = strings.Trim(repoKey, "/")
+	if !ok || repoKey == "" || strings.Contains(repoKey, "/") {
+		return nil, fmt.Errorf("%w: %s is not an Artifactory Helm repository URL", errAPIUnavailable, repoURL)
+	}
+	query := fmt.Sprintf(`items.find({"repo":%q,"@chart.name":%q}).include("name","path","property")`, repoKey, chartName)
+	endpoint := base + "/api/search/aql"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if err := setRepoCredentials(req, repoURL); err != nil {
+		return nil, err
+	}
+	resp, err := repoDo(repoURL, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden, http.StatusMethodNotAllowed:
+		// AQL can be restricted to administrators
+		return nil, fmt.Errorf("%w: %s returned %s", errAPIUnavailable, endpoint, resp.Status)
+	default:
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, endpoint)
+	}
+
+	var body struct {
+		Results []struct {
+			Name       string `json:"name"`
+			Path       string `json:"path"`
+			Properties []struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"properties"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding AQL response: %w", err)
+	}
+	var versions []repository.Entry
+	for _, item := range body.Results {
+		entry := repository.Entry{}
+		for _, p := range item.Properties {
+			if p.Key == "chart.version" {
+				entry.Version = p.Value
+			}
+		}
+		if entry.Version == "" {
+			continue
+		}
+		u, err := url.JoinPath(strings.TrimSuffix(repoURL, "/"), item.Path, item.Name)
+		if err == nil {
+			entry.URLs = []string{u}
+		}
+		versions = append(versions, entry)
+	}
+	slog.Debug("Listed chart versions through the Artifactory API", "repo_url", repoURL, "chart", chartName)
+	return chartVersions(versions, chartName)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+)
+
+var pendingUpdateGauge = metrics.NewExpiringGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_auto_update_pending",
+		Help: "Updates of the targetRevision of a chart an auto-update would apply, planned in dry-run mode (1 = pending)",
+	},
+	[]string{"application", "chart", "repo_url", "current_version", "latest_version", "cluster", "argocd_instance", "source"},
+	15*time.Minute,
+)
+
+func init() {
+	prometheus.MustRegister(pendingUpdateGauge)
+}
+
+// applicationUpdate is the JSON patch that moves the targetRevision of an
+// Application source from one version to another
+type applicationUpdate struct {
+	From  string          `json:"from"`
+	To    string          `json:"to"`
+	Patch json.RawMessage `json:"patch"`
+}
+
+// jsonPatchOperation is an operation of an RFC 6902 JSON patch
+type jsonPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// planUpdate returns the update of the source at path, a JSON pointer into
+// the Application, to the latest version of an outdated result when dry-run
+// auto-updates are enabled for its Application, logging the patch. Versions
+// resolved from a constraint are left to Argo CD.
+func planUpdate(ctx context.Context, r chartResult, path string) *applicationUpdate {
+	cfg := contextConfig(ctx).AutoUpdate
+	if !cfg.DryRun || path == "" || !r.outdated() || r.Missing || r.TargetRevision != "" || r.LatestVersion == "" {
+		return nil
+	}
+	selector, err := labels.Parse(cfg.Selector)
+	if err != nil || !selector.Matches(labels.Set(r.Labels)) {
+		return nil
+	}
+	// The test operation keeps the patch from overwriting a version changed
+	// since the check
+	patch, err := json.Marshal([]jsonPatchOperation{
+		{Op: "test", Path: path + "/targetRevision", Value: r.CurrentVersion},
+		{Op: "replace", Path: path + "/targetRevision", Value: r.LatestVersion},
+	})
+	if err != nil {
+		return nil
+	}
+	slog.Info("Planned auto-update (dry run)", "cluster", contextCluster(ctx).name, "namespace", r.Namespace, "application", r.Application,
+		"chart", r.Chart, "from", r.CurrentVersion, "to", r.LatestVersion, "patch", string(patch))
+	return &applicationUpdate{From: r.CurrentVersion, To: r.LatestVersion, Patch: patch}
+}
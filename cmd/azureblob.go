@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// azureStorageScope requests an Entra ID token for Blob storage
+	azureStorageScope = "https://storage.azure.com/.default"
+	// azureStorageVersion is the Blob REST API version sent with bearer
+	// tokens, which older default versions do not accept
+	azureStorageVersion = "2020-04-08"
+)
+
+// isAzureBlobHost reports whether host is an Azure Blob storage endpoint
+func isAzureBlobHost(host string) bool {
+	return strings.Contains(host, ".blob.core.")
+}
+
+// setAzureBlobAuth authorizes a request to Blob storage with the SAS token of
+// repo, or with the workload's Entra ID identity when enabled
+func setAzureBlobAuth(req *http.Request, repo *repositoryConfig, cfg credentialsConfig) (bool, error) {
+	if repo != nil && (repo.SASToken != "" || repo.SASTokenFile != "") {
+		sas, err := repo.sasToken()
+		if err != nil {
+			return false, err
+		}
+		params, err := url.ParseQuery(strings.TrimPrefix(sas, "?"))
+		if err != nil {
+			return false, fmt.Errorf("invalid SAS token for %s: %w", repo.URL, err)
+		}
+		query := req.URL.Query()
+		for key, values := range params {
+			query[key] = values
+		}
+		req.URL.RawQuery = query.Encode()
+		return true, nil
+	}
+	if !cfg.AzureBlob {
+		return false, nil
+	}
+	token, err := azureToken(req.Context(), azureStorageScope)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("x-ms-version", azureStorageVersion)
+	return true, nil
+}
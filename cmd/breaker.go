@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// errCircuitOpen is returned for requests to a host whose circuit is open
+	errCircuitOpen = errors.New("circuit open")
+
+	circuitOpenGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_repository_circuit_open",
+			Help: "Whether requests to a repository host are skipped after repeated failures (1 = skipped, 0 = allowed)",
+		},
+		[]string{"host"},
+	)
+
+	// repoBreakers tracks consecutive failures per repository host
+	repoBreakers = &circuitBreakers{circuits: map[string]*circuit{}}
+)
+
+func init() {
+	prometheus.MustRegister(circuitOpenGauge)
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+type circuitBreakers struct {
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// allow returns errCircuitOpen while host is cooling down after reaching the
+// failure threshold; once the cool-down passes a single failure reopens it
+func (b *circuitBreakers) allow(host string, cfg circuitBreakerConfig) error {
+	if cfg.FailureThreshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[host]
+	if !ok || c.openUntil.IsZero() {
+		return nil
+	}
+	if until := c.openUntil; time.Now().Before(until) {
+		return fmt.Errorf("skipping %s until %s: %w", host, until.Format(time.RFC3339), errCircuitOpen)
+	}
+	return nil
+}
+
+// record counts a failed request or resets the circuit after a successful one
+func (b *circuitBreakers) record(host string, failed bool, cfg circuitBreakerConfig) {
+	if cfg.FailureThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[host]
+	if !ok {
+		if !failed {
+			return
+		}
+		c = &circuit{}
+		b.circuits[host] = c
+	}
+	if !failed {
+		if !c.openUntil.IsZero() {
+			slog.Info("Closed circuit for repository", "host", host)
+		}
+		delete(b.circuits, host)
+		circuitOpenGauge.WithLabelValues(host).Set(0)
+		return
+	}
+	c.failures++
+	if c.failures >= cfg.FailureThreshold || !c.openUntil.IsZero() {
+		c.openUntil = time.Now().Add(cfg.CoolDown)
+		slog.Warn("Opened circuit for repository", "host", host, "failures", c.failures, "cool_down", cfg.CoolDown)
+		circuitOpenGauge.WithLabelValues(host).Set(1)
+	}
+}
+
+// requestFailed reports whether a response means the repository is unavailable
+func requestFailed(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
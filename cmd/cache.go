@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+const (
+	resultsStateFile = "results.json"
+	indexStateFile   = "index-cache.json"
+)
+
+// indexCacheEntry holds the versions of the charts requested from one
+// repository along with the validators of the index.yaml they came from
+type indexCacheEntry struct {
+	ETag         string                        `json:"etag,omitempty"`
+	LastModified string                        `json:"lastModified,omitempty"`
+	FetchedAt    time.Time                     `json:"fetchedAt"`
+	Generated    time.Time                     `json:"generated,omitempty"`
+	Charts       map[string][]repository.Entry `json:"charts"`
+}
+
+// indexCache avoids downloading an index.yaml for every chart of every cycle.
+// Entries are replaced, never modified, so they can be read without the lock.
+type indexCache struct {
+	mu      sync.Mutex
+	entries map[string]*indexCacheEntry
+	dirty   bool
+}
+
+var repoIndexCache = &indexCache{entries: make(map[string]*indexCacheEntry)}
+
+// versions returns the entries of chartName in the repository index. Cached
+// entries younger than ttl are used as is; older ones are revalidated with a
+// conditional request.
+func (c *indexCache) versions(ctx context.Context, repoURL, chartName string, ttl time.Duration) ([]repository.Entry, error) {
+	c.mu.Lock()
+	cached := c.entries[repoURL]
+	c.mu.Unlock()
+
+	var known bool
+	if cached != nil {
+		var versions []repository.Entry
+		versions, known = cached.Charts[chartName]
+		if known && time.Since(cached.FetchedAt) < ttl {
+			slog.Debug("Using cached index", "repo_url", repoURL, "chart", chartName)
+			recordIndexGenerated(repoURL, cached.Generated)
+			return chartVersions(versions, chartName)
+		}
+	}
+
+	var etag, lastModified string
+	if known {
+		etag, lastModified = cached.ETag, cached.LastModified
+	}
+	slog.Debug("Fetching index.yaml", "repo_url", repoURL, "chart", chartName, "conditional", known)
+	resp, err := fetchIndex(ctx, repoURL, etag, lastModified)
+	if err != nil {
+		return nil, err
+	}
+	if resp.notModified {
+		refreshed := *cached
+		refreshed.FetchedAt = time.Now()
+		c.store(repoURL, &refreshed)
+		recordIndexGenerated(repoURL, cached.Generated)
+		return chartVersions(cached.Charts[chartName], chartName)
+	}
+	defer resp.body.Close()
+
+	data, err := io.ReadAll(resp.body)
+	if err != nil {
+		return nil, fmt.Errorf("reading index.yaml: %w", err)
+	}
+	// Only charts that were asked for are decoded and kept to bound memory
+	// and disk use
+	wanted := []string{chartName}
+	if cached != nil {
+		for name := range cached.Charts {
+			wanted = append(wanted, name)
+		}
+	}
+	index, err := repository.LoadIndex(data, repoURL, wanted)
+	if err != nil {
+		return nil, fmt.Errorf("decoding index.yaml: %w", err)
+	}
+	entry := &indexCacheEntry{
+		ETag:         resp.etag,
+		LastModified: resp.lastModified,
+		FetchedAt:    time.Now(),
+		Generated:    index.Generated,
+		Charts:       make(map[string][]repository.Entry, len(wanted)),
+	}
+	for _, name := range wanted {
+		entry.Charts[name] = repository.Entries(index.Entries[name])
+	}
+	c.store(repoURL, entry)
+	recordIndexGenerated(repoURL, entry.Generated)
+	return chartVersions(entry.Charts[chartName], chartName)
+}
+
+var indexGeneratedGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_repository_index_generated_timestamp_seconds",
+		Help: "Unix time an index.yaml was generated at, telling mirrors that stopped re-indexing from quiet repositories",
+	},
+	[]string{"repo_url"},
+)
+
+func init() {
+	prometheus.MustRegister(indexGeneratedGauge)
+}
+
+// recordIndexGenerated sets the generated time of an index, which is
+// optional in index.yaml files
+func recordIndexGenerated(repoURL string, generated time.Time) {
+	if !generated.IsZero() {
+		indexGeneratedGauge.WithLabelValues(repoURL).Set(float64(generated.Unix()))
+	}
+}
+
+// indexResponse is a fetched index.yaml with its validators; body is nil
+// when the cached copy is still current
+type indexResponse struct {
+	body         io.ReadCloser
+	etag         string
+	lastModified string
+	notModified  bool
+}
+
+// fetchIndex fetches the index.yaml of repoURL by its scheme, conditionally
+// when validators of a cached copy are given
+func fetchIndex(ctx context.Context, repoURL, etag, lastModified string) (*indexResponse, error) {
+	switch {
+	case strings.HasPrefix(repoURL, "s3://"):
+		return fetchS3Index(ctx, repoURL, etag)
+	case strings.HasPrefix(repoURL, "gs://"):
+		return fetchGCSIndex(ctx, repoURL, etag)
+	case strings.HasPrefix(repoURL, "file://"):
+		return fetchFileIndex(repoURL, lastModified)
+	}
+	return fetchHTTPIndex(ctx, repoURL, etag, lastModified)
+}
+
+func fetchHTTPIndex(ctx context.Context, repoURL, etag, lastModified string) (*indexResponse, error) {
+	indexURL, err := repoIndexURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	req, err := newRepoRequest(ctx, indexURL)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	resp, err := repoDo(repoURL, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && (etag != "" || lastModified != "") {
+		resp.Body.Close()
+		return &indexResponse{notModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s fetching index.yaml", resp.Status)
+	}
+	return &indexResponse{
+		body:         resp.Body,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// repoIndexPath returns the path of the index of repoURL relative to it,
+// index.yaml unless its repository sets indexPath
+func repoIndexPath(repoURL string) string {
+	if repo := currentConfig().repositoryFor(repoURL); repo != nil && repo.IndexPath != "" {
+		return repo.IndexPath
+	}
+	return "index.yaml"
+}
+
+// repoIndexURL returns the URL of the index of an HTTP repository, where an
+// indexPath with a leading slash starts from the host root
+func repoIndexURL(repoURL string) (string, error) {
+	base, err := url.Parse(strings.TrimSuffix(repoURL, "/") + "/")
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(repoIndexPath(repoURL))
+	if err != nil {
+		return "", fmt.Errorf("indexPath: %w", err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// fetchFileIndex opens the index.yaml in a file:// directory, such as one
+// synced into a disconnected cluster, using its modification time as validator
+func fetchFileIndex(repoURL, lastModified string) (*indexResponse, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(filepath.FromSlash(u.Path), filepath.FromSlash(repoIndexPath(repoURL)))
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	modified := info.ModTime().UTC().Format(http.TimeFormat)
+	if modified == lastModified {
+		f.Close()
+		return &indexResponse{notModified: true}, nil
+	}
+	return &indexResponse{body: f, lastModified: modified}, nil
+}
+
+func (c *indexCache) store(repoURL string, entry *indexCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[repoURL] = entry
+	c.dirty = true
+}
+
+// invalidate drops the cached index of repoURL so the next lookup fetches it
+// unconditionally, reporting whether it was cached
+func (c *indexCache) invalidate(repoURL string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[repoURL]
+	if ok {
+		delete(c.entries, repoURL)
+		c.dirty = true
+	}
+	return ok
+}
+
+// chartVersions reports a chart missing from the index as an error
+func chartVersions(versions []repository.Entry, chartName string) ([]repository.Entry, error) {
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("chart %s %w", chartName, errChartNotFound)
+	}
+	return versions, nil
+}
+
+// load reads a persisted cache, keeping the current entries on error
+func (c *indexCache) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]*indexCacheEntry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = entries
+	return nil
+}
+
+// save writes the cache to path if it changed since the last save
+func (c *indexCache) save(path string) error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// restoreState loads the persisted index cache and, when restoreResults is
+// set, the results of the last cycle, republishing their gauges so metrics
+// are meaningful before the first cycle completes
+func restoreState(dir string, restoreResults bool) {
+	if err := repoIndexCache.load(filepath.Join(dir, indexStateFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Error loading index cache", "dir", dir, "error", err)
+	}
+	if !restoreResults {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(dir, resultsStateFile))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Error loading results", "dir", dir, "error", err)
+		}
+		return
+	}
+	var state struct {
+		GeneratedAt time.Time     `json:"generatedAt"`
+		Results     []chartResult `json:"results"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		slog.Warn("Error loading results", "dir", dir, "error", err)
+		return
+	}
+	latestResults.restore(state.Results, state.GeneratedAt)
+	for _, r := range state.Results {
+		outdatedCharts.observe(r)
+		recordMetrics(r)
+	}
+	slog.Info("Restored results", "count", len(state.Results), "generated_at", state.GeneratedAt)
+}
+
+// persistState writes the index cache and the latest results to dir
+func persistState(dir string) {
+	if err := repoIndexCache.save(filepath.Join(dir, indexStateFile)); err != nil {
+		slog.Error("Error saving index cache", "dir", dir, "error", err)
+	}
+	data, err := latestResults.marshal()
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(dir, resultsStateFile), data)
+	}
+	if err != nil {
+		slog.Error("Error saving results", "dir", dir, "error", err)
+	}
+}
+
+// writeFileAtomic replaces path with data so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// failedCharts remembers charts that could not be looked up, so a missing
+// chart or a broken repository is not fetched and logged again every cycle
+var failedCharts = &failureCache{entries: map[string]chartFailure{}}
+
+type chartFailure struct {
+	err   error
+	until time.Time
+}
+
+type failureCache struct {
+	mu      sync.Mutex
+	entries map[string]chartFailure
+}
+
+func failureKey(repoURL, chartName string) string {
+	return strings.TrimSuffix(repoURL, "/") + "|" + chartName
+}
+
+// get returns the error of a chart that failed within the negative TTL
+func (c *failureCache) get(repoURL, chartName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := failureKey(repoURL, chartName)
+	failure, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(failure.until) {
+		delete(c.entries, key)
+		return nil
+	}
+	return failure.err
+}
+
+// add remembers a failed lookup for ttl. Open circuits and rate limits,
+// which expire on their own, are not remembered.
+func (c *failureCache) add(repoURL, chartName string, err error, ttl time.Duration) {
+	if ttl <= 0 || errors.Is(err, errCircuitOpen) || errors.Is(err, errRateLimited) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[failureKey(repoURL, chartName)] = chartFailure{err: err, until: time.Now().Add(ttl)}
+}
+
+// invalidate forgets the failures of every chart of repoURL, reporting how
+// many there were
+func (c *failureCache) invalidate(repoURL string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := failureKey(repoURL, "")
+	n := 0
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n
+}
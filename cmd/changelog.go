@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// artifactHubChangesAnnotation lists the changes of a chart version, see
+// https://artifacthub.io/docs/topics/annotations/helm/
+const artifactHubChangesAnnotation = "artifacthub.io/changes"
+
+// chartChange is a single changelog entry of a chart version
+type chartChange struct {
+	Kind        string   `json:"kind,omitempty"`
+	Description string   `json:"description"`
+	Links       []string `json:"links,omitempty"`
+}
+
+// parseChanges decodes the artifacthub.io/changes annotation, which is
+// either a list of plain strings or a list of kind/description/links objects
+func parseChanges(annotation string) ([]chartChange, error) {
+	if annotation == "" {
+		return nil, nil
+	}
+	var raw []interface{}
+	if err := yaml.Unmarshal([]byte(annotation), &raw); err != nil {
+		return nil, err
+	}
+	var changes []chartChange
+	for _, item := range raw {
+		switch v := item.(type) {
+		case string:
+			changes = append(changes, chartChange{Description: v})
+		case map[interface{}]interface{}:
+			c := chartChange{}
+			c.Kind, _ = v["kind"].(string)
+			c.Description, _ = v["description"].(string)
+			if links, ok := v["links"].([]interface{}); ok {
+				for _, l := range links {
+					if link, ok := l.(map[interface{}]interface{}); ok {
+						if u, ok := link["url"].(string); ok {
+							c.Links = append(c.Links, u)
+						}
+					}
+				}
+			}
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// releaseNotes extracts the changelog and reference links of a chart version
+func releaseNotes(entry repository.Entry) ([]chartChange, []string) {
+	changes, err := parseChanges(entry.Annotations[artifactHubChangesAnnotation])
+	if err != nil {
+		slog.Debug("Invalid changes annotation", "annotation", artifactHubChangesAnnotation, "version", entry.Version, "error", err)
+	}
+	var links []string
+	if entry.Home != "" {
+		links = append(links, entry.Home)
+	}
+	links = append(links, entry.Sources...)
+	return changes, links
+}
+
+// ociChartMetadata reads the Chart.yaml metadata stored in the config blob of an OCI chart
+func ociChartMetadata(ctx context.Context, repoURL, chartName, version string) (repository.Entry, error) {
+	ref, err := parseOCIReference(repoURL, chartName)
+	if err != nil {
+		return repository.Entry{}, err
+	}
+	manifest, _, err := ociClient.manifest(ctx, ref, strings.ReplaceAll(version, "+", "_"))
+	if err != nil {
+		return repository.Entry{}, err
+	}
+	config, err := ociClient.blob(ctx, ref, manifest.Config.Digest)
+	if err != nil {
+		return repository.Entry{}, err
+	}
+	var entry repository.Entry
+	if err := json.Unmarshal(config, &entry); err != nil {
+		return repository.Entry{}, err
+	}
+	return entry, nil
+}
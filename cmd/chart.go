@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const helmChartContentMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+// downloadChartArchive fetches the packaged .tgz of a specific chart version
+// from either a classic Helm repository or an OCI registry, or their mirror
+func downloadChartArchive(ctx context.Context, repoURL, chartName, version string) ([]byte, error) {
+	repoURL = currentConfig().mirrorFor(repoURL)
+	if isOCIRepo(repoURL) {
+		ref, err := parseOCIReference(repoURL, chartName)
+		if err != nil {
+			return nil, err
+		}
+		manifest, _, err := ociClient.manifest(ctx, ref, strings.ReplaceAll(version, "+", "_"))
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType == helmChartContentMediaType {
+				return ociClient.blob(ctx, ref, layer.Digest)
+			}
+		}
+		return nil, fmt.Errorf("no chart content layer in %s:%s", ref.Repository, version)
+	}
+
+	if !strings.HasSuffix(repoURL, "/") {
+		repoURL += "/"
+	}
+	versions, err := getChartVersions(ctx, repoURL, chartName)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range versions {
+		if entry.Version != version {
+			continue
+		}
+		if len(entry.URLs) == 0 {
+			return nil, fmt.Errorf("no download URL for %s %s", chartName, version)
+		}
+		chartURL, err := resolveChartURL(repoURL, entry.URLs[0])
+		if err != nil {
+			return nil, err
+		}
+		slog.Debug("Downloading chart archive", "url", chartURL)
+		return download(ctx, chartURL)
+	}
+	return nil, fmt.Errorf("version %s of chart %s not found in repository", version, chartName)
+}
+
+// readChartFiles returns the named files from the top-level chart directory
+// of a packaged chart, ignoring files of bundled subcharts
+func readChartFiles(archive []byte, names ...string) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Entries are laid out as <chart>/<path>
+		_, rel, ok := strings.Cut(hdr.Name, "/")
+		if !ok || !wanted[rel] {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[rel] = data
+	}
+	return files, nil
+}
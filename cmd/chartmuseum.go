@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// errAPIUnavailable means a repository does not serve the configured API, so
+// the index.yaml is used instead
+var errAPIUnavailable = errors.New("repository API unavailable")
+
+// chartMuseumVersions lists the versions of chartName through the ChartMuseum
+// API, which returns a single chart instead of the whole index. A repository at
+// https://host/org/repo/ is served by https://host/api/org/repo/charts/<name>.
+func chartMuseumVersions(ctx context.Context, repoURL, chartName string) ([]repository.Entry, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join("/api", u.Path, "charts", chartName)
+
+	resp, err := repoGet(ctx, u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body json.RawMessage
+	decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+	switch {
+	case resp.StatusCode == http.StatusNotFound && decodeErr == nil && strings.Contains(string(body), "chart not found"):
+		return chartVersions(nil, chartName)
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusMethodNotAllowed,
+		resp.StatusCode == http.StatusNotImplemented, decodeErr != nil && resp.StatusCode == http.StatusOK:
+		return nil, fmt.Errorf("%w: %s returned %s", errAPIUnavailable, u.Redacted(), resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, u.Redacted())
+	}
+
+	var charts []struct {
+		Version     string            `json:"version"`
+		URLs        []string          `json:"urls"`
+		Digest      string            `json:"digest"`
+		Home        string            `json:"home"`
+		Sources     []string          `json:"sources"`
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.Unmarshal(body, &charts); err != nil {
+		return nil, fmt.Errorf("%w: decoding %s: %v", errAPIUnavailable, u.Redacted(), err)
+	}
+	versions := make([]repository.Entry, 0, len(charts))
+	for _, c := range charts {
+		versions = append(versions, repository.Entry{
+			Version:     c.Version,
+			URLs:        c.URLs,
+			Digest:      c.Digest,
+			Home:        c.Home,
+			Sources:     c.Sources,
+			Annotations: c.Annotations,
+		})
+	}
+	slog.Debug("Listed chart versions through the ChartMuseum API", "repo_url", repoURL, "chart", chartName)
+	return chartVersions(versions, chartName)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// clusterClient lists Applications in one cluster
+type clusterClient struct {
+	name       string
+	client     dynamic.Interface
+	namespaces []string
+	// kubeVersion is the Kubernetes version of the cluster, such as v1.29.2,
+	// empty when it could not be read
+	kubeVersion string
+}
+
+// newClusterClients connects to every configured cluster. Without clusters
+// the local one is used under an empty name, leaving the cluster label unset.
+// Clusters are read at startup only.
+func newClusterClients(kubeconfig string, clusters []clusterConfig) ([]clusterClient, error) {
+	if len(clusters) == 0 {
+		cluster, err := newClusterClient(kubeconfig, "")
+		if err != nil {
+			return nil, err
+		}
+		return []clusterClient{cluster}, nil
+	}
+	clients := make([]clusterClient, 0, len(clusters))
+	for _, c := range clusters {
+		path := c.Kubeconfig
+		if path == "" {
+			path = kubeconfig
+		}
+		cluster, err := newClusterClient(path, c.Context)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", c.Name, err)
+		}
+		slog.Info("Checking cluster", "cluster", c.Name, "context", c.Context)
+		cluster.name, cluster.namespaces = c.Name, c.Namespaces
+		clients = append(clients, cluster)
+	}
+	return clients, nil
+}
+
+// newClusterClient connects to a cluster and reads its Kubernetes version
+func newClusterClient(kubeconfig, kubeContext string) (clusterClient, error) {
+	restConfig, err := newRestConfig(kubeconfig, kubeContext)
+	if err != nil {
+		return clusterClient{}, err
+	}
+	client, err := newDynamicClient(restConfig)
+	if err != nil {
+		return clusterClient{}, err
+	}
+	return clusterClient{client: client, kubeVersion: serverVersion(restConfig)}, nil
+}
+
+// serverVersion returns the Kubernetes version of a cluster, or an empty
+// string when it cannot be read
+func serverVersion(restConfig *rest.Config) string {
+	restConfig = rest.CopyConfig(restConfig)
+	restConfig.Timeout = 10 * time.Second
+	disco, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		slog.Debug("Error creating discovery client", "error", err)
+		return ""
+	}
+	info, err := disco.ServerVersion()
+	if err != nil {
+		slog.Debug("Error reading Kubernetes version", "error", err)
+		return ""
+	}
+	return info.GitVersion
+}
@@ -0,0 +1,872 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+	"github.com/caseyrobb/helm-version-check/pkg/source"
+)
+
+// shutdownTimeout bounds how long in-flight HTTP requests may take after a
+// termination signal, well within the default pod grace period
+const shutdownTimeout = 10 * time.Second
+
+// errOutdated makes the check command exit non-zero when charts are outdated
+var errOutdated = errors.New("outdated charts found")
+
+// options are the flags shared by all subcommands
+type options struct {
+	configPath string
+	kubeconfig string
+	namespaces []string
+	interval   time.Duration
+	logLevel   string
+	logFormat  string
+	output     string
+	pprofAddr  string
+	metrics    metricsConfig
+	sharding   shardingConfig
+	// dryRun validates the configuration and connectivity instead of serving
+	dryRun bool
+	// offline validates the configuration only
+	offline bool
+}
+
+func newRootCommand() *cobra.Command {
+	opts := &options{}
+	root := &cobra.Command{
+		Use:   "helm-version-check",
+		Short: "Compare Helm chart versions of Argo CD Applications with their repositories",
+		// Without a subcommand the exporter runs, as it always has
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServe(cmd, opts)
+		},
+		SilenceUsage: true,
+		Version:      versionString(),
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&opts.configPath, "config", os.Getenv("CONFIG_FILE"), "path to the YAML config file (CONFIG_FILE)")
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig; defaults to in-cluster config, then KUBECONFIG or ~/.kube/config")
+	flags.StringSliceVar(&opts.namespaces, "namespace", nil, "namespaces to list Applications in (NAMESPACE)")
+	flags.DurationVar(&opts.interval, "interval", 0, "time between check cycles (INTERVAL)")
+	flags.StringVar(&opts.logLevel, "loglevel", "", "log level: debug, info, warn or error (LOGLEVEL)")
+	flags.StringVar(&opts.logFormat, "log-format", "", "log format, text or json (LOGFORMAT)")
+	flags.StringVarP(&opts.output, "output", "o", "", "result format: log, text, json, yaml, table or none (OUTPUT_FORMAT)")
+	flags.StringVar(&opts.metrics.ListenAddress, "metrics-listen-address", "", "address the metrics server binds to; all interfaces when empty (METRICS_LISTEN_ADDRESS)")
+	flags.IntVar(&opts.metrics.Port, "metrics-port", 0, "port of the metrics server (METRICS_PORT)")
+	flags.StringVar(&opts.metrics.Path, "metrics-path", "", "path metrics are served on (METRICS_PATH)")
+	flags.IntVar(&opts.sharding.Shards, "shards", 0, "number of replicas sharing the Applications (SHARDS)")
+	flags.IntVar(&opts.sharding.Index, "shard-index", 0, "shard checked by this replica, from 0 (SHARD_INDEX)")
+	flags.StringVar(&opts.pprofAddr, "pprof-addr", "", "address to serve pprof on, e.g. localhost:6060; disabled when empty (PPROF_ADDR)")
+	root.Flags().BoolVar(&opts.dryRun, "dry-run", false, "validate the configuration and connectivity like validate and exit instead of serving")
+
+	serve := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the exporter, checking periodically and serving metrics",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServe(cmd, opts)
+		},
+	}
+	serve.Flags().BoolVar(&opts.dryRun, "dry-run", false, "validate the configuration and connectivity like validate and exit instead of serving")
+	validate := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration, credentials and connectivity to clusters and repositories, and exit",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runValidate(cmd, opts)
+		},
+	}
+	validate.Flags().BoolVar(&opts.offline, "offline", false, "only validate the configuration, without connecting to clusters or repositories")
+
+	root.AddCommand(
+		serve,
+		newCheckCommand(opts),
+		newReportCommand(opts),
+		newListCommand(opts),
+		newRulesCommand(opts),
+		validate,
+	)
+	return root
+}
+
+// overrides applies flags that were set explicitly; they take precedence
+// over the file and environment, including on reload
+func (o *options) overrides(cmd *cobra.Command) func(*config) {
+	flags := cmd.Flags()
+	return func(cfg *config) {
+		if flags.Changed("namespace") {
+			cfg.Namespaces = o.namespaces
+		}
+		if flags.Changed("interval") {
+			cfg.Interval = o.interval
+		}
+		if flags.Changed("loglevel") {
+			cfg.LogLevel = o.logLevel
+		}
+		if flags.Changed("log-format") {
+			cfg.LogFormat = o.logFormat
+		}
+		if flags.Changed("output") {
+			cfg.Output.Format = o.output
+		}
+		if flags.Changed("pprof-addr") {
+			cfg.PprofAddr = o.pprofAddr
+		}
+		if flags.Changed("shards") {
+			cfg.Sharding.Shards = o.sharding.Shards
+		}
+		if flags.Changed("shard-index") {
+			cfg.Sharding.Index = o.sharding.Index
+		}
+		if flags.Changed("metrics-listen-address") {
+			cfg.Metrics.ListenAddress = o.metrics.ListenAddress
+		}
+		if flags.Changed("metrics-port") {
+			cfg.Metrics.Port = o.metrics.Port
+		}
+		if flags.Changed("metrics-path") {
+			cfg.Metrics.Path = o.metrics.Path
+		}
+	}
+}
+
+// setup loads, validates and applies the configuration
+func setup(cmd *cobra.Command, opts *options) (*config, error) {
+	cfg, err := loadConfig(opts.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	opts.overrides(cmd)(cfg)
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if err := applyConfig(cfg); err != nil {
+		return nil, err
+	}
+	if opts.configPath != "" {
+		slog.Info("Loaded config", "path", opts.configPath)
+	}
+	return cfg, nil
+}
+
+// newRestConfig returns in-cluster credentials, falling back to a
+// kubeconfig. A kubeconfig context, when given, is always used.
+func newRestConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	var restConfig *rest.Config
+	var err error
+	if kubeconfig == "" && kubeContext == "" {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if kubeconfig != "" || kubeContext != "" || err != nil {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = kubeconfig
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+		restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("getting cluster config: %w", err)
+		}
+		slog.Debug("Using kubeconfig credentials")
+	} else {
+		slog.Debug("Successfully obtained in-cluster config")
+	}
+	return restConfig, nil
+}
+
+// newDynamicClient connects to the cluster of restConfig
+func newDynamicClient(restConfig *rest.Config) (dynamic.Interface, error) {
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating dynamic client: %w", err)
+	}
+	slog.Debug("Created Kubernetes dynamic client")
+	return client, nil
+}
+
+// runCycle checks the Applications of every configured cluster and namespace
+// and the releases of the configured helmfiles once. It stops between applications when ctx is cancelled and returns its error.
+func runCycle(ctx context.Context, clusters []clusterClient, cfg *config) (results []chartResult, err error) {
+	ctx, span := tracer.Start(ctx, "cycle", trace.WithAttributes(attribute.StringSlice("namespaces", cfg.Namespaces)))
+	defer span.End()
+
+	start := time.Now()
+	processed := 0
+	delay := spreadDelay(cfg)
+	defer func() {
+		result := "completed"
+		if err != nil {
+			result = "aborted"
+		} else {
+			cycleDurationGauge.Set(time.Since(start).Seconds())
+			applicationsProcessedGauge.Set(float64(processed))
+			lastProcessed.Store(int64(processed))
+		}
+		cyclesCounter.WithLabelValues(result).Inc()
+	}()
+
+	chartApprovals.refresh(ctx, clusters[0], cfg.Approvals)
+	for _, cluster := range clusters {
+		scopes, err := checkScopes(ctx, cluster, cfg)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			slog.Error("Error listing check scopes", "cluster", cluster.name, "error", err)
+			continue
+		}
+		for _, scope := range scopes {
+			for _, namespace := range scope.namespaces(cluster) {
+				slog.Debug("Listing applications", "cluster", cluster.name, "check", scope.name, "namespace", namespace)
+				provider, err := applicationProvider(cluster, scope, namespace, cfg)
+				if err != nil {
+					slog.Error("Error listing applications", "cluster", cluster.name, "namespace", namespace, "error", err)
+					continue
+				}
+				checked, count, err := checkProvider(ctx, cluster, scope, provider, delay, processed)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+					slog.Error("Error listing applications", "cluster", cluster.name, "namespace", namespace, "error", err)
+					continue
+				}
+				results = append(results, checked...)
+				processed += count
+				slog.Debug("Checked applications", "cluster", cluster.name, "check", scope.name, "namespace", namespace, "count", count)
+			}
+		}
+	}
+	for _, name := range source.Registered() {
+		provider, _ := source.Lookup(name)
+		checked, count, err := checkProvider(ctx, clusterClient{}, checkScope{cfg: cfg}, provider, delay, processed)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			slog.Error("Error listing charts in use", "provider", name, "error", err)
+			continue
+		}
+		results = append(results, checked...)
+		processed += count
+	}
+	results = append(results, checkHelmfiles(ctx, cfg)...)
+	span.SetAttributes(attribute.Int("results", len(results)))
+	return results, ctx.Err()
+}
+
+var (
+	cyclesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "helm_check_cycles_total",
+			Help: "Check cycles run, by whether they completed or were aborted",
+		},
+		[]string{"result"},
+	)
+	applicationsProcessedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "helm_applications_processed",
+		Help: "Applications checked by this replica in the last completed cycle",
+	})
+	cycleDurationGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "helm_check_cycle_duration_seconds",
+		Help: "Duration of the last completed check cycle",
+	})
+	chartsCheckedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "helm_charts_checked",
+		Help: "Charts checked by this replica in the last completed cycle",
+	})
+	chartsOutdatedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "helm_charts_outdated",
+		Help: "Charts with a newer version available in the last completed cycle",
+	})
+	checkErrorsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "helm_check_errors",
+		Help: "Charts or applications that could not be checked in the last completed cycle",
+	})
+	chartsUpToDateRatioGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "helm_charts_up_to_date_ratio",
+		Help: "Share of the charts of the last completed cycle that are up to date or ahead (0 to 1)",
+	})
+)
+
+// lastProcessed is the number of Applications of the last completed cycle,
+// used to pace the next one
+var lastProcessed atomic.Int64
+
+func init() {
+	prometheus.MustRegister(cyclesCounter, applicationsProcessedGauge, cycleDurationGauge,
+		chartsCheckedGauge, chartsOutdatedGauge, checkErrorsGauge, chartsUpToDateRatioGauge)
+}
+
+// recordCycleSummary sets the totals of a completed cycle, which track the
+// overall state without aggregating the per-chart series. A cycle without
+// charts counts as up to date.
+func recordCycleSummary(results []chartResult, errors int) {
+	outdated := 0
+	for _, r := range results {
+		if r.outdated() {
+			outdated++
+		}
+	}
+	ratio := 1.0
+	if len(results) > 0 {
+		ratio = float64(len(results)-outdated) / float64(len(results))
+	}
+	chartsCheckedGauge.Set(float64(len(results)))
+	chartsOutdatedGauge.Set(float64(outdated))
+	checkErrorsGauge.Set(float64(errors))
+	chartsUpToDateRatioGauge.Set(ratio)
+}
+
+// spreadDelay returns the pause between Applications that spreads a cycle
+// over the configured fraction of the interval, assuming as many
+// Applications as last time; the first cycle and scrape-driven cycles are
+// not paced
+func spreadDelay(cfg *config) time.Duration {
+	n := lastProcessed.Load()
+	if cfg.Schedule.Spread <= 0 || n < 2 || cfg.Collection == collectionScrape {
+		return 0
+	}
+	return time.Duration(float64(cyclePeriod(cfg, time.Now())) * cfg.Schedule.Spread / float64(n-1))
+}
+
+// jittered randomizes interval by up to the given fraction in either direction
+func jittered(interval time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return interval
+	}
+	return interval + time.Duration((rand.Float64()*2-1)*fraction*float64(interval))
+}
+
+// checkApplication checks an Application with the settings of scope and
+// records the gauges of its results
+func checkApplication(ctx context.Context, cluster clusterClient, scope checkScope, app unstructured.Unstructured) []chartResult {
+	results := processApplication(withCluster(withConfig(ctx, scope.cfg), cluster), app)
+	for i := range results {
+		results[i].Cluster = cluster.name
+		results[i].Check = scope.name
+		results[i].Namespace = app.GetNamespace()
+		results[i].Instance = scope.cfg.Instance.name(cluster.name, app.GetNamespace())
+		results[i].Labels = app.GetLabels()
+		results[i].OutdatedSince = outdatedCharts.observe(results[i])
+		recordMetrics(results[i])
+	}
+	return results
+}
+
+// checkProvider checks the charts listed by a source provider with the
+// settings of scope, one application at a time and pausing delay between
+// applications once processed have been checked. Providers listing in pages
+// are checked page by page. It returns the results and the number of
+// applications checked, including those without charts.
+func checkProvider(ctx context.Context, cluster clusterClient, scope checkScope, provider source.Provider, delay time.Duration, processed int) ([]chartResult, int, error) {
+	checkCtx := withCluster(withConfig(ctx, scope.cfg), cluster)
+	var results []chartResult
+	count := 0
+	check := func(name string, app []source.Chart) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !scope.cfg.Sharding.owns(name) {
+			return nil
+		}
+		if processed+count > 0 && delay > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		count++
+		for _, r := range processCharts(checkCtx, name, app) {
+			r.Cluster = cluster.name
+			r.Check = scope.name
+			r.Instance = scope.cfg.Instance.name(cluster.name, r.Namespace)
+			r.OutdatedSince = outdatedCharts.observe(r)
+			recordMetrics(r)
+			results = append(results, r)
+		}
+		return nil
+	}
+	if walker, ok := provider.(source.ApplicationWalker); ok {
+		if err := walker.WalkApplications(ctx, check); err != nil {
+			return nil, 0, err
+		}
+		return results, count, nil
+	}
+	charts, err := listChartsInUse(ctx, cluster, provider)
+	if err != nil {
+		return nil, 0, err
+	}
+	for len(charts) > 0 {
+		n := 1
+		for n < len(charts) && charts[n].Application == charts[0].Application && charts[n].Namespace == charts[0].Namespace {
+			n++
+		}
+		if err := check(charts[0].Application, charts[:n]); err != nil {
+			return nil, 0, err
+		}
+		charts = charts[n:]
+	}
+	return results, count, nil
+}
+
+// listChartsInUse lists the charts of a source provider within a span
+func listChartsInUse(ctx context.Context, cluster clusterClient, provider source.Provider) ([]source.Chart, error) {
+	ctx, span := tracer.Start(ctx, "source.list",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("cluster", cluster.name)))
+	defer span.End()
+	charts, err := provider.ListChartsInUse(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("charts", len(charts)))
+	return charts, nil
+}
+
+func runServe(cmd *cobra.Command, opts *options) error {
+	if opts.dryRun {
+		return runValidate(cmd, opts)
+	}
+	ctx := cmd.Context()
+	cfg, err := setup(cmd, opts)
+	if err != nil {
+		return err
+	}
+	slog.Info("Starting helm-version-check", "version", version, "commit", commit, "loglevel", cfg.LogLevel, "namespaces", cfg.Namespaces,
+		"shard", cfg.Sharding.Index, "shards", cfg.Sharding.Shards)
+	if opts.configPath != "" {
+		go newConfigReloader(opts.configPath, opts.overrides(cmd)).run(ctx)
+	}
+
+	clusters, err := newClusterClients(opts.kubeconfig, cfg.Clusters)
+	if err != nil {
+		return err
+	}
+	// The cache directory is read at startup only
+	cacheDir := cfg.Cache.Dir
+	if cacheDir != "" {
+		restoreState(cacheDir, true)
+	}
+
+	// The listener is bound at startup only; changing it requires a restart
+	server, err := newMetricsServer(cfg.Metrics)
+	if err != nil {
+		return err
+	}
+	go func() {
+		slog.Info("Starting Prometheus metrics server", "addr", server.Addr, "path", cfg.Metrics.Path, "tls", server.TLSConfig != nil)
+		if err := serveMetrics(server); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+	grpcServer, err := startGRPCServer(cfg)
+	if err != nil {
+		return err
+	}
+	// pprof is bound at startup only; changing its address requires a restart
+	if cfg.PprofAddr != "" {
+		go servePprof(ctx, cfg.PprofAddr)
+	}
+	stopTelemetry, err := startTelemetry(ctx, cfg.OTLP)
+	if err != nil {
+		return err
+	}
+
+	cycle := func(ctx context.Context) error {
+		return completeCycle(ctx, clusters, cacheDir)
+	}
+	checkApplication := func(ctx context.Context, app string) {
+		reconcileApplication(ctx, clusters, cacheDir, app)
+	}
+	// The collection mode is read at startup only
+	if cfg.Collection == collectionScrape {
+		slog.Info("Checking charts when scraped", "max_age", cfg.Interval)
+		collector, err := registerScrapeCollector(ctx, cycle)
+		if err != nil {
+			return err
+		}
+		collector.serveReconciles(checkApplication)
+	} else if cfg.Schedule.Cron != "" {
+		slog.Info("Checking charts on a cron schedule", "cron", cfg.Schedule.Cron, "time_zone", cfg.Schedule.TimeZone)
+	}
+	if jitter := cfg.Schedule.StartupJitter; jitter > 0 && cfg.Collection != collectionScrape {
+		delay := time.Duration(rand.Int63n(int64(jitter)))
+		slog.Info("Delaying first cycle", "delay", delay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	for ctx.Err() == nil {
+		if err := cycle(ctx); err != nil {
+			// Partial results would report every unchecked chart as removed
+			slog.Info("Aborted check cycle", "reason", err)
+			break
+		}
+		now := time.Now()
+		next := nextCycle(currentConfig(), now)
+		slog.Debug("Sleeping until the next cycle", "sleep", next.Sub(now), "next", next.Format(time.RFC3339))
+		waitForCycle(ctx, next.Sub(now), checkApplication)
+	}
+
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	eventStream.close()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error stopping metrics server", "error", err)
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if err := stopTelemetry(shutdownCtx); err != nil {
+		slog.Error("Error flushing OpenTelemetry data", "error", err)
+	}
+	return nil
+}
+
+// completeCycle checks all charts and publishes the results: it writes them,
+// notifies webhooks of status changes, pushes the gauges and persists state
+func completeCycle(ctx context.Context, clusters []clusterClient, cacheDir string) error {
+	// Settings may change between cycles when the config is reloaded
+	cfg := currentConfig()
+
+	start := time.Now()
+	metrics.SetMinimumTTL(gaugeTTL(cfg, start))
+	errs := &checkErrors{}
+	pacer := newRequestPacer(cfg)
+	results, err := runCycle(withRequestPacer(withCheckErrors(ctx, errs), pacer), clusters, cfg)
+	if err != nil {
+		return err
+	}
+	pacer.finish()
+	checkHistory.add(cycleRecord{StartedAt: start, CompletedAt: time.Now(), Results: results, Errors: errs.list()}, cfg.History.Cycles)
+	recordCycleSummary(results, len(errs.list()))
+	recordVersionSkew(results)
+	sloSamples.record(cfg.SLO, results, time.Now())
+	if err := cfg.Output.output(outputLog).write(os.Stdout, results); err != nil {
+		slog.Error("Error writing results", "error", err)
+	}
+
+	previous, hadPrevious := latestResults.swap(results)
+	recordNewVersions(previous, results)
+	outdatedCharts.prune(results)
+	var events []statusEvent
+	if hadPrevious {
+		events = statusEvents(previous, results)
+		notifyStatusChanges(ctx, cfg, events)
+	}
+	published := eventStream.publish(events, errs.list(), results, "")
+	if cfg.Output.ChangesOnly {
+		// the first cycle logs the charts found outdated
+		if !hadPrevious {
+			published = append(statusEvents(nil, results), published...)
+		}
+		logStatusEvents(published)
+	}
+	manageIncidents(cfg.Notifiers, previous, results)
+	if cfg.CustomResources.Enabled {
+		checkResources.updateStatus(ctx, results)
+	}
+	if cfg.Reports.Resources {
+		publishReports(ctx, clusters, cfg, results)
+	}
+	if cfg.Reports.ConfigMap.Name != "" {
+		if err := writeConfigMapReport(ctx, clusters[0], cfg.Reports.ConfigMap, cfg.Sharding, results); err != nil {
+			slog.Error("Error writing report ConfigMap", "name", cfg.Reports.ConfigMap.Name, "error", err)
+		}
+	}
+	if cfg.Reports.Directory.Path != "" {
+		if err := writeReportFile(cfg.Reports.Directory, results, errs.list(), time.Now()); err != nil {
+			slog.Error("Error writing report file", "dir", cfg.Reports.Directory.Path, "error", err)
+		}
+	}
+	if cfg.Reports.Upload.URL != "" {
+		if err := uploadReport(ctx, cfg.Reports.Upload, results, errs.list(), time.Now()); err != nil {
+			slog.Error("Error uploading report", "url", cfg.Reports.Upload.URL, "error", err)
+		}
+	}
+	if cfg.Pushgateway.URL != "" {
+		if err := pushMetrics(ctx, cfg.Pushgateway); err != nil {
+			slog.Error("Error pushing metrics", "url", cfg.Pushgateway.URL, "error", err)
+		}
+	}
+	if cfg.RemoteWrite.URL != "" {
+		if err := remoteWriteMetrics(ctx, cfg.RemoteWrite); err != nil {
+			slog.Error("Error sending metrics via remote write", "url", cfg.RemoteWrite.URL, "error", err)
+		}
+	}
+	if cfg.StatsD.Address != "" {
+		if err := statsdMetrics(ctx, cfg.StatsD); err != nil {
+			slog.Error("Error sending metrics to StatsD", "address", cfg.StatsD.Address, "error", err)
+		}
+	}
+	if cacheDir != "" {
+		persistState(cacheDir)
+	}
+	slog.Debug("Completed cycle", "results", len(results))
+	return nil
+}
+
+// oneShot runs a single cycle for the check and report commands, logging to
+// stderr so stdout only carries results. When manifests are given their
+// Applications are checked instead of those of the clusters. When a
+// Pushgateway is configured the gauges are pushed, which suits running as a
+// Kubernetes Job.
+func oneShot(cmd *cobra.Command, opts *options, manifests []string) ([]chartResult, error) {
+	logOutput = os.Stderr
+	cfg, err := setup(cmd, opts)
+	if err != nil {
+		return nil, err
+	}
+	var clusters []clusterClient
+	if len(manifests) == 0 {
+		if clusters, err = newClusterClients(opts.kubeconfig, cfg.Clusters); err != nil {
+			return nil, err
+		}
+	}
+	stopTelemetry, err := startTelemetry(cmd.Context(), cfg.OTLP)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Cache.Dir != "" {
+		restoreState(cfg.Cache.Dir, false)
+	}
+	errs := &checkErrors{}
+	ctx := withCheckErrors(cmd.Context(), errs)
+	var results []chartResult
+	if len(manifests) > 0 {
+		results, err = checkManifests(ctx, cfg, manifests)
+	} else {
+		results, err = runCycle(ctx, clusters, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordCycleSummary(results, len(errs.list()))
+	recordVersionSkew(results)
+	if cfg.Cache.Dir != "" {
+		if err := repoIndexCache.save(filepath.Join(cfg.Cache.Dir, indexStateFile)); err != nil {
+			slog.Error("Error saving index cache", "dir", cfg.Cache.Dir, "error", err)
+		}
+	}
+	if cfg.Pushgateway.URL != "" {
+		if err := pushMetrics(cmd.Context(), cfg.Pushgateway); err != nil {
+			return nil, fmt.Errorf("pushing metrics to %s: %w", cfg.Pushgateway.URL, err)
+		}
+	}
+	if cfg.RemoteWrite.URL != "" {
+		if err := remoteWriteMetrics(cmd.Context(), cfg.RemoteWrite); err != nil {
+			return nil, fmt.Errorf("sending metrics to %s: %w", cfg.RemoteWrite.URL, err)
+		}
+	}
+	if cfg.StatsD.Address != "" {
+		if err := statsdMetrics(cmd.Context(), cfg.StatsD); err != nil {
+			return nil, fmt.Errorf("sending metrics to StatsD at %s: %w", cfg.StatsD.Address, err)
+		}
+	}
+	// Shutting down exports the gauges and spans of this cycle once
+	if err := stopTelemetry(cmd.Context()); err != nil {
+		return nil, fmt.Errorf("exporting OpenTelemetry data: %w", err)
+	}
+	return results, nil
+}
+
+func newCheckCommand(opts *options) *cobra.Command {
+	var failOn, failSelector string
+	var manifests []string
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check once, print the results and exit non-zero if charts are outdated",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy := incidentPolicy{Behind: failOn, Selector: failSelector}
+			switch failOn {
+			case "any":
+				// every outdated chart is at least a patch version behind
+				policy.Behind = "patch"
+			case "major", "minor", "patch":
+			default:
+				return fmt.Errorf("--fail-on must be major, minor, patch or any, got %q", failOn)
+			}
+			if _, err := labels.Parse(failSelector); err != nil {
+				return fmt.Errorf("invalid --fail-on-selector: %w", err)
+			}
+			return runCheck(cmd, opts, manifests, policy)
+		},
+	}
+	cmd.Flags().StringVar(&failOn, "fail-on", "any", "exit non-zero only for charts at least a major, minor or patch version behind, or any outdated chart")
+	cmd.Flags().StringVar(&failSelector, "fail-on-selector", "", "exit non-zero only for outdated charts of Applications matching this label selector")
+	cmd.Flags().StringSliceVar(&manifests, "manifests", nil, "check the Applications of these manifest files or directories instead of the clusters")
+	return cmd
+}
+
+// runCheck prints the results and fails when outdated charts match policy
+func runCheck(cmd *cobra.Command, opts *options, manifests []string, policy incidentPolicy) error {
+	results, err := oneShot(cmd, opts, manifests)
+	if err != nil {
+		return err
+	}
+	if err := currentConfig().Output.output(outputText).write(cmd.OutOrStdout(), results); err != nil {
+		return err
+	}
+	failing := 0
+	for _, r := range results {
+		if policy.matches(r) {
+			failing++
+		}
+	}
+	if failing > 0 {
+		return fmt.Errorf("%w: %d of %d", errOutdated, failing, len(results))
+	}
+	return nil
+}
+
+func newReportCommand(opts *options) *cobra.Command {
+	var format, groupBy string
+	var manifests []string
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Check once and print the results as a JSON or SARIF report",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "json" && format != "sarif" {
+				return fmt.Errorf("--format must be json or sarif, got %q", format)
+			}
+			switch groupBy {
+			case "", "chart", "repository":
+			default:
+				return fmt.Errorf("--group-by must be chart or repository, got %q", groupBy)
+			}
+			if groupBy != "" && format != "json" {
+				return errors.New("--group-by requires --format json")
+			}
+			return runReport(cmd, opts, manifests, format, groupBy)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "json", "report format, json or sarif")
+	cmd.Flags().StringVar(&groupBy, "group-by", "", "group the JSON report by chart or repository instead of listing results")
+	cmd.Flags().StringSliceVar(&manifests, "manifests", nil, "check the Applications of these manifest files or directories instead of the clusters")
+	return cmd
+}
+
+func runReport(cmd *cobra.Command, opts *options, manifests []string, format, groupBy string) error {
+	results, err := oneShot(cmd, opts, manifests)
+	if err != nil {
+		return err
+	}
+	if format == "sarif" {
+		return writeSARIF(cmd.OutOrStdout(), results)
+	}
+	latestResults.swap(results)
+	if groupBy != "" {
+		return writeGroupedReport(cmd.OutOrStdout(), groupBy)
+	}
+	return latestResults.writeJSON(cmd.OutOrStdout())
+}
+
+func newListCommand(opts *options) *cobra.Command {
+	var columns []string
+	var sortBy string
+	var descending, outdatedOnly bool
+	var manifests []string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Check once and print the results as a table",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateTableColumns(columns); err != nil {
+				return fmt.Errorf("--columns: %w", err)
+			}
+			if err := validateTableColumns([]string{sortBy}); err != nil {
+				return fmt.Errorf("--sort: %w", err)
+			}
+			results, err := oneShot(cmd, opts, manifests)
+			if err != nil {
+				return err
+			}
+			if outdatedOnly {
+				results = slices.DeleteFunc(results, func(r chartResult) bool { return !r.outdated() })
+			}
+			return tableOutput{columns: columns, sortBy: sortBy, descending: descending}.write(cmd.OutOrStdout(), results)
+		},
+	}
+	cmd.Flags().StringSliceVar(&columns, "columns", defaultTableColumns, "columns to show: "+strings.Join(tableColumnNames(), ", "))
+	cmd.Flags().StringVar(&sortBy, "sort", "application", "column to sort by")
+	cmd.Flags().BoolVar(&descending, "desc", false, "sort in descending order")
+	cmd.Flags().BoolVar(&outdatedOnly, "outdated", false, "only list outdated charts")
+	cmd.Flags().StringSliceVar(&manifests, "manifests", nil, "check the Applications of these manifest files or directories instead of the clusters")
+	return cmd
+}
+
+func newRulesCommand(opts *options) *cobra.Command {
+	rules := rulesOptions{}
+	cmd := &cobra.Command{
+		Use:   "rules",
+		Short: "Print a PrometheusRule with alerts for the exported metrics",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if rules.outdatedRatio < 0 || rules.outdatedRatio >= 1 {
+				return fmt.Errorf("--outdated-ratio must be between 0 and 1, got %g", rules.outdatedRatio)
+			}
+			logOutput = os.Stderr
+			cfg, err := setup(cmd, opts)
+			if err != nil {
+				return err
+			}
+			return writePrometheusRule(cmd.OutOrStdout(), cfg, rules)
+		},
+	}
+	cmd.Flags().StringVar(&rules.name, "name", "helm-version-check", "name of the PrometheusRule")
+	cmd.Flags().StringVar(&rules.namespace, "rule-namespace", "", "namespace of the PrometheusRule")
+	cmd.Flags().StringToStringVar(&rules.labels, "labels", nil, "labels selecting the rule for Prometheus, e.g. release=kube-prometheus-stack")
+	cmd.Flags().BoolVar(&rules.plain, "plain", false, "print a Prometheus rules file instead of a PrometheusRule")
+	cmd.Flags().DurationVar(&rules.outdatedFor, "outdated-for", time.Hour, "how long a chart is outdated before a warning")
+	cmd.Flags().DurationVar(&rules.criticalAfter, "critical-after", 0, "raise charts outdated this long to critical, e.g. 720h; disabled when 0")
+	cmd.Flags().Float64Var(&rules.outdatedRatio, "outdated-ratio", 0, "warn when more than this fraction of charts is outdated, e.g. 0.5; disabled when 0")
+	cmd.Flags().DurationVar(&rules.indexStaleAfter, "index-stale-after", 90*24*time.Hour, "warn when a repository has not regenerated its index this long; disabled when 0")
+	return cmd
+}
+
+func runValidate(cmd *cobra.Command, opts *options) error {
+	logOutput = os.Stderr
+	cfg, err := setup(cmd, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Configuration is valid (namespaces: %s, interval: %s)\n",
+		strings.Join(cfg.Namespaces, ", "), cfg.Interval)
+	if opts.offline {
+		return nil
+	}
+	if failed := writeProbes(cmd.OutOrStdout(), probeConnectivity(cmd.Context(), opts.kubeconfig, cfg)); failed > 0 {
+		return fmt.Errorf("%d connectivity checks failed", failed)
+	}
+	return nil
+}
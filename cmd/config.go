@@ -0,0 +1,1887 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+)
+
+// config holds every setting of the checker. It is read from an optional
+// YAML file, then overridden by environment variables and command line flags.
+type config struct {
+	Namespaces      []string             `yaml:"namespaces"`
+	Clusters        []clusterConfig      `yaml:"clusters"`
+	ListPageSize    int64                `yaml:"listPageSize"`
+	AppSelector     string               `yaml:"applicationSelector"`
+	StatusFilter    statusFilterConfig   `yaml:"statusFilter"`
+	CustomResources crdConfig            `yaml:"customResources"`
+	Interval        time.Duration        `yaml:"interval"`
+	Collection      string               `yaml:"collection"`
+	LogLevel        string               `yaml:"logLevel"`
+	LogFormat       string               `yaml:"logFormat"`
+	Output          outputConfig         `yaml:"output"`
+	Repositories    []repositoryConfig   `yaml:"repositories"`
+	Credentials     credentialsConfig    `yaml:"credentials"`
+	Policy          policyConfig         `yaml:"policy"`
+	Exclusions      exclusionConfig      `yaml:"exclusions"`
+	GitSources      gitSourcesConfig     `yaml:"gitSources"`
+	Helmfiles       []helmfileConfig     `yaml:"helmfiles"`
+	RepoFilter      repoFilterConfig     `yaml:"repositoryFilter"`
+	Mirrors         []mirrorConfig       `yaml:"mirrors"`
+	Notifiers       notifiersConfig      `yaml:"notifiers"`
+	Provenance      provenanceConfig     `yaml:"provenance"`
+	Cosign          cosignConfig         `yaml:"cosign"`
+	ArtifactHub     artifactHubConfig    `yaml:"artifactHub"`
+	DeepCheckImages bool                 `yaml:"deepCheckImages"`
+	RateLimit       rateLimitConfig      `yaml:"rateLimit"`
+	CircuitBreaker  circuitBreakerConfig `yaml:"circuitBreaker"`
+	Redirects       redirectConfig       `yaml:"redirects"`
+	Admin           adminConfig          `yaml:"admin"`
+	Receiver        receiverConfig       `yaml:"receiver"`
+	PprofAddr       string               `yaml:"pprofAddr"`
+	Metrics         metricsConfig        `yaml:"metrics"`
+	Pushgateway     pushgatewayConfig    `yaml:"pushgateway"`
+	OTLP            otlpConfig           `yaml:"otlp"`
+	RemoteWrite     remoteWriteConfig    `yaml:"remoteWrite"`
+	StatsD          statsdConfig         `yaml:"statsd"`
+	Sharding        shardingConfig       `yaml:"sharding"`
+	Instance        instanceConfig       `yaml:"instance"`
+	Schedule        scheduleConfig       `yaml:"schedule"`
+	Cache           cacheConfig          `yaml:"cache"`
+	Reports         reportsConfig        `yaml:"reports"`
+	History         historyConfig        `yaml:"history"`
+	GRPC            grpcConfig           `yaml:"grpc"`
+	AutoUpdate      autoUpdateConfig     `yaml:"autoUpdate"`
+	Approvals       approvalsConfig      `yaml:"approvals"`
+	SLO             sloConfig            `yaml:"slo"`
+	ArgoCDExtension extensionConfig      `yaml:"argocdExtension"`
+	ArgoCDServer    argoCDServerConfig   `yaml:"argocdServer"`
+	ImageTags       imageTagsConfig      `yaml:"imageTags"`
+	RemovedAPIs     removedAPIsConfig    `yaml:"removedAPIs"`
+	// StaleAfter flags charts whose latest version is older, such as no
+	// release in a year; zero disables it
+	StaleAfter time.Duration `yaml:"staleAfter"`
+
+	// Integrations built from the settings above by applyConfig
+	keyring     openpgp.EntityList
+	verifier    *cosignVerifier
+	artifactHub *artifactHubClient
+}
+
+// clusterConfig is a cluster running Argo CD, reached through a kubeconfig
+// context. Namespaces defaults to the top-level namespaces.
+type clusterConfig struct {
+	Name       string   `yaml:"name"`
+	Kubeconfig string   `yaml:"kubeconfig"`
+	Context    string   `yaml:"context"`
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// repositoryConfig holds credentials and transport settings for repositories
+// whose URL starts with URL
+type repositoryConfig struct {
+	URL          string `yaml:"url"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"passwordFile"`
+	// Token is sent as a bearer token instead of basic auth, e.g. an
+	// Artifactory access token or one of a token-authenticated proxy; TokenEnv
+	// names an environment variable holding it
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"tokenFile"`
+	TokenEnv  string `yaml:"tokenEnv"`
+	// OAuth2 fetches the bearer token with the client credentials grant
+	// instead, refreshing it when it expires
+	OAuth2 oauth2Config `yaml:"oauth2"`
+	// SASToken is appended to requests for Azure Blob storage repositories
+	SASToken     string `yaml:"sasToken"`
+	SASTokenFile string `yaml:"sasTokenFile"`
+	// Headers are added to every request, e.g. an API gateway key or a
+	// tenant header required by a repository frontend
+	Headers map[string]string `yaml:"headers"`
+	// Proxy overrides the proxy environment variables for this repository
+	Proxy string `yaml:"proxy"`
+	// InsecureSkipVerify accepts any server certificate, for lab setups only
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+	// IndexPath locates the index relative to the URL, or from the host root
+	// with a leading slash, for repositories behind gateways that do not
+	// serve it at index.yaml
+	IndexPath string `yaml:"indexPath"`
+	// API lists versions through a repository server API instead of the
+	// index.yaml or OCI tags: chartmuseum, harbor, artifactory, nexus or
+	// github. Harbor registries are also detected automatically.
+	API string `yaml:"api"`
+	// GitHubRepository is the owner/name whose releases are chart versions
+	// with api: github, by default taken from a github.com URL
+	GitHubRepository string `yaml:"githubRepository"`
+	// Ordering picks the latest version by semver, or with created by the
+	// newest created date, for repositories publishing hotfixes with lower
+	// versions
+	Ordering string `yaml:"ordering"`
+	// GitProvider reads files of git sources through the API of github,
+	// gitlab or bitbucket instead of cloning, detected for github.com,
+	// gitlab.com and bitbucket.org; GitAPIURL is the API of a self-hosted
+	// server such as https://github.example.com/api/v3
+	GitProvider string `yaml:"gitProvider"`
+	GitAPIURL   string `yaml:"gitAPIURL"`
+	// SSHKeyFile holds the private key for ssh:// and git@ URLs of git sources
+	SSHKeyFile string `yaml:"sshPrivateKeyFile"`
+}
+
+// oauth2Config is an OAuth2 client of a repository, enabled by TokenURL
+type oauth2Config struct {
+	TokenURL         string   `yaml:"tokenURL"`
+	ClientID         string   `yaml:"clientID"`
+	ClientSecret     string   `yaml:"clientSecret"`
+	ClientSecretFile string   `yaml:"clientSecretFile"`
+	Scopes           []string `yaml:"scopes"`
+}
+
+// credentialsConfig locates credential files used for repositories without
+// configured credentials; empty paths use the helm and docker CLI defaults
+type credentialsConfig struct {
+	NetrcFile        string `yaml:"netrcFile"`
+	DockerConfigFile string `yaml:"dockerConfigFile"`
+	// HelmReposFile resolves repository aliases and holds credentials of
+	// repositories added with helm repo add
+	HelmReposFile string `yaml:"helmRepositoriesFile"`
+	// ECR logs in to private ECR registries with the ambient AWS identity
+	ECR bool `yaml:"ecr"`
+	// GAR logs in to Artifact Registry with Google application default credentials
+	GAR bool `yaml:"gar"`
+	// ACR logs in to Azure Container Registry with workload or managed identity
+	ACR bool `yaml:"acr"`
+	// AzureBlob authorizes Blob storage repositories without a SAS token with
+	// workload or managed identity
+	AzureBlob bool `yaml:"azureBlob"`
+}
+
+// mirrorConfig fetches repositories whose URL starts with From from To
+// instead, while results and metrics keep the original URL
+type mirrorConfig struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// policyConfig controls how the latest version is selected
+type policyConfig struct {
+	IgnorePrereleases bool `yaml:"ignorePrereleases"`
+	// NonSemverOrdering orders versions that are not semver: lenient,
+	// lexical or created; by default they are never newer than semver ones
+	NonSemverOrdering string `yaml:"nonSemverOrdering"`
+	// PrereleaseOrder places prereleases before the release of their base
+	// version, as semver does, or after it for publishers numbering later
+	// builds such as 1.2.0-hotfix.1
+	PrereleaseOrder string `yaml:"prereleaseOrder"`
+	// BuildMetadata is ignored when comparing versions, as semver does, or
+	// compared leniently
+	BuildMetadata string `yaml:"buildMetadata"`
+	// StableTracksStable compares stable current versions only with stable
+	// versions, so they are never behind a prerelease
+	StableTracksStable bool `yaml:"stableTracksStable"`
+	// LenientSemver normalizes versions such as V1.2.3, 1.2.3.4 or
+	// 1.2.3-rc.01 before parsing them as semver, while results keep them as
+	// published
+	LenientSemver bool `yaml:"lenientSemver"`
+	// ChannelAnnotation names the annotation of index entries holding their
+	// channel, and Channels those versions are taken from, by default the
+	// channel of the current version
+	ChannelAnnotation string   `yaml:"channelAnnotation"`
+	Channels          []string `yaml:"channels"`
+	// Rules are CEL expressions a version must all satisfy to be taken as
+	// the latest, evaluated with the version, chart and application
+	Rules []string `yaml:"rules"`
+	// GracePeriod keeps versions published more recently from being taken
+	// as the latest, for teams waiting for early bugfix releases
+	GracePeriod time.Duration `yaml:"gracePeriod"`
+}
+
+// exclusionConfig lists applications and charts that are never checked, by
+// name or by regular expressions matching part of the name
+type exclusionConfig struct {
+	Applications        []string `yaml:"applications"`
+	Charts              []string `yaml:"charts"`
+	ApplicationPatterns []string `yaml:"applicationPatterns"`
+	ChartPatterns       []string `yaml:"chartPatterns"`
+}
+
+// gitSourcesConfig enables checking the dependencies of charts kept in git
+// repositories, for sources with a path instead of a chart
+type gitSourcesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CacheTTL is how long a cloned revision is reused
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+	// SecretsNamespace is where Argo CD repository secrets are read for the
+	// credentials of repositories not configured here; empty disables it
+	SecretsNamespace string `yaml:"secretsNamespace"`
+	// KnownHostsFile verifies SSH host keys, by default $SSH_KNOWN_HOSTS or
+	// ~/.ssh/known_hosts
+	KnownHostsFile string `yaml:"knownHostsFile"`
+}
+
+// outputConfig selects how results are written: log entries by the exporter
+// and text blocks by the check command unless Format is set. Columns and Sort
+// apply to the table format. ChangesOnly logs the changes in the status of
+// charts at info level and every result at debug level only.
+type outputConfig struct {
+	Format      string   `yaml:"format"`
+	Columns     []string `yaml:"columns"`
+	Sort        string   `yaml:"sort"`
+	ChangesOnly bool     `yaml:"changesOnly"`
+}
+
+// helmfileConfig is a helmfile whose releases are checked like Applications,
+// read from Path in a git repository or, without RepoURL, on disk. A directory
+// path reads its helmfile.yaml, or on disk its helmfile.d.
+type helmfileConfig struct {
+	// Name prefixes the release names reported as applications, by default
+	// the path
+	Name     string `yaml:"name"`
+	RepoURL  string `yaml:"repoURL"`
+	Revision string `yaml:"revision"`
+	Path     string `yaml:"path"`
+}
+
+// statusFilterConfig limits checks to Applications in one of the listed sync
+// and health statuses; an empty list matches any status
+type statusFilterConfig struct {
+	Sync   []string `yaml:"sync"`
+	Health []string `yaml:"health"`
+}
+
+// repoFilterConfig limits the repositories charts are checked from by URL,
+// matched as urlWithin does: only those matching Allow when it is set, except
+// those matching Deny
+type repoFilterConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// crdConfig makes HelmVersionCheck resources define what is checked instead
+// of the namespaces, selector, policy and exclusions of the config
+type crdConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Namespace limits where resources are read; empty reads all namespaces
+	Namespace string `yaml:"namespace"`
+	// NamespaceGrants lists the namespaces whose Applications the resources
+	// of a namespace may check besides their own, "*" for any
+	NamespaceGrants map[string][]string `yaml:"namespaceGrants"`
+	// WebhookURLs are the URLs, and the paths below them, resources may send
+	// notifications to; without any resources cannot have webhooks
+	WebhookURLs []string `yaml:"webhookURLs"`
+}
+
+// allowsNamespace reports whether resources of namespace may check the
+// Applications of target
+func (c crdConfig) allowsNamespace(namespace, target string) bool {
+	if target == namespace {
+		return true
+	}
+	for _, granted := range c.NamespaceGrants[namespace] {
+		if granted == "*" || granted == target {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsWebhook reports whether resources may send notifications to rawURL
+func (c crdConfig) allowsWebhook(rawURL string) bool {
+	for _, prefix := range c.WebhookURLs {
+		if urlWithin(rawURL, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// notifiersConfig lists the destinations for status change notifications
+type notifiersConfig struct {
+	Webhooks     []webhookConfig      `yaml:"webhooks"`
+	PagerDuty    []pagerDutyConfig    `yaml:"pagerDuty"`
+	Opsgenie     []opsgenieConfig     `yaml:"opsgenie"`
+	Alertmanager []alertmanagerConfig `yaml:"alertmanager"`
+	GitHubIssues []githubIssuesConfig `yaml:"githubIssues"`
+	Kafka        []kafkaConfig        `yaml:"kafka"`
+	NATS         []natsConfig         `yaml:"nats"`
+	DedupeWindow time.Duration        `yaml:"dedupeWindow"`
+	// MaintenanceWindows hold status change notifications, which are sent
+	// together once they end
+	MaintenanceWindows []maintenanceWindow `yaml:"maintenanceWindows"`
+}
+
+// webhookConfig is a URL that receives status change events as JSON or
+// CloudEvents, or rendered with a Go template over the event when one is set
+type webhookConfig struct {
+	URL             string            `yaml:"url"`
+	Headers         map[string]string `yaml:"headers"`
+	eventFormat     `yaml:",inline"`
+	notifierOptions `yaml:",inline"`
+}
+
+// incidentPolicy selects the outdated charts that open an incident: those at
+// least a major, minor or patch version behind (major by default) in
+// Applications matching the label selector
+type incidentPolicy struct {
+	Behind   string `yaml:"behind"`
+	Selector string `yaml:"selector"`
+}
+
+// pagerDutyConfig opens and resolves PagerDuty incidents through the Events
+// API v2. Severity is critical, error (the default), warning or info.
+type pagerDutyConfig struct {
+	RoutingKey     string         `yaml:"routingKey"`
+	RoutingKeyFile string         `yaml:"routingKeyFile"`
+	Severity       string         `yaml:"severity"`
+	Policy         incidentPolicy `yaml:"policy"`
+}
+
+// opsgenieConfig opens and closes Opsgenie alerts. APIURL defaults to
+// https://api.opsgenie.com; use https://api.eu.opsgenie.com for EU accounts.
+// Priority is P1 to P5, P3 by default.
+type opsgenieConfig struct {
+	APIKey     string         `yaml:"apiKey"`
+	APIKeyFile string         `yaml:"apiKeyFile"`
+	APIURL     string         `yaml:"apiURL"`
+	Priority   string         `yaml:"priority"`
+	Policy     incidentPolicy `yaml:"policy"`
+}
+
+// alertmanagerConfig posts alerts for the charts matching the policy to an
+// Alertmanager, with Labels added to every alert
+type alertmanagerConfig struct {
+	URL       string            `yaml:"url"`
+	BasicAuth basicAuthConfig   `yaml:"basicAuth"`
+	Labels    map[string]string `yaml:"labels"`
+	Policy    incidentPolicy    `yaml:"policy"`
+}
+
+// githubIssuesConfig opens an issue per chart matching the policy in the
+// owner/name tracking repository and closes it when the chart is current.
+// Issues get Labels and, for every key in LabelsFromApplication, a key:value
+// label from the Application. The token defaults to GITHUB_TOKEN; APIURL
+// defaults to https://api.github.com, use https://<host>/api/v3 for GitHub
+// Enterprise.
+type githubIssuesConfig struct {
+	Repository            string         `yaml:"repository"`
+	APIURL                string         `yaml:"apiURL"`
+	Token                 string         `yaml:"token"`
+	TokenFile             string         `yaml:"tokenFile"`
+	Labels                []string       `yaml:"labels"`
+	LabelsFromApplication []string       `yaml:"labelsFromApplication"`
+	Policy                incidentPolicy `yaml:"policy"`
+}
+
+// eventFormat serializes status change events for webhooks and message
+// buses: as JSON (the default) or a structured CloudEvent, or with a Go
+// template when one is set. Source is the source of CloudEvents, by default
+// helm-version-check.
+type eventFormat struct {
+	Format       string `yaml:"format"`
+	Source       string `yaml:"source"`
+	Template     string `yaml:"template"`
+	TemplateFile string `yaml:"templateFile"`
+}
+
+// kafkaConfig publishes status change events to a Kafka topic, keyed by chart
+// so the events of a chart stay in order
+type kafkaConfig struct {
+	Brokers         []string        `yaml:"brokers"`
+	Topic           string          `yaml:"topic"`
+	TLS             bool            `yaml:"tls"`
+	SASL            kafkaSASLConfig `yaml:"sasl"`
+	eventFormat     `yaml:",inline"`
+	notifierOptions `yaml:",inline"`
+}
+
+// kafkaSASLConfig authenticates with the plain, scram-sha-256 or
+// scram-sha-512 mechanism when one is set
+type kafkaSASLConfig struct {
+	Mechanism       string `yaml:"mechanism"`
+	basicAuthConfig `yaml:",inline"`
+}
+
+// natsConfig publishes status change events to a NATS subject. Credentials
+// are taken from the URL or from a .creds file.
+type natsConfig struct {
+	URL             string `yaml:"url"`
+	Subject         string `yaml:"subject"`
+	CredentialsFile string `yaml:"credentialsFile"`
+	eventFormat     `yaml:",inline"`
+	notifierOptions `yaml:",inline"`
+}
+
+type provenanceConfig struct {
+	Keyring string `yaml:"keyring"`
+	// Require only takes versions whose provenance verifies as upgrade
+	// candidates, as cosign does for OCI charts
+	Require bool `yaml:"require"`
+}
+
+type cosignConfig struct {
+	PublicKey                 string `yaml:"publicKey"`
+	FulcioRoots               string `yaml:"fulcioRoots"`
+	CertificateIdentity       string `yaml:"certificateIdentity"`
+	CertificateIdentityRegexp string `yaml:"certificateIdentityRegexp"`
+	CertificateOIDCIssuer     string `yaml:"certificateOIDCIssuer"`
+	// RekorPublicKey verifies the transparency log bundles that keyless
+	// signatures must carry
+	RekorPublicKey string `yaml:"rekorPublicKey"`
+}
+
+// rateLimitConfig limits requests to each repository host; a zero rate disables it
+type rateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	Burst             int     `yaml:"burst"`
+}
+
+// circuitBreakerConfig skips a repository host for CoolDown after
+// FailureThreshold consecutive failures; a zero threshold disables it
+type circuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failureThreshold"`
+	CoolDown         time.Duration `yaml:"coolDown"`
+}
+
+// redirectConfig controls how repository redirects, such as to a CDN or a
+// storage bucket, are followed
+type redirectConfig struct {
+	Max int `yaml:"max"`
+	// ForwardAuthorization keeps the Authorization header, the GitLab token
+	// and the repository headers when redirected to another host, which are
+	// otherwise dropped so credentials do not leak
+	ForwardAuthorization bool `yaml:"forwardAuthorization"`
+	// LogFinalURL logs the URL responses came from after redirects
+	LogFinalURL bool `yaml:"logFinalURL"`
+}
+
+type artifactHubConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	CacheTTL     time.Duration `yaml:"cacheTTL"`
+	APIKeyID     string        `yaml:"apiKeyID"`
+	APIKeySecret string        `yaml:"apiKeySecret"`
+}
+
+// adminConfig holds the bearer token required by administrative endpoints
+type adminConfig struct {
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"tokenFile"`
+}
+
+// receiverConfig holds the bearer token required by the inbound webhook,
+// kept apart from the admin token so publishing pipelines get no admin access
+type receiverConfig struct {
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"tokenFile"`
+}
+
+// imageTagsConfig compares the image tags set in the Helm values of
+// Applications with the latest semver tags of their registries, listed at
+// most every CacheTTL per image
+type imageTagsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+}
+
+// removedAPIsConfig renders the latest version of outdated charts with the
+// values of their Applications and flags manifests using APIs removed in
+// KubeVersion, that of each cluster when empty
+type removedAPIsConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	KubeVersion string `yaml:"kubeVersion"`
+}
+
+// argoCDServerConfig reads Applications through the Argo CD API server at URL
+// with the token of an account allowed to get them, instead of the Kubernetes
+// API, for setups where the exporter has no RBAC on the Applications resource
+type argoCDServerConfig struct {
+	URL       string `yaml:"url"`
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"tokenFile"`
+	// CAFile is trusted in addition to the system roots, e.g. for the
+	// self-signed certificate of argocd-server
+	CAFile             string `yaml:"caFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// extensionConfig holds the bearer token required by the Argo CD UI
+// extension endpoint, added by the proxy extension configuration, and the
+// origins allowed to call it from a browser
+type extensionConfig struct {
+	Token          string   `yaml:"token"`
+	TokenFile      string   `yaml:"tokenFile"`
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+// metricsConfig is where the metrics server listens. An empty listen address
+// binds all interfaces; use 127.0.0.1 when running as a sidecar.
+type metricsConfig struct {
+	ListenAddress string          `yaml:"listenAddress"`
+	Port          int             `yaml:"port"`
+	Path          string          `yaml:"path"`
+	TLS           tlsConfig       `yaml:"tls"`
+	BasicAuth     basicAuthConfig `yaml:"basicAuth"`
+}
+
+// autoUpdateConfig plans updates of the targetRevision of outdated charts in
+// Applications matching Selector. Only DryRun is supported: the JSON patch of
+// each source is logged, reported and exported without applying it.
+type autoUpdateConfig struct {
+	DryRun   bool   `yaml:"dryRun"`
+	Selector string `yaml:"selector"`
+}
+
+// approvalsConfig makes the latest version of the charts listed in a
+// ConfigMap of the first cluster the latest approved one. The namespace
+// defaults to the one the exporter runs in.
+type approvalsConfig struct {
+	ConfigMap string `yaml:"configMap"`
+	Namespace string `yaml:"namespace"`
+}
+
+// sloConfig exports the share of charts lagging the latest version by at
+// most Versions at Level, such as 1 minor version, over the cycles of
+// Window, overall and per value of the application Labels, such as team. An
+// empty level disables it.
+type sloConfig struct {
+	Level    string        `yaml:"level"`
+	Versions int           `yaml:"versions"`
+	Target   float64       `yaml:"target"`
+	Window   time.Duration `yaml:"window"`
+	Labels   []string      `yaml:"labels"`
+}
+
+// grpcConfig serves the gRPC API on Port, with the TLS and basic auth
+// settings of metrics; a zero port disables it
+type grpcConfig struct {
+	ListenAddress string `yaml:"listenAddress"`
+	Port          int    `yaml:"port"`
+}
+
+// addr returns the host:port to listen on
+func (g grpcConfig) addr() string {
+	return net.JoinHostPort(g.ListenAddress, strconv.Itoa(g.Port))
+}
+
+// tlsConfig enables HTTPS, and client certificate verification when a CA is set
+type tlsConfig struct {
+	CertFile     string `yaml:"certFile"`
+	KeyFile      string `yaml:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile"`
+}
+
+// basicAuthConfig protects the metrics and report endpoints when a username is set
+type basicAuthConfig struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"passwordFile"`
+}
+
+// addr returns the host:port to listen on
+func (m metricsConfig) addr() string {
+	return net.JoinHostPort(m.ListenAddress, strconv.Itoa(m.Port))
+}
+
+// pushgatewayConfig enables pushing the gauges after every cycle
+type pushgatewayConfig struct {
+	URL       string          `yaml:"url"`
+	Job       string          `yaml:"job"`
+	BasicAuth basicAuthConfig `yaml:"basicAuth"`
+}
+
+// cacheConfig controls the repository index cache and where it and the
+// latest results are persisted across restarts
+type cacheConfig struct {
+	Dir      string        `yaml:"dir"`
+	IndexTTL time.Duration `yaml:"indexTTL"`
+	// NegativeTTL is how long a chart that could not be looked up is
+	// skipped; zero disables it
+	NegativeTTL time.Duration `yaml:"negativeTTL"`
+}
+
+// reportsConfig publishes results inside the cluster or on disk for tools
+// that do not read Prometheus
+type reportsConfig struct {
+	// Resources writes a HelmVersionReport next to every Application
+	Resources bool                  `yaml:"resources"`
+	ConfigMap configMapReportConfig `yaml:"configMap"`
+	Directory reportDirectoryConfig `yaml:"directory"`
+	Upload    reportUploadConfig    `yaml:"upload"`
+}
+
+// reportDirectoryConfig writes the results of every cycle to a timestamped
+// JSON file under Path when it is set, such as a mounted volume keeping
+// audit evidence, removing the files older than Retention unless it is zero
+type reportDirectoryConfig struct {
+	Path      string        `yaml:"path"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+// reportUploadConfig uploads the report of a cycle to an s3://bucket/prefix
+// or gs://bucket/prefix URL at most every Interval, in a folder named Name
+// (by default the host name) so reports of many clusters can share a bucket
+type reportUploadConfig struct {
+	URL      string        `yaml:"url"`
+	Name     string        `yaml:"name"`
+	Interval time.Duration `yaml:"interval"`
+	// CredentialsFile is an AWS shared credentials file, read with Profile,
+	// or a Google service account key. Without it the AWS default chain or
+	// Google application default credentials are used.
+	CredentialsFile string `yaml:"credentialsFile"`
+	Profile         string `yaml:"profile"`
+}
+
+// historyConfig keeps the results and errors of the last Cycles cycles for
+// /api/v1/history; zero disables it
+type historyConfig struct {
+	Cycles int `yaml:"cycles"`
+}
+
+// configMapReportConfig writes the latest results into a ConfigMap of the
+// first cluster when a name is set. The namespace defaults to the one the
+// exporter runs in.
+type configMapReportConfig struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	Format    string `yaml:"format"`
+}
+
+// shardingConfig splits Applications across replicas; each replica checks
+// the Applications whose name hashes to its index modulo the shard count
+type shardingConfig struct {
+	Shards int `yaml:"shards"`
+	Index  int `yaml:"index"`
+}
+
+// instanceConfig labels results with the Argo CD installation they were read
+// from, so that several installations scanned by one deployment stay apart.
+// From is namespace, cluster or cluster/namespace; empty leaves it unset.
+type instanceConfig struct {
+	From string `yaml:"from"`
+	// Names renames derived instances, such as argocd-team-b to team-b
+	Names map[string]string `yaml:"names"`
+}
+
+// scheduleConfig spreads the load of many replicas: StartupJitter delays the
+// first cycle by up to its value, Jitter randomizes each interval by up to
+// that fraction and Spread paces the Applications of a cycle over that
+// fraction of the interval. RequestBudget paces the repository requests of a
+// cycle over the same window at a rate of at most that many per window; a
+// cycle sending more is not cut short but takes longer. Cron, such as
+// "0 */4 * * *", starts cycles when it fires in TimeZone instead of after
+// every interval.
+type scheduleConfig struct {
+	StartupJitter time.Duration `yaml:"startupJitter"`
+	Jitter        float64       `yaml:"jitter"`
+	Spread        float64       `yaml:"spread"`
+	RequestBudget int           `yaml:"requestBudget"`
+	Cron          string        `yaml:"cron"`
+	TimeZone      string        `yaml:"timeZone"`
+}
+
+// remoteWriteConfig enables sending the gauges to a Prometheus remote_write
+// endpoint after every cycle, authenticated with a bearer token or basic auth
+type remoteWriteConfig struct {
+	URL             string            `yaml:"url"`
+	Job             string            `yaml:"job"`
+	ExternalLabels  map[string]string `yaml:"externalLabels"`
+	BearerToken     string            `yaml:"bearerToken"`
+	BearerTokenFile string            `yaml:"bearerTokenFile"`
+	BasicAuth       basicAuthConfig   `yaml:"basicAuth"`
+}
+
+// statsdConfig enables sending the gauges to a StatsD or DogStatsD agent
+// over UDP after every cycle. Plain StatsD has no tags, so labels are folded
+// into the metric names.
+type statsdConfig struct {
+	Address string   `yaml:"address"`
+	Format  string   `yaml:"format"`
+	Prefix  string   `yaml:"prefix"`
+	Tags    []string `yaml:"tags"`
+}
+
+// otlpConfig is the OpenTelemetry collector that metrics are exported to
+type otlpConfig struct {
+	Endpoint string            `yaml:"endpoint"`
+	Protocol string            `yaml:"protocol"`
+	Insecure bool              `yaml:"insecure"`
+	Headers  map[string]string `yaml:"headers"`
+	Metrics  otlpMetricsConfig `yaml:"metrics"`
+	Traces   otlpTracesConfig  `yaml:"traces"`
+}
+
+type otlpMetricsConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+type otlpTracesConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+func defaultConfig() config {
+	return config{
+		Namespaces:   []string{"argocd"},
+		Collection:   collectionInterval,
+		ListPageSize: 500,
+		Interval:     60 * time.Second,
+		LogLevel:     "info",
+		LogFormat:    "text",
+		ArtifactHub: artifactHubConfig{
+			CacheTTL: 6 * time.Hour,
+		},
+		RateLimit: rateLimitConfig{
+			RequestsPerSecond: 5,
+			Burst:             10,
+		},
+		CircuitBreaker: circuitBreakerConfig{
+			FailureThreshold: 5,
+			CoolDown:         10 * time.Minute,
+		},
+		Metrics: metricsConfig{
+			Port: 9080,
+			Path: "/metrics",
+		},
+		Pushgateway: pushgatewayConfig{
+			Job: "helm-version-check",
+		},
+		RemoteWrite: remoteWriteConfig{
+			Job: "helm-version-check",
+		},
+		Sharding: shardingConfig{
+			Shards: 1,
+		},
+		OTLP: otlpConfig{
+			Protocol: "grpc",
+			Metrics: otlpMetricsConfig{
+				Interval: 60 * time.Second,
+			},
+			Traces: otlpTracesConfig{
+				SampleRatio: 1,
+			},
+		},
+		Reports: reportsConfig{
+			ConfigMap: configMapReportConfig{Format: "yaml"},
+		},
+		GitSources: gitSourcesConfig{CacheTTL: 10 * time.Minute},
+		History:    historyConfig{Cycles: 10},
+		Notifiers:  notifiersConfig{DedupeWindow: time.Hour},
+		Redirects:  redirectConfig{Max: 10},
+		SLO:        sloConfig{Target: 0.95, Window: 7 * 24 * time.Hour},
+		ImageTags:  imageTagsConfig{CacheTTL: time.Hour},
+		StatsD:     statsdConfig{Format: "dogstatsd"},
+	}
+}
+
+// loadConfig reads the config file at path (if any) on top of the defaults
+// and applies environment variable overrides
+func loadConfig(path string) (*config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// applyEnv overrides settings with the environment variables supported
+// before the config file existed
+func (c *config) applyEnv() error {
+	if v := os.Getenv("NAMESPACE"); v != "" {
+		c.Namespaces = splitList(v)
+	}
+	if v := os.Getenv("LIST_PAGE_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid LIST_PAGE_SIZE: %w", err)
+		}
+		c.ListPageSize = n
+	}
+	if v := os.Getenv("APPLICATION_SELECTOR"); v != "" {
+		c.AppSelector = v
+	}
+	if v := os.Getenv("GIT_SOURCES_ENABLED"); v != "" {
+		c.GitSources.Enabled = v == "true"
+	}
+	if v := os.Getenv("GIT_SOURCES_SECRETS_NAMESPACE"); v != "" {
+		c.GitSources.SecretsNamespace = v
+	}
+	if v := os.Getenv("GIT_SOURCES_KNOWN_HOSTS_FILE"); v != "" {
+		c.GitSources.KnownHostsFile = v
+	}
+	if v := os.Getenv("SYNC_STATUSES"); v != "" {
+		c.StatusFilter.Sync = splitList(v)
+	}
+	if v := os.Getenv("HEALTH_STATUSES"); v != "" {
+		c.StatusFilter.Health = splitList(v)
+	}
+	if v := os.Getenv("REPOSITORY_ALLOW"); v != "" {
+		c.RepoFilter.Allow = splitList(v)
+	}
+	if v := os.Getenv("REPOSITORY_DENY"); v != "" {
+		c.RepoFilter.Deny = splitList(v)
+	}
+	if v := os.Getenv("CUSTOM_RESOURCES_ENABLED"); v != "" {
+		c.CustomResources.Enabled = v == "true"
+	}
+	if v := os.Getenv("CUSTOM_RESOURCES_NAMESPACE"); v != "" {
+		c.CustomResources.Namespace = v
+	}
+	if v := os.Getenv("REPORT_RESOURCES"); v != "" {
+		c.Reports.Resources = v == "true"
+	}
+	if v := os.Getenv("REPORT_CONFIGMAP_NAME"); v != "" {
+		c.Reports.ConfigMap.Name = v
+	}
+	if v := os.Getenv("REPORT_CONFIGMAP_NAMESPACE"); v != "" {
+		c.Reports.ConfigMap.Namespace = v
+	}
+	if v := os.Getenv("REPORT_CONFIGMAP_FORMAT"); v != "" {
+		c.Reports.ConfigMap.Format = v
+	}
+	if v := os.Getenv("REPORT_DIRECTORY"); v != "" {
+		c.Reports.Directory.Path = v
+	}
+	if v := os.Getenv("REPORT_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid REPORT_RETENTION: %w", err)
+		}
+		c.Reports.Directory.Retention = d
+	}
+	if v := os.Getenv("REPORT_UPLOAD_URL"); v != "" {
+		c.Reports.Upload.URL = v
+	}
+	if v := os.Getenv("REPORT_UPLOAD_NAME"); v != "" {
+		c.Reports.Upload.Name = v
+	}
+	if v := os.Getenv("REPORT_UPLOAD_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid REPORT_UPLOAD_INTERVAL: %w", err)
+		}
+		c.Reports.Upload.Interval = d
+	}
+	if v := os.Getenv("REPORT_UPLOAD_CREDENTIALS_FILE"); v != "" {
+		c.Reports.Upload.CredentialsFile = v
+	}
+	if v := os.Getenv("REPORT_UPLOAD_PROFILE"); v != "" {
+		c.Reports.Upload.Profile = v
+	}
+	if v := os.Getenv("HISTORY_CYCLES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid HISTORY_CYCLES: %w", err)
+		}
+		c.History.Cycles = n
+	}
+	if v := os.Getenv("COLLECTION"); v != "" {
+		c.Collection = v
+	}
+	if v := os.Getenv("INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid INTERVAL: %w", err)
+		}
+		c.Interval = d
+	}
+	if v := os.Getenv("LOGLEVEL"); v != "" {
+		c.LogLevel = v
+	}
+	if v := os.Getenv("LOGFORMAT"); v != "" {
+		c.LogFormat = v
+	}
+	if v := os.Getenv("OUTPUT_FORMAT"); v != "" {
+		c.Output.Format = v
+	}
+	if v := os.Getenv("OUTPUT_CHANGES_ONLY"); v != "" {
+		c.Output.ChangesOnly = v == "true"
+	}
+	if v := os.Getenv("PROVENANCE_KEYRING"); v != "" {
+		c.Provenance.Keyring = v
+	}
+	if v := os.Getenv("PROVENANCE_REQUIRE"); v != "" {
+		c.Provenance.Require = v == "true"
+	}
+	if v := os.Getenv("NETRC_FILE"); v != "" {
+		c.Credentials.NetrcFile = v
+	}
+	if v := os.Getenv("DOCKER_CONFIG_FILE"); v != "" {
+		c.Credentials.DockerConfigFile = v
+	}
+	if v := os.Getenv("HELM_REPOSITORIES_FILE"); v != "" {
+		c.Credentials.HelmReposFile = v
+	}
+	if v := os.Getenv("ECR_AUTH"); v != "" {
+		c.Credentials.ECR = v == "true"
+	}
+	if v := os.Getenv("GAR_AUTH"); v != "" {
+		c.Credentials.GAR = v == "true"
+	}
+	if v := os.Getenv("ACR_AUTH"); v != "" {
+		c.Credentials.ACR = v == "true"
+	}
+	if v := os.Getenv("AZURE_BLOB_AUTH"); v != "" {
+		c.Credentials.AzureBlob = v == "true"
+	}
+	if v := os.Getenv("COSIGN_PUBLIC_KEY"); v != "" {
+		c.Cosign.PublicKey = v
+	}
+	if v := os.Getenv("COSIGN_FULCIO_ROOTS"); v != "" {
+		c.Cosign.FulcioRoots = v
+	}
+	if v := os.Getenv("COSIGN_CERTIFICATE_IDENTITY"); v != "" {
+		c.Cosign.CertificateIdentity = v
+	}
+	if v := os.Getenv("COSIGN_CERTIFICATE_IDENTITY_REGEXP"); v != "" {
+		c.Cosign.CertificateIdentityRegexp = v
+	}
+	if v := os.Getenv("COSIGN_CERTIFICATE_OIDC_ISSUER"); v != "" {
+		c.Cosign.CertificateOIDCIssuer = v
+	}
+	if v := os.Getenv("COSIGN_REKOR_PUBLIC_KEY"); v != "" {
+		c.Cosign.RekorPublicKey = v
+	}
+	if v := os.Getenv("ARTIFACTHUB_ENABLED"); v != "" {
+		c.ArtifactHub.Enabled = v == "true"
+	}
+	if v := os.Getenv("ARTIFACTHUB_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid ARTIFACTHUB_CACHE_TTL: %w", err)
+		}
+		c.ArtifactHub.CacheTTL = d
+	}
+	if v := os.Getenv("ARTIFACTHUB_API_KEY_ID"); v != "" {
+		c.ArtifactHub.APIKeyID = v
+	}
+	if v := os.Getenv("ARTIFACTHUB_API_KEY_SECRET"); v != "" {
+		c.ArtifactHub.APIKeySecret = v
+	}
+	if v := os.Getenv("REPO_RATE_LIMIT"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid REPO_RATE_LIMIT: %w", err)
+		}
+		c.RateLimit.RequestsPerSecond = f
+	}
+	if v := os.Getenv("REPO_RATE_BURST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid REPO_RATE_BURST: %w", err)
+		}
+		c.RateLimit.Burst = n
+	}
+	if v := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid CIRCUIT_BREAKER_THRESHOLD: %w", err)
+		}
+		c.CircuitBreaker.FailureThreshold = n
+	}
+	if v := os.Getenv("CIRCUIT_BREAKER_COOL_DOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid CIRCUIT_BREAKER_COOL_DOWN: %w", err)
+		}
+		c.CircuitBreaker.CoolDown = d
+	}
+	if v := os.Getenv("REDIRECT_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid REDIRECT_MAX: %w", err)
+		}
+		c.Redirects.Max = n
+	}
+	if v := os.Getenv("REDIRECT_FORWARD_AUTHORIZATION"); v != "" {
+		c.Redirects.ForwardAuthorization = v == "true"
+	}
+	if v := os.Getenv("REDIRECT_LOG_FINAL_URL"); v != "" {
+		c.Redirects.LogFinalURL = v == "true"
+	}
+	if v := os.Getenv("SLO_LEVEL"); v != "" {
+		c.SLO.Level = v
+	}
+	if v := os.Getenv("SLO_VERSIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid SLO_VERSIONS: %w", err)
+		}
+		c.SLO.Versions = n
+	}
+	if v := os.Getenv("SLO_TARGET"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SLO_TARGET: %w", err)
+		}
+		c.SLO.Target = f
+	}
+	if v := os.Getenv("SLO_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid SLO_WINDOW: %w", err)
+		}
+		c.SLO.Window = d
+	}
+	if v := os.Getenv("SLO_LABELS"); v != "" {
+		c.SLO.Labels = splitList(v)
+	}
+	if v := os.Getenv("APPROVALS_CONFIGMAP"); v != "" {
+		c.Approvals.ConfigMap = v
+	}
+	if v := os.Getenv("APPROVALS_NAMESPACE"); v != "" {
+		c.Approvals.Namespace = v
+	}
+	if v := os.Getenv("AUTO_UPDATE_DRY_RUN"); v != "" {
+		c.AutoUpdate.DryRun = v == "true"
+	}
+	if v := os.Getenv("DEEP_CHECK_IMAGES"); v != "" {
+		c.DeepCheckImages = v == "true"
+	}
+	if v := os.Getenv("IMAGE_TAGS_ENABLED"); v != "" {
+		c.ImageTags.Enabled = v == "true"
+	}
+	if v := os.Getenv("IMAGE_TAGS_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid IMAGE_TAGS_CACHE_TTL: %w", err)
+		}
+		c.ImageTags.CacheTTL = d
+	}
+	if v := os.Getenv("REMOVED_APIS_ENABLED"); v != "" {
+		c.RemovedAPIs.Enabled = v == "true"
+	}
+	if v := os.Getenv("REMOVED_APIS_KUBE_VERSION"); v != "" {
+		c.RemovedAPIs.KubeVersion = v
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		c.Admin.Token = v
+	}
+	if v := os.Getenv("ADMIN_TOKEN_FILE"); v != "" {
+		c.Admin.TokenFile = v
+	}
+	if v := os.Getenv("RECEIVER_TOKEN"); v != "" {
+		c.Receiver.Token = v
+	}
+	if v := os.Getenv("RECEIVER_TOKEN_FILE"); v != "" {
+		c.Receiver.TokenFile = v
+	}
+	if v := os.Getenv("STALE_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid STALE_AFTER: %w", err)
+		}
+		c.StaleAfter = d
+	}
+	if v := os.Getenv("ARGOCD_SERVER_URL"); v != "" {
+		c.ArgoCDServer.URL = v
+	}
+	if v := os.Getenv("ARGOCD_SERVER_TOKEN"); v != "" {
+		c.ArgoCDServer.Token = v
+	}
+	if v := os.Getenv("ARGOCD_SERVER_TOKEN_FILE"); v != "" {
+		c.ArgoCDServer.TokenFile = v
+	}
+	if v := os.Getenv("ARGOCD_SERVER_CA_FILE"); v != "" {
+		c.ArgoCDServer.CAFile = v
+	}
+	if v := os.Getenv("ARGOCD_SERVER_INSECURE"); v != "" {
+		c.ArgoCDServer.InsecureSkipVerify = v == "true"
+	}
+	if v := os.Getenv("ARGOCD_EXTENSION_TOKEN"); v != "" {
+		c.ArgoCDExtension.Token = v
+	}
+	if v := os.Getenv("ARGOCD_EXTENSION_TOKEN_FILE"); v != "" {
+		c.ArgoCDExtension.TokenFile = v
+	}
+	if v := os.Getenv("ARGOCD_EXTENSION_ALLOWED_ORIGINS"); v != "" {
+		c.ArgoCDExtension.AllowedOrigins = splitList(v)
+	}
+	if v := os.Getenv("PPROF_ADDR"); v != "" {
+		c.PprofAddr = v
+	}
+	if v := os.Getenv("METRICS_LISTEN_ADDRESS"); v != "" {
+		c.Metrics.ListenAddress = v
+	}
+	if v := os.Getenv("METRICS_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid METRICS_PORT: %w", err)
+		}
+		c.Metrics.Port = port
+	}
+	if v := os.Getenv("GRPC_LISTEN_ADDRESS"); v != "" {
+		c.GRPC.ListenAddress = v
+	}
+	if v := os.Getenv("GRPC_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid GRPC_PORT: %w", err)
+		}
+		c.GRPC.Port = port
+	}
+	if v := os.Getenv("METRICS_PATH"); v != "" {
+		c.Metrics.Path = v
+	}
+	if v := os.Getenv("METRICS_TLS_CERT_FILE"); v != "" {
+		c.Metrics.TLS.CertFile = v
+	}
+	if v := os.Getenv("METRICS_TLS_KEY_FILE"); v != "" {
+		c.Metrics.TLS.KeyFile = v
+	}
+	if v := os.Getenv("METRICS_TLS_CLIENT_CA_FILE"); v != "" {
+		c.Metrics.TLS.ClientCAFile = v
+	}
+	if v := os.Getenv("METRICS_BASIC_AUTH_USERNAME"); v != "" {
+		c.Metrics.BasicAuth.Username = v
+	}
+	if v := os.Getenv("METRICS_BASIC_AUTH_PASSWORD"); v != "" {
+		c.Metrics.BasicAuth.Password = v
+	}
+	if v := os.Getenv("METRICS_BASIC_AUTH_PASSWORD_FILE"); v != "" {
+		c.Metrics.BasicAuth.PasswordFile = v
+	}
+	if v := os.Getenv("PUSHGATEWAY_URL"); v != "" {
+		c.Pushgateway.URL = v
+	}
+	if v := os.Getenv("PUSHGATEWAY_JOB"); v != "" {
+		c.Pushgateway.Job = v
+	}
+	if v := os.Getenv("PUSHGATEWAY_USERNAME"); v != "" {
+		c.Pushgateway.BasicAuth.Username = v
+	}
+	if v := os.Getenv("PUSHGATEWAY_PASSWORD_FILE"); v != "" {
+		c.Pushgateway.BasicAuth.PasswordFile = v
+	}
+	if v := os.Getenv("CACHE_DIR"); v != "" {
+		c.Cache.Dir = v
+	}
+	if v := os.Getenv("INDEX_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid INDEX_CACHE_TTL: %w", err)
+		}
+		c.Cache.IndexTTL = d
+	}
+	if v := os.Getenv("NEGATIVE_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid NEGATIVE_CACHE_TTL: %w", err)
+		}
+		c.Cache.NegativeTTL = d
+	}
+	if v := os.Getenv("NOTIFY_DEDUPE_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid NOTIFY_DEDUPE_WINDOW: %w", err)
+		}
+		c.Notifiers.DedupeWindow = d
+	}
+	if v := os.Getenv("STARTUP_JITTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid STARTUP_JITTER: %w", err)
+		}
+		c.Schedule.StartupJitter = d
+	}
+	if v := os.Getenv("INTERVAL_JITTER"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid INTERVAL_JITTER: %w", err)
+		}
+		c.Schedule.Jitter = f
+	}
+	if v := os.Getenv("SPREAD"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SPREAD: %w", err)
+		}
+		c.Schedule.Spread = f
+	}
+	if v := os.Getenv("REQUEST_BUDGET"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid REQUEST_BUDGET: %w", err)
+		}
+		c.Schedule.RequestBudget = n
+	}
+	if v := os.Getenv("SCHEDULE_CRON"); v != "" {
+		c.Schedule.Cron = v
+	}
+	if v := os.Getenv("SCHEDULE_TIMEZONE"); v != "" {
+		c.Schedule.TimeZone = v
+	}
+	if v := os.Getenv("SHARDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid SHARDS: %w", err)
+		}
+		c.Sharding.Shards = n
+	}
+	if v := os.Getenv("SHARD_INDEX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid SHARD_INDEX: %w", err)
+		}
+		c.Sharding.Index = n
+	}
+	if v := os.Getenv("INSTANCE_FROM"); v != "" {
+		c.Instance.From = v
+	}
+	if v := os.Getenv("REMOTE_WRITE_URL"); v != "" {
+		c.RemoteWrite.URL = v
+	}
+	if v := os.Getenv("REMOTE_WRITE_BEARER_TOKEN_FILE"); v != "" {
+		c.RemoteWrite.BearerTokenFile = v
+	}
+	if v := os.Getenv("REMOTE_WRITE_USERNAME"); v != "" {
+		c.RemoteWrite.BasicAuth.Username = v
+	}
+	if v := os.Getenv("REMOTE_WRITE_PASSWORD_FILE"); v != "" {
+		c.RemoteWrite.BasicAuth.PasswordFile = v
+	}
+	if v := os.Getenv("STATSD_ADDRESS"); v != "" {
+		c.StatsD.Address = v
+	}
+	if v := os.Getenv("STATSD_FORMAT"); v != "" {
+		c.StatsD.Format = v
+	}
+	if v := os.Getenv("STATSD_PREFIX"); v != "" {
+		c.StatsD.Prefix = v
+	}
+	if v := os.Getenv("STATSD_TAGS"); v != "" {
+		c.StatsD.Tags = splitList(v)
+	}
+	if v := os.Getenv("OTLP_ENDPOINT"); v != "" {
+		c.OTLP.Endpoint = v
+	}
+	if v := os.Getenv("OTLP_PROTOCOL"); v != "" {
+		c.OTLP.Protocol = v
+	}
+	if v := os.Getenv("OTLP_INSECURE"); v != "" {
+		c.OTLP.Insecure = v == "true"
+	}
+	if v := os.Getenv("OTLP_METRICS_ENABLED"); v != "" {
+		c.OTLP.Metrics.Enabled = v == "true"
+	}
+	if v := os.Getenv("OTLP_TRACES_ENABLED"); v != "" {
+		c.OTLP.Traces.Enabled = v == "true"
+	}
+	return nil
+}
+
+// validate reports settings that cannot work
+func (c *config) validate() error {
+	if len(c.Namespaces) == 0 {
+		return errors.New("at least one namespace is required")
+	}
+	names := make(map[string]bool)
+	for i, cluster := range c.Clusters {
+		if cluster.Name == "" {
+			return fmt.Errorf("clusters[%d]: name is required", i)
+		}
+		if names[cluster.Name] {
+			return fmt.Errorf("clusters[%d]: duplicate name %q", i, cluster.Name)
+		}
+		names[cluster.Name] = true
+	}
+	if v := c.RemovedAPIs.KubeVersion; v != "" {
+		if _, err := semver.NewVersion(v); err != nil {
+			return fmt.Errorf("invalid removedAPIs.kubeVersion %q: %w", v, err)
+		}
+	}
+	if c.StaleAfter < 0 {
+		return fmt.Errorf("staleAfter must not be negative, got %s", c.StaleAfter)
+	}
+	if err := c.ArgoCDServer.validate(); err != nil {
+		return fmt.Errorf("argocdServer.%w", err)
+	}
+	if c.ArgoCDServer.URL != "" && len(c.Clusters) > 0 {
+		return errors.New("argocdServer.url cannot be combined with clusters")
+	}
+	if c.RateLimit.RequestsPerSecond < 0 || c.RateLimit.Burst < 0 {
+		return fmt.Errorf("rateLimit must not be negative")
+	}
+	if c.CircuitBreaker.FailureThreshold > 0 && c.CircuitBreaker.CoolDown <= 0 {
+		return fmt.Errorf("circuitBreaker.coolDown must be positive, got %s", c.CircuitBreaker.CoolDown)
+	}
+	if _, err := labels.Parse(c.AutoUpdate.Selector); err != nil {
+		return fmt.Errorf("autoUpdate.selector: %w", err)
+	}
+	if err := c.SLO.validate(); err != nil {
+		return fmt.Errorf("slo.%w", err)
+	}
+	if c.GRPC.Port < 0 || c.GRPC.Port > 65535 {
+		return fmt.Errorf("grpc.port must be between 0 and 65535, got %d", c.GRPC.Port)
+	}
+	if c.GRPC.Port != 0 && c.GRPC.Port == c.Metrics.Port {
+		return fmt.Errorf("grpc.port must differ from metrics.port %d", c.Metrics.Port)
+	}
+	if c.Redirects.Max < 0 {
+		return fmt.Errorf("redirects.max must not be negative, got %d", c.Redirects.Max)
+	}
+	if c.ListPageSize < 0 {
+		return fmt.Errorf("listPageSize must not be negative, got %d", c.ListPageSize)
+	}
+	if _, err := labels.Parse(c.AppSelector); err != nil {
+		return fmt.Errorf("applicationSelector: %w", err)
+	}
+	if err := c.Policy.validate(); err != nil {
+		return fmt.Errorf("policy.%w", err)
+	}
+	if c.GitSources.Enabled && c.GitSources.CacheTTL <= 0 {
+		return fmt.Errorf("gitSources.cacheTTL must be positive, got %s", c.GitSources.CacheTTL)
+	}
+	for i, h := range c.Helmfiles {
+		if h.Path == "" && h.RepoURL == "" {
+			return fmt.Errorf("helmfiles[%d]: path is required", i)
+		}
+	}
+	if err := c.Output.validate(); err != nil {
+		return fmt.Errorf("output.%w", err)
+	}
+	if err := c.StatusFilter.validate(); err != nil {
+		return fmt.Errorf("statusFilter.%w", err)
+	}
+	if err := c.Exclusions.validate(); err != nil {
+		return fmt.Errorf("exclusions.%w", err)
+	}
+	switch c.Reports.ConfigMap.Format {
+	case "yaml", "json":
+	default:
+		return fmt.Errorf("reports.configMap.format must be yaml or json, got %q", c.Reports.ConfigMap.Format)
+	}
+	if c.Reports.Directory.Retention < 0 {
+		return fmt.Errorf("reports.directory.retention must not be negative, got %s", c.Reports.Directory.Retention)
+	}
+	if err := c.Reports.Upload.validate(); err != nil {
+		return fmt.Errorf("reports.upload.%w", err)
+	}
+	if c.History.Cycles < 0 {
+		return fmt.Errorf("history.cycles must not be negative, got %d", c.History.Cycles)
+	}
+	switch c.Collection {
+	case collectionInterval, collectionScrape:
+	default:
+		return fmt.Errorf("collection must be %q or %q, got %q", collectionInterval, collectionScrape, c.Collection)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", c.Interval)
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	switch c.LogFormat {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unsupported log format %q", c.LogFormat)
+	}
+	if c.Metrics.Port < 1 || c.Metrics.Port > 65535 {
+		return fmt.Errorf("metrics.port must be between 1 and 65535, got %d", c.Metrics.Port)
+	}
+	if !strings.HasPrefix(c.Metrics.Path, "/") {
+		return fmt.Errorf("metrics.path must start with /, got %q", c.Metrics.Path)
+	}
+	if tls := c.Metrics.TLS; (tls.CertFile == "") != (tls.KeyFile == "") {
+		return errors.New("metrics.tls requires both certFile and keyFile")
+	}
+	if c.Metrics.TLS.ClientCAFile != "" && c.Metrics.TLS.CertFile == "" {
+		return errors.New("metrics.tls.clientCAFile requires certFile and keyFile")
+	}
+	if c.Pushgateway.URL != "" && c.Pushgateway.Job == "" {
+		return errors.New("pushgateway.job is required")
+	}
+	if c.Schedule.StartupJitter < 0 {
+		return fmt.Errorf("schedule.startupJitter must not be negative, got %s", c.Schedule.StartupJitter)
+	}
+	if j := c.Schedule.Jitter; j < 0 || j >= 1 {
+		return fmt.Errorf("schedule.jitter must be at least 0 and below 1, got %g", j)
+	}
+	if s := c.Schedule.Spread; s < 0 || s > 1 {
+		return fmt.Errorf("schedule.spread must be between 0 and 1, got %g", s)
+	}
+	if c.Schedule.RequestBudget < 0 {
+		return fmt.Errorf("schedule.requestBudget must not be negative, got %d", c.Schedule.RequestBudget)
+	}
+	if c.Schedule.Cron != "" {
+		if c.Collection == collectionScrape {
+			return errors.New("schedule.cron does not apply to scrape collection")
+		}
+		if err := c.Schedule.validateCron(); err != nil {
+			return err
+		}
+	} else if c.Schedule.TimeZone != "" {
+		return errors.New("schedule.timeZone requires schedule.cron")
+	}
+	if c.Sharding.Shards < 1 {
+		return fmt.Errorf("sharding.shards must be at least 1, got %d", c.Sharding.Shards)
+	}
+	if c.Sharding.Index < 0 || c.Sharding.Index >= c.Sharding.Shards {
+		return fmt.Errorf("sharding.index must be between 0 and %d, got %d", c.Sharding.Shards-1, c.Sharding.Index)
+	}
+	switch c.Instance.From {
+	case "", "namespace", "cluster", "cluster/namespace":
+	default:
+		return fmt.Errorf("unsupported instance.from %q", c.Instance.From)
+	}
+	if c.RemoteWrite.URL != "" && (c.RemoteWrite.BearerToken != "" || c.RemoteWrite.BearerTokenFile != "") && c.RemoteWrite.BasicAuth.Username != "" {
+		return errors.New("remoteWrite accepts either a bearer token or basic auth, not both")
+	}
+	switch c.StatsD.Format {
+	case "dogstatsd", "statsd":
+	default:
+		return fmt.Errorf("unsupported statsd.format %q", c.StatsD.Format)
+	}
+	if c.StatsD.Address != "" {
+		if _, _, err := net.SplitHostPort(c.StatsD.Address); err != nil {
+			return fmt.Errorf("invalid statsd.address: %w", err)
+		}
+	}
+	switch c.OTLP.Protocol {
+	case "grpc", "http":
+	default:
+		return fmt.Errorf("unsupported otlp.protocol %q", c.OTLP.Protocol)
+	}
+	if c.OTLP.Metrics.Enabled && c.OTLP.Metrics.Interval <= 0 {
+		return fmt.Errorf("otlp.metrics.interval must be positive, got %s", c.OTLP.Metrics.Interval)
+	}
+	if r := c.OTLP.Traces.SampleRatio; r < 0 || r > 1 {
+		return fmt.Errorf("otlp.traces.sampleRatio must be between 0 and 1, got %g", r)
+	}
+	for i, repo := range c.Repositories {
+		if repo.URL == "" {
+			return fmt.Errorf("repositories[%d]: url is required", i)
+		}
+		switch repo.API {
+		case "", "chartmuseum", "harbor", "artifactory", "nexus", "github":
+		default:
+			return fmt.Errorf("repositories[%d]: unsupported api %q", i, repo.API)
+		}
+		switch repo.Ordering {
+		case "", "semver", check.OrderingCreated:
+		default:
+			return fmt.Errorf("repositories[%d]: ordering must be semver or created, got %q", i, repo.Ordering)
+		}
+		switch repo.GitProvider {
+		case "", "github", "gitlab", "bitbucket":
+		default:
+			return fmt.Errorf("repositories[%d]: gitProvider must be github, gitlab or bitbucket, got %q", i, repo.GitProvider)
+		}
+		if repo.OAuth2.TokenURL != "" {
+			if u, err := url.Parse(repo.OAuth2.TokenURL); err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("repositories[%d]: oauth2.tokenURL must be an absolute URL, got %q", i, repo.OAuth2.TokenURL)
+			}
+			if repo.OAuth2.ClientID == "" {
+				return fmt.Errorf("repositories[%d]: oauth2.clientID is required", i)
+			}
+			if repo.hasToken() || repo.Username != "" {
+				return fmt.Errorf("repositories[%d]: oauth2 replaces token and username, set only one", i)
+			}
+		}
+		if repo.Proxy != "" {
+			if u, err := url.Parse(repo.Proxy); err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("repositories[%d]: proxy must be an absolute URL, got %q", i, repo.Proxy)
+			}
+		}
+	}
+	for i, m := range c.Mirrors {
+		if m.From == "" || m.To == "" {
+			return fmt.Errorf("mirrors[%d]: from and to are required", i)
+		}
+	}
+	if c.Notifiers.DedupeWindow < 0 {
+		return errors.New("notifiers.dedupeWindow must not be negative")
+	}
+	for i, hook := range c.Notifiers.Webhooks {
+		if err := hook.validate(); err != nil {
+			return fmt.Errorf("notifiers.webhooks[%d]: %w", i, err)
+		}
+	}
+	for i, pd := range c.Notifiers.PagerDuty {
+		if err := pd.validate(); err != nil {
+			return fmt.Errorf("notifiers.pagerDuty[%d]: %w", i, err)
+		}
+	}
+	for i, og := range c.Notifiers.Opsgenie {
+		if err := og.validate(); err != nil {
+			return fmt.Errorf("notifiers.opsgenie[%d]: %w", i, err)
+		}
+	}
+	for i, gh := range c.Notifiers.GitHubIssues {
+		if err := gh.validate(); err != nil {
+			return fmt.Errorf("notifiers.githubIssues[%d]: %w", i, err)
+		}
+	}
+	for i, k := range c.Notifiers.Kafka {
+		if err := k.validate(); err != nil {
+			return fmt.Errorf("notifiers.kafka[%d]: %w", i, err)
+		}
+	}
+	for i, n := range c.Notifiers.NATS {
+		if err := n.validate(); err != nil {
+			return fmt.Errorf("notifiers.nats[%d]: %w", i, err)
+		}
+	}
+	for i, w := range c.Notifiers.MaintenanceWindows {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("notifiers.maintenanceWindows[%d]: %w", i, err)
+		}
+	}
+	for i, am := range c.Notifiers.Alertmanager {
+		if am.URL == "" {
+			return fmt.Errorf("notifiers.alertmanager[%d]: url is required", i)
+		}
+		if err := am.Policy.validate(); err != nil {
+			return fmt.Errorf("notifiers.alertmanager[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// repositoryFor returns the credentials of the longest configured URL
+// matching repoURL on its scheme, host and path segments, or nil
+func (c *config) repositoryFor(repoURL string) *repositoryConfig {
+	var match *repositoryConfig
+	for i := range c.Repositories {
+		repo := &c.Repositories[i]
+		if urlWithin(repoURL, repo.URL) && (match == nil || len(repo.URL) > len(match.URL)) {
+			match = repo
+		}
+	}
+	return match
+}
+
+// validate checks the ordering of versions that are not semver and compiles
+// the rules
+func (p policyConfig) validate() error {
+	if p.GracePeriod < 0 {
+		return fmt.Errorf("gracePeriod must not be negative, got %s", p.GracePeriod)
+	}
+	switch p.NonSemverOrdering {
+	case "", check.OrderingLenient, check.OrderingLexical, check.OrderingCreated:
+	default:
+		return fmt.Errorf("nonSemverOrdering must be %s, %s or %s, got %q", check.OrderingLenient, check.OrderingLexical, check.OrderingCreated, p.NonSemverOrdering)
+	}
+	switch p.PrereleaseOrder {
+	case "", "before", "after":
+	default:
+		return fmt.Errorf("prereleaseOrder must be before or after, got %q", p.PrereleaseOrder)
+	}
+	switch p.BuildMetadata {
+	case "", "ignore", "compare":
+	default:
+		return fmt.Errorf("buildMetadata must be ignore or compare, got %q", p.BuildMetadata)
+	}
+	if len(p.Channels) > 0 && p.ChannelAnnotation == "" {
+		return errors.New("channels require channelAnnotation")
+	}
+	if _, err := policyRules(p.Rules); err != nil {
+		return err
+	}
+	return nil
+}
+
+// semver returns the ordering of semver versions of the policy
+func (p policyConfig) semver() check.SemverPolicy {
+	return check.SemverPolicy{
+		PrereleasesAfter:   p.PrereleaseOrder == "after",
+		CompareMetadata:    p.BuildMetadata == "compare",
+		StableTracksStable: p.StableTracksStable,
+		Lenient:            p.LenientSemver,
+	}
+}
+
+// validate checks the statuses against those Argo CD reports
+func (f statusFilterConfig) validate() error {
+	for _, status := range f.Sync {
+		switch status {
+		case "Synced", "OutOfSync", "Unknown":
+		default:
+			return fmt.Errorf("sync: unknown status %q", status)
+		}
+	}
+	for _, status := range f.Health {
+		switch status {
+		case "Healthy", "Progressing", "Degraded", "Suspended", "Missing", "Unknown":
+		default:
+			return fmt.Errorf("health: unknown status %q", status)
+		}
+	}
+	return nil
+}
+
+// matches reports whether an Application's status passes the filter
+func (f statusFilterConfig) matches(app unstructured.Unstructured) bool {
+	sync, _, _ := unstructured.NestedString(app.Object, "status", "sync", "status")
+	health, _, _ := unstructured.NestedString(app.Object, "status", "health", "status")
+	return (len(f.Sync) == 0 || slices.Contains(f.Sync, sync)) &&
+		(len(f.Health) == 0 || slices.Contains(f.Health, health))
+}
+
+// allows reports whether charts of repoURL are checked, matching entries on
+// scheme, host and whole path segments
+func (f repoFilterConfig) allows(repoURL string) bool {
+	for _, prefix := range f.Deny {
+		if urlWithin(repoURL, prefix) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, prefix := range f.Allow {
+		if urlWithin(repoURL, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasToken reports whether a bearer token is configured instead of basic auth
+func (r *repositoryConfig) hasToken() bool {
+	return r.Token != "" || r.TokenFile != "" || r.TokenEnv != ""
+}
+
+// token returns the bearer token, reading the token file or environment
+// variable if set
+func (r *repositoryConfig) token() (string, error) {
+	if r.TokenEnv != "" {
+		token := os.Getenv(r.TokenEnv)
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s of %s is empty", r.TokenEnv, r.URL)
+		}
+		return token, nil
+	}
+	if r.TokenFile == "" {
+		return r.Token, nil
+	}
+	data, err := os.ReadFile(r.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// sasToken returns the SAS token, reading the token file if set
+func (r *repositoryConfig) sasToken() (string, error) {
+	if r.SASTokenFile == "" {
+		return r.SASToken, nil
+	}
+	data, err := os.ReadFile(r.SASTokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// mirrorFor rewrites repoURL with the longest matching mirror prefix,
+// returning it unchanged when no mirror applies
+func (c *config) mirrorFor(repoURL string) string {
+	var match *mirrorConfig
+	for i := range c.Mirrors {
+		m := &c.Mirrors[i]
+		from := strings.TrimSuffix(m.From, "/")
+		rest, ok := strings.CutPrefix(repoURL, from)
+		if !ok || (rest != "" && rest[0] != '/') {
+			continue
+		}
+		if match == nil || len(from) > len(strings.TrimSuffix(match.From, "/")) {
+			match = m
+		}
+	}
+	if match == nil {
+		return repoURL
+	}
+	return strings.TrimSuffix(match.To, "/") + strings.TrimPrefix(repoURL, strings.TrimSuffix(match.From, "/"))
+}
+
+// credentials returns the username and password, reading the password file if set
+func (r *repositoryConfig) credentials() (string, string, error) {
+	if r.PasswordFile == "" {
+		return r.Username, r.Password, nil
+	}
+	data, err := os.ReadFile(r.PasswordFile)
+	if err != nil {
+		return "", "", err
+	}
+	return r.Username, strings.TrimSpace(string(data)), nil
+}
+
+// token returns the admin token, reading the token file if set
+func (a *adminConfig) token() (string, error) {
+	if a.TokenFile == "" {
+		return a.Token, nil
+	}
+	data, err := os.ReadFile(a.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// token returns the receiver token, reading the token file if set
+func (r *receiverConfig) token() (string, error) {
+	if r.TokenFile == "" {
+		return r.Token, nil
+	}
+	data, err := os.ReadFile(r.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// token returns the extension token, reading the token file if set
+func (e *extensionConfig) token() (string, error) {
+	if e.TokenFile == "" {
+		return e.Token, nil
+	}
+	data, err := os.ReadFile(e.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// credentials returns the username and password, reading the password file if set
+func (b *basicAuthConfig) credentials() (string, string, error) {
+	if b.PasswordFile == "" {
+		return b.Username, b.Password, nil
+	}
+	data, err := os.ReadFile(b.PasswordFile)
+	if err != nil {
+		return "", "", err
+	}
+	return b.Username, strings.TrimSpace(string(data)), nil
+}
+
+// owns reports whether an Application belongs to this replica's shard
+func (s shardingConfig) owns(appName string) bool {
+	if s.Shards <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(appName))
+	return int(h.Sum32()%uint32(s.Shards)) == s.Index
+}
+
+// resourceName returns the name of a resource each replica writes its own
+// of, suffixed with the shard index when sharded
+func (s shardingConfig) resourceName(name string) string {
+	if s.Shards <= 1 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, s.Index)
+}
+
+// name returns the instance of an Application read from namespace of cluster
+func (i instanceConfig) name(cluster, namespace string) string {
+	var name string
+	switch i.From {
+	case "namespace":
+		name = namespace
+	case "cluster":
+		name = cluster
+	case "cluster/namespace":
+		name = namespace
+		if cluster != "" {
+			name = cluster + "/" + namespace
+		}
+	}
+	if renamed, ok := i.Names[name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// excluded reports whether an application or chart is excluded from checks
+func (c *config) excluded(appName, chartName string) bool {
+	for _, name := range c.Exclusions.Applications {
+		if name == appName {
+			return true
+		}
+	}
+	for _, name := range c.Exclusions.Charts {
+		if name == chartName {
+			return true
+		}
+	}
+	return matchesPattern(c.Exclusions.ApplicationPatterns, appName) || matchesPattern(c.Exclusions.ChartPatterns, chartName)
+}
+
+// validate checks that the patterns are valid regular expressions
+func (e exclusionConfig) validate() error {
+	for i, expr := range e.ApplicationPatterns {
+		if _, err := compilePattern(expr); err != nil {
+			return fmt.Errorf("applicationPatterns[%d]: %w", i, err)
+		}
+	}
+	for i, expr := range e.ChartPatterns {
+		if _, err := compilePattern(expr); err != nil {
+			return fmt.Errorf("chartPatterns[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// exclusionPatterns caches compiled patterns by their expression
+var exclusionPatterns sync.Map
+
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	if re, ok := exclusionPatterns.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	exclusionPatterns.Store(expr, re)
+	return re, nil
+}
+
+// matchesPattern reports whether any of the patterns matches s; invalid
+// patterns are rejected when the config is validated and never match
+func matchesPattern(patterns []string, s string) bool {
+	for _, expr := range patterns {
+		if re, err := compilePattern(expr); err == nil && re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestRepositoryFor(t *testing.T) {
+	cfg := &config{Repositories: []repositoryConfig{
+		{URL: "https://charts.example.com", Username: "host"},
+		{URL: "https://charts.example.com/private/", Username: "private"},
+		{URL: "git@github.com:acme", Username: "git"},
+	}}
+	tests := []struct {
+		repoURL string
+		want    string
+	}{
+		{repoURL: "https://charts.example.com", want: "host"},
+		{repoURL: "https://charts.example.com/", want: "host"},
+		{repoURL: "https://charts.example.com/stable/nginx-1.0.0.tgz", want: "host"},
+		{repoURL: "https://CHARTS.example.com/stable", want: "host"},
+		{repoURL: "https://charts.example.com/private", want: "private"},
+		{repoURL: "https://charts.example.com/private/index.yaml", want: "private"},
+		{repoURL: "https://charts.example.com/privateer", want: "host"},
+		{repoURL: "https://charts.example.com.evil.io/stable", want: ""},
+		{repoURL: "https://charts.example.com@evil.io/stable", want: ""},
+		{repoURL: "https://charts.example.com:8443/stable", want: ""},
+		{repoURL: "http://charts.example.com/stable", want: ""},
+		{repoURL: "git@github.com:acme/charts.git", want: "git"},
+		{repoURL: "git@github.com:acme-evil/charts.git", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repoURL, func(t *testing.T) {
+			got := ""
+			if repo := cfg.repositoryFor(tt.repoURL); repo != nil {
+				got = repo.Username
+			}
+			if got != tt.want {
+				t.Errorf("repositoryFor(%q) = %q, want %q", tt.repoURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepoFilterAllows(t *testing.T) {
+	filter := repoFilterConfig{
+		Allow: []string{"https://charts.example.com", "registry.example.com/charts"},
+		Deny:  []string{"https://charts.example.com/incubator/"},
+	}
+	tests := []struct {
+		repoURL string
+		want    bool
+	}{
+		{repoURL: "https://charts.example.com", want: true},
+		{repoURL: "https://charts.example.com/stable", want: true},
+		{repoURL: "https://charts.example.com.attacker.net", want: false},
+		{repoURL: "https://charts.example.com@attacker.net/stable", want: false},
+		{repoURL: "https://charts.example.com/incubator", want: false},
+		{repoURL: "https://Charts.Example.com/incubator/", want: false},
+		{repoURL: "https://charts.example.com/incubator-graduated", want: true},
+		{repoURL: "registry.example.com/charts/nginx", want: true},
+		{repoURL: "registry.example.com/charts-evil/nginx", want: false},
+		{repoURL: "https://other.example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repoURL, func(t *testing.T) {
+			if got := filter.allows(tt.repoURL); got != tt.want {
+				t.Errorf("allows(%q) = %t, want %t", tt.repoURL, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+var configMapsGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// serviceAccountNamespaceFile holds the namespace of the pod
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// maxConfigMapSize is the limit the API server puts on ConfigMaps
+const maxConfigMapSize = 1 << 20
+
+// configMapReport is the content of the report ConfigMap
+type configMapReport struct {
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Summary     reportSummary `json:"summary"`
+	Results     []chartResult `json:"results"`
+}
+
+// writeConfigMapReport writes the results of a cycle into the configured
+// ConfigMap under report.yaml or report.json. Each shard writes its own
+// ConfigMap, named with its index.
+func writeConfigMapReport(ctx context.Context, cluster clusterClient, cfg configMapReportConfig, sharding shardingConfig, results []chartResult) error {
+	name := sharding.resourceName(cfg.Name)
+	namespace := cfg.Namespace
+	if namespace == "" {
+		var err error
+		if namespace, err = podNamespace(); err != nil {
+			return err
+		}
+	}
+
+	report := configMapReport{GeneratedAt: time.Now().UTC().Truncate(time.Second), Results: results}
+	if report.Results == nil {
+		report.Results = []chartResult{}
+	}
+	for _, r := range results {
+		report.Summary.add(r)
+	}
+	var (
+		content []byte
+		err     error
+	)
+	if cfg.Format == "json" {
+		content, err = json.MarshalIndent(report, "", "  ")
+	} else {
+		content, err = yaml.Marshal(report)
+	}
+	if err != nil {
+		return err
+	}
+	if len(content) > maxConfigMapSize {
+		return errors.New("report exceeds the ConfigMap size limit of 1MiB")
+	}
+
+	obj := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":      name,
+			"namespace": namespace,
+			"labels":    map[string]string{managedByLabel: fieldManager},
+		},
+		"data": map[string]string{"report." + cfg.Format: string(content)},
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = cluster.client.Resource(configMapsGVR).Namespace(namespace).Patch(ctx, name,
+		types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+	return err
+}
+
+// podNamespace returns the namespace the exporter runs in
+func podNamespace() (string, error) {
+	data, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("namespace is required outside a cluster: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+var (
+	// Fulcio certificate extensions carrying the OIDC issuer
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// cosignVerifier checks cosign signatures either against static public keys
+// or, for keyless signatures, against Fulcio roots and an expected identity.
+// Keyless signatures must come with a Rekor bundle whose signed entry
+// timestamp proves they were made while the short-lived certificate was
+// valid; the chain is validated at that time.
+type cosignVerifier struct {
+	publicKeys     []crypto.PublicKey
+	roots          *x509.CertPool
+	identity       string
+	identityRegexp *regexp.Regexp
+	issuer         string
+	rekorKey       crypto.PublicKey
+	// rekorLogID is the hex SHA-256 of the Rekor key, naming its log
+	rekorLogID string
+}
+
+// newCosignVerifier builds a verifier from the cosign settings, returning
+// nil when signature verification is not configured
+func newCosignVerifier(cfg cosignConfig) (*cosignVerifier, error) {
+	keyPath := cfg.PublicKey
+	rootsPath := cfg.FulcioRoots
+	if keyPath == "" && rootsPath == "" {
+		return nil, nil
+	}
+
+	v := &cosignVerifier{
+		identity: cfg.CertificateIdentity,
+		issuer:   cfg.CertificateOIDCIssuer,
+	}
+	if expr := cfg.CertificateIdentityRegexp; expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate identity regexp: %w", err)
+		}
+		v.identityRegexp = re
+	}
+
+	if keyPath != "" {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing public key in %s: %w", keyPath, err)
+			}
+			v.publicKeys = append(v.publicKeys, key)
+		}
+		if len(v.publicKeys) == 0 {
+			return nil, fmt.Errorf("no PEM public keys found in %s", keyPath)
+		}
+	}
+
+	if rootsPath != "" {
+		if v.identity == "" && v.identityRegexp == nil {
+			return nil, errors.New("keyless verification requires a certificate identity or identity regexp")
+		}
+		data, err := os.ReadFile(rootsPath)
+		if err != nil {
+			return nil, err
+		}
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", rootsPath)
+		}
+		if cfg.RekorPublicKey == "" {
+			return nil, errors.New("keyless verification requires the Rekor public key")
+		}
+		if data, err = os.ReadFile(cfg.RekorPublicKey); err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM public key found in %s", cfg.RekorPublicKey)
+		}
+		if v.rekorKey, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("parsing Rekor public key in %s: %w", cfg.RekorPublicKey, err)
+		}
+		logID := sha256.Sum256(block.Bytes)
+		v.rekorLogID = hex.EncodeToString(logID[:])
+	}
+	return v, nil
+}
+
+// Verify checks the cosign signature of a version of an OCI chart, as listed
+// by ociChartEntries
+func (v *cosignVerifier) Verify(ctx context.Context, repoURL, chartName string, entry repository.Entry) error {
+	ref, err := parseOCIReference(repoURL, chartName)
+	if err != nil {
+		return err
+	}
+	tag := ociTag(entry)
+	_, digest, err := ociClient.manifest(ctx, ref, tag)
+	if err != nil {
+		return fmt.Errorf("resolving tag %s: %w", tag, err)
+	}
+	if err := v.verify(ctx, ref, digest); err != nil {
+		return err
+	}
+	slog.Debug("Verified signature", "repository", ref.Repository, "tag", tag, "digest", digest)
+	return nil
+}
+
+// verify checks that the manifest digest has at least one valid cosign signature
+func (v *cosignVerifier) verify(ctx context.Context, ref ociReference, digest string) error {
+	sigTag := strings.Replace(digest, ":", "-", 1) + ".sig"
+	manifest, _, err := ociClient.manifest(ctx, ref, sigTag)
+	if err != nil {
+		return fmt.Errorf("no signature found: %w", err)
+	}
+
+	err = errors.New("signature manifest has no layers")
+	for _, layer := range manifest.Layers {
+		if err = v.verifyLayer(ctx, ref, digest, layer.Digest, layer.Annotations); err == nil {
+			return nil
+		}
+		slog.Debug("Signature layer rejected", "layer", layer.Digest, "digest", digest, "error", err)
+	}
+	return err
+}
+
+// verifyLayer verifies one signature layer of a cosign signature manifest
+func (v *cosignVerifier) verifyLayer(ctx context.Context, ref ociReference, digest, layerDigest string, annotations map[string]string) error {
+	sig, err := base64.StdEncoding.DecodeString(annotations[cosignSignatureAnnotation])
+	if err != nil || len(sig) == 0 {
+		return errors.New("missing or malformed signature annotation")
+	}
+	payload, err := ociClient.blob(ctx, ref, layerDigest)
+	if err != nil {
+		return err
+	}
+
+	var keys []crypto.PublicKey
+	if certPEM := annotations[cosignCertificateAnnotation]; certPEM != "" && v.roots != nil {
+		key, err := v.verifyKeyless(annotations, payload, sig)
+		if err != nil {
+			return err
+		}
+		keys = []crypto.PublicKey{key}
+	} else {
+		keys = v.publicKeys
+	}
+	if len(keys) == 0 {
+		return errors.New("no key available to verify signature")
+	}
+
+	verified := false
+	for _, key := range keys {
+		if verifySignature(key, payload, sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return errors.New("signature does not match any trusted key")
+	}
+
+	var simpleSigning struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+		return fmt.Errorf("malformed signature payload: %w", err)
+	}
+	if simpleSigning.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %s, not %s", simpleSigning.Critical.Image.DockerManifestDigest, digest)
+	}
+	return nil
+}
+
+// verifyKeyless returns the key of the certificate of a keyless signature
+// once its Rekor bundle shows sig over payload was logged while the
+// certificate was valid
+func (v *cosignVerifier) verifyKeyless(annotations map[string]string, payload, sig []byte) (crypto.PublicKey, error) {
+	entry, err := v.verifyBundle(annotations[cosignBundleAnnotation])
+	if err != nil {
+		return nil, err
+	}
+	signedAt := time.Unix(entry.IntegratedTime, 0)
+	cert, err := v.verifyCertificate(annotations[cosignCertificateAnnotation], annotations[cosignChainAnnotation], signedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := entry.matches(cert, payload, sig); err != nil {
+		return nil, err
+	}
+	return cert.PublicKey, nil
+}
+
+// rekorBundle is the transparency log entry cosign attaches to a keyless
+// signature, signed by Rekor
+type rekorBundle struct {
+	SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+	Payload              rekorPayload `json:"Payload"`
+}
+
+// rekorPayload is what the signed entry timestamp covers; its fields are in
+// the order of the canonical JSON Rekor signs
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// verifyBundle checks the signed entry timestamp of a Rekor bundle
+func (v *cosignVerifier) verifyBundle(bundleJSON string) (*rekorPayload, error) {
+	if bundleJSON == "" {
+		return nil, errors.New("keyless signature has no Rekor bundle")
+	}
+	var bundle rekorBundle
+	if err := json.Unmarshal([]byte(bundleJSON), &bundle); err != nil {
+		return nil, fmt.Errorf("malformed Rekor bundle: %w", err)
+	}
+	if bundle.Payload.LogID != v.rekorLogID {
+		return nil, fmt.Errorf("Rekor bundle is from log %s, not the trusted one", bundle.Payload.LogID)
+	}
+	canonical, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(v.rekorKey, canonical, bundle.SignedEntryTimestamp); err != nil {
+		return nil, fmt.Errorf("invalid Rekor signed entry timestamp: %w", err)
+	}
+	return &bundle.Payload, nil
+}
+
+// matches checks that the logged entry is a hashedrekord of sig over payload
+// made with cert
+func (p *rekorPayload) matches(cert *x509.Certificate, payload, sig []byte) error {
+	body, err := base64.StdEncoding.DecodeString(p.Body)
+	if err != nil {
+		return fmt.Errorf("malformed Rekor entry: %w", err)
+	}
+	var entry struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			Data struct {
+				Hash struct {
+					Algorithm string `json:"algorithm"`
+					Value     string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+			Signature struct {
+				Content   []byte `json:"content"`
+				PublicKey struct {
+					Content []byte `json:"content"`
+				} `json:"publicKey"`
+			} `json:"signature"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return fmt.Errorf("malformed Rekor entry: %w", err)
+	}
+	digest := sha256.Sum256(payload)
+	hash := entry.Spec.Data.Hash
+	if entry.Kind != "hashedrekord" || hash.Algorithm != "sha256" || hash.Value != hex.EncodeToString(digest[:]) {
+		return errors.New("Rekor entry is not for this signature payload")
+	}
+	if string(entry.Spec.Signature.Content) != string(sig) {
+		return errors.New("Rekor entry is for another signature")
+	}
+	block, _ := pem.Decode(entry.Spec.Signature.PublicKey.Content)
+	if block == nil || string(block.Bytes) != string(cert.Raw) {
+		return errors.New("Rekor entry is for another certificate")
+	}
+	return nil
+}
+
+// verifyCertificate validates a keyless signing certificate against the
+// configured roots at the time it was used and the identity constraints
+func (v *cosignVerifier) verifyCertificate(certPEM, chainPEM string, signedAt time.Time) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil, errors.New("malformed signing certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(chainPEM))
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted signing certificate: %w", err)
+	}
+
+	var identities []string
+	identities = append(identities, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		identities = append(identities, u.String())
+	}
+	matched := false
+	for _, id := range identities {
+		if id == v.identity || (v.identityRegexp != nil && v.identityRegexp.MatchString(id)) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return nil, fmt.Errorf("certificate identities %v do not match", identities)
+	}
+
+	if v.issuer != "" {
+		if issuer := certificateIssuer(cert); issuer != v.issuer {
+			return nil, fmt.Errorf("certificate issuer %q does not match %q", issuer, v.issuer)
+		}
+	}
+	return cert, nil
+}
+
+// certificateIssuer returns the OIDC issuer recorded in a Fulcio certificate
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(oidFulcioIssuer):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+// verifySignature checks sig over payload with an ECDSA, RSA, or Ed25519 key
+func verifySignature(key crypto.PublicKey, payload, sig []byte) error {
+	digest := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return rsa.VerifyPSS(k, crypto.SHA256, digest[:], sig, nil)
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
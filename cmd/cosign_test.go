@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyKeyless(t *testing.T) {
+	issued := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fulcio"},
+		NotBefore:             issued.Add(-time.Hour),
+		NotAfter:              issued.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := x509.ParseCertificate(rootDER)
+
+	signerKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		NotBefore:      issued,
+		NotAfter:       issued.Add(10 * time.Minute),
+		EmailAddresses: []string{"release@example.com"},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}, root, &signerKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}))
+
+	rekorKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rekorDER, _ := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+	logID := sha256.Sum256(rekorDER)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	v := &cosignVerifier{
+		roots:      roots,
+		identity:   "release@example.com",
+		rekorKey:   &rekorKey.PublicKey,
+		rekorLogID: hex.EncodeToString(logID[:]),
+	}
+
+	payload := []byte(`{"critical":{"image":{"docker-manifest-digest":"sha256:0"}}}`)
+	payloadDigest := sha256.Sum256(payload)
+	sig, _ := ecdsa.SignASN1(rand.Reader, signerKey, payloadDigest[:])
+
+	bundle := func(signedAt time.Time, sig []byte, tamper bool) string {
+		body, _ := json.Marshal(map[string]any{
+			"kind": "hashedrekord",
+			"spec": map[string]any{
+				"data":      map[string]any{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(payloadDigest[:])}},
+				"signature": map[string]any{"content": sig, "publicKey": map[string]any{"content": []byte(certPEM)}},
+			},
+		})
+		p := rekorPayload{
+			Body:           base64.StdEncoding.EncodeToString(body),
+			IntegratedTime: signedAt.Unix(),
+			LogID:          v.rekorLogID,
+			LogIndex:       42,
+		}
+		canonical, _ := json.Marshal(p)
+		digest := sha256.Sum256(canonical)
+		set, _ := ecdsa.SignASN1(rand.Reader, rekorKey, digest[:])
+		if tamper {
+			p.IntegratedTime++
+		}
+		data, _ := json.Marshal(rekorBundle{SignedEntryTimestamp: set, Payload: p})
+		return string(data)
+	}
+	otherSig, _ := ecdsa.SignASN1(rand.Reader, signerKey, payloadDigest[:])
+
+	tests := []struct {
+		name    string
+		bundle  string
+		wantErr string
+	}{
+		{name: "logged while valid", bundle: bundle(issued.Add(time.Minute), sig, false)},
+		{name: "no bundle", wantErr: "no Rekor bundle"},
+		{name: "logged after expiry", bundle: bundle(issued.Add(time.Hour), sig, false), wantErr: "untrusted signing certificate"},
+		{name: "logged before issue", bundle: bundle(issued.Add(-time.Minute), sig, false), wantErr: "untrusted signing certificate"},
+		{name: "tampered timestamp", bundle: bundle(issued.Add(time.Minute), sig, true), wantErr: "invalid Rekor signed entry timestamp"},
+		{name: "other signature", bundle: bundle(issued.Add(time.Minute), otherSig, false), wantErr: "another signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{cosignCertificateAnnotation: certPEM}
+			if tt.bundle != "" {
+				annotations[cosignBundleAnnotation] = tt.bundle
+			}
+			_, err := v.verifyKeyless(annotations, payload, sig)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("verifyKeyless() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("verifyKeyless() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var helmVersionChecksGVR = schema.GroupVersionResource{
+	Group:    "helmversioncheck.io",
+	Version:  "v1alpha1",
+	Resource: "helmversionchecks",
+}
+
+// helmVersionCheckSpec is the spec of a HelmVersionCheck resource. Its
+// fields use the names of the config file.
+type helmVersionCheckSpec struct {
+	Namespaces []string        `yaml:"namespaces"`
+	Selector   string          `yaml:"selector"`
+	Policy     policyConfig    `yaml:"policy"`
+	Exclusions exclusionConfig `yaml:"exclusions"`
+	Notifiers  notifiersConfig `yaml:"notifiers"`
+}
+
+// checkScope is a set of Applications checked with the same settings: those
+// of the config, or of a HelmVersionCheck resource named namespace/name
+type checkScope struct {
+	name string
+	cfg  *config
+}
+
+// namespaces returns the namespaces of a cluster the scope reads
+// Applications from
+func (s checkScope) namespaces(cluster clusterClient) []string {
+	if s.name == "" && len(cluster.namespaces) > 0 {
+		return cluster.namespaces
+	}
+	return s.cfg.Namespaces
+}
+
+// checkScopes returns the scopes checked in a cluster: the config itself, or
+// one per HelmVersionCheck resource when custom resources are enabled
+func checkScopes(ctx context.Context, cluster clusterClient, cfg *config) ([]checkScope, error) {
+	if !cfg.CustomResources.Enabled {
+		return []checkScope{{cfg: cfg}}, nil
+	}
+	list, err := cluster.client.Resource(helmVersionChecksGVR).Namespace(cfg.CustomResources.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing HelmVersionCheck resources: %w", err)
+	}
+	var scopes []checkScope
+	resources := make(map[string]checkResource)
+	for _, obj := range list.Items {
+		name := obj.GetNamespace() + "/" + obj.GetName()
+		spec, err := decodeHelmVersionCheck(obj, cfg.CustomResources)
+		if err != nil {
+			slog.Error("Ignoring invalid HelmVersionCheck", "cluster", cluster.name, "name", name, "error", err)
+			continue
+		}
+		scopes = append(scopes, checkScope{name: name, cfg: spec.apply(cfg, obj.GetNamespace())})
+		resources[name] = checkResource{
+			cluster:    cluster,
+			namespace:  obj.GetNamespace(),
+			name:       obj.GetName(),
+			generation: obj.GetGeneration(),
+			webhooks:   spec.Notifiers.Webhooks,
+		}
+	}
+	checkResources.set(cluster.name, resources)
+	slog.Debug("Listed HelmVersionCheck resources", "cluster", cluster.name, "count", len(scopes))
+	return scopes, nil
+}
+
+// decodeHelmVersionCheck reads and validates the spec of a resource, which
+// may only check the namespaces and post to the webhooks granted by crd
+func decodeHelmVersionCheck(obj unstructured.Unstructured, crd crdConfig) (helmVersionCheckSpec, error) {
+	var spec helmVersionCheckSpec
+	data, err := json.Marshal(obj.Object["spec"])
+	if err != nil {
+		return spec, err
+	}
+	// YAML is a superset of JSON, so the yaml tags of the config types apply
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("decoding spec: %w", err)
+	}
+	for _, namespace := range spec.Namespaces {
+		if !crd.allowsNamespace(obj.GetNamespace(), namespace) {
+			return spec, fmt.Errorf("namespaces: %s is not granted to resources of %s", namespace, obj.GetNamespace())
+		}
+	}
+	if _, err := labels.Parse(spec.Selector); err != nil {
+		return spec, fmt.Errorf("selector: %w", err)
+	}
+	if err := spec.Policy.validate(); err != nil {
+		return spec, fmt.Errorf("policy.%w", err)
+	}
+	if err := spec.Exclusions.validate(); err != nil {
+		return spec, fmt.Errorf("exclusions.%w", err)
+	}
+	if len(spec.Notifiers.PagerDuty) > 0 || len(spec.Notifiers.Opsgenie) > 0 || len(spec.Notifiers.Alertmanager) > 0 ||
+		len(spec.Notifiers.GitHubIssues) > 0 {
+		return spec, errors.New("notifiers: only webhooks are supported")
+	}
+	if len(spec.Notifiers.MaintenanceWindows) > 0 {
+		return spec, errors.New("notifiers.maintenanceWindows: only supported in the exporter config")
+	}
+	for i, hook := range spec.Notifiers.Webhooks {
+		// Template files are not read from the exporter's file system
+		if hook.TemplateFile != "" {
+			return spec, fmt.Errorf("notifiers.webhooks[%d]: templateFile is not supported, use template", i)
+		}
+		if err := hook.validate(); err != nil {
+			return spec, fmt.Errorf("notifiers.webhooks[%d]: %w", i, err)
+		}
+		if !crd.allowsWebhook(hook.URL) {
+			return spec, fmt.Errorf("notifiers.webhooks[%d]: url is not in customResources.webhookURLs", i)
+		}
+	}
+	return spec, nil
+}
+
+// apply returns a copy of cfg scoped to a resource of namespace. Namespaces
+// default to that of the resource.
+func (s helmVersionCheckSpec) apply(cfg *config, namespace string) *config {
+	scoped := *cfg
+	scoped.Namespaces = []string{namespace}
+	if len(s.Namespaces) > 0 {
+		scoped.Namespaces = s.Namespaces
+	}
+	scoped.AppSelector = s.Selector
+	scoped.Policy = s.Policy
+	scoped.Exclusions = s.Exclusions
+	return &scoped
+}
+
+// checkResource is a HelmVersionCheck resource found in the last cycle
+type checkResource struct {
+	cluster    clusterClient
+	namespace  string
+	name       string
+	generation int64
+	webhooks   []webhookConfig
+}
+
+// checkRegistry holds the resources of every cluster for notifications and
+// status updates
+type checkRegistry struct {
+	mu        sync.Mutex
+	resources map[string]map[string]checkResource
+}
+
+var checkResources = &checkRegistry{resources: make(map[string]map[string]checkResource)}
+
+// set replaces the resources of a cluster
+func (c *checkRegistry) set(cluster string, resources map[string]checkResource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resources[cluster] = resources
+}
+
+// webhooks returns the webhooks of the resource a result was checked for
+func (c *checkRegistry) webhooks(r chartResult) []webhookConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resources[r.Cluster][r.Check].webhooks
+}
+
+// helmVersionCheckStatus is the status written to a resource after a cycle
+type helmVersionCheckStatus struct {
+	ObservedGeneration int64     `json:"observedGeneration"`
+	LastCheckTime      time.Time `json:"lastCheckTime"`
+	Applications       int       `json:"applications"`
+	Charts             int       `json:"charts"`
+	Outdated           int       `json:"outdated"`
+}
+
+// updateStatus writes a summary of the results to every resource
+func (c *checkRegistry) updateStatus(ctx context.Context, results []chartResult) {
+	statuses := make(map[string]*helmVersionCheckStatus)
+	apps := make(map[string]map[string]bool)
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, r := range results {
+		if r.Check == "" {
+			continue
+		}
+		key := r.Cluster + "|" + r.Check
+		status := statuses[key]
+		if status == nil {
+			status = &helmVersionCheckStatus{}
+			statuses[key] = status
+			apps[key] = make(map[string]bool)
+		}
+		apps[key][r.Application] = true
+		status.Charts++
+		if r.outdated() {
+			status.Outdated++
+		}
+	}
+
+	c.mu.Lock()
+	var resources []checkResource
+	for _, byName := range c.resources {
+		for _, resource := range byName {
+			resources = append(resources, resource)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, resource := range resources {
+		key := resource.cluster.name + "|" + resource.namespace + "/" + resource.name
+		status := statuses[key]
+		if status == nil {
+			status = &helmVersionCheckStatus{}
+		}
+		status.ObservedGeneration = resource.generation
+		status.LastCheckTime = now
+		status.Applications = len(apps[key])
+		patch, err := json.Marshal(map[string]any{"status": status})
+		if err != nil {
+			slog.Error("Error encoding HelmVersionCheck status", "error", err)
+			continue
+		}
+		_, err = resource.cluster.client.Resource(helmVersionChecksGVR).Namespace(resource.namespace).
+			Patch(ctx, resource.name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+		if err != nil {
+			slog.Error("Error updating HelmVersionCheck status", "cluster", resource.cluster.name,
+				"namespace", resource.namespace, "name", resource.name, "error", err)
+		}
+	}
+}
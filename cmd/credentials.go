@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialHelperTTL bounds how long credential helper output is reused
+const credentialHelperTTL = 10 * time.Minute
+
+// dockerConfig is the subset of ~/.docker/config.json holding registry credentials
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+type helperCredentials struct {
+	username, password string
+	fetched            time.Time
+}
+
+var (
+	helperCacheMu sync.Mutex
+	helperCache   = map[string]helperCredentials{}
+)
+
+// fileCredentials looks up credentials for the host of repoURL in the
+// Docker config for oci:// repositories and in .netrc, returning empty
+// strings when neither has an entry
+func fileCredentials(cfg credentialsConfig, repoURL string) (string, string, error) {
+	host := repoHost(repoURL)
+	if host == "" {
+		return "", "", nil
+	}
+	if isOCIRepo(repoURL) {
+		username, password, err := dockerCredentials(cfg.dockerConfigFile(), host)
+		if err != nil || username != "" || password != "" {
+			return username, password, err
+		}
+	}
+	return netrcCredentials(cfg.netrcFile(), host)
+}
+
+// cloudCredentials logs in to the managed registry hosting an oci://
+// repository with the workload's cloud identity, when enabled for that cloud
+func cloudCredentials(ctx context.Context, cfg credentialsConfig, repoURL string) (string, string, bool, error) {
+	if !isOCIRepo(repoURL) {
+		return "", "", false, nil
+	}
+	var (
+		username, password string
+		err                error
+	)
+	host := repoHost(repoURL)
+	switch {
+	case cfg.ECR && ecrHostPattern.MatchString(host):
+		username, password, err = ecrTokens.credentials(ctx, host)
+	case cfg.GAR && isGARHost(host):
+		username, password, err = garCredentials()
+	case cfg.ACR && isACRHost(host):
+		username, password, err = acrTokens.credentials(ctx, host)
+	default:
+		return "", "", false, nil
+	}
+	return username, password, err == nil, err
+}
+
+// repoHost returns the host name of an http(s) or oci:// repository URL
+func repoHost(repoURL string) string {
+	if isOCIRepo(repoURL) {
+		host, _, _ := strings.Cut(strings.TrimPrefix(repoURL, "oci://"), "/")
+		return host
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// netrcFile returns the configured .netrc path, $NETRC or ~/.netrc
+func (c credentialsConfig) netrcFile() string {
+	if c.NetrcFile != "" {
+		return c.NetrcFile
+	}
+	if v := os.Getenv("NETRC"); v != "" {
+		return v
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// dockerConfigFile returns the configured Docker config path,
+// $DOCKER_CONFIG/config.json or ~/.docker/config.json
+func (c credentialsConfig) dockerConfigFile() string {
+	if c.DockerConfigFile != "" {
+		return c.DockerConfigFile
+	}
+	if v := os.Getenv("DOCKER_CONFIG"); v != "" {
+		return filepath.Join(v, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// netrcCredentials returns the login and password of the machine entry for
+// host, or of the default entry
+func netrcCredentials(path, host string) (string, string, error) {
+	if path == "" {
+		return "", "", nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	hostname := strings.Split(host, ":")[0]
+
+	var (
+		machine, login, password string
+		matched, inDefault       bool
+		defLogin, defPassword    string
+	)
+	fields := strings.Fields(string(data))
+	for i := 0; i < len(fields); i++ {
+		next := func() string {
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+		switch fields[i] {
+		case "machine":
+			if matched {
+				return login, password, nil
+			}
+			machine, inDefault = next(), false
+			matched = machine == host || machine == hostname
+			login, password = "", ""
+		case "default":
+			if matched {
+				return login, password, nil
+			}
+			inDefault = true
+		case "login":
+			if v := next(); matched {
+				login = v
+			} else if inDefault {
+				defLogin = v
+			}
+		case "password":
+			if v := next(); matched {
+				password = v
+			} else if inDefault {
+				defPassword = v
+			}
+		case "account":
+			next()
+		case "macdef":
+			// macro definitions run until an empty line, which Fields hides;
+			// stop here since they conventionally come last
+			if matched {
+				return login, password, nil
+			}
+			return defLogin, defPassword, nil
+		}
+	}
+	if matched {
+		return login, password, nil
+	}
+	return defLogin, defPassword, nil
+}
+
+// dockerCredentials returns the credentials for registry from the Docker
+// config, running its credential helper when one is configured
+func dockerCredentials(path, registry string) (string, string, error) {
+	if path == "" {
+		return "", "", nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	var dc dockerConfig
+	if err := json.Unmarshal(data, &dc); err != nil {
+		return "", "", fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	keys := []string{registry}
+	if registry == "registry-1.docker.io" {
+		keys = append(keys, "docker.io", "index.docker.io", "https://index.docker.io/v1/")
+	}
+	for _, key := range keys {
+		if helper := dc.CredHelpers[key]; helper != "" {
+			return helperGet(helper, key)
+		}
+	}
+	for key, entry := range dc.Auths {
+		if !slices.Contains(keys, key) && !slices.Contains(keys, normalizeRegistry(key)) {
+			continue
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return "", "", fmt.Errorf("decoding auth for %s in %s: %w", key, path, err)
+			}
+			username, password, _ := strings.Cut(string(decoded), ":")
+			return username, password, nil
+		}
+		if entry.Username != "" || entry.Password != "" {
+			return entry.Username, entry.Password, nil
+		}
+	}
+	if dc.CredsStore != "" {
+		return helperGet(dc.CredsStore, keys[len(keys)-1])
+	}
+	return "", "", nil
+}
+
+// normalizeRegistry strips the scheme and path from a Docker config key
+func normalizeRegistry(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host, _, _ := strings.Cut(key, "/")
+	return host
+}
+
+// helperGet runs docker-credential-<helper> get for server, reusing
+// recent output; a server unknown to the helper yields empty credentials
+func helperGet(helper, server string) (string, string, error) {
+	cacheKey := helper + "|" + server
+	helperCacheMu.Lock()
+	cached, ok := helperCache[cacheKey]
+	helperCacheMu.Unlock()
+	if ok && time.Since(cached.fetched) < credentialHelperTTL {
+		return cached.username, cached.password, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("running docker-credential-%s: %w: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+	var out struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return "", "", fmt.Errorf("parsing docker-credential-%s output: %w", helper, err)
+	}
+
+	helperCacheMu.Lock()
+	helperCache[cacheKey] = helperCredentials{username: out.Username, password: out.Secret, fetched: time.Now()}
+	helperCacheMu.Unlock()
+	return out.Username, out.Secret, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// keyChange describes a key whose value (or schema type) differs between versions
+type keyChange struct {
+	Key  string      `json:"key"`
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// keyRename pairs a removed key with the added key that most likely replaced it
+type keyRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// keyDiff lists the differences between two flattened key sets
+type keyDiff struct {
+	Added   []string    `json:"added"`
+	Removed []string    `json:"removed"`
+	Renamed []keyRename `json:"renamed"`
+	Changed []keyChange `json:"changed"`
+}
+
+// valuesDiff compares the default values and values schema of two chart versions
+type valuesDiff struct {
+	Chart   string   `json:"chart"`
+	RepoURL string   `json:"repoURL"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Values  keyDiff  `json:"values"`
+	Schema  *keyDiff `json:"schema,omitempty"`
+}
+
+// diffChartValues downloads two versions of a chart and diffs their
+// values.yaml and values.schema.json
+func diffChartValues(ctx context.Context, repoURL, chartName, from, to string) (*valuesDiff, error) {
+	files := make([]map[string][]byte, 2)
+	for i, version := range []string{from, to} {
+		archive, err := downloadChartArchive(ctx, repoURL, chartName, version)
+		if err != nil {
+			return nil, err
+		}
+		files[i], err = readChartFiles(archive, "values.yaml", "values.schema.json")
+		if err != nil {
+			return nil, fmt.Errorf("reading %s %s: %w", chartName, version, err)
+		}
+	}
+
+	diff := &valuesDiff{Chart: chartName, RepoURL: repoURL, From: from, To: to}
+	oldValues, err := flattenValues(files[0]["values.yaml"])
+	if err != nil {
+		return nil, fmt.Errorf("parsing values.yaml of %s: %w", from, err)
+	}
+	newValues, err := flattenValues(files[1]["values.yaml"])
+	if err != nil {
+		return nil, fmt.Errorf("parsing values.yaml of %s: %w", to, err)
+	}
+	diff.Values = diffKeys(oldValues, newValues)
+
+	oldSchema, hasOld := files[0]["values.schema.json"]
+	newSchema, hasNew := files[1]["values.schema.json"]
+	if hasOld || hasNew {
+		oldTypes, err := flattenSchema(oldSchema)
+		if err != nil {
+			return nil, fmt.Errorf("parsing values.schema.json of %s: %w", from, err)
+		}
+		newTypes, err := flattenSchema(newSchema)
+		if err != nil {
+			return nil, fmt.Errorf("parsing values.schema.json of %s: %w", to, err)
+		}
+		schemaDiff := diffKeys(oldTypes, newTypes)
+		diff.Schema = &schemaDiff
+	}
+	return diff, nil
+}
+
+// flattenValues turns a values.yaml document into dotted key paths mapped to leaf values
+func flattenValues(data []byte) (map[string]interface{}, error) {
+	var values interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	flat := make(map[string]interface{})
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		m, ok := v.(map[interface{}]interface{})
+		if !ok || len(m) == 0 {
+			if prefix != "" {
+				flat[prefix] = jsonCompatible(v)
+			}
+			return
+		}
+		for k, child := range m {
+			key := fmt.Sprint(k)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			walk(key, child)
+		}
+	}
+	walk("", values)
+	return flat, nil
+}
+
+// jsonCompatible converts the interface-keyed maps produced by yaml.v2 into
+// string-keyed maps so values can be encoded as JSON
+func jsonCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, child := range t {
+			m[fmt.Sprint(k)] = jsonCompatible(child)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, child := range t {
+			l[i] = jsonCompatible(child)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// flattenSchema turns a JSON schema into dotted property paths mapped to their declared type
+func flattenSchema(data []byte) (map[string]interface{}, error) {
+	flat := make(map[string]interface{})
+	if len(data) == 0 {
+		return flat, nil
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	var walk func(prefix string, s map[string]interface{})
+	walk = func(prefix string, s map[string]interface{}) {
+		props, _ := s["properties"].(map[string]interface{})
+		if prefix != "" {
+			flat[prefix] = s["type"]
+		}
+		for name, child := range props {
+			key := name
+			if prefix != "" {
+				key = prefix + "." + name
+			}
+			if childSchema, ok := child.(map[string]interface{}); ok {
+				walk(key, childSchema)
+			}
+		}
+	}
+	walk("", schema)
+	return flat, nil
+}
+
+// diffKeys compares two flattened key sets. A removed key and an added key
+// are reported as a rename when they share the same final path segment and
+// value and the pairing is unambiguous.
+func diffKeys(oldKeys, newKeys map[string]interface{}) keyDiff {
+	diff := keyDiff{Added: []string{}, Removed: []string{}, Renamed: []keyRename{}, Changed: []keyChange{}}
+	var added, removed []string
+	for key, oldValue := range oldKeys {
+		newValue, ok := newKeys[key]
+		if !ok {
+			removed = append(removed, key)
+		} else if !reflect.DeepEqual(oldValue, newValue) {
+			diff.Changed = append(diff.Changed, keyChange{Key: key, From: oldValue, To: newValue})
+		}
+	}
+	for key := range newKeys {
+		if _, ok := oldKeys[key]; !ok {
+			added = append(added, key)
+		}
+	}
+
+	candidates := make(map[string][]string)
+	for _, key := range added {
+		candidates[lastSegment(key)] = append(candidates[lastSegment(key)], key)
+	}
+	renamedTo := make(map[string]bool)
+	for _, key := range removed {
+		var match []string
+		for _, candidate := range candidates[lastSegment(key)] {
+			if !renamedTo[candidate] && reflect.DeepEqual(oldKeys[key], newKeys[candidate]) {
+				match = append(match, candidate)
+			}
+		}
+		if len(match) == 1 {
+			diff.Renamed = append(diff.Renamed, keyRename{From: key, To: match[0]})
+			renamedTo[match[0]] = true
+			continue
+		}
+		diff.Removed = append(diff.Removed, key)
+	}
+	for _, key := range added {
+		if !renamedTo[key] {
+			diff.Added = append(diff.Added, key)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Renamed, func(i, j int) bool { return diff.Renamed[i].From < diff.Renamed[j].From })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Key < diff.Changed[j].Key })
+	return diff
+}
+
+// lastSegment returns the final component of a dotted key path
+func lastSegment(key string) string {
+	return key[strings.LastIndex(key, ".")+1:]
+}
+
+// diffHandler serves /diff. Charts are selected with app (and optionally
+// cluster and chart) to diff the current and latest versions from the most
+// recent cycle, behind the metrics basic auth like the reports. Selecting
+// them with repo, chart, from and to needs the admin token instead and a
+// chart of the most recent cycle, as charts are downloaded with the
+// credentials of their repository.
+func diffHandler() http.HandlerFunc {
+	byApplication := requireBasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		result, ok := latestResults.find(q.Get("cluster"), q.Get("app"), q.Get("chart"))
+		if !ok {
+			http.Error(w, fmt.Sprintf("no result for application %s", q.Get("app")), http.StatusNotFound)
+			return
+		}
+		writeChartDiff(w, r, result.RepoURL, result.Chart, result.CurrentVersion, result.LatestVersion)
+	}))
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("app") != "" {
+			byApplication.ServeHTTP(w, r)
+			return
+		}
+		repoURL, chartName, from, to := q.Get("repo"), q.Get("chart"), q.Get("from"), q.Get("to")
+		if repoURL == "" || chartName == "" || from == "" || to == "" {
+			http.Error(w, "either app or repo, chart, from and to are required", http.StatusBadRequest)
+			return
+		}
+		if !checkBearerToken(w, r, currentConfig().Admin.token) {
+			return
+		}
+		if len(latestResults.applications(repoURL, chartName)) == 0 {
+			http.Error(w, fmt.Sprintf("chart %s of %s is not in use", chartName, repoURL), http.StatusNotFound)
+			return
+		}
+		writeChartDiff(w, r, repoURL, chartName, from, to)
+	}
+}
+
+// writeChartDiff responds with the diff of the default values of two
+// versions of a chart
+func writeChartDiff(w http.ResponseWriter, r *http.Request, repoURL, chartName, from, to string) {
+	diff, err := diffChartValues(r.Context(), repoURL, chartName, from, to)
+	if err != nil {
+		slog.Warn("Error diffing chart values", "chart", chartName, "from", from, "to", to, "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		slog.Error("Error writing diff", "error", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+// ecrHostPattern matches private ECR registries and captures their region
+var ecrHostPattern = regexp.MustCompile(`^\d{12}\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// ecrTokens caches ECR authorization tokens per registry until shortly before they expire
+var ecrTokens = &ecrTokenCache{tokens: map[string]ecrToken{}}
+
+type ecrToken struct {
+	username, password string
+	expires            time.Time
+}
+
+type ecrTokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]ecrToken
+}
+
+// credentials exchanges the ambient AWS identity (IRSA, instance role or
+// environment) for a registry login
+func (c *ecrTokenCache) credentials(ctx context.Context, registry string) (string, string, error) {
+	m := ecrHostPattern.FindStringSubmatch(registry)
+	if m == nil {
+		return "", "", fmt.Errorf("%s is not an ECR registry", registry)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tokens[registry]; ok && time.Until(t.expires) > 5*time.Minute {
+		return t.username, t.password, nil
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(m[1]))
+	if err != nil {
+		return "", "", fmt.Errorf("loading AWS config: %w", err)
+	}
+	out, err := ecr.NewFromConfig(awsCfg).GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return "", "", fmt.Errorf("getting ECR authorization token for %s: %w", registry, err)
+	}
+	if len(out.AuthorizationData) == 0 || out.AuthorizationData[0].AuthorizationToken == nil {
+		return "", "", fmt.Errorf("no ECR authorization token returned for %s", registry)
+	}
+	data := out.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(*data.AuthorizationToken)
+	if err != nil {
+		return "", "", fmt.Errorf("decoding ECR authorization token: %w", err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", fmt.Errorf("malformed ECR authorization token for %s", registry)
+	}
+	t := ecrToken{username: username, password: password, expires: time.Now().Add(12 * time.Hour)}
+	if data.ExpiresAt != nil {
+		t.expires = *data.ExpiresAt
+	}
+	c.tokens[registry] = t
+	return username, password, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+const (
+	eventFormatJSON        = "json"
+	eventFormatCloudEvents = "cloudevents"
+
+	// eventBusTimeout bounds publishing the events of a cycle to one bus
+	eventBusTimeout = 30 * time.Second
+)
+
+// cloudEvent is the structured content mode of a CloudEvent in JSON
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            statusEvent `json:"data"`
+}
+
+func init() {
+	registerNotifiers(func(cfg notifiersConfig) []eventNotifier {
+		notifiers := make([]eventNotifier, 0, len(cfg.Kafka)+len(cfg.NATS))
+		for _, k := range cfg.Kafka {
+			notifiers = append(notifiers, k)
+		}
+		for _, n := range cfg.NATS {
+			notifiers = append(notifiers, n)
+		}
+		return notifiers
+	})
+}
+
+// encode serializes an event in the configured format
+func (f eventFormat) encode(event statusEvent) ([]byte, error) {
+	tmpl, err := notificationTemplate(f.Template, f.TemplateFile)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl != nil {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, event); err != nil {
+			return nil, fmt.Errorf("executing template: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+	if f.Format != eventFormatCloudEvents {
+		return json.Marshal(event)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	source := valueOr(f.Source, "helm-version-check")
+	if event.Result.Cluster != "" {
+		source += "/" + event.Result.Cluster
+	}
+	return json.Marshal(cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              hex.EncodeToString(id),
+		Source:          source,
+		Type:            "com.github.caseyrobb.helm-version-check." + event.Type,
+		Subject:         event.Result.Application + "/" + event.Result.Chart,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            event,
+	})
+}
+
+// contentType returns the media type of encoded events
+func (f eventFormat) contentType() string {
+	if f.Format == eventFormatCloudEvents && f.Template == "" && f.TemplateFile == "" {
+		return "application/cloudevents+json"
+	}
+	return "application/json"
+}
+
+// validate checks the format and that the template parses
+func (f eventFormat) validate() error {
+	switch f.Format {
+	case "", eventFormatJSON, eventFormatCloudEvents:
+	default:
+		return fmt.Errorf("format must be json or cloudevents, got %q", f.Format)
+	}
+	if _, err := notificationTemplate(f.Template, f.TemplateFile); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (k kafkaConfig) validate() error {
+	if len(k.Brokers) == 0 || k.Topic == "" {
+		return errors.New("brokers and topic are required")
+	}
+	if k.SASL.Mechanism != "" {
+		if _, err := k.SASL.mechanism(); err != nil {
+			return fmt.Errorf("sasl.%w", err)
+		}
+	}
+	if err := k.notifierOptions.validate(); err != nil {
+		return err
+	}
+	return k.eventFormat.validate()
+}
+
+func (k kafkaConfig) target() string {
+	return "kafka " + k.Topic
+}
+
+func (k kafkaConfig) options() notifierOptions {
+	return k.notifierOptions
+}
+
+// mechanism returns the SASL mechanism with its credentials
+func (s kafkaSASLConfig) mechanism() (sasl.Mechanism, error) {
+	username, password, err := s.credentials()
+	if err != nil {
+		return nil, err
+	}
+	switch s.Mechanism {
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("mechanism must be plain, scram-sha-256 or scram-sha-512, got %q", s.Mechanism)
+}
+
+// send writes events to the topic, waiting for all in-sync replicas
+func (k kafkaConfig) send(events []statusEvent) (int, error) {
+	transport := &kafka.Transport{ClientID: "helm-version-check"}
+	if k.TLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if k.SASL.Mechanism != "" {
+		mechanism, err := k.SASL.mechanism()
+		if err != nil {
+			return 0, err
+		}
+		transport.SASL = mechanism
+	}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(k.Brokers...),
+		Topic:        k.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+	}
+	defer writer.Close()
+
+	messages := make([]kafka.Message, 0, len(events))
+	for _, event := range events {
+		value, err := k.encode(event)
+		if err != nil {
+			return 0, err
+		}
+		messages = append(messages, kafka.Message{
+			Key:     []byte(resultKey(event.Result)),
+			Value:   value,
+			Headers: []kafka.Header{{Key: "content-type", Value: []byte(k.contentType())}},
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventBusTimeout)
+	defer cancel()
+	if err := writer.WriteMessages(ctx, messages...); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+func (n natsConfig) validate() error {
+	if n.URL == "" || n.Subject == "" {
+		return errors.New("url and subject are required")
+	}
+	if err := n.notifierOptions.validate(); err != nil {
+		return err
+	}
+	return n.eventFormat.validate()
+}
+
+func (n natsConfig) target() string {
+	return "nats " + n.Subject
+}
+
+func (n natsConfig) options() notifierOptions {
+	return n.notifierOptions
+}
+
+// send publishes events to the subject, flushing before disconnecting
+func (n natsConfig) send(events []statusEvent) (int, error) {
+	opts := []nats.Option{nats.Name("helm-version-check"), nats.Timeout(eventBusTimeout)}
+	if n.CredentialsFile != "" {
+		opts = append(opts, nats.UserCredentials(n.CredentialsFile))
+	}
+	conn, err := nats.Connect(n.URL, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	for _, event := range events {
+		data, err := n.encode(event)
+		if err != nil {
+			return 0, err
+		}
+		msg := &nats.Msg{Subject: n.Subject, Data: data, Header: nats.Header{"Content-Type": []string{n.contentType()}}}
+		if err := conn.PublishMsg(msg); err != nil {
+			return 0, err
+		}
+	}
+	// Messages are only known to be delivered once flushed
+	if err := conn.FlushTimeout(eventBusTimeout); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+)
+
+// Headers set by the Argo CD API server on requests to proxy extensions,
+// once it has authenticated the user and authorized the extension for the
+// application
+const (
+	argocdApplicationHeader = "Argocd-Application-Name"
+	argocdProjectHeader     = "Argocd-Project-Name"
+	argocdUsernameHeader    = "Argocd-Username"
+)
+
+// extensionChart is the version status of one chart of an application, as
+// shown by the Argo CD UI extension
+type extensionChart struct {
+	Chart          string       `json:"chart"`
+	RepoURL        string       `json:"repoURL"`
+	Source         string       `json:"source,omitempty"`
+	CurrentVersion string       `json:"currentVersion"`
+	LatestVersion  string       `json:"latestVersion"`
+	Status         string       `json:"status"`
+	UpToDate       bool         `json:"upToDate"`
+	Behind         *check.Delta `json:"behind,omitempty"`
+	Deprecated     bool         `json:"deprecated,omitempty"`
+	Stale          bool         `json:"stale,omitempty"`
+	OutdatedSince  *time.Time   `json:"outdatedSince,omitempty"`
+	Links          []string     `json:"links,omitempty"`
+}
+
+// extensionApplication is the response of /extensions/application
+type extensionApplication struct {
+	Application string           `json:"application"`
+	Namespace   string           `json:"namespace,omitempty"`
+	Project     string           `json:"project,omitempty"`
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Outdated    int              `json:"outdated"`
+	Charts      []extensionChart `json:"charts"`
+}
+
+// extensionHandler serves GET /extensions/application for the Argo CD UI
+// extension, reached through the Argo CD proxy extension. The application
+// comes from the Argocd-Application-Name header as namespace:name, or from
+// ?app= (and ?namespace=) when calling it directly, and must belong to the
+// project of Argocd-Project-Name when set. It requires the extension bearer
+// token, which the proxy extension configuration adds to every request.
+func extensionHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig().ArgoCDExtension
+	if !allowOrigin(w, r, cfg.AllowedOrigins) {
+		return
+	}
+	if !checkBearerToken(w, r, cfg.token) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET, OPTIONS")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	namespace, app := q.Get("namespace"), q.Get("app")
+	if header := r.Header.Get(argocdApplicationHeader); header != "" {
+		var ok bool
+		if namespace, app, ok = strings.Cut(header, ":"); !ok {
+			namespace, app = "", header
+		}
+	}
+	if app == "" {
+		http.Error(w, fmt.Sprintf("%s header or app is required", argocdApplicationHeader), http.StatusBadRequest)
+		return
+	}
+	project := r.Header.Get(argocdProjectHeader)
+
+	report := latestResults.report()
+	response := extensionApplication{Application: app, Namespace: namespace, Project: project, GeneratedAt: report.GeneratedAt, Charts: []extensionChart{}}
+	for _, res := range report.Results {
+		if res.Application != app || (namespace != "" && res.Namespace != "" && res.Namespace != namespace) || (project != "" && res.Project != project) {
+			continue
+		}
+		if res.outdated() {
+			response.Outdated++
+		}
+		response.Charts = append(response.Charts, extensionChart{
+			Chart:          res.Chart,
+			RepoURL:        res.RepoURL,
+			Source:         res.Source,
+			CurrentVersion: res.CurrentVersion,
+			LatestVersion:  res.LatestVersion,
+			Status:         resultStatus(res),
+			UpToDate:       res.UpToDate,
+			Behind:         res.Behind,
+			Deprecated:     res.Deprecated,
+			Stale:          res.Stale,
+			OutdatedSince:  res.OutdatedSince,
+			Links:          res.Links,
+		})
+	}
+	if len(response.Charts) == 0 {
+		http.Error(w, fmt.Sprintf("no result for application %s", app), http.StatusNotFound)
+		return
+	}
+	slog.Debug("Served extension request", "application", app, "user", r.Header.Get(argocdUsernameHeader))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error("Error writing extension response", "error", err)
+	}
+}
+
+// allowOrigin sets the CORS headers of a request from one of the allowed
+// origins, which may send credentials, or from any with "*", which may not,
+// and answers preflight requests. It returns false when the request has been
+// answered.
+func allowOrigin(w http.ResponseWriter, r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	switch {
+	case origin == "":
+	case slices.Contains(allowed, origin):
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Add("Vary", "Origin")
+	case slices.Contains(allowed, "*"):
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return true
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join([]string{"Authorization", "Content-Type", argocdApplicationHeader, argocdProjectHeader}, ", "))
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+	return false
+}
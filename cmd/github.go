@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// githubPageSize is the number of releases requested per GitHub API page
+const githubPageSize = 100
+
+// githubReleaseVersions lists the versions of chartName published as GitHub
+// releases. Tags named <chart>-<version>, as created by chart-releaser, or
+// plain versions with an optional "v" prefix are accepted.
+func githubReleaseVersions(ctx context.Context, repo *repositoryConfig, repoURL, chartName string) ([]repository.Entry, error) {
+	apiBase, owner, name, err := githubRepository(repo, repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []repository.Entry
+	for page := 1; ; page++ {
+		endpoint := fmt.Sprintf("%s/repos/%s/%s/releases?per_page=%d&page=%d", apiBase, owner, name, githubPageSize, page)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if err := setGitHubAuth(req, repo); err != nil {
+			return nil, err
+		}
+		resp, err := repoDo(repoURL, req)
+		if err != nil {
+			return nil, err
+		}
+		var releases []struct {
+			TagName    string `json:"tag_name"`
+			Draft      bool   `json:"draft"`
+			Prerelease bool   `json:"prerelease"`
+			HTMLURL    string `json:"html_url"`
+			Assets     []struct {
+				Name               string `json:"name"`
+				BrowserDownloadURL string `json:"browser_download_url"`
+			} `json:"assets"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			err = json.NewDecoder(resp.Body).Decode(&releases)
+		} else {
+			err = fmt.Errorf("unexpected status %s listing releases of %s/%s", resp.Status, owner, name)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range releases {
+			if r.Draft {
+				continue
+			}
+			version, ok := releaseVersion(r.TagName, chartName)
+			if !ok {
+				continue
+			}
+			entry := repository.Entry{Version: version, Home: r.HTMLURL}
+			for _, asset := range r.Assets {
+				if strings.HasSuffix(asset.Name, ".tgz") {
+					entry.URLs = append(entry.URLs, asset.BrowserDownloadURL)
+				}
+			}
+			versions = append(versions, entry)
+		}
+		if len(releases) < githubPageSize {
+			break
+		}
+	}
+	slog.Debug("Listed chart versions from GitHub releases", "repository", owner+"/"+name, "chart", chartName, "count", len(versions))
+	return chartVersions(versions, chartName)
+}
+
+// releaseVersion extracts the chart version from a release tag, skipping
+// releases of other charts
+func releaseVersion(tag, chartName string) (string, bool) {
+	version := strings.TrimPrefix(tag, chartName+"-")
+	if _, err := semver.StrictNewVersion(strings.TrimPrefix(version, "v")); err != nil {
+		return "", false
+	}
+	return version, true
+}
+
+// githubRepository returns the API base URL, owner and name of the GitHub
+// repository holding the releases: githubRepository of the repository config
+// or the owner and name in a https://github.com/<owner>/<name> repository URL.
+// Hosts other than github.com and GitHub Pages are treated as GitHub Enterprise.
+func githubRepository(repo *repositoryConfig, repoURL string) (string, string, string, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", "", "", err
+	}
+	apiBase := "https://api.github.com"
+	if u.Host != "github.com" && !strings.HasSuffix(u.Host, ".github.io") {
+		apiBase = "https://" + u.Host + "/api/v3"
+	}
+	path := strings.Trim(u.Path, "/")
+	if repo != nil && repo.GitHubRepository != "" {
+		path = repo.GitHubRepository
+	}
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("cannot determine the GitHub repository of %s, set githubRepository", repoURL)
+	}
+	return apiBase, parts[0], parts[1], nil
+}
+
+// setGitHubAuth sends the repository token, or GITHUB_TOKEN, as a bearer token
+func setGitHubAuth(req *http.Request, repo *repositoryConfig) error {
+	token := os.Getenv("GITHUB_TOKEN")
+	if repo != nil && repo.hasToken() {
+		var err error
+		if token, err = repo.token(); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// githubIssueLabel marks the issues managed by the exporter
+const githubIssueLabel = "helm-version-check"
+
+type githubIssue struct {
+	Number int    `json:"number"`
+	Body   string `json:"body"`
+}
+
+func (g githubIssuesConfig) policy() incidentPolicy {
+	return g.Policy
+}
+
+// open creates an issue for the chart, or updates the open one when a newer
+// version was released since it was created
+func (g githubIssuesConfig) open(r chartResult) error {
+	issue, err := g.findIssue(r)
+	if err != nil {
+		return err
+	}
+	if issue != nil {
+		path := fmt.Sprintf("/issues/%d", issue.Number)
+		return g.do(http.MethodPatch, path, map[string]any{"title": issueTitle(r), "body": issueBody(r)}, nil)
+	}
+	labels := append([]string{githubIssueLabel}, g.Labels...)
+	for _, key := range g.LabelsFromApplication {
+		if value, ok := r.Labels[key]; ok {
+			labels = append(labels, key+":"+value)
+		}
+	}
+	return g.do(http.MethodPost, "/issues", map[string]any{"title": issueTitle(r), "body": issueBody(r), "labels": labels}, nil)
+}
+
+// resolve closes the issue of the chart with a comment
+func (g githubIssuesConfig) resolve(r chartResult) error {
+	issue, err := g.findIssue(r)
+	if err != nil || issue == nil {
+		return err
+	}
+	comment := fmt.Sprintf("%s is no longer outdated or no longer checked.", r.Chart)
+	if r.UpToDate {
+		comment = fmt.Sprintf("%s is up to date at %s.", r.Chart, r.CurrentVersion)
+	}
+	path := fmt.Sprintf("/issues/%d", issue.Number)
+	if err := g.do(http.MethodPost, path+"/comments", map[string]any{"body": comment}, nil); err != nil {
+		return err
+	}
+	return g.do(http.MethodPatch, path, map[string]any{"state": "closed", "state_reason": "completed"}, nil)
+}
+
+// findIssue returns the open issue of a chart, identified by the marker in
+// its body, or nil
+func (g githubIssuesConfig) findIssue(r chartResult) (*githubIssue, error) {
+	marker := issueMarker(r)
+	for page := 1; ; page++ {
+		var issues []githubIssue
+		path := fmt.Sprintf("/issues?state=open&labels=%s&per_page=%d&page=%d", githubIssueLabel, githubPageSize, page)
+		if err := g.do(http.MethodGet, path, nil, &issues); err != nil {
+			return nil, err
+		}
+		for i := range issues {
+			if strings.Contains(issues[i].Body, marker) {
+				return &issues[i], nil
+			}
+		}
+		if len(issues) < githubPageSize {
+			return nil, nil
+		}
+	}
+}
+
+// do sends a request to the API of the tracking repository, decoding the
+// response into out when it is not nil
+func (g githubIssuesConfig) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	base := g.APIURL
+	if base == "" {
+		base = "https://api.github.com"
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(base, "/")+"/repos/"+g.Repository+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	token := os.Getenv("GITHUB_TOKEN")
+	if g.TokenFile != "" {
+		data, err := os.ReadFile(g.TokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+	} else if g.Token != "" {
+		token = g.Token
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := tracedDo(notifyClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s from %s %s", resp.Status, method, req.URL.Path)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (g githubIssuesConfig) validate() error {
+	if parts := strings.Split(g.Repository, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return errors.New("repository must be owner/name")
+	}
+	return g.Policy.validate()
+}
+
+// issueMarker identifies the issue of a chart across cycles
+func issueMarker(r chartResult) string {
+	return "<!-- helm-version-check: " + resultKey(r) + " -->"
+}
+
+func issueTitle(r chartResult) string {
+	title := fmt.Sprintf("Upgrade Helm chart %s in %s", r.Chart, r.Application)
+	if r.Cluster != "" {
+		title += " (" + r.Cluster + ")"
+	}
+	return title
+}
+
+func issueBody(r chartResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "A newer version of the Helm chart **%s** is available.\n\n", r.Chart)
+	b.WriteString("| | |\n|---|---|\n")
+	fmt.Fprintf(&b, "| Application | %s |\n", r.Application)
+	if r.Cluster != "" {
+		fmt.Fprintf(&b, "| Cluster | %s |\n", r.Cluster)
+	}
+	if r.DestinationNamespace != "" {
+		fmt.Fprintf(&b, "| Destination | %s %s |\n", r.DestinationCluster, r.DestinationNamespace)
+	}
+	if r.Source != "" {
+		fmt.Fprintf(&b, "| Source | %s |\n", r.Source)
+	}
+	fmt.Fprintf(&b, "| Repository | %s |\n", r.RepoURL)
+	fmt.Fprintf(&b, "| Current version | %s |\n", r.CurrentVersion)
+	fmt.Fprintf(&b, "| Latest version | %s |\n", r.LatestVersion)
+	if len(r.Links) > 0 {
+		b.WriteString("\n")
+		for _, link := range r.Links {
+			fmt.Fprintf(&b, "- %s\n", link)
+		}
+	}
+	b.WriteString("\n" + issueMarker(r) + "\n")
+	return b.String()
+}
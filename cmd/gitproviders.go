@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// gitProvider reads single files of a repository through a hosting API,
+// which is much cheaper than cloning for a Chart.yaml and a Chart.lock
+type gitProvider interface {
+	readFile(ctx context.Context, revision, file string) ([]byte, error)
+}
+
+// gitProviderFor returns the API client for a git repository: the provider
+// configured for its URL, or the one detected from github.com, gitlab.com and
+// bitbucket.org. It returns nil when files must be read by cloning, as for
+// SSH URLs without a token, whose private repositories the API would hide.
+func gitProviderFor(repoURL string) gitProvider {
+	host, repoPath, ok := parseGitURL(repoURL)
+	if !ok {
+		return nil
+	}
+	repo := currentConfig().repositoryFor(repoURL)
+	if isSSHURL(repoURL) && (repo == nil || !repo.hasToken()) {
+		return nil
+	}
+	kind, apiURL := "", ""
+	if repo != nil {
+		kind, apiURL = repo.GitProvider, repo.GitAPIURL
+	}
+	if kind == "" {
+		switch host {
+		case "github.com":
+			kind = "github"
+		case "gitlab.com":
+			kind = "gitlab"
+		case "bitbucket.org":
+			kind = "bitbucket"
+		default:
+			return nil
+		}
+	}
+	api := gitAPI{repoURL: repoURL, repoPath: repoPath, repo: repo}
+	switch kind {
+	case "github":
+		api.baseURL = valueOr(apiURL, "https://api.github.com")
+		return githubContents{api}
+	case "gitlab":
+		api.baseURL = valueOr(apiURL, "https://gitlab.com/api/v4")
+		return gitlabFiles{api}
+	case "bitbucket":
+		api.baseURL = valueOr(apiURL, "https://api.bitbucket.org/2.0")
+		return bitbucketSource{api}
+	}
+	return nil
+}
+
+// parseGitURL returns the host and the owner/name path of an https:// or
+// ssh:// git URL, or of an scp-like one such as git@github.com:owner/name.git
+func parseGitURL(repoURL string) (string, string, bool) {
+	if !strings.Contains(repoURL, "://") {
+		userHost, repoPath, ok := strings.Cut(repoURL, ":")
+		if !ok {
+			return "", "", false
+		}
+		_, host, _ := strings.Cut(userHost, "@")
+		if host == "" {
+			host = userHost
+		}
+		repoURL = "ssh://" + host + "/" + repoPath
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return "", "", false
+	}
+	repoPath := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if !strings.Contains(repoPath, "/") {
+		return "", "", false
+	}
+	return u.Hostname(), repoPath, true
+}
+
+// gitAPI holds what the provider clients share
+type gitAPI struct {
+	baseURL  string
+	repoURL  string
+	repoPath string
+	repo     *repositoryConfig
+}
+
+// get requests an API path, returning the body of a 200 response; a 404 is
+// returned as fs.ErrNotExist when authenticated, as providers answer 404 for
+// private repositories to anonymous requests
+func (a gitAPI) get(ctx context.Context, path string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(a.baseURL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := repoDo(a.repoURL, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound && header.Get("Authorization") == "" && header.Get("PRIVATE-TOKEN") == "":
+		return nil, fmt.Errorf("%s not found without an API token, the repository may be private", path)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, req.URL.Host)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// token returns the token of the repository, or GITHUB_TOKEN for GitHub
+func (a gitAPI) token(fallbackEnv string) (string, error) {
+	if a.repo != nil && a.repo.hasToken() {
+		return a.repo.token()
+	}
+	if fallbackEnv != "" {
+		return os.Getenv(fallbackEnv), nil
+	}
+	return "", nil
+}
+
+// githubContents reads files with the contents API of GitHub or GitHub
+// Enterprise Server
+type githubContents struct{ gitAPI }
+
+func (g githubContents) readFile(ctx context.Context, revision, file string) ([]byte, error) {
+	header := http.Header{"Accept": {"application/vnd.github.raw"}}
+	token, err := g.token("GITHUB_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	path := "/repos/" + g.repoPath + "/contents/" + escapeFilePath(file)
+	if revision != "" && revision != "HEAD" {
+		path += "?ref=" + url.QueryEscape(revision)
+	}
+	return g.get(ctx, path, header)
+}
+
+// gitlabFiles reads files with the repository files API of GitLab, where
+// projects may be nested in subgroups
+type gitlabFiles struct{ gitAPI }
+
+func (g gitlabFiles) readFile(ctx context.Context, revision, file string) ([]byte, error) {
+	header := http.Header{}
+	token, err := g.token("")
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		header.Set("PRIVATE-TOKEN", token)
+	}
+	if revision == "" {
+		revision = "HEAD"
+	}
+	path := "/projects/" + url.PathEscape(g.repoPath) + "/repository/files/" + url.PathEscape(file) +
+		"/raw?ref=" + url.QueryEscape(revision)
+	return g.get(ctx, path, header)
+}
+
+// bitbucketSource reads files with the source API of Bitbucket Cloud,
+// authenticated with an access token or a username and app password
+type bitbucketSource struct{ gitAPI }
+
+func (b bitbucketSource) readFile(ctx context.Context, revision, file string) ([]byte, error) {
+	header := http.Header{}
+	token, err := b.token("")
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	} else if b.repo != nil {
+		username, password, err := b.repo.credentials()
+		if err != nil {
+			return nil, err
+		}
+		if username != "" || password != "" {
+			req := http.Request{Header: http.Header{}}
+			req.SetBasicAuth(username, password)
+			header.Set("Authorization", req.Header.Get("Authorization"))
+		}
+	}
+	if revision == "" || revision == "HEAD" {
+		// The source API needs a commit or branch name
+		data, err := b.get(ctx, "/repositories/"+b.repoPath, header)
+		if err != nil {
+			return nil, err
+		}
+		var repo struct {
+			MainBranch struct {
+				Name string `json:"name"`
+			} `json:"mainbranch"`
+		}
+		if err := json.Unmarshal(data, &repo); err != nil {
+			return nil, fmt.Errorf("decoding Bitbucket repository: %w", err)
+		}
+		revision = repo.MainBranch.Name
+	}
+	return b.get(ctx, "/repositories/"+b.repoPath+"/src/"+url.PathEscape(revision)+"/"+escapeFilePath(file), header)
+}
+
+// escapeFilePath escapes the segments of a file path for a URL path
+func escapeFilePath(file string) string {
+	segments := strings.Split(strings.TrimPrefix(file, "/"), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
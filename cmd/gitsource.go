@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	gossh "golang.org/x/crypto/ssh"
+	"helm.sh/helm/v3/pkg/chart"
+	"sigs.k8s.io/yaml"
+
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+)
+
+// vendoredChart is a chart kept in a git repository, whose dependencies are
+// checked against their repositories
+type vendoredChart struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	RepoURL  string `json:"repoURL"`
+	Path     string `json:"path"`
+	Revision string `json:"revision,omitempty"`
+}
+
+var vendoredChartGauge = metrics.NewExpiringGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_vendored_chart_info",
+		Help: "Charts read from git sources with their declared version, always 1",
+	},
+	[]string{"application", "chart", "version", "repo_url", "path", "cluster", "argocd_instance"},
+	15*time.Minute,
+)
+
+func init() {
+	prometheus.MustRegister(vendoredChartGauge)
+}
+
+// commitPattern matches revisions that can only be commits
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// processGitSource reports a chart vendored at the path of a git source and
+// checks its dependencies, using the versions of Chart.lock when present. Sources
+// without a Chart.yaml are read as kustomizations; plain manifests have no
+// results.
+func processGitSource(ctx context.Context, appName, destNamespace string, source map[string]interface{}) []chartResult {
+	repoURL, _ := source["repoURL"].(string)
+	dir, _ := source["path"].(string)
+	revision, _ := source["targetRevision"].(string)
+	log := slog.With("application", appName, "repo_url", repoURL, "path", dir)
+
+	metadata, lock, err := readVendoredChart(ctx, repoURL, revision, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return processKustomization(ctx, appName, destNamespace, repoURL, revision, dir)
+	}
+	if err != nil {
+		log.Error("Error reading chart from git", "revision", revision, "error", err)
+		return nil
+	}
+	vendored := &vendoredChart{Name: metadata.Name, Version: metadata.Version, RepoURL: repoURL, Path: dir, Revision: revision}
+	log.Debug("Found vendored chart", "chart", metadata.Name, "version", metadata.Version, "dependencies", len(metadata.Dependencies))
+
+	locked := make(map[string]string)
+	if lock != nil {
+		for _, dep := range lock.Dependencies {
+			locked[dep.Name] = dep.Version
+		}
+	}
+	// The vendored chart itself has no newer version to check, but is
+	// reported so it has a result and a gauge without remote dependencies
+	results := []chartResult{{
+		Application:    appName,
+		Chart:          metadata.Name,
+		RepoURL:        repoURL,
+		CurrentVersion: metadata.Version,
+		TargetRevision: revision,
+		LatestVersion:  metadata.Version,
+		UpToDate:       true,
+		VendoredChart:  vendored,
+	}}
+	for _, dep := range metadata.Dependencies {
+		if dep.Repository == "" || strings.HasPrefix(dep.Repository, "file://") {
+			continue
+		}
+		version := dep.Version
+		if v, ok := locked[dep.Name]; ok {
+			version = v
+		}
+		depSource := map[string]interface{}{
+			"repoURL":        dep.Repository,
+			"chart":          dep.Name,
+			"targetRevision": version,
+		}
+		if result := processHelmSource(ctx, appName, destNamespace, depSource); result != nil {
+			result.VendoredChart = vendored
+			results = append(results, *result)
+		}
+	}
+	if len(results) == 1 {
+		log.Info("Vendored chart has no dependencies to check", "chart", metadata.Name, "version", metadata.Version)
+	}
+	return results
+}
+
+// readVendoredChart reads the Chart.yaml and the optional Chart.lock of the
+// chart at dir
+func readVendoredChart(ctx context.Context, repoURL, revision, dir string) (*chart.Metadata, *chart.Lock, error) {
+	data, err := readGitFile(ctx, repoURL, revision, path.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata := &chart.Metadata{}
+	if err := yaml.Unmarshal(data, metadata); err != nil {
+		return nil, nil, fmt.Errorf("decoding Chart.yaml: %w", err)
+	}
+	data, err = readGitFile(ctx, repoURL, revision, path.Join(dir, "Chart.lock"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return metadata, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	lock := &chart.Lock{}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, nil, fmt.Errorf("decoding Chart.lock: %w", err)
+	}
+	return metadata, lock, nil
+}
+
+// gitTree is the tree of a commit read for a cycle
+type gitTree struct {
+	tree    *object.Tree
+	fetched time.Time
+}
+
+// gitTrees caches the trees of cloned revisions so Applications sharing a
+// repository clone it once per cache period
+var gitTrees = &gitTreeCache{trees: make(map[string]gitTree)}
+
+type gitTreeCache struct {
+	mu    sync.Mutex
+	trees map[string]gitTree
+}
+
+// readGitFile returns the content of a file at a revision of a git
+// repository, or an error wrapping fs.ErrNotExist when it does not exist.
+// Files of known providers are read through their API, falling back to
+// cloning when it fails or, without an API token, finds no file.
+func readGitFile(ctx context.Context, repoURL, revision, file string) ([]byte, error) {
+	if provider := gitProviderFor(repoURL); provider != nil {
+		data, err := provider.readFile(ctx, revision, strings.TrimPrefix(path.Clean(file), "/"))
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return data, err
+		}
+		slog.Debug("Reading file through git provider API failed, cloning", "repo_url", repoURL, "file", file, "error", err)
+	}
+	tree, err := gitTrees.get(ctx, repoURL, revision)
+	if err != nil {
+		return nil, err
+	}
+	f, err := tree.File(strings.TrimPrefix(path.Clean(file), "/"))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, fmt.Errorf("%s: %w", file, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+// get returns the tree of a revision, cloning the repository into memory
+// when it is not cached
+func (c *gitTreeCache) get(ctx context.Context, repoURL, revision string) (*object.Tree, error) {
+	key := repoURL + "@" + revision
+	ttl := contextConfig(ctx).GitSources.CacheTTL
+	c.mu.Lock()
+	cached, ok := c.trees[key]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetched) < ttl {
+		return cached.tree, nil
+	}
+	tree, err := cloneTree(ctx, repoURL, revision)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.trees[key] = gitTree{tree: tree, fetched: time.Now()}
+	for k, t := range c.trees {
+		if time.Since(t.fetched) >= ttl {
+			delete(c.trees, k)
+		}
+	}
+	c.mu.Unlock()
+	return tree, nil
+}
+
+// cloneTree clones a single revision without a worktree: HEAD, a branch or a
+// tag with depth 1, or a commit, which needs the full history
+func cloneTree(ctx context.Context, repoURL, revision string) (*object.Tree, error) {
+	auth, err := gitAuth(ctx, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	var caBundle []byte
+	if u, err := url.Parse(repoURL); err == nil && u.Scheme == "https" {
+		caBundle = []byte(argoCDTLSCerts(ctx, u.Host))
+	}
+	slog.Debug("Cloning git repository", "repo_url", repoURL, "revision", revision)
+	var refs []plumbing.ReferenceName
+	if revision != "" && revision != "HEAD" {
+		refs = []plumbing.ReferenceName{plumbing.NewBranchReferenceName(revision), plumbing.NewTagReferenceName(revision)}
+	} else {
+		refs = []plumbing.ReferenceName{""}
+	}
+	for _, ref := range refs {
+		repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, &git.CloneOptions{
+			URL:           repoURL,
+			Auth:          auth,
+			CABundle:      caBundle,
+			ReferenceName: ref,
+			SingleBranch:  true,
+			Depth:         1,
+			NoCheckout:    true,
+			Tags:          git.NoTags,
+		})
+		if errors.Is(err, plumbing.ErrReferenceNotFound) || err != nil && strings.Contains(err.Error(), "couldn't find remote ref") {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cloning %s: %w", repoURL, err)
+		}
+		head, err := repo.Head()
+		if err != nil {
+			return nil, err
+		}
+		return commitTree(repo, head.Hash())
+	}
+	if !commitPattern.MatchString(revision) {
+		return nil, fmt.Errorf("revision %s not found in %s", revision, repoURL)
+	}
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, &git.CloneOptions{
+		URL:        repoURL,
+		Auth:       auth,
+		CABundle:   caBundle,
+		NoCheckout: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cloning %s: %w", repoURL, err)
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s in %s: %w", revision, repoURL, err)
+	}
+	return commitTree(repo, *hash)
+}
+
+func commitTree(repo *git.Repository, hash plumbing.Hash) (*object.Tree, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// gitAuth returns the credentials configured for a git repository: a token,
+// sent as the password as GitHub, GitLab and Bitbucket expect, basic auth or
+// an SSH key, falling back to those of the Argo CD repository secrets
+func gitAuth(ctx context.Context, repoURL string) (transport.AuthMethod, error) {
+	repo := contextConfig(ctx).repositoryFor(repoURL)
+	if isSSHURL(repoURL) {
+		return gitSSHAuth(ctx, repoURL, repo)
+	}
+	if repo != nil && repo.hasToken() {
+		token, err := repo.token()
+		if err != nil {
+			return nil, err
+		}
+		return &githttp.BasicAuth{Username: "x-access-token", Password: token}, nil
+	}
+	if repo != nil {
+		username, password, err := repo.credentials()
+		if err != nil {
+			return nil, err
+		}
+		if username != "" || password != "" {
+			return &githttp.BasicAuth{Username: username, Password: password}, nil
+		}
+	}
+	argoRepo, err := argoCDRepoCredentials(ctx, repoURL)
+	if err != nil || argoRepo == nil || argoRepo.username == "" && argoRepo.password == "" {
+		return nil, err
+	}
+	return &githttp.BasicAuth{Username: argoRepo.username, Password: argoRepo.password}, nil
+}
+
+// gitSSHAuth returns the private key of the repository or of its Argo CD
+// secret, verifying host keys unless the secret is marked insecure. Without a
+// key go-git falls back to the SSH agent.
+func gitSSHAuth(ctx context.Context, repoURL string, repo *repositoryConfig) (transport.AuthMethod, error) {
+	var key []byte
+	insecure := false
+	if repo != nil && repo.SSHKeyFile != "" {
+		data, err := os.ReadFile(repo.SSHKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = data
+	} else {
+		argoRepo, err := argoCDRepoCredentials(ctx, repoURL)
+		if err != nil {
+			return nil, err
+		}
+		if argoRepo == nil || argoRepo.sshPrivateKey == "" {
+			return nil, nil
+		}
+		key, insecure = []byte(argoRepo.sshPrivateKey), argoRepo.insecure
+	}
+	auth, err := gitssh.NewPublicKeys(sshUser(repoURL), key, "")
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH private key: %w", err)
+	}
+	if insecure {
+		auth.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+		return auth, nil
+	}
+	var files []string
+	if f := contextConfig(ctx).GitSources.KnownHostsFile; f != "" {
+		files = append(files, f)
+	}
+	argoHosts, err := argoCDKnownHostsFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if argoHosts != "" {
+		files = append(files, argoHosts)
+	}
+	if auth.HostKeyCallback, err = gitssh.NewKnownHostsCallback(files...); err != nil {
+		return nil, fmt.Errorf("reading SSH known hosts: %w", err)
+	}
+	return auth, nil
+}
+
+// isSSHURL reports whether a git URL is ssh:// or scp-like, as git@host:path
+func isSSHURL(repoURL string) bool {
+	return strings.HasPrefix(repoURL, "ssh://") || !strings.Contains(repoURL, "://") && strings.Contains(repoURL, ":")
+}
+
+// sshUser returns the user of an SSH git URL, git when it has none
+func sshUser(repoURL string) string {
+	userHost, _, _ := strings.Cut(strings.TrimPrefix(repoURL, "ssh://"), ":")
+	if user, _, ok := strings.Cut(userHost, "@"); ok && user != "" {
+		return user
+	}
+	return "git"
+}
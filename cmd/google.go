@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// googleScope is the OAuth scope needed to read from Artifact Registry and Cloud Storage
+const googleScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// googleTokens holds the Google token source, created on first use
+var googleTokens = &googleTokenSource{}
+
+type googleTokenSource struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+}
+
+// isGARHost reports whether host is an Artifact Registry endpoint such as
+// europe-docker.pkg.dev
+func isGARHost(host string) bool {
+	return strings.HasSuffix(host, ".pkg.dev")
+}
+
+// accessToken returns a token from Application Default Credentials, which on
+// GKE is the Workload Identity of the pod via the metadata server; the token
+// source refreshes it before it expires
+func (g *googleTokenSource) accessToken() (string, error) {
+	g.mu.Lock()
+	if g.source == nil {
+		// the token source outlives the request, so it must not use its context
+		source, err := google.DefaultTokenSource(context.Background(), googleScope)
+		if err != nil {
+			g.mu.Unlock()
+			return "", fmt.Errorf("finding Google credentials: %w", err)
+		}
+		g.source = source
+	}
+	source := g.source
+	g.mu.Unlock()
+
+	token, err := source.Token()
+	if err != nil {
+		return "", fmt.Errorf("getting Google access token: %w", err)
+	}
+	return token.AccessToken, nil
+}
+
+// garCredentials returns the Artifact Registry login for the access token
+func garCredentials() (string, string, error) {
+	token, err := googleTokens.accessToken()
+	if err != nil {
+		return "", "", err
+	}
+	return "oauth2accesstoken", token, nil
+}
+
+// fetchGCSIndex reads the index.yaml of a gs://bucket/prefix repository, as
+// published by the helm-gcs plugin, through the Cloud Storage XML API
+func fetchGCSIndex(ctx context.Context, repoURL, etag string) (*indexResponse, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	object := strings.TrimPrefix(path.Join(u.Path, repoIndexPath(repoURL)), "/")
+	token, err := googleTokens.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	endpoint := "https://storage.googleapis.com/" + u.Host + "/" + (&url.URL{Path: object}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := repoDo(repoURL, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		resp.Body.Close()
+		return &indexResponse{notModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s reading gs://%s/%s", resp.Status, u.Host, object)
+	}
+	return &indexResponse{body: resp.Body, etag: resp.Header.Get("ETag")}, nil
+}
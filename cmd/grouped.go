@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// groupedApplication is an application deploying a version of a chart
+type groupedApplication struct {
+	Cluster     string `json:"cluster,omitempty"`
+	Instance    string `json:"instance,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Application string `json:"application"`
+	Source      string `json:"source,omitempty"`
+}
+
+// groupedVersion is a deployed version of a chart and the applications
+// deploying it
+type groupedVersion struct {
+	Version      string               `json:"version"`
+	UpToDate     bool                 `json:"upToDate"`
+	Behind       *check.Delta         `json:"behind,omitempty"`
+	Applications []groupedApplication `json:"applications"`
+}
+
+// chartGroup is a chart of a repository with the versions deployed of it,
+// oldest first
+type chartGroup struct {
+	Chart         string           `json:"chart"`
+	RepoURL       string           `json:"repoURL"`
+	LatestVersion string           `json:"latestVersion"`
+	Applications  int              `json:"applications"`
+	Outdated      int              `json:"outdated"`
+	Versions      []groupedVersion `json:"versions"`
+}
+
+// repositoryGroup is a repository with the charts deployed from it
+type repositoryGroup struct {
+	RepoURL      string       `json:"repoURL"`
+	Applications int          `json:"applications"`
+	Outdated     int          `json:"outdated"`
+	Charts       []chartGroup `json:"charts"`
+}
+
+// groupedReport is the report of the latest results grouped by chart or by
+// repository
+type groupedReport struct {
+	GeneratedAt  time.Time         `json:"generatedAt"`
+	Charts       []chartGroup      `json:"charts,omitempty"`
+	Repositories []repositoryGroup `json:"repositories,omitempty"`
+}
+
+// groupByChart groups results by chart and repository, the charts deployed
+// by the most outdated applications first. Upgrade work is usually planned
+// per chart, covering every application using it.
+func groupByChart(results []chartResult) []chartGroup {
+	type chartKey struct{ chart, repoURL string }
+	byChart := map[chartKey]*chartGroup{}
+	var keys []chartKey
+	for _, r := range results {
+		key := chartKey{r.Chart, strings.TrimSuffix(r.RepoURL, "/")}
+		g, ok := byChart[key]
+		if !ok {
+			g = &chartGroup{Chart: r.Chart, RepoURL: r.RepoURL, LatestVersion: r.LatestVersion}
+			byChart[key] = g
+			keys = append(keys, key)
+		}
+		g.Applications++
+		if r.outdated() {
+			g.Outdated++
+		}
+		i := slices.IndexFunc(g.Versions, func(v groupedVersion) bool { return check.Same(v.Version, r.CurrentVersion) })
+		if i < 0 {
+			g.Versions = append(g.Versions, groupedVersion{Version: r.CurrentVersion, UpToDate: !r.outdated()})
+			if r.outdated() {
+				g.Versions[len(g.Versions)-1].Behind = r.Behind
+			}
+			i = len(g.Versions) - 1
+		}
+		g.Versions[i].Applications = append(g.Versions[i].Applications, groupedApplication{
+			Cluster:     r.Cluster,
+			Instance:    r.Instance,
+			Namespace:   r.Namespace,
+			Application: r.Application,
+			Source:      r.Source,
+		})
+	}
+	groups := make([]chartGroup, 0, len(keys))
+	for _, key := range keys {
+		g := byChart[key]
+		slices.SortFunc(g.Versions, func(a, b groupedVersion) int {
+			cmp, _ := check.Compare(repository.Entry{Version: a.Version}, repository.Entry{Version: b.Version}, check.OrderingLenient)
+			return cmp
+		})
+		groups = append(groups, *g)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Outdated != groups[j].Outdated {
+			return groups[i].Outdated > groups[j].Outdated
+		}
+		if groups[i].Chart != groups[j].Chart {
+			return groups[i].Chart < groups[j].Chart
+		}
+		return groups[i].RepoURL < groups[j].RepoURL
+	})
+	return groups
+}
+
+// groupByRepository groups the charts of results by repository, the
+// repositories with the most outdated applications first
+func groupByRepository(results []chartResult) []repositoryGroup {
+	byRepo := map[string]*repositoryGroup{}
+	var repos []string
+	for _, g := range groupByChart(results) {
+		key := strings.TrimSuffix(g.RepoURL, "/")
+		repo, ok := byRepo[key]
+		if !ok {
+			repo = &repositoryGroup{RepoURL: g.RepoURL}
+			byRepo[key] = repo
+			repos = append(repos, key)
+		}
+		repo.Applications += g.Applications
+		repo.Outdated += g.Outdated
+		repo.Charts = append(repo.Charts, g)
+	}
+	groups := make([]repositoryGroup, 0, len(repos))
+	for _, key := range repos {
+		groups = append(groups, *byRepo[key])
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Outdated != groups[j].Outdated {
+			return groups[i].Outdated > groups[j].Outdated
+		}
+		return groups[i].RepoURL < groups[j].RepoURL
+	})
+	return groups
+}
+
+// writeGroupedReport encodes the stored results grouped by chart or
+// repository
+func writeGroupedReport(w io.Writer, groupBy string) error {
+	report := latestResults.report()
+	grouped := groupedReport{GeneratedAt: report.GeneratedAt}
+	if groupBy == "repository" {
+		grouped.Repositories = groupByRepository(report.Results)
+	} else {
+		grouped.Charts = groupByChart(report.Results)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(grouped)
+}
+
+// groupedHandler serves the latest results grouped by chart or repository,
+// as /api/v1/charts and /api/v1/repositories
+func groupedHandler(groupBy string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := writeGroupedReport(w, groupBy); err != nil {
+			slog.Error("Error writing grouped report", "group_by", groupBy, "error", err)
+		}
+	}
+}
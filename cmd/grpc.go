@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	apiv1 "github.com/caseyrobb/helm-version-check/pkg/api/v1"
+)
+
+// checkService serves the results and history of the exporter over gRPC
+type checkService struct {
+	apiv1.UnimplementedCheckServiceServer
+}
+
+// startGRPCServer serves the gRPC API in the background, returning nil when
+// it is disabled. Like the metrics server it is set up at startup only;
+// credentials are read from the active config on every call.
+func startGRPCServer(cfg *config) (*grpc.Server, error) {
+	if cfg.GRPC.Port == 0 {
+		return nil, nil
+	}
+	tlsConfig, err := serverTLSConfig(cfg.Metrics.TLS)
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(authorizeCall)}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	listener, err := net.Listen("tcp", cfg.GRPC.addr())
+	if err != nil {
+		return nil, fmt.Errorf("listening for gRPC: %w", err)
+	}
+	server := grpc.NewServer(opts...)
+	apiv1.RegisterCheckServiceServer(server, checkService{})
+	go func() {
+		slog.Info("Starting gRPC server", "addr", listener.Addr().String(), "tls", tlsConfig != nil)
+		if err := server.Serve(listener); err != nil {
+			slog.Error("gRPC server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+	return server, nil
+}
+
+// authorizeCall requires the admin bearer token for rechecks, which are
+// disabled without one, and the metrics basic auth credentials, when set,
+// for queries
+func authorizeCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	cfg := currentConfig()
+	if info.FullMethod == apiv1.CheckService_Recheck_FullMethodName {
+		expected, err := cfg.Admin.token()
+		if err != nil {
+			slog.Error("Error reading token", "method", info.FullMethod, "error", err)
+			return nil, status.Error(codes.Internal, "token unavailable")
+		}
+		if expected == "" {
+			return nil, status.Error(codes.PermissionDenied, "rechecks require an admin token")
+		}
+		provided, ok := strings.CutPrefix(authorization, "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "unauthorized")
+		}
+		return handler(ctx, req)
+	}
+
+	auth := cfg.Metrics.BasicAuth
+	if auth.Username == "" {
+		return handler(ctx, req)
+	}
+	username, password, err := auth.credentials()
+	if err != nil {
+		slog.Error("Error reading metrics basic auth password", "error", err)
+		return nil, status.Error(codes.Internal, "credentials unavailable")
+	}
+	user, pass, ok := (&http.Request{Header: http.Header{"Authorization": {authorization}}}).BasicAuth()
+	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+	passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+	if !ok || !userMatch || !passMatch {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return handler(ctx, req)
+}
+
+// ListResults returns the results of the latest cycle matching the request
+func (checkService) ListResults(_ context.Context, req *apiv1.ListResultsRequest) (*apiv1.ListResultsResponse, error) {
+	report := latestResults.report()
+	resp := &apiv1.ListResultsResponse{GeneratedAt: timestamppb.New(report.GeneratedAt)}
+	for _, r := range report.Results {
+		if (req.Application == "" || r.Application == req.Application) &&
+			(req.Cluster == "" || r.Cluster == req.Cluster) &&
+			(req.Chart == "" || r.Chart == req.Chart) &&
+			(!req.OutdatedOnly || r.outdated()) {
+			resp.Results = append(resp.Results, protoResult(r))
+		}
+	}
+	return resp, nil
+}
+
+// GetHistory returns the kept cycles as /api/v1/history does
+func (checkService) GetHistory(_ context.Context, req *apiv1.GetHistoryRequest) (*apiv1.GetHistoryResponse, error) {
+	if currentConfig().History.Cycles == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "history is disabled")
+	}
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	resp := &apiv1.GetHistoryResponse{}
+	for _, c := range checkHistory.query(req.Application, req.Cluster, req.Chart, int(req.Limit)) {
+		cycle := &apiv1.Cycle{StartedAt: timestamppb.New(c.StartedAt), CompletedAt: timestamppb.New(c.CompletedAt)}
+		for _, r := range c.Results {
+			cycle.Results = append(cycle.Results, protoResult(r))
+		}
+		for _, e := range c.Errors {
+			cycle.Errors = append(cycle.Errors, &apiv1.CheckError{Cluster: e.Cluster, Application: e.Application, Chart: e.Chart, RepoUrl: e.RepoURL, Error: e.Error})
+		}
+		resp.Cycles = append(resp.Cycles, cycle)
+	}
+	return resp, nil
+}
+
+// Recheck queues a check as /reconcile does
+func (checkService) Recheck(ctx context.Context, req *apiv1.RecheckRequest) (*apiv1.RecheckResponse, error) {
+	if !queueReconcile(req.Application) {
+		return nil, status.Error(codes.ResourceExhausted, "too many reconciles queued")
+	}
+	remote := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	slog.Info("Reconcile requested", "application", req.Application, "remote", remote)
+	return &apiv1.RecheckResponse{}, nil
+}
+
+// protoResult converts a result to its gRPC message
+func protoResult(r chartResult) *apiv1.ChartResult {
+	result := &apiv1.ChartResult{
+		Cluster:              r.Cluster,
+		Check:                r.Check,
+		Application:          r.Application,
+		Namespace:            r.Namespace,
+		Project:              r.Project,
+		Labels:               r.Labels,
+		DestinationCluster:   r.DestinationCluster,
+		DestinationNamespace: r.DestinationNamespace,
+		Source:               r.Source,
+		Chart:                r.Chart,
+		RepoUrl:              r.RepoURL,
+		CurrentVersion:       r.CurrentVersion,
+		TargetRevision:       r.TargetRevision,
+		LatestVersion:        r.LatestVersion,
+		UpToDate:             r.UpToDate,
+		Ahead:                r.Ahead,
+		Deprecated:           r.Deprecated,
+		Missing:              r.Missing,
+	}
+	if d := r.Behind; d != nil {
+		result.Behind = &apiv1.VersionDelta{Major: d.Major, Minor: d.Minor, Patch: d.Patch}
+	}
+	return result
+}
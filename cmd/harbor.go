@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// harborPageSize is the number of artifacts requested per Harbor API page
+const harborPageSize = 100
+
+// harborHosts remembers which registries were detected as Harbor
+var harborHosts sync.Map
+
+// harborArtifact is the subset of a Harbor artifact used for chart versions
+type harborArtifact struct {
+	Type string `json:"type"`
+	Tags []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	ScanOverview map[string]struct {
+		ScanStatus string `json:"scan_status"`
+		Severity   string `json:"severity"`
+		Summary    struct {
+			Total   int `json:"total"`
+			Fixable int `json:"fixable"`
+		} `json:"summary"`
+	} `json:"scan_overview"`
+}
+
+// isHarbor reports whether the registry of ref is Harbor, either because
+// its repository is configured with api: harbor or because it answers the
+// Harbor ping endpoint; detection happens once per registry
+func isHarbor(ctx context.Context, ref ociReference) bool {
+	if repo := currentConfig().repositoryFor(ref.repoURL()); repo != nil && repo.API != "" {
+		return repo.API == "harbor"
+	}
+	if detected, ok := harborHosts.Load(ref.Registry); ok {
+		return detected.(bool)
+	}
+	detected := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+ref.Registry+"/api/v2.0/ping", nil)
+	if err != nil {
+		return false
+	}
+	if resp, err := repoDo(ref.repoURL(), req); err == nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 16))
+		resp.Body.Close()
+		detected = resp.StatusCode == http.StatusOK && strings.TrimSpace(string(body)) == "Pong"
+	} else if ctx.Err() != nil {
+		return false
+	}
+	harborHosts.Store(ref.Registry, detected)
+	if detected {
+		slog.Info("Detected Harbor registry", "registry", ref.Registry)
+	}
+	return detected
+}
+
+// listHarborChartVersions lists the chart versions of ref through the Harbor
+// artifacts API along with their scan overview
+func listHarborChartVersions(ctx context.Context, ref ociReference) ([]ociVersion, error) {
+	project, repository, ok := strings.Cut(ref.Repository, "/")
+	if !ok {
+		return nil, fmt.Errorf("Harbor repository %s has no project", ref.Repository)
+	}
+	// repository names with slashes must be encoded twice
+	escaped := url.PathEscape(url.PathEscape(repository))
+
+	policy := contextConfig(ctx).Policy.semver()
+	var versions []ociVersion
+	for page := 1; ; page++ {
+		endpoint := fmt.Sprintf("https://%s/api/v2.0/projects/%s/repositories/%s/artifacts?with_tag=true&with_scan_overview=true&page=%d&page_size=%d",
+			ref.Registry, url.PathEscape(project), escaped, page, harborPageSize)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := ociClient.auth.Authenticate(req, ref.repoURL()); err != nil {
+			return nil, err
+		}
+		resp, err := repoDo(ref.repoURL(), req)
+		if err != nil {
+			return nil, err
+		}
+		var artifacts []harborArtifact
+		err = decodeHarborResponse(resp, &artifacts)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listing Harbor artifacts of %s: %w", ref.Repository, err)
+		}
+
+		for _, a := range artifacts {
+			scan := a.scanSummary()
+			for _, tag := range a.Tags {
+				v, err := policy.Parse(strings.ReplaceAll(tag.Name, "_", "+"))
+				if err != nil {
+					slog.Debug("Ignoring non-semver tag", "tag", tag.Name, "repository", ref.Repository)
+					continue
+				}
+				if v.Prerelease() != "" && contextConfig(ctx).Policy.IgnorePrereleases {
+					continue
+				}
+				versions = append(versions, ociVersion{Tag: tag.Name, Version: v, Scan: scan})
+			}
+		}
+		if len(artifacts) < harborPageSize {
+			break
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return policy.CompareSemver(versions[i].Version, versions[j].Version) > 0
+	})
+	slog.Debug("Found Harbor chart versions", "registry", ref.Registry, "repository", ref.Repository, "count", len(versions))
+	return versions, nil
+}
+
+func decodeHarborResponse(resp *http.Response, v interface{}) error {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// scanSummary returns the first scan report of an artifact, or nil if it was never scanned
+func (a harborArtifact) scanSummary() *repository.ScanSummary {
+	for _, report := range a.ScanOverview {
+		return &repository.ScanSummary{
+			Status:   report.ScanStatus,
+			Severity: report.Severity,
+			Total:    report.Summary.Total,
+			Fixable:  report.Summary.Fixable,
+		}
+	}
+	return nil
+}
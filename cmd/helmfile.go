@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// helmfileNames are the files read from a helmfile directory, in the order
+// helmfile looks for them
+var helmfileNames = []string{"helmfile.yaml", "helmfile.yaml.gotmpl"}
+
+// templateActionLine matches lines holding only template actions, such as
+// {{ if }} and {{ end }}, which are blanked before parsing; their newlines
+// are kept so release lines keep their numbers
+var templateActionLine = regexp.MustCompile(`(?m)^[ \t]*\{\{[^}]*\}\}[ \t\r]*$`)
+
+// helmfileRelease is a release of a helmfile with the repository its chart
+// resolves to and the line of its version
+type helmfileRelease struct {
+	name      string
+	namespace string
+	chart     string
+	repoName  string
+	repoURL   string
+	version   string
+	line      int
+}
+
+// helmfileFile is the content of one helmfile
+type helmfileFile struct {
+	path string
+	data []byte
+}
+
+// checkHelmfiles checks the releases of the configured helmfiles, reporting
+// each as the application <name>/<release>
+func checkHelmfiles(ctx context.Context, cfg *config) []chartResult {
+	var results []chartResult
+	for _, h := range cfg.Helmfiles {
+		if ctx.Err() != nil {
+			return results
+		}
+		name := valueOr(h.Name, path.Join(h.RepoURL, h.Path))
+		if !cfg.Sharding.owns(name) {
+			continue
+		}
+		log := slog.With("helmfile", name)
+		files, err := readHelmfile(ctx, h)
+		if err != nil {
+			log.Error("Error reading helmfile", "error", err)
+			continue
+		}
+		for _, file := range files {
+			releases, err := parseHelmfile(file.data)
+			if err != nil {
+				log.Error("Error parsing helmfile", "file", file.path, "error", err)
+				continue
+			}
+			for _, rel := range releases {
+				if rel.repoURL == "" || rel.version == "" {
+					log.Debug("Skipping release without repository or version", "release", rel.name, "chart", rel.chart)
+					skipCheck(skipIncompleteSource)
+					continue
+				}
+				source := map[string]interface{}{
+					"repoURL":        rel.repoURL,
+					"chart":          rel.chart,
+					"targetRevision": rel.version,
+				}
+				result := processHelmSource(withConfig(ctx, cfg), name+"/"+rel.name, rel.namespace, source)
+				if result == nil {
+					continue
+				}
+				result.DestinationNamespace = rel.namespace
+				result.File = file.path
+				result.Line = rel.line
+				result.OutdatedSince = outdatedCharts.observe(*result)
+				recordMetrics(*result)
+				results = append(results, *result)
+			}
+		}
+	}
+	return results
+}
+
+// readHelmfile returns the helmfiles of h
+func readHelmfile(ctx context.Context, h helmfileConfig) ([]helmfileFile, error) {
+	if h.RepoURL != "" {
+		candidates := []string{h.Path}
+		if !isHelmfileName(h.Path) {
+			candidates = nil
+			for _, name := range helmfileNames {
+				candidates = append(candidates, path.Join(h.Path, name))
+			}
+		}
+		for _, file := range candidates {
+			data, err := readGitFile(ctx, h.RepoURL, h.Revision, file)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			return []helmfileFile{{path: file, data: data}}, nil
+		}
+		return nil, fmt.Errorf("helmfile in %s: %w", h.Path, fs.ErrNotExist)
+	}
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{h.Path}
+	if info.IsDir() {
+		files = nil
+		for _, name := range helmfileNames {
+			if _, err := os.Stat(filepath.Join(h.Path, name)); err == nil {
+				files = []string{filepath.Join(h.Path, name)}
+				break
+			}
+		}
+		if files == nil {
+			// helmfile.d holds helmfiles applied in the order of their names
+			for _, pattern := range []string{"*.yaml", "*.yaml.gotmpl", "*.yml"} {
+				matches, _ := filepath.Glob(filepath.Join(h.Path, "helmfile.d", pattern))
+				files = append(files, matches...)
+			}
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("helmfile in %s: %w", h.Path, fs.ErrNotExist)
+		}
+	}
+	var contents []helmfileFile
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, helmfileFile{path: filepath.ToSlash(file), data: data})
+	}
+	return contents, nil
+}
+
+func isHelmfileName(file string) bool {
+	return strings.HasSuffix(file, ".yaml") || strings.HasSuffix(file, ".yml") || strings.HasSuffix(file, ".gotmpl")
+}
+
+// parseHelmfile returns the releases of the documents of a helmfile,
+// resolving charts as <repository>/<chart> against its repositories. The
+// helmfile is not rendered: lines of template actions are blanked and
+// documents that are still not valid YAML are skipped, as are local charts.
+func parseHelmfile(data []byte) ([]helmfileRelease, error) {
+	data = templateActionLine.ReplaceAll(data, nil)
+	repos := make(map[string]string)
+	var releases []helmfileRelease
+	parsed := 0
+	var lastErr error
+	for _, doc := range splitDocuments(data) {
+		var node yaml.Node
+		if err := yaml.Unmarshal(doc.data, &node); err != nil {
+			lastErr = err
+			continue
+		}
+		parsed++
+		if len(node.Content) == 0 {
+			continue
+		}
+		shiftLines(&node, doc.line-1)
+		root := node.Content[0]
+		if list := mappingValue(root, "repositories"); list != nil && list.Kind == yaml.SequenceNode {
+			for _, r := range list.Content {
+				var repo struct {
+					Name string `yaml:"name"`
+					URL  string `yaml:"url"`
+					OCI  bool   `yaml:"oci"`
+				}
+				if err := r.Decode(&repo); err != nil || repo.Name == "" || repo.URL == "" {
+					continue
+				}
+				if repo.OCI && !strings.HasPrefix(repo.URL, "oci://") {
+					repo.URL = "oci://" + repo.URL
+				}
+				repos[repo.Name] = repo.URL
+			}
+		}
+		list := mappingValue(root, "releases")
+		if list == nil || list.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, r := range list.Content {
+			var rel struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+				Chart     string `yaml:"chart"`
+				Version   string `yaml:"version"`
+				Installed *bool  `yaml:"installed"`
+			}
+			if err := r.Decode(&rel); err != nil || rel.Chart == "" {
+				continue
+			}
+			if rel.Installed != nil && !*rel.Installed {
+				continue
+			}
+			release := helmfileRelease{name: rel.Name, namespace: rel.Namespace, version: rel.Version, line: r.Line}
+			if v := mappingValue(r, "version"); v != nil {
+				release.line = v.Line
+			}
+			if ref, ok := strings.CutPrefix(rel.Chart, "oci://"); ok {
+				i := strings.LastIndex(ref, "/")
+				if i < 0 {
+					continue
+				}
+				release.repoURL, release.chart = "oci://"+ref[:i], ref[i+1:]
+			} else if repoName, chartName, ok := strings.Cut(rel.Chart, "/"); ok && !strings.HasPrefix(rel.Chart, ".") {
+				release.repoName, release.chart = repoName, chartName
+			} else {
+				continue
+			}
+			release.name = valueOr(release.name, release.chart)
+			releases = append(releases, release)
+		}
+	}
+	if parsed == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	// Repositories may be declared in any document
+	for i := range releases {
+		if releases[i].repoName != "" {
+			releases[i].repoURL = repos[releases[i].repoName]
+		}
+	}
+	return releases, nil
+}
+
+// documentSeparator matches the lines separating YAML documents
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// yamlDocument is a document of a YAML stream with the line it starts on
+type yamlDocument struct {
+	data []byte
+	line int
+}
+
+// splitDocuments splits a YAML stream so each document is parsed on its own
+// and an invalid one does not hide the others
+func splitDocuments(data []byte) []yamlDocument {
+	var docs []yamlDocument
+	start, line := 0, 1
+	for _, loc := range documentSeparator.FindAllIndex(data, -1) {
+		docs = append(docs, yamlDocument{data: data[start:loc[0]], line: line})
+		line += bytes.Count(data[start:loc[1]], []byte("\n"))
+		start = loc[1]
+	}
+	return append(docs, yamlDocument{data: data[start:], line: line})
+}
+
+// shiftLines adds offset to the lines of a node and its children
+func shiftLines(node *yaml.Node, offset int) {
+	node.Line += offset
+	for _, child := range node.Content {
+		shiftLines(child, offset)
+	}
+}
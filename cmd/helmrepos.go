@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/helmpath"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// helmRepoAlias returns the repository name of a repoURL written as @name or
+// alias:name, as in the dependencies of a Chart.yaml
+func helmRepoAlias(repoURL string) (string, bool) {
+	if name, ok := strings.CutPrefix(repoURL, "@"); ok {
+		return name, name != ""
+	}
+	if name, ok := strings.CutPrefix(repoURL, "alias:"); ok {
+		return name, name != ""
+	}
+	return "", false
+}
+
+// resolveHelmRepoAlias returns the URL of the named repository in the Helm
+// repositories file
+func resolveHelmRepoAlias(cfg credentialsConfig, name string) (string, error) {
+	entries, err := helmRepositories(cfg)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry.URL, nil
+		}
+	}
+	return "", fmt.Errorf("repository %q not found in %s", name, cfg.helmRepositoriesFile())
+}
+
+// helmRepoCredentials returns the username and password of the Helm
+// repositories file entry whose URL repoURL is within, on the same scheme and
+// host, or empty strings
+func helmRepoCredentials(cfg credentialsConfig, repoURL string) (string, string, error) {
+	entries, err := helmRepositories(cfg)
+	if err != nil {
+		return "", "", err
+	}
+	for _, entry := range entries {
+		if entry.URL != "" && urlWithin(repoURL, entry.URL) {
+			return entry.Username, entry.Password, nil
+		}
+	}
+	return "", "", nil
+}
+
+// helmRepositories reads the entries of the Helm repositories file; a missing
+// file has none. It is read on every call, like .netrc, so updates of a
+// mounted Secret apply without a restart.
+func helmRepositories(cfg credentialsConfig) ([]*repo.Entry, error) {
+	path := cfg.helmRepositoriesFile()
+	if path == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	file, err := repo.LoadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading Helm repositories file: %w", err)
+	}
+	return file.Repositories, nil
+}
+
+// helmRepositoriesFile returns the configured path, $HELM_REPOSITORY_CONFIG
+// or the default of the helm CLI
+func (c credentialsConfig) helmRepositoriesFile() string {
+	if c.HelmReposFile != "" {
+		return c.HelmReposFile
+	}
+	if v := os.Getenv("HELM_REPOSITORY_CONFIG"); v != "" {
+		return v
+	}
+	return helmpath.ConfigPath("repositories.yaml")
+}
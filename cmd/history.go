@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cycleRecord is a completed check cycle kept in the history
+type cycleRecord struct {
+	StartedAt   time.Time     `json:"startedAt"`
+	CompletedAt time.Time     `json:"completedAt"`
+	Results     []chartResult `json:"results"`
+	Errors      []checkError  `json:"errors"`
+}
+
+// checkError is a chart that could not be checked in a cycle and so has no
+// result in it
+type checkError struct {
+	Cluster     string `json:"cluster,omitempty"`
+	Instance    string `json:"instance,omitempty"`
+	Application string `json:"application"`
+	Chart       string `json:"chart,omitempty"`
+	RepoURL     string `json:"repoURL,omitempty"`
+	Error       string `json:"error"`
+}
+
+// checkErrors collects the errors of the checks of a cycle
+type checkErrors struct {
+	mu     sync.Mutex
+	errors []checkError
+}
+
+type checkErrorsKey struct{}
+
+// withCheckErrors makes checks done with ctx record their errors in errs
+func withCheckErrors(ctx context.Context, errs *checkErrors) context.Context {
+	return context.WithValue(ctx, checkErrorsKey{}, errs)
+}
+
+// recordCheckError adds an error to the cycle of ctx, if any
+func recordCheckError(ctx context.Context, appName, chartName, repoURL string, err error) {
+	errs, ok := ctx.Value(checkErrorsKey{}).(*checkErrors)
+	if !ok || ctx.Err() != nil {
+		return
+	}
+	errs.mu.Lock()
+	defer errs.mu.Unlock()
+	cluster := contextCluster(ctx).name
+	instance := contextConfig(ctx).Instance.name(cluster, contextApplication(ctx).Namespace)
+	errs.errors = append(errs.errors, checkError{Cluster: cluster, Instance: instance, Application: appName, Chart: chartName, RepoURL: repoURL, Error: err.Error()})
+}
+
+func (e *checkErrors) list() []checkError {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]checkError{}, e.errors...)
+}
+
+// cycleHistory holds the most recent cycles, oldest first
+type cycleHistory struct {
+	mu     sync.RWMutex
+	cycles []cycleRecord
+}
+
+var checkHistory = &cycleHistory{}
+
+// add appends a cycle, dropping the oldest beyond size; a size of zero
+// clears the history
+func (h *cycleHistory) add(record cycleRecord, size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if record.Results == nil {
+		record.Results = []chartResult{}
+	}
+	h.cycles = append(h.cycles, record)
+	if drop := len(h.cycles) - size; drop > 0 {
+		h.cycles = append([]cycleRecord(nil), h.cycles[drop:]...)
+	}
+}
+
+// ServeHTTP writes the kept cycles, newest first, narrowed by the app,
+// cluster and chart query parameters and limited to limit cycles
+func (h *cycleHistory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if currentConfig().History.Cycles == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	cycles := h.query(q.Get("app"), q.Get("cluster"), q.Get("chart"), limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(struct {
+		Cycles []cycleRecord `json:"cycles"`
+	}{cycles}); err != nil {
+		slog.Error("Error writing history", "error", err)
+	}
+}
+
+// query returns up to limit kept cycles, all when zero, newest first, with
+// the results and errors matching app, cluster and chart when set
+func (h *cycleHistory) query(app, cluster, chart string, limit int) []cycleRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	cycles := make([]cycleRecord, 0, len(h.cycles))
+	for i := len(h.cycles) - 1; i >= 0 && (limit == 0 || len(cycles) < limit); i-- {
+		c := h.cycles[i]
+		record := cycleRecord{StartedAt: c.StartedAt, CompletedAt: c.CompletedAt, Results: []chartResult{}, Errors: []checkError{}}
+		for _, res := range c.Results {
+			if (app == "" || res.Application == app) && (cluster == "" || res.Cluster == cluster) && (chart == "" || res.Chart == chart) {
+				record.Results = append(record.Results, res)
+			}
+		}
+		for _, e := range c.Errors {
+			if (app == "" || e.Application == app) && (cluster == "" || e.Cluster == cluster) && (chart == "" || e.Chart == chart) {
+				record.Errors = append(record.Errors, e)
+			}
+		}
+		cycles = append(cycles, record)
+	}
+	return cycles
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// repoGet fetches a repository URL, adding the basic auth credentials
+// configured for the longest matching repository prefix
+func repoGet(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := newRepoRequest(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return repoDo(rawURL, req)
+}
+
+// urlWithin reports whether rawURL is prefix or below it: the same scheme and
+// host, and the path of prefix or one under it. References without a scheme,
+// such as git@github.com:owner/name, match when prefix is followed by a / or
+// : in rawURL, so a look-alike host never matches.
+func urlWithin(rawURL, prefix string) bool {
+	if !strings.Contains(rawURL, "://") && !strings.Contains(prefix, "://") {
+		rest, ok := strings.CutPrefix(strings.ToLower(rawURL), strings.ToLower(strings.TrimSuffix(prefix, "/")))
+		return ok && prefix != "" && (rest == "" || rest[0] == '/' || rest[0] == ':')
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	p, err := url.Parse(prefix)
+	if err != nil || p.Host == "" {
+		return false
+	}
+	if !strings.EqualFold(u.Scheme, p.Scheme) || !strings.EqualFold(u.Host, p.Host) {
+		return false
+	}
+	base := strings.TrimSuffix(p.Path, "/")
+	return base == "" || u.Path == base || strings.HasPrefix(u.Path, base+"/")
+}
+
+// repoDo sends a request for repoURL through the client matching its
+// repository settings once the host's rate limit allows it, failing fast
+// while the host's circuit is open or it asked to back off
+func repoDo(repoURL string, req *http.Request) (*http.Response, error) {
+	cfg := currentConfig()
+	client, err := repoClients.get(cfg.repositoryFor(repoURL), argoCDTLSCerts(req.Context(), req.URL.Host))
+	if err != nil {
+		return nil, err
+	}
+	host := req.URL.Host
+	if err := repoBreakers.allow(host, cfg.CircuitBreaker); err != nil {
+		return nil, err
+	}
+	if err := repoBackoffs.allow(host); err != nil {
+		return nil, err
+	}
+	if err := pace(req.Context()); err != nil {
+		return nil, err
+	}
+	if err := repoLimiters.wait(req.Context(), host, cfg.RateLimit); err != nil {
+		return nil, err
+	}
+	resp, err := tracedDo(client, req)
+	if err == nil {
+		repoBackoffs.record(host, resp)
+		if final := resp.Request.URL; cfg.Redirects.LogFinalURL && final.String() != req.URL.String() {
+			slog.Info("Followed repository redirect", "url", redactedURL(req.URL), "final_url", redactedURL(final))
+		}
+	}
+	repoResponsesCounter.WithLabelValues(host, responseClass(resp, err)).Inc()
+	if req.Context().Err() == nil {
+		repoBreakers.record(host, requestFailed(resp, err), cfg.CircuitBreaker)
+	}
+	return resp, err
+}
+
+// repoResponsesCounter counts repository responses per host by status class,
+// so 401 storms and server errors show up in metrics
+var repoResponsesCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "helm_repository_responses_total",
+		Help: "Responses of repository hosts by status class (2xx, 3xx, 4xx, 5xx), or error when no response was received",
+	},
+	[]string{"host", "code"},
+)
+
+func init() {
+	prometheus.MustRegister(repoResponsesCounter)
+}
+
+// responseClass returns the status class of a response, or error
+func responseClass(resp *http.Response, err error) string {
+	if err != nil {
+		return "error"
+	}
+	return fmt.Sprintf("%dxx", resp.StatusCode/100)
+}
+
+// newRepoRequest builds a GET request for a repository URL with its credentials
+func newRepoRequest(ctx context.Context, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := setRepoCredentials(req, rawURL); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// tracedDo sends req within a client span recording the URL and status
+func tracedDo(client *http.Client, req *http.Request) (*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", redactedURL(req.URL)),
+			attribute.String("server.address", req.URL.Host),
+		))
+	defer span.End()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
+
+// repoClients caches one HTTP client per repository transport setting
+var repoClients = &clientCache{clients: map[transportKey]*http.Client{}}
+
+// defaultRepoClient is used for repositories without transport settings
+var defaultRepoClient = &http.Client{CheckRedirect: checkRepoRedirect}
+
+// checkRepoRedirect follows redirects up to the configured limit, keeping
+// credential headers only on the original host unless forwarding is
+// enabled; Go itself would forward Authorization to subdomains and every
+// other header anywhere
+func checkRepoRedirect(req *http.Request, via []*http.Request) error {
+	cfg := currentConfig()
+	redirects := cfg.Redirects
+	if len(via) > redirects.Max {
+		return fmt.Errorf("stopped after %d redirects", redirects.Max)
+	}
+	first := via[0]
+	if req.URL.Host == first.URL.Host {
+		return nil
+	}
+	for _, name := range credentialHeaders(cfg) {
+		value := first.Header.Get(name)
+		switch {
+		case value == "":
+		case redirects.ForwardAuthorization:
+			req.Header.Set(name, value)
+		default:
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// credentialHeaders returns the headers that may carry repository
+// credentials: Authorization, the GitLab token and those configured for
+// any repository
+func credentialHeaders(cfg *config) []string {
+	names := []string{"Authorization", "PRIVATE-TOKEN"}
+	for _, repo := range cfg.Repositories {
+		for name := range repo.Headers {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// redactedURL returns u without credentials or query, which may hold
+// signatures of presigned storage URLs
+func redactedURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	redacted.RawQuery = ""
+	return redacted.String()
+}
+
+// transportKey identifies the transport settings of a repository
+type transportKey struct {
+	proxy              string
+	insecureSkipVerify bool
+	// caCerts are PEM certificates trusted in addition to the system roots,
+	// those Argo CD trusts for the server
+	caCerts string
+}
+
+type clientCache struct {
+	mu      sync.Mutex
+	clients map[transportKey]*http.Client
+}
+
+// get returns the client for repo trusting caCerts, the default client when
+// it has no transport settings
+func (c *clientCache) get(repo *repositoryConfig, caCerts string) (*http.Client, error) {
+	key := transportKey{caCerts: caCerts}
+	if repo != nil {
+		key.proxy, key.insecureSkipVerify = repo.Proxy, repo.InsecureSkipVerify
+	}
+	if key == (transportKey{}) {
+		return defaultRepoClient, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.clients[key]; ok {
+		return client, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if key.proxy != "" {
+		proxyURL, err := url.Parse(key.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy for %s: %w", repo.URL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if key.insecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	} else if key.caCerts != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(key.caCerts)) {
+			return nil, fmt.Errorf("no certificates found in %s", argoCDTLSCertsConfigMap)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	client := &http.Client{Transport: transport, CheckRedirect: checkRepoRedirect}
+	c.clients[key] = client
+	return client, nil
+}
+
+// setRepoCredentials adds the headers and credentials configured for repoURL
+// to req, falling back to the Argo CD repository and repo-creds secrets, cloud
+// identities, the Helm repositories file, .netrc and the Docker config when
+// none are configured
+func setRepoCredentials(req *http.Request, repoURL string) error {
+	cfg := currentConfig()
+	repo := cfg.repositoryFor(repoURL)
+	if repo != nil {
+		for name, value := range repo.Headers {
+			req.Header.Set(name, value)
+		}
+	}
+	if isAzureBlobHost(req.URL.Host) {
+		if ok, err := setAzureBlobAuth(req, repo, cfg.Credentials); ok || err != nil {
+			return err
+		}
+	}
+	if repo != nil {
+		if repo.OAuth2.TokenURL != "" {
+			token, err := oauth2Tokens.accessToken(&repo.OAuth2)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}
+		if repo.hasToken() {
+			token, err := repo.token()
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}
+		username, password, err := repo.credentials()
+		if err != nil {
+			return err
+		}
+		if username != "" || password != "" {
+			req.SetBasicAuth(username, password)
+			return nil
+		}
+		// Entries setting only a proxy, TLS or headers fall through, unless
+		// the headers authenticate already
+		if req.Header.Get("Authorization") != "" {
+			return nil
+		}
+	}
+	argoRepo, err := argoCDRepoCredentials(req.Context(), repoURL)
+	if err != nil {
+		slog.Debug("Skipping Argo CD repository credentials", "repo_url", repoURL, "error", err)
+	} else if argoRepo != nil && (argoRepo.username != "" || argoRepo.password != "") {
+		req.SetBasicAuth(argoRepo.username, argoRepo.password)
+		return nil
+	}
+	username, password, found, err := cloudCredentials(req.Context(), cfg.Credentials, repoURL)
+	if err != nil {
+		return err
+	}
+	if found {
+		req.SetBasicAuth(username, password)
+		return nil
+	}
+	username, password, err = helmRepoCredentials(cfg.Credentials, repoURL)
+	if err != nil {
+		return err
+	}
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+		return nil
+	}
+	username, password, err = fileCredentials(cfg.Credentials, repoURL)
+	if err != nil {
+		return err
+	}
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+	return nil
+}
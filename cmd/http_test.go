@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetRepoCredentials(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	if err := os.WriteFile(netrc, []byte("machine charts.example.com login netrc-user password netrc-password\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		repo         repositoryConfig
+		wantUser     string
+		wantPassword string
+	}{
+		{name: "basic auth", repo: repositoryConfig{Username: "reader", Password: "secret"}, wantUser: "reader", wantPassword: "secret"},
+		{name: "proxy only", repo: repositoryConfig{Proxy: "http://proxy.internal:3128"}, wantUser: "netrc-user", wantPassword: "netrc-password"},
+		{name: "headers only", repo: repositoryConfig{Headers: map[string]string{"X-Tenant": "platform"}}, wantUser: "netrc-user", wantPassword: "netrc-password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tt.repo.URL = "https://charts.example.com"
+			cfg.Repositories = []repositoryConfig{tt.repo}
+			cfg.Credentials.NetrcFile = netrc
+			activeConfig.Store(&cfg)
+			req, err := http.NewRequest(http.MethodGet, "https://charts.example.com/index.yaml", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := setRepoCredentials(req, "https://charts.example.com"); err != nil {
+				t.Fatal(err)
+			}
+			user, password, _ := req.BasicAuth()
+			if user != tt.wantUser || password != tt.wantPassword {
+				t.Errorf("basic auth = %q:%q, want %q:%q", user, password, tt.wantUser, tt.wantPassword)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+)
+
+var imageTagGauge = metrics.NewExpiringGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_image_tag_status",
+		Help: "Whether an image tag set in the Helm values of an application is the latest semver tag of its registry (1 = up to date, 0 = outdated)",
+	},
+	[]string{"application", "chart", "path", "image", "current_tag", "latest_tag", "cluster", "argocd_instance", "source"},
+	15*time.Minute,
+)
+
+func init() {
+	prometheus.MustRegister(imageTagGauge)
+}
+
+// imageTagStatus compares an image tag set in the Helm values of an
+// application with the latest tag of the image
+type imageTagStatus struct {
+	// Path is the values key of the image, such as controller.image
+	Path      string `json:"path"`
+	Image     string `json:"image"`
+	Tag       string `json:"tag"`
+	LatestTag string `json:"latestTag"`
+	UpToDate  bool   `json:"upToDate"`
+}
+
+// valueImage is an image referenced in Helm values
+type valueImage struct {
+	path, image, tag string
+}
+
+// valueImages finds the images of Helm values: maps with a repository and a
+// tag, optionally a registry, as most charts use, and image strings with a
+// tag. Digests and images without a tag are left out.
+func valueImages(vals map[string]interface{}) []valueImage {
+	var images []valueImage
+	var walk func(path string, node interface{})
+	walk = func(path string, node interface{}) {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if repo, ok := n["repository"].(string); ok && repo != "" {
+				if tag := fmt.Sprint(n["tag"]); n["tag"] != nil && tag != "" {
+					if registry, ok := n["registry"].(string); ok && registry != "" {
+						repo = strings.TrimSuffix(registry, "/") + "/" + repo
+					}
+					images = append(images, valueImage{path: path, image: repo, tag: tag})
+					return
+				}
+			}
+			keys := make([]string, 0, len(n))
+			for k := range n {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				child := k
+				if path != "" {
+					child = path + "." + k
+				}
+				if ref, ok := n[k].(string); ok && k == "image" {
+					if name, tag := splitImageReference(ref); strings.Contains(ref, ":") && !strings.HasPrefix(tag, "@") {
+						images = append(images, valueImage{path: child, image: name, tag: tag})
+					}
+					continue
+				}
+				walk(child, n[k])
+			}
+		case []interface{}:
+			for i, v := range n {
+				walk(fmt.Sprintf("%s[%d]", path, i), v)
+			}
+		}
+	}
+	walk("", vals)
+	return images
+}
+
+// imageReference locates an image such as nginx or ghcr.io/org/app in its
+// registry, Docker Hub by default
+func imageReference(image string) ociReference {
+	registry, repo, ok := strings.Cut(image, "/")
+	if !ok || (!strings.ContainsAny(registry, ".:") && registry != "localhost") {
+		registry, repo = "docker.io", image
+	}
+	if registry == "docker.io" || registry == "index.docker.io" {
+		registry = "registry-1.docker.io"
+		if !strings.Contains(repo, "/") {
+			repo = "library/" + repo
+		}
+	}
+	return ociReference{Registry: registry, Repository: repo}
+}
+
+// imageTagsCache keeps the tags listed for each image for the configured TTL
+var imageTagsCache = struct {
+	sync.Mutex
+	entries map[string]imageTagsEntry
+}{entries: map[string]imageTagsEntry{}}
+
+type imageTagsEntry struct {
+	tags    []string
+	expires time.Time
+}
+
+// imageTags lists the tags of an image, cached for ttl
+func imageTags(ctx context.Context, image string, ttl time.Duration) ([]string, error) {
+	imageTagsCache.Lock()
+	entry, ok := imageTagsCache.entries[image]
+	imageTagsCache.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.tags, nil
+	}
+	tags, err := ociClient.listTags(ctx, imageReference(image))
+	if errors.Is(err, errChartNotFound) {
+		return nil, fmt.Errorf("image %s not found in its registry", image)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing tags of %s: %w", image, err)
+	}
+	imageTagsCache.Lock()
+	imageTagsCache.entries[image] = imageTagsEntry{tags: tags, expires: time.Now().Add(ttl)}
+	imageTagsCache.Unlock()
+	return tags, nil
+}
+
+// latestImageTag returns the newest semver tag among tags, keeping the v
+// prefix of current and skipping prereleases unless current is one. It is
+// false when current is not semver, as such tags cannot be ordered.
+func latestImageTag(current string, tags []string) (string, bool) {
+	cv, err := semver.NewVersion(current)
+	if err != nil {
+		return "", false
+	}
+	prefixed := strings.HasPrefix(current, "v")
+	latest, latestTag := cv, current
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "v") != prefixed {
+			continue
+		}
+		v, err := semver.NewVersion(tag)
+		if err != nil || (v.Prerelease() != "" && cv.Prerelease() == "") {
+			continue
+		}
+		if v.GreaterThan(latest) {
+			latest, latestTag = v, tag
+		}
+	}
+	return latestTag, true
+}
+
+// checkValueImages compares the image tags of an Application's Helm values
+// with the latest tags of their registries
+func checkValueImages(ctx context.Context, appName string, source map[string]interface{}, ttl time.Duration) []imageTagStatus {
+	vals, err := applicationValues(source)
+	if err != nil {
+		slog.Warn("Error reading Helm values", "application", appName, "error", err)
+		return nil
+	}
+	var statuses []imageTagStatus
+	for _, img := range valueImages(vals) {
+		tags, err := imageTags(ctx, img.image, ttl)
+		if err != nil {
+			slog.Warn("Error checking image tag", "application", appName, "path", img.path, "image", img.image, "error", err)
+			continue
+		}
+		latest, ok := latestImageTag(img.tag, tags)
+		if !ok {
+			slog.Debug("Cannot compare image tag that is not semver", "application", appName, "image", img.image, "tag", img.tag)
+			continue
+		}
+		statuses = append(statuses, imageTagStatus{
+			Path:      img.path,
+			Image:     img.image,
+			Tag:       img.tag,
+			LatestTag: latest,
+			UpToDate:  latest == img.tag,
+		})
+	}
+	return statuses
+}
+
+// recordImageTags sets the status of the image tags of a result
+func recordImageTags(r chartResult) {
+	for _, s := range r.ImageTags {
+		imageTagGauge.WithLabelValues(r.Application, r.Chart, s.Path, s.Image, s.Tag, s.LatestTag, r.Cluster, r.Instance, r.Source).Set(boolValue(s.UpToDate))
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/Masterminds/semver/v3"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// incidentSource identifies the exporter in incidents and alerts
+const incidentSource = "helm-version-check"
+
+// incidentNotifier opens and resolves incidents for the charts matching its
+// policy, deduplicated by the result key
+type incidentNotifier interface {
+	policy() incidentPolicy
+	open(r chartResult) error
+	resolve(r chartResult) error
+}
+
+// manageIncidents opens incidents for results that start matching a policy,
+// or match it with a new latest version, and resolves those of results that
+// stop matching it or disappear. Alertmanager alerts are pushed as well.
+func manageIncidents(cfg notifiersConfig, previous, current []chartResult) {
+	var notifiers []incidentNotifier
+	for _, pd := range cfg.PagerDuty {
+		notifiers = append(notifiers, pd)
+	}
+	for _, og := range cfg.Opsgenie {
+		notifiers = append(notifiers, og)
+	}
+	for _, gh := range cfg.GitHubIssues {
+		notifiers = append(notifiers, gh)
+	}
+	for _, n := range notifiers {
+		open, resolve := incidentChanges(n.policy(), previous, current)
+		for _, r := range open {
+			if err := n.open(r); err != nil {
+				slog.Error("Error opening incident", "notifier", fmt.Sprintf("%T", n), "application", r.Application, "chart", r.Chart, "error", err)
+			}
+		}
+		for _, r := range resolve {
+			if err := n.resolve(r); err != nil {
+				slog.Error("Error resolving incident", "notifier", fmt.Sprintf("%T", n), "application", r.Application, "chart", r.Chart, "error", err)
+			}
+		}
+	}
+	for _, am := range cfg.Alertmanager {
+		if err := am.push(previous, current); err != nil {
+			slog.Error("Error pushing alerts to Alertmanager", "url", am.URL, "error", err)
+		}
+	}
+}
+
+// incidentChanges returns the current results to open incidents for and the
+// previous results whose incidents are resolved
+func incidentChanges(p incidentPolicy, previous, current []chartResult) (open, resolve []chartResult) {
+	before := make(map[string]chartResult)
+	for _, r := range previous {
+		if p.matches(r) {
+			before[resultKey(r)] = r
+		}
+	}
+	seen := make(map[string]bool, len(current))
+	for _, r := range current {
+		key := resultKey(r)
+		seen[key] = true
+		prev, matched := before[key]
+		switch {
+		case p.matches(r):
+			if !matched || prev.LatestVersion != r.LatestVersion {
+				open = append(open, r)
+			}
+		case matched:
+			resolve = append(resolve, prev)
+		}
+	}
+	for key, prev := range before {
+		if !seen[key] {
+			resolve = append(resolve, prev)
+		}
+	}
+	return open, resolve
+}
+
+// validate checks the level and the selector of the policy
+func (p incidentPolicy) validate() error {
+	switch p.Behind {
+	case "", "major", "minor", "patch":
+	default:
+		return fmt.Errorf("policy.behind must be major, minor or patch, got %q", p.Behind)
+	}
+	if _, err := labels.Parse(p.Selector); err != nil {
+		return fmt.Errorf("policy.selector: %w", err)
+	}
+	return nil
+}
+
+// matches reports whether an outdated result falls under the policy
+func (p incidentPolicy) matches(r chartResult) bool {
+	if !r.outdated() {
+		return false
+	}
+	selector, err := labels.Parse(p.Selector)
+	if err != nil || !selector.Matches(labels.Set(r.Labels)) {
+		return false
+	}
+	return versionBehind(r.CurrentVersion, r.LatestVersion, p.Behind)
+}
+
+// versionBehind reports whether latest is at least a major, minor or patch
+// version ahead of current. Versions that are not semver only count as a
+// patch version behind.
+func versionBehind(current, latest, level string) bool {
+	if level == "patch" {
+		return true
+	}
+	cur, err := semver.NewVersion(current)
+	if err != nil {
+		return false
+	}
+	lat, err := semver.NewVersion(latest)
+	if err != nil {
+		return false
+	}
+	if level == "minor" {
+		return lat.Major() > cur.Major() || lat.Major() == cur.Major() && lat.Minor() > cur.Minor()
+	}
+	return lat.Major() > cur.Major()
+}
+
+// incidentSummary describes an outdated chart in one line
+func incidentSummary(r chartResult) string {
+	summary := fmt.Sprintf("%s: chart %s %s is outdated, %s is available", r.Application, r.Chart, r.CurrentVersion, r.LatestVersion)
+	if r.Cluster != "" {
+		summary = r.Cluster + "/" + summary
+	}
+	return summary
+}
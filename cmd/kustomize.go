@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// kustomizationFiles are the names kustomize reads a kustomization from, in
+// its order
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// kustomization holds the fields of a kustomization.yaml leading to Helm
+// charts inflated by the helmCharts generator
+type kustomization struct {
+	Resources  []string             `json:"resources"`
+	Components []string             `json:"components"`
+	HelmCharts []kustomizeHelmChart `json:"helmCharts"`
+}
+
+type kustomizeHelmChart struct {
+	Name    string `json:"name"`
+	Repo    string `json:"repo"`
+	Version string `json:"version"`
+}
+
+// kustomizeChartRef is a helmCharts entry with the kustomization declaring it
+type kustomizeChartRef struct {
+	file  string
+	chart kustomizeHelmChart
+}
+
+// processKustomization checks the charts pinned in the helmCharts of the
+// kustomization at the path of a git source and of the local bases and
+// components it includes. Charts without a repo or version, which kustomize
+// takes from the chart home or resolves to the latest, are skipped.
+func processKustomization(ctx context.Context, appName, destNamespace, repoURL, revision, dir string) []chartResult {
+	log := slog.With("application", appName, "repo_url", repoURL, "path", dir)
+	refs, err := kustomizeHelmCharts(ctx, repoURL, revision, dir, make(map[string]bool))
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Debug("Skipping git source without Chart.yaml or kustomization")
+		return nil
+	}
+	if err != nil {
+		log.Error("Error reading kustomization from git", "revision", revision, "error", err)
+		return nil
+	}
+	var results []chartResult
+	for _, ref := range refs {
+		if ref.chart.Repo == "" || ref.chart.Version == "" {
+			log.Debug("Skipping helmCharts entry without repo or version", "chart", ref.chart.Name, "file", ref.file)
+			skipCheck(skipIncompleteSource)
+			continue
+		}
+		source := map[string]interface{}{
+			"repoURL":        ref.chart.Repo,
+			"chart":          ref.chart.Name,
+			"targetRevision": ref.chart.Version,
+		}
+		if result := processHelmSource(ctx, appName, destNamespace, source); result != nil {
+			result.Kustomization = ref.file
+			results = append(results, *result)
+		}
+	}
+	return results
+}
+
+// kustomizeHelmCharts returns the helmCharts of the kustomization in dir and
+// of the local directories among its resources and components, or an error
+// wrapping fs.ErrNotExist when dir has no kustomization
+func kustomizeHelmCharts(ctx context.Context, repoURL, revision, dir string, visited map[string]bool) ([]kustomizeChartRef, error) {
+	dir = path.Clean(dir)
+	if visited[dir] {
+		return nil, nil
+	}
+	visited[dir] = true
+	file, k, err := readKustomization(ctx, repoURL, revision, dir)
+	if err != nil {
+		return nil, err
+	}
+	var refs []kustomizeChartRef
+	for _, chart := range k.HelmCharts {
+		refs = append(refs, kustomizeChartRef{file: file, chart: chart})
+	}
+	for _, resource := range append(k.Resources, k.Components...) {
+		// Remote bases and manifest files have no charts to check
+		if strings.Contains(resource, "://") || strings.Contains(resource, "?") || path.Ext(resource) != "" {
+			continue
+		}
+		nested, err := kustomizeHelmCharts(ctx, repoURL, revision, path.Join(dir, resource), visited)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, nested...)
+	}
+	return refs, nil
+}
+
+// readKustomization reads the first kustomization file found in dir
+func readKustomization(ctx context.Context, repoURL, revision, dir string) (string, *kustomization, error) {
+	for _, name := range kustomizationFiles {
+		file := path.Join(dir, name)
+		data, err := readGitFile(ctx, repoURL, revision, file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		k := &kustomization{}
+		if err := yaml.Unmarshal(data, k); err != nil {
+			return "", nil, fmt.Errorf("decoding %s: %w", file, err)
+		}
+		return file, k, nil
+	}
+	return "", nil, fmt.Errorf("kustomization in %s: %w", dir, fs.ErrNotExist)
+}
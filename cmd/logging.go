@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	// logLevel is shared by every handler so the level can change at runtime
+	logLevel = new(slog.LevelVar)
+	// logOutput is stdout for the exporter and stderr for one-shot commands
+	// or when results are written to stdout
+	logOutput io.Writer = os.Stdout
+)
+
+// setupLogging installs a text or JSON handler at the given level as the default logger
+func setupLogging(level, format string) error {
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(l)
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(logOutput, opts)
+	} else {
+		handler = slog.NewTextHandler(logOutput, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// parseLogLevel maps a configured level name to its slog level
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unsupported log level %q", name)
+}
+
+// logLevelHandler serves /loglevel: GET returns the current level and PUT
+// sets it until the next config reload. Both require the admin bearer token;
+// without a configured token the endpoint is disabled.
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkBearerToken(w, r, currentConfig().Admin.token) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level, err := parseLogLevel(strings.TrimSpace(string(body)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		previous := logLevel.Level()
+		logLevel.Set(level)
+		slog.Warn("Log level changed", "from", previous, "to", level, "remote", r.RemoteAddr)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintln(w, strings.ToLower(logLevel.Level().String()))
+}
@@ -2,161 +2,265 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"gopkg.in/yaml.v2"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/caseyrobb/helm-version-check/pkg/argocd"
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+	"github.com/caseyrobb/helm-version-check/pkg/source"
 )
 
 var (
-	verboseLogger    = log.New(os.Stdout, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile)
-	infoLogger       = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
-	helmVersionGauge = newExpiringGaugeVec(
+	helmVersionGauge = metrics.NewExpiringGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "helm_chart_version_status",
-			Help: "Status of Helm chart versions (1 = up-to-date, 0 = outdated)",
+			Help: "Status of Helm chart versions (1 = up-to-date, 0 = outdated, 2 = ahead of the repository)",
 		},
-		[]string{"application", "chart", "repo_url", "current_version", "latest_version"},
+		[]string{"application", "chart", "repo_url", "current_version", "latest_version", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source"},
 		15*time.Minute, // Metrics expire after 15 minutes
 	)
+	provenanceGauge = metrics.NewExpiringGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_chart_provenance_verified",
+			Help: "Provenance verification of the latest Helm chart version (1 = verified, 0 = unverified)",
+		},
+		[]string{"application", "chart", "repo_url", "latest_version", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source"},
+		15*time.Minute,
+	)
+	signatureGauge = metrics.NewExpiringGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_chart_signature_verified",
+			Help: "Cosign signature verification of the newest OCI chart version (1 = verified, 0 = unverified)",
+		},
+		[]string{"application", "chart", "repo_url", "latest_version", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source"},
+		15*time.Minute,
+	)
+	missingVersionGauge = metrics.NewExpiringGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_chart_version_missing",
+			Help: "Whether the deployed chart version is no longer published in its repository (1 = missing, 0 = published)",
+		},
+		[]string{"application", "chart", "repo_url", "current_version", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source"},
+		15*time.Minute,
+	)
+	repoUpGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_repo_up",
+			Help: "Whether the last request to a Helm repository succeeded (1 = reachable, 0 = failed), regardless of the chart being found",
+		},
+		[]string{"repo_url"},
+	)
+	repoLastSuccessGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_repo_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful request to a Helm repository",
+		},
+		[]string{"repo_url"},
+	)
+	// errChartNotFound means the repository answered but does not list the chart
+	errChartNotFound = errors.New("not found in repository")
+	// activeConfig holds the settings in effect, replaced on reload
+	activeConfig atomic.Pointer[config]
 )
 
-// expiringGaugeVec wraps a GaugeVec with expiration logic
-type expiringGaugeVec struct {
-	gauge   *prometheus.GaugeVec
-	metrics map[string]struct {
-		lastSet time.Time
-	}
-	mu  sync.Mutex
-	ttl time.Duration
+func init() {
+	prometheus.MustRegister(helmVersionGauge)
+	prometheus.MustRegister(provenanceGauge)
+	prometheus.MustRegister(signatureGauge)
+	prometheus.MustRegister(missingVersionGauge)
+	prometheus.MustRegister(repoUpGauge)
+	prometheus.MustRegister(repoLastSuccessGauge)
 }
 
-func newExpiringGaugeVec(gaugeOpts prometheus.GaugeOpts, labelNames []string, ttl time.Duration) *expiringGaugeVec {
-	gauge := prometheus.NewGaugeVec(gaugeOpts, labelNames)
-	return &expiringGaugeVec{
-		gauge:   gauge,
-		metrics: make(map[string]struct{ lastSet time.Time }),
-		ttl:     ttl,
+// recordRepoStatus exports whether a repository answered, counting a missing
+// chart as a successful request
+func recordRepoStatus(repoURL string, err error) {
+	if err != nil && !errors.Is(err, errChartNotFound) {
+		repoUpGauge.WithLabelValues(repoURL).Set(0)
+		return
 	}
+	repoUpGauge.WithLabelValues(repoURL).Set(1)
+	repoLastSuccessGauge.WithLabelValues(repoURL).SetToCurrentTime()
 }
 
-// WithLabelValues sets a gauge value and tracks its timestamp
-func (e *expiringGaugeVec) WithLabelValues(lvs ...string) prometheus.Gauge {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	key := strings.Join(lvs, "|")
-	e.metrics[key] = struct{ lastSet time.Time }{lastSet: time.Now()}
-	return e.gauge.WithLabelValues(lvs...)
+// getChartVersions returns all entries of chartName in the index.yaml of
+// repoURL, or from the repository's API when one is configured
+func getChartVersions(ctx context.Context, repoURL, chartName string) ([]repository.Entry, error) {
+	cfg := currentConfig()
+	if repo := cfg.repositoryFor(repoURL); repo != nil {
+		var (
+			versions []repository.Entry
+			err      error
+		)
+		switch repo.API {
+		case "chartmuseum":
+			versions, err = chartMuseumVersions(ctx, repoURL, chartName)
+		case "artifactory":
+			versions, err = artifactoryVersions(ctx, repoURL, chartName)
+		case "nexus":
+			versions, err = nexusVersions(ctx, repoURL, chartName)
+		case "github":
+			return githubReleaseVersions(ctx, repo, repoURL, chartName)
+		default:
+			err = errAPIUnavailable
+		}
+		if !errors.Is(err, errAPIUnavailable) {
+			return versions, err
+		}
+		if repo.API != "" && repo.API != "harbor" {
+			slog.Debug("Falling back to index.yaml", "repo_url", repoURL, "error", err)
+		}
+	}
+	versions, err := repoIndexCache.versions(ctx, repoURL, chartName, cfg.Cache.IndexTTL)
+	if err != nil {
+		slog.Debug("Failed to read index.yaml", "repo_url", repoURL, "chart", chartName, "error", err)
+		return nil, err
+	}
+	slog.Debug("Found chart versions", "chart", chartName, "count", len(versions))
+	return versions, nil
 }
 
-// Collect implements the prometheus.Collector interface
-func (e *expiringGaugeVec) Collect(ch chan<- prometheus.Metric) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	now := time.Now()
-	for key, meta := range e.metrics {
-		if now.Sub(meta.lastSet) > e.ttl {
-			// Remove expired metric
-			lvs := strings.Split(key, "|")
-			e.gauge.DeleteLabelValues(lvs...)
-			delete(e.metrics, key)
-			if verboseLogger != nil {
-				verboseLogger.Printf("Expired metric for labels: %v", lvs)
+// chartChecker returns the checker of the charts of repoURL with the
+// settings of ctx, listing the tags of OCI charts and exporting the versions
+// of others that are not semver
+func chartChecker(ctx context.Context, repoURL string) check.Checker {
+	cfg := contextConfig(ctx)
+	repo := cfg.repositoryFor(repoURL)
+	resolver := repository.Resolver(repository.ResolverFunc(ociChartEntries))
+	if !isOCIRepo(repoURL) {
+		resolver = repository.ResolverFunc(func(ctx context.Context, repoURL, chartName string) ([]repository.Entry, error) {
+			versions, err := getChartVersions(ctx, repoURL, chartName)
+			if err != nil {
+				return nil, err
 			}
-		}
+			recordNonSemver(repoURL, chartName, versions, cfg.Policy.semver())
+			return approvedEntries(ctx, chartName, versions)
+		})
+	}
+	return check.Checker{
+		Resolver:          resolver,
+		Ordering:          cfg.Policy.NonSemverOrdering,
+		ByCreated:         repo != nil && repo.Ordering == check.OrderingCreated,
+		IgnorePrereleases: cfg.Policy.IgnorePrereleases,
+		Semver:            cfg.Policy.semver(),
+		ChannelAnnotation: cfg.Policy.ChannelAnnotation,
+		Channels:          cfg.Policy.Channels,
+		Accept:            versionAcceptor(ctx, repoURL),
 	}
-	e.gauge.Collect(ch)
 }
 
-// Describe implements the prometheus.Collector interface
-func (e *expiringGaugeVec) Describe(ch chan<- *prometheus.Desc) {
-	e.gauge.Describe(ch)
+// chartResolver returns the resolver of the latest versions of the charts
+// of repoURL with the settings of ctx, verifying the cosign signatures of OCI
+// charts and, when required, the provenance of others
+func chartResolver(ctx context.Context, repoURL string) check.Resolver {
+	cfg := contextConfig(ctx)
+	resolver := check.Resolver{Checker: chartChecker(ctx, repoURL)}
+	switch {
+	case isOCIRepo(repoURL) && cfg.verifier != nil:
+		resolver.Verifier = cfg.verifier
+	case !isOCIRepo(repoURL) && cfg.keyring != nil && cfg.Provenance.Require:
+		resolver.Verifier = provenanceResults
+	}
+	return resolver
 }
 
-func init() {
-	prometheus.MustRegister(helmVersionGauge)
+// latestChartVersion returns the latest version of a chart as chartResolver
+// finds it
+func latestChartVersion(ctx context.Context, fetchURL, chartName, chartVersion string) (check.Resolution, error) {
+	res, err := chartResolver(ctx, fetchURL).Latest(ctx, fetchURL, chartName, chartVersion)
+	// Prereleases are not listed from OCI registries when ignored, so they
+	// cannot be missing
+	policy := contextConfig(ctx).Policy
+	if v, perr := policy.semver().Parse(chartVersion); perr == nil && v.Prerelease() != "" && isOCIRepo(fetchURL) && policy.IgnorePrereleases {
+		res.Published = true
+	}
+	return res, err
 }
 
-func getLatestChartVersion(repoURL, chartName string, verbose bool) (string, error) {
-	if verbose {
-		verboseLogger.Printf("Fetching index.yaml from %s for chart %s", repoURL, chartName)
-	}
-	resp, err := http.Get(repoURL + "/index.yaml")
+// resolveTargetRevision returns the version Argo CD deploys for a constraint:
+// the newest published version satisfying it
+func resolveTargetRevision(ctx context.Context, repoURL, chartName, constraint string) (string, error) {
+	c, err := semver.NewConstraint(constraint)
 	if err != nil {
-		if verbose {
-			verboseLogger.Printf("Failed to fetch index.yaml: %v", err)
-		}
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	var index struct {
-		Entries map[string][]struct {
-			Version string `yaml:"version"`
-		} `yaml:"entries"`
-	}
-	if err := yaml.NewDecoder(resp.Body).Decode(&index); err != nil {
-		if verbose {
-			verboseLogger.Printf("Failed to decode index.yaml: %v", err)
+	var candidates []*semver.Version
+	if isOCIRepo(repoURL) {
+		ref, err := parseOCIReference(repoURL, chartName)
+		if err != nil {
+			return "", err
 		}
-		return "", err
-	}
-
-	versions, ok := index.Entries[chartName]
-	if verbose {
-		verboseLogger.Printf("Found %d versions of %s", len(versions), chartName)
-		for _, v := range versions {
-			verboseLogger.Printf("Found version: %s", v.Version)
+		versions, err := listOCIChartVersions(ctx, ref)
+		if err != nil {
+			return "", err
 		}
-	}
-	if !ok || len(versions) == 0 {
-		if verbose {
-			verboseLogger.Printf("Chart %s not found in repository %s", chartName, repoURL)
+		for _, v := range versions {
+			candidates = append(candidates, v.Version)
 		}
-		return "", fmt.Errorf("chart %s not found in repository", chartName)
-	}
-
-	latest := versions[0].Version
-	for _, v := range versions[1:] {
-		current, err := semver.NewVersion(latest)
-		if err != nil && verbose {
-			verboseLogger.Printf("Invalid semver for version %s: %v", latest, err)
+	} else {
+		entries, err := getChartVersions(ctx, repoURL, chartName)
+		if err != nil {
+			return "", err
 		}
-		next, err := semver.NewVersion(v.Version)
-		if err != nil && verbose {
-			verboseLogger.Printf("Invalid semver for version %s: %v", v.Version, err)
+		policy := contextConfig(ctx).Policy.semver()
+		for _, e := range entries {
+			if v, err := policy.Parse(e.Version); err == nil {
+				candidates = append(candidates, v)
+			}
 		}
-		if err == nil && next.GreaterThan(current) {
-			latest = v.Version
+	}
+	var best *semver.Version
+	for _, v := range candidates {
+		if c.Check(v) && (best == nil || v.GreaterThan(best)) {
+			best = v
 		}
 	}
-	if verbose {
-		verboseLogger.Printf("Determined latest version for %s: %s", chartName, latest)
+	if best == nil {
+		return "", fmt.Errorf("no version of chart %s satisfies %s", chartName, constraint)
 	}
-	return latest, nil
+	return best.Original(), nil
 }
 
-// processHelmSource handles a single Helm source and updates metrics
-func processHelmSource(appName string, source map[string]interface{}, verbose bool) {
+// carryOver returns the last result of a chart whose check failed with err,
+// so that it stays in reports and its incidents are not resolved by transient
+// errors; nil when the chart was never checked
+func carryOver(ctx context.Context, appName, chartName, repoURL string, err error) *chartResult {
+	previous, ok := latestResults.previous(contextCluster(ctx).name, appName, chartName, repoURL)
+	if !ok {
+		return nil
+	}
+	previous.CarriedOver = err.Error()
+	return &previous
+}
+
+// processHelmSource handles a single Helm source, updates metrics and
+// returns the result, or nil when the source was skipped or failed without
+// an earlier result to carry over
+func processHelmSource(ctx context.Context, appName, destNamespace string, source map[string]interface{}) *chartResult {
+	ctx, span := tracer.Start(ctx, "chart")
+	defer span.End()
+
 	helm, helmFound := source["chart"]
 	if !helmFound || helm == nil {
-		if verbose {
-			verboseLogger.Printf("No Helm source found for %s in this source", appName)
-		}
-		return
+		slog.Debug("No Helm chart in source", "application", appName)
+		return nil
 	}
 
 	chartName := ""
@@ -173,170 +277,439 @@ func processHelmSource(appName string, source map[string]interface{}, verbose bo
 		chartVersion = version
 	}
 
-	if verbose {
-		verboseLogger.Printf("Extracted: chart=%s, repoURL=%s, version=%s", chartName, repoURL, chartVersion)
+	log := slog.With("application", appName, "chart", chartName)
+	log.Debug("Extracted Helm source", "repo_url", repoURL, "version", chartVersion)
+	span.SetAttributes(
+		attribute.String("chart", chartName),
+		attribute.String("repo_url", repoURL),
+		attribute.String("current_version", chartVersion),
+	)
+
+	cfg := contextConfig(ctx)
+	if cfg.excluded(appName, chartName) {
+		log.Debug("Skipping excluded application or chart")
+		skipCheck(skipExcluded)
+		return nil
 	}
 
 	if chartName == "" || repoURL == "" || chartVersion == "" {
-		if verbose {
-			verboseLogger.Printf("Skipping %s: incomplete Helm data (chart=%s, repoURL=%s, version=%s)",
-				appName, chartName, repoURL, chartVersion)
+		log.Debug("Skipping incomplete Helm source", "repo_url", repoURL, "version", chartVersion)
+		skipCheck(skipIncompleteSource)
+		return nil
+	}
+	if alias, ok := helmRepoAlias(repoURL); ok {
+		resolved, err := resolveHelmRepoAlias(cfg.Credentials, alias)
+		if err != nil {
+			log.Error("Error resolving repository alias", "repo_url", repoURL, "error", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			recordCheckError(ctx, appName, chartName, repoURL, err)
+			return nil
 		}
-		return
+		log.Debug("Resolved repository alias", "alias", alias, "repo_url", resolved)
+		repoURL = resolved
+	}
+	if !cfg.RepoFilter.allows(repoURL) {
+		log.Debug("Skipping chart of a repository not allowed by the filter", "repo_url", repoURL)
+		skipCheck(skipRepoFilter)
+		return nil
 	}
 
-	if !strings.HasSuffix(repoURL, "/") {
+	var (
+		latest            repository.Entry
+		newestVersion     string
+		signatureVerified bool
+		published         bool
+		err               error
+	)
+	if !isOCIRepo(repoURL) && !strings.HasSuffix(repoURL, "/") {
 		repoURL += "/"
-		if verbose {
-			verboseLogger.Printf("Normalized repoURL to: %s", repoURL)
+		log.Debug("Normalized repoURL", "repo_url", repoURL)
+	}
+	// fetchURL is where the repository is read from; results keep repoURL
+	fetchURL := cfg.mirrorFor(repoURL)
+	if fetchURL != repoURL {
+		log.Debug("Using mirror", "repo_url", repoURL, "mirror", fetchURL)
+		span.SetAttributes(attribute.String("mirror_url", fetchURL))
+	}
+	if err := failedCharts.get(repoURL, chartName); err != nil {
+		log.Debug("Skipping chart that failed recently", "repo_url", repoURL, "error", err)
+		skipCheck(skipFailedRecently)
+		span.SetStatus(codes.Error, err.Error())
+		recordCheckError(ctx, appName, chartName, repoURL, err)
+		return carryOver(ctx, appName, chartName, repoURL, err)
+	}
+	// a constraint such as "1.x" deploys the newest matching version
+	targetRevision := chartVersion
+	if check.IsConstraint(targetRevision) {
+		chartVersion, err = resolveTargetRevision(ctx, fetchURL, chartName, targetRevision)
+		if err != nil {
+			if ctx.Err() == nil {
+				recordRepoStatus(repoURL, err)
+				failedCharts.add(repoURL, chartName, err, cfg.Cache.NegativeTTL)
+			}
+			log.Error("Error resolving targetRevision", "target_revision", targetRevision, "error", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			recordCheckError(ctx, appName, chartName, repoURL, err)
+			return carryOver(ctx, appName, chartName, repoURL, err)
 		}
+		log.Debug("Resolved targetRevision", "target_revision", targetRevision, "version", chartVersion)
+		span.SetAttributes(attribute.String("current_version", chartVersion))
+	}
+	checker := chartChecker(ctx, fetchURL)
+	res, err := latestChartVersion(ctx, fetchURL, chartName, chartVersion)
+	latest, newestVersion, signatureVerified, published = res.Latest, res.Newest, res.Verified, res.Published
+	if ctx.Err() == nil {
+		recordRepoStatus(repoURL, err)
+	}
+	if errors.Is(err, errCircuitOpen) {
+		log.Debug("Skipping repository with open circuit", "repo_url", repoURL, "error", err)
+		skipCheck(skipCircuitOpen)
+		span.SetStatus(codes.Error, err.Error())
+		recordCheckError(ctx, appName, chartName, repoURL, err)
+		return carryOver(ctx, appName, chartName, repoURL, err)
+	}
+	if errors.Is(err, errRateLimited) {
+		log.Debug("Skipping rate limited repository", "repo_url", repoURL, "error", err)
+		skipCheck(skipRateLimited)
+		span.SetStatus(codes.Error, err.Error())
+		recordCheckError(ctx, appName, chartName, repoURL, err)
+		return carryOver(ctx, appName, chartName, repoURL, err)
 	}
-
-	latestVersion, err := getLatestChartVersion(repoURL, chartName, verbose)
 	if err != nil {
-		infoLogger.Printf("Error getting latest version for %s: %v", chartName, err)
-		return
+		if ctx.Err() == nil {
+			failedCharts.add(repoURL, chartName, err, cfg.Cache.NegativeTTL)
+		}
+		log.Error("Error getting latest version", "repo_url", repoURL, "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		recordCheckError(ctx, appName, chartName, repoURL, err)
+		return carryOver(ctx, appName, chartName, repoURL, err)
+	}
+	// Charts subject to approval are compared with the latest approved
+	// version, and with the latest published one for the approval gauges
+	var approval *approvalStatus
+	if approved, ok := chartApprovals.versions(repoURL, chartName); ok {
+		approvedCtx := withApprovedVersions(ctx, approved)
+		approvedRes, err := latestChartVersion(approvedCtx, fetchURL, chartName, chartVersion)
+		publishedUpToDate, _, _ := checker.Status(chartVersion, latest)
+		approval = &approvalStatus{
+			Approved:          isApproved(approved, chartVersion),
+			LatestPublished:   latest.Version,
+			PublishedUpToDate: publishedUpToDate,
+		}
+		if err != nil {
+			log.Warn("No approved version is published, comparing with the latest published version", "repo_url", repoURL, "error", err)
+		} else {
+			latest, newestVersion, signatureVerified = approvedRes.Latest, approvedRes.Newest, approvedRes.Verified
+		}
+	}
+	latestVersion := latest.Version
+	span.SetAttributes(attribute.String("latest_version", latestVersion))
+
+	upToDate, ahead, comparable := checker.Status(chartVersion, latest)
+	if !comparable {
+		log.Debug("Cannot compare versions that are not semver", "version", chartVersion, "latest_version", latestVersion)
+		skipCheck(skipNonSemver)
+	}
+	if ahead {
+		log.Info("Current version is ahead of the repository", "version", chartVersion, "latest_version", latestVersion)
+	}
+	if !published {
+		log.Warn("Current version is no longer in the repository", "version", chartVersion, "latest_version", latestVersion)
 	}
 
-	currentVer, err := semver.NewVersion(chartVersion)
-	if err != nil && verbose {
-		verboseLogger.Printf("Invalid current version %s: %v", chartVersion, err)
+	result := chartResult{
+		Application:    appName,
+		Chart:          chartName,
+		RepoURL:        repoURL,
+		CurrentVersion: chartVersion,
+		LatestVersion:  latestVersion,
+		UpToDate:       upToDate,
+		Ahead:          ahead,
+		Deprecated:     latest.Deprecated,
+		Behind:         cfg.Policy.semver().Delta(chartVersion, latestVersion),
+		Missing:        !published,
+		Scan:           latest.Scan,
+		Approval:       approval,
 	}
-	latestVer, err := semver.NewVersion(latestVersion)
-	if err != nil && verbose {
-		verboseLogger.Printf("Invalid latest version %s: %v", latestVersion, err)
+	result.LatestReleased, result.Stale = latestRelease(latest, cfg.StaleAfter, time.Now())
+	if result.Stale {
+		log.Warn("Latest version is stale, the chart may be unmaintained", "latest_version", latestVersion, "released", result.LatestReleased.Format(time.RFC3339))
 	}
-	status := 0.0
-	if err == nil && currentVer.Equal(latestVer) {
-		status = 1.0
+	if targetRevision != chartVersion {
+		result.TargetRevision = targetRevision
 	}
 
-	helmVersionGauge.WithLabelValues(
-		appName,
-		chartName,
-		repoURL,
-		chartVersion,
-		latestVersion,
-	).Set(status)
+	if isOCIRepo(fetchURL) && cfg.verifier != nil {
+		result.NewestPublishedVersion = newestVersion
+		result.SignatureVerified = &signatureVerified
+	}
 
-	if verbose {
-		verboseLogger.Printf("Set metric: app=%s, status=%v", appName, status)
+	if cfg.keyring != nil && !isOCIRepo(fetchURL) {
+		verified := false
+		if err := provenanceResults.Verify(ctx, fetchURL, chartName, latest); err != nil {
+			log.Warn("Provenance not verified", "version", latestVersion, "error", err)
+		} else {
+			verified = true
+		}
+		result.ProvenanceVerified = &verified
 	}
 
-	fmt.Printf("Application: %s\n", appName)
-	fmt.Printf("  Chart Name: %s\n", chartName)
-	fmt.Printf("  Repository URL: %s\n", repoURL)
-	fmt.Printf("  Current Version: %s\n", chartVersion)
-	fmt.Printf("  Latest Version: %s\n", latestVersion)
-	fmt.Printf("  Up-to-date: %v\n", status == 1.0)
-	fmt.Println("---")
-}
+	if cfg.artifactHub != nil {
+		pkg, err := cfg.artifactHub.lookup(ctx, repoURL, chartName)
+		if err != nil {
+			log.Warn("Error looking up chart on ArtifactHub", "error", err)
+		}
+		result.ArtifactHub = pkg
+	}
 
-func main() {
-	verbose := false
-	if os.Getenv("LOGLEVEL") == "debug" {
-		verbose = true
+	if result.outdated() {
+		if isOCIRepo(fetchURL) {
+			if meta, err := ociChartMetadata(ctx, fetchURL, chartName, latestVersion); err != nil {
+				log.Warn("Error reading chart metadata", "version", latestVersion, "error", err)
+			} else {
+				latest = meta
+			}
+		}
+		result.Changes, result.Links = releaseNotes(latest)
+
+		if cfg.DeepCheckImages {
+			result.ImageChanges = checkImageChanges(ctx, appName, destNamespace, source, result)
+		}
+		if cfg.RemovedAPIs.Enabled {
+			result.RemovedAPIs = checkRemovedAPIs(ctx, appName, destNamespace, source, result)
+		}
 	}
-	infoLogger.Printf("Starting helm-version-check with loglevel=%s", os.Getenv("LOGLEVEL"))
 
-	// Get namespace from environment variable, default to "argocd"
-	namespace := os.Getenv("NAMESPACE")
-	if namespace == "" {
-		namespace = "argocd"
+	if cfg.ImageTags.Enabled {
+		result.ImageTags = checkValueImages(ctx, appName, source, cfg.ImageTags.CacheTTL)
 	}
-	infoLogger.Printf("Using namespace: %s", namespace)
 
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		log.Fatalf("Error getting in-cluster config: %v", err)
+	return &result
+}
+
+// recordMetrics sets the gauges describing a result
+func recordMetrics(r chartResult) {
+	status := boolValue(r.UpToDate)
+	if r.Ahead {
+		status = 2
+	}
+	helmVersionGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.CurrentVersion, r.LatestVersion, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source).Set(status)
+	if r.SignatureVerified != nil {
+		signatureGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.NewestPublishedVersion, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source).Set(boolValue(*r.SignatureVerified))
+	}
+	if r.ProvenanceVerified != nil {
+		provenanceGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.LatestVersion, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source).Set(boolValue(*r.ProvenanceVerified))
 	}
-	if verbose {
-		verboseLogger.Println("Successfully obtained in-cluster config")
+	recordVersionsBehind(r)
+	recordApproval(r)
+	recordOutdatedSeconds(r)
+	recordStale(r)
+	recordImageTags(r)
+	recordRemovedAPIs(r)
+	if u := r.Update; u != nil {
+		pendingUpdateGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, u.From, u.To, r.Cluster, r.Instance, r.Source).Set(1)
 	}
+	missingVersionGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.CurrentVersion, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source).Set(boolValue(r.Missing))
+	if v := r.VendoredChart; v != nil {
+		vendoredChartGauge.WithLabelValues(r.Application, v.Name, v.Version, v.RepoURL, v.Path, r.Cluster, r.Instance).Set(1)
+	}
+}
 
-	clientset, err := dynamic.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("Error creating dynamic client: %v", err)
+func boolValue(b bool) float64 {
+	if b {
+		return 1
 	}
-	if verbose {
-		verboseLogger.Println("Created Kubernetes dynamic client")
+	return 0
+}
+
+// currentConfig returns the settings in effect
+func currentConfig() *config {
+	return activeConfig.Load()
+}
+
+type configKey struct{}
+
+// withConfig makes checks done with ctx use cfg instead of the active
+// settings, applying the scope of a HelmVersionCheck resource
+func withConfig(ctx context.Context, cfg *config) context.Context {
+	return context.WithValue(ctx, configKey{}, cfg)
+}
+
+type clusterKey struct{}
+
+// withCluster records the cluster whose Applications are checked with ctx
+func withCluster(ctx context.Context, cluster clusterClient) context.Context {
+	return context.WithValue(ctx, clusterKey{}, cluster)
+}
+
+// contextCluster returns the cluster checked with ctx, without a client when
+// checking manifests
+func contextCluster(ctx context.Context) clusterClient {
+	cluster, _ := ctx.Value(clusterKey{}).(clusterClient)
+	return cluster
+}
+
+type applicationKey struct{}
+
+// withApplication records the application whose chart is checked with ctx,
+// which policy rules are evaluated against
+func withApplication(ctx context.Context, app source.Chart) context.Context {
+	return context.WithValue(ctx, applicationKey{}, app)
+}
+
+// contextApplication returns the application checked with ctx, empty when
+// checking manifests
+func contextApplication(ctx context.Context) source.Chart {
+	app, _ := ctx.Value(applicationKey{}).(source.Chart)
+	return app
+}
+
+// contextConfig returns the settings of checks done with ctx
+func contextConfig(ctx context.Context) *config {
+	if cfg, ok := ctx.Value(configKey{}).(*config); ok {
+		return cfg
 	}
+	return currentConfig()
+}
 
-	gvr := schema.GroupVersionResource{
-		Group:    "argoproj.io",
-		Version:  "v1alpha1",
-		Resource: "applications",
+// applyConfig builds the optional integrations described by cfg and makes it
+// the active configuration. Integrations whose settings are unchanged are
+// kept so their caches survive a reload.
+func applyConfig(cfg *config) error {
+	previous := currentConfig()
+	// Results written to stdout are kept apart from the logs
+	if f := cfg.Output.Format; f != "" && f != outputLog && f != outputNone {
+		logOutput = os.Stderr
+	}
+	if err := setupLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+		return err
 	}
 
-	go func() {
-		if verbose {
-			verboseLogger.Println("Starting Prometheus metrics server on :9080")
+	var keyring openpgp.EntityList
+	if cfg.Provenance.Keyring != "" {
+		var err error
+		keyring, err = loadKeyring(cfg.Provenance.Keyring)
+		if err != nil {
+			return fmt.Errorf("loading provenance keyring %s: %w", cfg.Provenance.Keyring, err)
 		}
-		http.Handle("/metrics", promhttp.Handler())
-		log.Fatal(http.ListenAndServe(":9080", nil))
-	}()
+		slog.Info("Verifying chart provenance", "keyring", cfg.Provenance.Keyring, "keys", len(keyring), "require", cfg.Provenance.Require)
+	}
+	provenanceResults.reset()
 
-	for {
-		if verbose {
-			verboseLogger.Printf("Listing applications in namespace %s", namespace)
+	for _, repo := range cfg.Repositories {
+		if repo.InsecureSkipVerify {
+			slog.Warn("TLS certificate verification is disabled for repository, connections can be intercepted", "repo_url", repo.URL)
 		}
-		list, err := clientset.Resource(gvr).Namespace(namespace).List(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			infoLogger.Printf("Error listing applications in namespace %s: %v", namespace, err)
-			if verbose {
-				verboseLogger.Printf("Full error details: %v", err)
-			}
-			time.Sleep(60 * time.Second)
-			continue
-		}
-		if verbose {
-			verboseLogger.Printf("Found %d applications", len(list.Items))
+	}
+
+	verifier, err := newCosignVerifier(cfg.Cosign)
+	if err != nil {
+		return fmt.Errorf("configuring cosign verification: %w", err)
+	}
+	if verifier != nil {
+		slog.Info("Verifying cosign signatures of OCI charts")
+	}
+
+	var hub *artifactHubClient
+	if previous != nil {
+		hub = previous.artifactHub
+	}
+	if previous == nil || previous.ArtifactHub != cfg.ArtifactHub {
+		hub = newArtifactHubClient(cfg.ArtifactHub)
+		if hub != nil {
+			slog.Info("Enriching results with ArtifactHub metadata")
 		}
+	}
+	if cfg.DeepCheckImages {
+		slog.Info("Rendering outdated charts to compare container images")
+	}
 
-		for _, app := range list.Items {
-			appName := app.GetName()
-			if verbose {
-				verboseLogger.Printf("Processing application: %s", appName)
-			}
+	cfg.keyring = keyring
+	cfg.verifier = verifier
+	cfg.artifactHub = hub
+	activeConfig.Store(cfg)
+	return nil
+}
 
-			spec, ok := app.Object["spec"].(map[string]interface{})
-			if !ok {
-				if verbose {
-					verboseLogger.Printf("Skipping %s: spec is not a map or is missing", appName)
-				}
-				continue
-			}
+// processApplication checks every Helm source of an Argo CD Application
+func processApplication(ctx context.Context, app unstructured.Unstructured) []chartResult {
+	log := slog.With("application", app.GetName())
+	log.Debug("Processing application")
+
+	if enabled, err := argocd.Enabled(app); err != nil {
+		log.Warn("Ignoring invalid annotation", "annotation", argocd.EnabledAnnotation, "value", app.GetAnnotations()[argocd.EnabledAnnotation])
+	} else if !enabled {
+		log.Debug("Skipping application disabled by annotation")
+		skipCheck(argocd.SkipDisabled)
+		return nil
+	}
+	if filter := contextConfig(ctx).StatusFilter; !filter.matches(app) {
+		log.Debug("Skipping application filtered by sync or health status")
+		skipCheck(argocd.SkipFiltered)
+		return nil
+	}
+	return processCharts(ctx, app.GetName(), argocd.Charts(app))
+}
 
-			// Check for single source (spec.source)
-			if source, ok := spec["source"].(map[string]interface{}); ok {
-				if verbose {
-					verboseLogger.Printf("Found single source for %s", appName)
-				}
-				processHelmSource(appName, source, verbose)
-			}
+// processCharts checks the charts an application deploys, as listed by a
+// source provider
+func processCharts(ctx context.Context, appName string, charts []source.Chart) []chartResult {
+	ctx, span := tracer.Start(ctx, "application", trace.WithAttributes(attribute.String("application", appName)))
+	defer span.End()
 
-			// Check for multiple sources (spec.sources)
-			if sources, ok := spec["sources"].([]interface{}); ok {
-				if verbose {
-					verboseLogger.Printf("Found %d sources for %s", len(sources), appName)
-				}
-				for i, src := range sources {
-					if sourceMap, ok := src.(map[string]interface{}); ok {
-						if verbose {
-							verboseLogger.Printf("Processing source #%d for %s", i+1, appName)
-						}
-						processHelmSource(appName, sourceMap, verbose)
-					} else if verbose {
-						verboseLogger.Printf("Skipping source #%d for %s: not a map", i+1, appName)
-					}
-				}
-			} else if verbose && spec["source"] == nil {
-				verboseLogger.Printf("No sources found for %s", appName)
+	if len(charts) == 0 {
+		slog.Debug("No sources found", "application", appName)
+		return nil
+	}
+	var results []chartResult
+	for _, c := range charts {
+		for _, r := range processSource(withApplication(ctx, c), appName, c.DestinationNamespace, chartSource(c)) {
+			r.Namespace = c.Namespace
+			r.Labels = c.Labels
+			r.Project = c.Project
+			r.DestinationCluster = c.DestinationCluster
+			r.DestinationNamespace = c.DestinationNamespace
+			r.Source = c.SourceID
+			if c.Chart != "" && r.VendoredChart == nil {
+				r.Update = planUpdate(ctx, r, c.Path)
 			}
+			results = append(results, r)
 		}
-		if verbose {
-			verboseLogger.Println("Completed cycle, sleeping for 60 seconds")
-		}
-		time.Sleep(60 * time.Second)
+	}
+	return results
+}
+
+// chartSource returns the source of a chart in the form of an Argo CD
+// Application source, which the checks read
+func chartSource(c source.Chart) map[string]interface{} {
+	if c.Source != nil {
+		return c.Source
+	}
+	return map[string]interface{}{"chart": c.Chart, "repoURL": c.RepoURL, "targetRevision": c.Version}
+}
+
+// processSource checks a Helm source, or the chart at the path of a git
+// source when git sources are enabled
+func processSource(ctx context.Context, appName, destNamespace string, source map[string]interface{}) []chartResult {
+	chartName, _ := source["chart"].(string)
+	dir, _ := source["path"].(string)
+	if chartName == "" && dir != "" && contextConfig(ctx).GitSources.Enabled {
+		return processGitSource(ctx, appName, destNamespace, source)
+	}
+	if result := processHelmSource(ctx, appName, destNamespace, source); result != nil {
+		return []chartResult{*result}
+	}
+	return nil
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := newRootCommand().ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
+)
+
+var deferredEventsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "helm_notifications_deferred",
+	Help: "Status change events held during a maintenance window, to be sent once it ends",
+})
+
+func init() {
+	prometheus.MustRegister(deferredEventsGauge)
+}
+
+// maintenanceWindow holds notifications for Duration from every time the
+// cron Schedule fires, such as "0 22 * * *" for quiet hours starting at
+// 22:00; a CRON_TZ=Europe/Berlin prefix sets the time zone, by default that
+// of the exporter
+type maintenanceWindow struct {
+	Schedule string        `yaml:"schedule"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// validate checks the schedule and duration
+func (w maintenanceWindow) validate() error {
+	if _, err := cron.ParseStandard(w.Schedule); err != nil {
+		return fmt.Errorf("schedule: %w", err)
+	}
+	if w.Duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+	return nil
+}
+
+// active reports whether the window is open at now: the schedule fired
+// within Duration before it
+func (w maintenanceWindow) active(now time.Time) bool {
+	schedule, err := cron.ParseStandard(w.Schedule)
+	if err != nil {
+		return false
+	}
+	return !schedule.Next(now.Add(-w.Duration)).After(now)
+}
+
+// inMaintenanceWindow reports whether any of windows is open at now
+func inMaintenanceWindow(windows []maintenanceWindow, now time.Time) bool {
+	for _, w := range windows {
+		if w.active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// deferredEvents holds the events of cycles run during maintenance windows
+var deferredEvents = &eventBacklog{}
+
+type eventBacklog struct {
+	mu     sync.Mutex
+	events []statusEvent
+}
+
+// add holds events until the maintenance window ends, merging those of a
+// chart changing again
+func (b *eventBacklog) add(events []statusEvent) {
+	if len(events) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = mergeEvents(append(b.events, events...))
+	deferredEventsGauge.Set(float64(len(b.events)))
+	slog.Debug("Holding notifications during maintenance window", "events", len(events), "held", len(b.events))
+}
+
+// release returns the held events followed by events, merged, and empties
+// the backlog
+func (b *eventBacklog) release(events []statusEvent) []statusEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.events) == 0 {
+		return events
+	}
+	slog.Info("Sending notifications held during maintenance window", "events", len(b.events))
+	merged := mergeEvents(append(b.events, events...))
+	b.events = nil
+	deferredEventsGauge.Set(0)
+	return merged
+}
+
+// mergeEvents keeps one event per chart, in the order charts first changed,
+// from the state before its first event to that after its last one. Charts
+// back where they started, such as outdated and then upgraded, are dropped.
+func mergeEvents(events []statusEvent) []statusEvent {
+	first := map[string]statusEvent{}
+	last := map[string]statusEvent{}
+	var keys []string
+	for _, event := range events {
+		key := resultKey(event.Result)
+		if _, ok := first[key]; !ok {
+			first[key] = event
+			keys = append(keys, key)
+		}
+		last[key] = event
+	}
+	merged := make([]statusEvent, 0, len(keys))
+	for _, key := range keys {
+		previous, r := first[key].Previous, last[key].Result
+		if t := eventType(previous, r); t != "" {
+			merged = append(merged, statusEvent{Type: t, Previous: previous, Result: r})
+		}
+	}
+	return merged
+}
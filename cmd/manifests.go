@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/caseyrobb/helm-version-check/pkg/argocd"
+)
+
+// manifestApplication is an Application read from a manifest file, with the
+// YAML node it was decoded from to locate its sources
+type manifestApplication struct {
+	app  unstructured.Unstructured
+	file string
+	node *yaml.Node
+}
+
+// readManifests reads the Argo CD Applications of the given files and
+// directories. Directories are walked for .yaml, .yml and .json files, and
+// documents of other kinds are skipped.
+func readManifests(paths []string) ([]manifestApplication, error) {
+	var apps []manifestApplication
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			// Files given explicitly are read whatever their extension
+			switch strings.ToLower(filepath.Ext(file)) {
+			case ".yaml", ".yml", ".json":
+			default:
+				if file != path {
+					return nil
+				}
+			}
+			found, err := readManifestFile(file)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", file, err)
+			}
+			apps = append(apps, found...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return apps, nil
+}
+
+func readManifestFile(file string) ([]manifestApplication, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var apps []manifestApplication
+	decoder := yaml.NewDecoder(f)
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return apps, nil
+			}
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := doc.Decode(&obj); err != nil {
+			return nil, err
+		}
+		app := unstructured.Unstructured{Object: obj}
+		if app.GetKind() != "Application" || !strings.HasPrefix(app.GetAPIVersion(), argocd.ApplicationsGVR.Group+"/") {
+			continue
+		}
+		apps = append(apps, manifestApplication{app: app, file: file, node: doc.Content[0]})
+	}
+}
+
+// checkManifests checks the Applications of manifest files instead of those
+// of a cluster, recording the file and line of each chart's source
+func checkManifests(ctx context.Context, cfg *config, paths []string) ([]chartResult, error) {
+	apps, err := readManifests(paths)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := labels.Parse(cfg.AppSelector)
+	if err != nil {
+		return nil, err
+	}
+	scope := checkScope{cfg: cfg}
+	var results []chartResult
+	for _, m := range apps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !selector.Matches(labels.Set(m.app.GetLabels())) {
+			continue
+		}
+		for _, r := range checkApplication(ctx, clusterClient{}, scope, m.app) {
+			r.File = filepath.ToSlash(m.file)
+			r.Line = m.sourceLine(r)
+			results = append(results, r)
+		}
+	}
+	slog.Debug("Checked manifests", "applications", len(apps), "results", len(results))
+	return results, nil
+}
+
+// sourceLine returns the line of the targetRevision of the source a result
+// was checked for, or of the Application when it cannot be found
+func (m manifestApplication) sourceLine(r chartResult) int {
+	spec := mappingValue(m.node, "spec")
+	var sources []*yaml.Node
+	if source := mappingValue(spec, "source"); source != nil {
+		sources = append(sources, source)
+	}
+	if list := mappingValue(spec, "sources"); list != nil && list.Kind == yaml.SequenceNode {
+		sources = append(sources, list.Content...)
+	}
+	for _, source := range sources {
+		chart, repoURL := mappingValue(source, "chart"), mappingValue(source, "repoURL")
+		if chart == nil || repoURL == nil || chart.Value != r.Chart ||
+			strings.TrimSuffix(repoURL.Value, "/") != strings.TrimSuffix(r.RepoURL, "/") {
+			continue
+		}
+		if revision := mappingValue(source, "targetRevision"); revision != nil {
+			return revision.Line
+		}
+		return source.Line
+	}
+	return m.node.Line
+}
+
+// mappingValue returns the value of key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// nexusVersions lists the versions of chartName in a Nexus Repository Helm
+// repository through the search API, which pages through matching
+// components instead of serving the whole index.yaml. The repository URL
+// must have the https://host/repository/<name>/ form.
+func nexusVersions(ctx context.Context, repoURL, chartName string) ([]repository.Entry, error) {
+	base, repoName, ok := strings.Cut(repoURL, "/repository/")
+	repoName = strings.Trim(repoName, "/")
+	if !ok || repoName == "" || strings.Contains(repoName, "/") {
+		return nil, fmt.Errorf("%w: %s is not a Nexus repository URL", errAPIUnavailable, repoURL)
+	}
+
+	var versions []repository.Entry
+	continuation := ""
+	for {
+		query := url.Values{
+			"repository": {repoName},
+			"format":     {"helm"},
+			"name":       {chartName},
+		}
+		if continuation != "" {
+			query.Set("continuationToken", continuation)
+		}
+		// credentials are configured for the repository, not the API path
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/service/rest/v1/search?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := setRepoCredentials(req, repoURL); err != nil {
+			return nil, err
+		}
+		resp, err := repoDo(repoURL, req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Version string `json:"version"`
+				Assets  []struct {
+					DownloadURL string `json:"downloadUrl"`
+					Checksum    struct {
+						SHA256 string `json:"sha256"`
+					} `json:"checksum"`
+				} `json:"assets"`
+			} `json:"items"`
+			ContinuationToken string `json:"continuationToken"`
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = json.NewDecoder(resp.Body).Decode(&page)
+		case http.StatusNotFound, http.StatusForbidden:
+			err = fmt.Errorf("%w: %s returned %s", errAPIUnavailable, base, resp.Status)
+		default:
+			err = fmt.Errorf("unexpected status %s from the Nexus search API", resp.Status)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page.Items {
+			entry := repository.Entry{Version: item.Version}
+			for _, asset := range item.Assets {
+				entry.URLs = append(entry.URLs, asset.DownloadURL)
+				if entry.Digest == "" {
+					entry.Digest = asset.Checksum.SHA256
+				}
+			}
+			versions = append(versions, entry)
+		}
+		if continuation = page.ContinuationToken; continuation == "" {
+			break
+		}
+	}
+	slog.Debug("Listed chart versions through the Nexus API", "repo_url", repoURL, "chart", chartName)
+	return chartVersions(versions, chartName)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// defaultNotifyAttempts and defaultNotifyBackoff apply to notifiers
+	// without a retry policy
+	defaultNotifyAttempts = 3
+	defaultNotifyBackoff  = 2 * time.Second
+)
+
+// eventNotifier delivers status change events to one destination
+type eventNotifier interface {
+	// target identifies the destination in logs and deduplication
+	target() string
+	options() notifierOptions
+	// send delivers events in order, returning how many were delivered
+	// before an error
+	send(events []statusEvent) (int, error)
+}
+
+// notifierOptions select the events a notifier receives and how failed
+// deliveries are retried
+type notifierOptions struct {
+	// Events are the event types sent, all by default
+	Events []string `yaml:"events"`
+	// Selector matches the labels of the Applications whose events are sent
+	Selector string      `yaml:"selector"`
+	Retry    retryConfig `yaml:"retry"`
+}
+
+// retryConfig retries failed deliveries with a doubling backoff
+type retryConfig struct {
+	Attempts int           `yaml:"attempts"`
+	Backoff  time.Duration `yaml:"backoff"`
+}
+
+// notifierBuilders return the notifiers of a kind in a config, added with
+// registerNotifiers by the files implementing them
+var notifierBuilders []func(notifiersConfig) []eventNotifier
+
+// registerNotifiers adds a kind of notifier that receives the status change
+// events of every cycle
+func registerNotifiers(build func(notifiersConfig) []eventNotifier) {
+	notifierBuilders = append(notifierBuilders, build)
+}
+
+// eventNotifiers returns the notifiers of every registered kind in cfg
+func eventNotifiers(cfg notifiersConfig) []eventNotifier {
+	var notifiers []eventNotifier
+	for _, build := range notifierBuilders {
+		notifiers = append(notifiers, build(cfg)...)
+	}
+	return notifiers
+}
+
+// notifyBatch is the events to send to one notifier
+type notifyBatch struct {
+	notifier eventNotifier
+	events   []statusEvent
+}
+
+// notifyJob is a delivery of batches queued by queueNotify
+type notifyJob struct {
+	ctx     context.Context
+	batches []notifyBatch
+	window  time.Duration
+}
+
+// notifyQueue holds the deliveries waiting for the notify worker, so a slow
+// or failing destination doesn't hold up cycles and reconciles
+var (
+	notifyQueue      = make(chan notifyJob, 64)
+	notifyWorkerOnce sync.Once
+)
+
+// queueNotify queues batches for delivery in the background, dropping them
+// when the queue is full. Jobs are delivered in order; retries stop when ctx
+// is cancelled.
+func queueNotify(ctx context.Context, batches []notifyBatch, window time.Duration) {
+	if len(batches) == 0 {
+		return
+	}
+	notifyWorkerOnce.Do(func() {
+		go func() {
+			for job := range notifyQueue {
+				fanOut(job.ctx, job.batches, job.window)
+			}
+		}()
+	})
+	select {
+	case notifyQueue <- notifyJob{ctx: ctx, batches: batches, window: window}:
+	default:
+		slog.Error("Notification queue full, dropping events", "notifiers", len(batches))
+	}
+}
+
+// fanOut sends each batch to its notifier concurrently, returning once all
+// deliveries succeeded or ran out of attempts
+func fanOut(ctx context.Context, batches []notifyBatch, window time.Duration) {
+	var wg sync.WaitGroup
+	for _, b := range batches {
+		wg.Add(1)
+		go func(b notifyBatch) {
+			defer wg.Done()
+			dispatch(ctx, b.notifier, b.events, window)
+		}(b)
+	}
+	wg.Wait()
+}
+
+// dispatch sends the events a notifier accepts, skipping those already sent
+// to its target within window and retrying failed deliveries until ctx is
+// cancelled
+func dispatch(ctx context.Context, n eventNotifier, events []statusEvent, window time.Duration) {
+	opts := n.options()
+	var pending []statusEvent
+	for _, event := range events {
+		if opts.accepts(event) && !sentEvents.seen(n.target(), event, window) {
+			pending = append(pending, event)
+		}
+	}
+	attempts, backoff := opts.Retry.Attempts, opts.Retry.Backoff
+	if attempts <= 0 {
+		attempts = defaultNotifyAttempts
+	}
+	if backoff <= 0 {
+		backoff = defaultNotifyBackoff
+	}
+	for attempt := 1; len(pending) > 0; attempt++ {
+		sent, err := n.send(pending)
+		if window > 0 {
+			sentEvents.mark(n.target(), pending[:sent])
+		}
+		pending = pending[sent:]
+		if err == nil {
+			slog.Debug("Sent notifications", "notifier", n.target(), "events", sent)
+			return
+		}
+		if attempt >= attempts {
+			slog.Error("Error sending notifications", "notifier", n.target(), "attempts", attempt, "dropped", len(pending), "error", err)
+			return
+		}
+		slog.Warn("Retrying notifications", "notifier", n.target(), "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			slog.Error("Error sending notifications", "notifier", n.target(), "attempts", attempt, "dropped", len(pending), "error", ctx.Err())
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// accepts reports whether an event has one of the types and an Application
+// matching the selector of the options
+func (o notifierOptions) accepts(event statusEvent) bool {
+	if len(o.Events) > 0 && !slices.Contains(o.Events, event.Type) {
+		return false
+	}
+	selector, err := labels.Parse(o.Selector)
+	return err == nil && selector.Matches(labels.Set(event.Result.Labels))
+}
+
+// validate checks the event types, the selector and the retry policy
+func (o notifierOptions) validate() error {
+	for _, t := range o.Events {
+		switch t {
+		case eventOutdated, eventUpToDate, eventNewVersion:
+		default:
+			return fmt.Errorf("events: unknown event type %q, expected %s, %s or %s", t, eventOutdated, eventUpToDate, eventNewVersion)
+		}
+	}
+	if _, err := labels.Parse(o.Selector); err != nil {
+		return fmt.Errorf("selector: %w", err)
+	}
+	if o.Retry.Attempts < 0 || o.Retry.Backoff < 0 {
+		return errors.New("retry: attempts and backoff must not be negative")
+	}
+	return nil
+}
+
+// sentEvents remembers the events delivered to each target to drop
+// duplicates, such as those of a reconcile followed by a cycle
+var sentEvents = &eventDedupe{sent: map[string]time.Time{}}
+
+type eventDedupe struct {
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+// dedupeKey identifies an event by its type and versions, so a chart
+// changing state again is notified
+func dedupeKey(target string, event statusEvent) string {
+	r := event.Result
+	return target + "|" + resultKey(r) + "|" + event.Type + "|" + r.CurrentVersion + "|" + r.LatestVersion
+}
+
+// seen reports whether event was delivered to target within window,
+// forgetting older deliveries
+func (d *eventDedupe) seen(target string, event statusEvent, window time.Duration) bool {
+	if window <= 0 {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for key, at := range d.sent {
+		if now.Sub(at) > window {
+			delete(d.sent, key)
+		}
+	}
+	_, ok := d.sent[dedupeKey(target, event)]
+	return ok
+}
+
+func (d *eventDedupe) mark(target string, events []statusEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for _, event := range events {
+		d.sent[dedupeKey(target, event)] = now
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+const (
+	eventOutdated   = "outdated"
+	eventUpToDate   = "up-to-date"
+	eventNewVersion = "new-version"
+	// eventFailing and eventRecovered report charts that could not be checked
+	// and that are checked again, on the event stream only
+	eventFailing   = "failing"
+	eventRecovered = "recovered"
+)
+
+// statusEvent describes a change in the status of a chart between two cycles
+type statusEvent struct {
+	Type     string       `json:"type"`
+	Previous *chartResult `json:"previous,omitempty"`
+	Result   chartResult  `json:"result"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// resultKey identifies a chart of an application across cycles, with the
+// source and instance only when set so keys of single-source applications of
+// a single Argo CD do not change
+func resultKey(r chartResult) string {
+	key := r.Cluster + "|" + r.Check + "|" + r.Application + "|" + r.Chart + "|" + r.RepoURL
+	if r.Source != "" {
+		key += "|" + r.Source
+	}
+	if r.Instance != "" {
+		key += "|" + r.Instance
+	}
+	return key
+}
+
+// statusEvents compares the results of two cycles. Charts seen for the first
+// time produce an event only when they are outdated.
+func statusEvents(previous, current []chartResult) []statusEvent {
+	before := make(map[string]chartResult, len(previous))
+	for _, r := range previous {
+		before[resultKey(r)] = r
+	}
+	var events []statusEvent
+	for _, r := range current {
+		var previous *chartResult
+		if prev, seen := before[resultKey(r)]; seen {
+			previous = &prev
+		}
+		if t := eventType(previous, r); t != "" {
+			events = append(events, statusEvent{Type: t, Previous: previous, Result: r})
+		}
+	}
+	return events
+}
+
+// eventType returns the type of the change from previous, nil for a chart
+// seen for the first time, to r, or empty when there is none to notify
+func eventType(previous *chartResult, r chartResult) string {
+	switch {
+	case previous == nil:
+		if r.outdated() {
+			return eventOutdated
+		}
+	case !previous.outdated() && r.outdated():
+		return eventOutdated
+	case previous.outdated() && !r.outdated():
+		return eventUpToDate
+	case r.outdated() && previous.LatestVersion != r.LatestVersion:
+		return eventNewVersion
+	}
+	return ""
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+func init() {
+	registerNotifiers(func(cfg notifiersConfig) []eventNotifier {
+		notifiers := make([]eventNotifier, 0, len(cfg.Webhooks))
+		for _, hook := range cfg.Webhooks {
+			notifiers = append(notifiers, hook)
+		}
+		return notifiers
+	})
+}
+
+// notifyStatusChanges queues events for the notifiers of the config, and
+// for the webhooks of the HelmVersionCheck resource each result was checked
+// for, batched per webhook. Events of a maintenance window are held and sent
+// along with those of the first cycle after it.
+func notifyStatusChanges(ctx context.Context, cfg *config, events []statusEvent) {
+	if inMaintenanceWindow(cfg.Notifiers.MaintenanceWindows, time.Now()) {
+		deferredEvents.add(events)
+		return
+	}
+	events = deferredEvents.release(events)
+	if len(events) == 0 {
+		return
+	}
+	var batches []notifyBatch
+	for _, n := range eventNotifiers(cfg.Notifiers) {
+		batches = append(batches, notifyBatch{notifier: n, events: events})
+	}
+	// a batch per webhook of each resource, keeping their options apart
+	resourceBatches := map[string]int{}
+	for _, event := range events {
+		for j, hook := range checkResources.webhooks(event.Result) {
+			key := fmt.Sprintf("%s|%s|%d", event.Result.Cluster, event.Result.Check, j)
+			i, ok := resourceBatches[key]
+			if !ok {
+				i = len(batches)
+				resourceBatches[key] = i
+				batches = append(batches, notifyBatch{notifier: hook})
+			}
+			batches[i].events = append(batches[i].events, event)
+		}
+	}
+	queueNotify(ctx, batches, cfg.Notifiers.DedupeWindow)
+}
+
+func (h webhookConfig) target() string {
+	return "webhook " + h.URL
+}
+
+func (h webhookConfig) options() notifierOptions {
+	return h.notifierOptions
+}
+
+// send posts each event in turn
+func (h webhookConfig) send(events []statusEvent) (int, error) {
+	for i, event := range events {
+		body, err := h.render(event)
+		if err != nil {
+			return i, fmt.Errorf("rendering notification: %w", err)
+		}
+		if err := postWebhook(h, body); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// validate checks that the URL is set and the format and options are valid
+func (h webhookConfig) validate() error {
+	if h.URL == "" {
+		return errors.New("url is required")
+	}
+	if err := h.notifierOptions.validate(); err != nil {
+		return err
+	}
+	return h.eventFormat.validate()
+}
+
+// notificationTemplate parses the template text, or the content of file when
+// set, returning nil without either. Templates are cached by their text, so
+// files are reread on every use to pick up changes.
+func notificationTemplate(text, file string) (*template.Template, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading template: %w", err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	if cached, ok := notificationTemplates.Load(text); ok {
+		return cached.(*template.Template), nil
+	}
+	tmpl, err := template.New("notification").Funcs(sprig.TxtFuncMap()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+	notificationTemplates.Store(text, tmpl)
+	return tmpl, nil
+}
+
+// notificationTemplates caches parsed templates by their text
+var notificationTemplates sync.Map
+
+// render returns the body posted for event
+func (h webhookConfig) render(event statusEvent) ([]byte, error) {
+	return h.encode(event)
+}
+
+func postWebhook(hook webhookConfig, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", hook.contentType())
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := tracedDo(notifyClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
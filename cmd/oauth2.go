@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// oauth2Client requests tokens from the token endpoints of repositories
+var oauth2Client = &http.Client{Timeout: 30 * time.Second}
+
+// oauth2Tokens holds a token source per client, created on first use; the
+// sources reuse tokens until shortly before they expire
+var oauth2Tokens = &oauth2TokenCache{sources: map[string]oauth2.TokenSource{}}
+
+type oauth2TokenCache struct {
+	mu      sync.Mutex
+	sources map[string]oauth2.TokenSource
+}
+
+// clientSecret returns the client secret, reading the secret file if set
+func (o *oauth2Config) clientSecret() (string, error) {
+	if o.ClientSecretFile == "" {
+		return o.ClientSecret, nil
+	}
+	data, err := os.ReadFile(o.ClientSecretFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// accessToken returns a token of the client credentials grant. Sources are
+// keyed by the secret as well, so a rotated secret file takes effect.
+func (c *oauth2TokenCache) accessToken(o *oauth2Config) (string, error) {
+	secret, err := o.clientSecret()
+	if err != nil {
+		return "", err
+	}
+	key := strings.Join([]string{o.TokenURL, o.ClientID, secret, strings.Join(o.Scopes, " ")}, "|")
+	c.mu.Lock()
+	source, ok := c.sources[key]
+	if !ok {
+		cc := clientcredentials.Config{
+			ClientID:     o.ClientID,
+			ClientSecret: secret,
+			TokenURL:     o.TokenURL,
+			Scopes:       o.Scopes,
+		}
+		// the token source outlives the request, so it must not use its context
+		source = cc.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, oauth2Client))
+		c.sources[key] = source
+	}
+	c.mu.Unlock()
+
+	token, err := source.Token()
+	if err != nil {
+		return "", fmt.Errorf("getting OAuth2 token from %s: %w", o.TokenURL, err)
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// ociReference identifies a chart repository inside an OCI registry
+type ociReference struct {
+	Registry   string
+	Repository string
+}
+
+// ociManifest is the subset of an OCI image manifest used for charts and signatures
+type ociManifest struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// ociVersion is a chart version published as a tag
+type ociVersion struct {
+	Tag     string
+	Version *semver.Version
+	Scan    *repository.ScanSummary
+}
+
+// registryClient talks to the OCI distribution API, handling bearer token challenges
+type registryClient struct {
+	mu     sync.Mutex
+	tokens map[string]string
+	// auth sets the credentials answering basic challenges and token requests
+	auth repository.Authenticator
+}
+
+var ociClient = &registryClient{
+	tokens: make(map[string]string),
+	auth:   repository.AuthenticatorFunc(setRepoCredentials),
+}
+
+func isOCIRepo(repoURL string) bool {
+	return strings.HasPrefix(repoURL, "oci://")
+}
+
+// parseOCIReference splits an oci:// repoURL and chart name into registry and repository
+func parseOCIReference(repoURL, chartName string) (ociReference, error) {
+	trimmed := strings.Trim(strings.TrimPrefix(repoURL, "oci://"), "/")
+	registry, repoPath, _ := strings.Cut(trimmed, "/")
+	if registry == "" {
+		return ociReference{}, fmt.Errorf("invalid OCI repository URL %s", repoURL)
+	}
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	repository := chartName
+	if repoPath != "" {
+		repository = repoPath + "/" + chartName
+	}
+	return ociReference{Registry: registry, Repository: repository}, nil
+}
+
+func (r ociReference) url(format string, args ...interface{}) string {
+	return fmt.Sprintf("https://%s/v2/%s", r.Registry, r.Repository) + fmt.Sprintf(format, args...)
+}
+
+// repoURL returns the oci:// URL used to look up configured credentials
+func (r ociReference) repoURL() string {
+	return "oci://" + r.Registry + "/" + r.Repository
+}
+
+// do sends req, answering a 401 challenge with configured credentials or an
+// anonymous bearer token
+func (c *registryClient) do(ref ociReference, req *http.Request) (*http.Response, error) {
+	key := ref.Registry + "/" + ref.Repository
+	c.mu.Lock()
+	token := c.tokens[key]
+	c.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := repoDo(ref.repoURL(), req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	if scheme, _ := parseChallenge(challenge); strings.EqualFold(scheme, "basic") {
+		retry := req.Clone(req.Context())
+		if err := c.auth.Authenticate(retry, ref.repoURL()); err != nil {
+			return nil, err
+		}
+		return repoDo(ref.repoURL(), retry)
+	}
+
+	token, err = c.fetchToken(req.Context(), ref, challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tokens[key] = token
+	c.mu.Unlock()
+
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return repoDo(ref.repoURL(), retry)
+}
+
+// fetchToken requests a pull token from the realm named in a WWW-Authenticate challenge
+func (c *registryClient) fetchToken(ctx context.Context, ref ociReference, challenge string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return "", fmt.Errorf("unsupported auth challenge from %s: %q", ref.Registry, challenge)
+	}
+	query := url.Values{}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + ref.Repository + ":pull"
+	}
+	query.Set("scope", scope)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if err := c.auth.Authenticate(req, ref.repoURL()); err != nil {
+		return "", err
+	}
+	resp, err := repoDo(ref.repoURL(), req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %s failed: %s", params["realm"], resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// parseChallenge parses `Bearer realm="...",service="..."` into its scheme and parameters
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+	return scheme, params
+}
+
+// listTags returns every tag of the repository, following pagination links
+func (c *registryClient) listTags(ctx context.Context, ref ociReference) ([]string, error) {
+	var tags []string
+	next := ref.url("/tags/list?n=1000")
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(ref, req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			// each chart is its own repository in a registry
+			return nil, fmt.Errorf("chart %s/%s %w", ref.Registry, ref.Repository, errChartNotFound)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("listing tags of %s/%s: %s", ref.Registry, ref.Repository, resp.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		next = ""
+		if link := resp.Header.Get("Link"); link != "" {
+			target, _, _ := strings.Cut(link, ";")
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+			if u, err := resp.Request.URL.Parse(target); err == nil {
+				next = u.String()
+			}
+		}
+	}
+	return tags, nil
+}
+
+// manifest fetches the manifest for a tag or digest and returns it with its digest
+func (c *registryClient) manifest(ctx context.Context, ref ociReference, reference string) (ociManifest, string, error) {
+	var m ociManifest
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref.url("/manifests/%s", reference), nil)
+	if err != nil {
+		return m, "", err
+	}
+	req.Header.Set("Accept", ociManifestMediaType+", "+dockerManifestMediaType)
+	resp, err := c.do(ref, req)
+	if err != nil {
+		return m, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return m, "", fmt.Errorf("fetching manifest %s of %s/%s: %s", reference, ref.Registry, ref.Repository, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return m, "", err
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(body)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return m, "", err
+	}
+	return m, digest, nil
+}
+
+// blob downloads a blob and checks it against its digest
+func (c *registryClient) blob(ctx context.Context, ref ociReference, digest string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ref.url("/blobs/%s", digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ref, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching blob %s of %s/%s: %s", digest, ref.Registry, ref.Repository, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != digest {
+		return nil, fmt.Errorf("blob digest mismatch: expected %s, got %s", digest, actual)
+	}
+	return body, nil
+}
+
+// listOCIChartVersions returns the semver tags of a chart, newest first
+func listOCIChartVersions(ctx context.Context, ref ociReference) ([]ociVersion, error) {
+	if isHarbor(ctx, ref) {
+		versions, err := listHarborChartVersions(ctx, ref)
+		if err == nil || ctx.Err() != nil {
+			return versions, err
+		}
+		slog.Warn("Error using the Harbor API, listing tags instead", "registry", ref.Registry, "error", err)
+	}
+	tags, err := ociClient.listTags(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	policy := contextConfig(ctx).Policy.semver()
+	var versions []ociVersion
+	for _, tag := range tags {
+		// Helm stores "+" build metadata as "_" because "+" is not allowed in tags
+		v, err := policy.Parse(strings.ReplaceAll(tag, "_", "+"))
+		if err != nil {
+			slog.Debug("Ignoring non-semver tag", "tag", tag, "repository", ref.Repository)
+			continue
+		}
+		if v.Prerelease() != "" && contextConfig(ctx).Policy.IgnorePrereleases {
+			continue
+		}
+		versions = append(versions, ociVersion{Tag: tag, Version: v})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return policy.CompareSemver(versions[i].Version, versions[j].Version) > 0
+	})
+	slog.Debug("Found OCI chart versions", "registry", ref.Registry, "repository", ref.Repository, "count", len(versions))
+	return versions, nil
+}
+
+// ociChartEntries lists the versions of a chart in an OCI registry, newest
+// first, for a checker as index.yaml entries. Their URL is the tagged
+// reference, as Helm stores "+" build metadata as "_" in tags.
+func ociChartEntries(ctx context.Context, repoURL, chartName string) ([]repository.Entry, error) {
+	ref, err := parseOCIReference(repoURL, chartName)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Listing OCI tags", "registry", ref.Registry, "repository", ref.Repository, "chart", chartName)
+	versions, err := listOCIChartVersions(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("chart %s %w", chartName, errChartNotFound)
+	}
+	entries := make([]repository.Entry, 0, len(versions))
+	for _, v := range versions {
+		entries = append(entries, repository.Entry{
+			Version: v.Version.Original(),
+			URLs:    []string{ref.repoURL() + ":" + v.Tag},
+			Scan:    v.Scan,
+		})
+	}
+	return approvedEntries(ctx, chartName, entries)
+}
+
+// ociTag returns the tag of a version listed by ociChartEntries
+func ociTag(entry repository.Entry) string {
+	if len(entry.URLs) == 0 {
+		return strings.ReplaceAll(entry.Version, "+", "_")
+	}
+	return entry.URLs[0][strings.LastIndex(entry.URLs[0], ":")+1:]
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+func TestRegistryClientListTags(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "reader" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"tags": ["1.0.0", "1.1.0"]}`))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	cfg := defaultConfig()
+	cfg.Repositories = []repositoryConfig{{URL: "oci://" + host, InsecureSkipVerify: true}}
+	activeConfig.Store(&cfg)
+
+	var authenticated []string
+	client := &registryClient{
+		tokens: map[string]string{},
+		auth: repository.AuthenticatorFunc(func(req *http.Request, repoURL string) error {
+			authenticated = append(authenticated, repoURL)
+			req.SetBasicAuth("reader", "secret")
+			return nil
+		}),
+	}
+	ref := ociReference{Registry: host, Repository: "charts/nginx"}
+	tags, err := client.listTags(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("listTags() error = %v", err)
+	}
+	if !slices.Equal(tags, []string{"1.0.0", "1.1.0"}) {
+		t.Errorf("listTags() = %v, want [1.0.0 1.1.0]", tags)
+	}
+	if want := []string{"oci://" + host + "/charts/nginx"}; !slices.Equal(authenticated, want) {
+		t.Errorf("authenticated %v, want %v", authenticated, want)
+	}
+}
+
+func TestOCITag(t *testing.T) {
+	tests := []struct {
+		entry repository.Entry
+		want  string
+	}{
+		{entry: repository.Entry{Version: "1.0.0+build.1", URLs: []string{"oci://registry.example.com:5000/charts/nginx:1.0.0_build.1"}}, want: "1.0.0_build.1"},
+		{entry: repository.Entry{Version: "v1.0.0", URLs: []string{"oci://registry.example.com/charts/nginx:v1.0.0"}}, want: "v1.0.0"},
+		{entry: repository.Entry{Version: "1.0.0+build.1"}, want: "1.0.0_build.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := ociTag(tt.entry); got != tt.want {
+				t.Errorf("ociTag(%+v) = %q, want %q", tt.entry, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const defaultOpsgenieURL = "https://api.opsgenie.com"
+
+type opsgenieAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description"`
+	Priority    string            `json:"priority"`
+	Source      string            `json:"source"`
+	Details     map[string]string `json:"details"`
+}
+
+func (o opsgenieConfig) policy() incidentPolicy {
+	return o.Policy
+}
+
+// open creates an alert, which Opsgenie deduplicates by its alias
+func (o opsgenieConfig) open(r chartResult) error {
+	priority := o.Priority
+	if priority == "" {
+		priority = "P3"
+	}
+	message := incidentSummary(r)
+	// Opsgenie truncates messages longer than 130 characters
+	if len(message) > 130 {
+		message = message[:130]
+	}
+	alert := opsgenieAlert{
+		Message:     message,
+		Alias:       resultKey(r),
+		Description: incidentSummary(r) + "\n" + strings.Join(r.Links, "\n"),
+		Priority:    priority,
+		Source:      incidentSource,
+		Details: map[string]string{
+			"cluster":        r.Cluster,
+			"instance":       r.Instance,
+			"application":    r.Application,
+			"chart":          r.Chart,
+			"repoURL":        r.RepoURL,
+			"currentVersion": r.CurrentVersion,
+			"latestVersion":  r.LatestVersion,
+		},
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return o.post("/v2/alerts", body)
+}
+
+// resolve closes the alert with the alias of the result
+func (o opsgenieConfig) resolve(r chartResult) error {
+	body, err := json.Marshal(map[string]string{"source": incidentSource})
+	if err != nil {
+		return err
+	}
+	return o.post("/v2/alerts/"+url.PathEscape(resultKey(r))+"/close?identifierType=alias", body)
+}
+
+func (o opsgenieConfig) post(path string, body []byte) error {
+	key, err := o.apiKey()
+	if err != nil {
+		return err
+	}
+	base := o.APIURL
+	if base == "" {
+		base = defaultOpsgenieURL
+	}
+	hook := webhookConfig{
+		URL:     strings.TrimSuffix(base, "/") + path,
+		Headers: map[string]string{"Authorization": "GenieKey " + key},
+	}
+	return postWebhook(hook, body)
+}
+
+// apiKey returns the API key, reading the key file if set
+func (o opsgenieConfig) apiKey() (string, error) {
+	if o.APIKeyFile == "" {
+		return o.APIKey, nil
+	}
+	data, err := os.ReadFile(o.APIKeyFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (o opsgenieConfig) validate() error {
+	if o.APIKey == "" && o.APIKeyFile == "" {
+		return errors.New("apiKey or apiKeyFile is required")
+	}
+	switch o.Priority {
+	case "", "P1", "P2", "P3", "P4", "P5":
+	default:
+		return fmt.Errorf("priority must be P1 to P5, got %q", o.Priority)
+	}
+	return o.Policy.validate()
+}
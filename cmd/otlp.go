@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promBridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// serviceName identifies the checker to OpenTelemetry backends
+const serviceName = "helm-version-check"
+
+// tracer records spans; it is a no-op until tracing is started
+var tracer = otel.Tracer(serviceName)
+
+// startTelemetry starts the OTLP exporters enabled in cfg. The returned
+// function flushes and stops them.
+func startTelemetry(ctx context.Context, cfg otlpConfig) (func(context.Context) error, error) {
+	var stops []func(context.Context) error
+	shutdown := func(ctx context.Context) error {
+		var errs []error
+		for _, stop := range stops {
+			errs = append(errs, stop(ctx))
+		}
+		return errors.Join(errs...)
+	}
+	if cfg.Metrics.Enabled {
+		stop, err := startOTLPMetrics(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		stops = append(stops, stop)
+	}
+	if cfg.Traces.Enabled {
+		stop, err := startOTLPTraces(ctx, cfg)
+		if err != nil {
+			shutdown(ctx)
+			return nil, err
+		}
+		stops = append(stops, stop)
+	}
+	return shutdown, nil
+}
+
+func otlpResource() *resource.Resource {
+	return resource.NewSchemaless(semconv.ServiceName(serviceName))
+}
+
+// startOTLPMetrics periodically exports the chart gauges to an OTLP
+// collector. The returned function flushes and stops the exporter.
+func startOTLPMetrics(ctx context.Context, cfg otlpConfig) (func(context.Context) error, error) {
+	var exporter sdkmetric.Exporter
+	var err error
+	switch cfg.Protocol {
+	case "http":
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithHeaders(cfg.Headers)}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		exporter, err = otlpmetrichttp.New(ctx, opts...)
+	default:
+		opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithHeaders(cfg.Headers)}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlpmetricgrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		exporter, err = otlpmetricgrpc.New(ctx, opts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP metrics exporter: %w", err)
+	}
+
+	// The gauges are bridged from Prometheus so both outputs stay identical
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(helmVersionGauge, provenanceGauge, signatureGauge)
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(cfg.Metrics.Interval),
+		sdkmetric.WithProducer(promBridge.NewMetricProducer(promBridge.WithGatherer(registry))),
+	)
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(otlpResource()),
+	)
+	slog.Info("Exporting metrics over OTLP", "endpoint", cfg.Endpoint, "protocol", cfg.Protocol, "interval", cfg.Metrics.Interval)
+	return provider.Shutdown, nil
+}
+
+// startOTLPTraces installs a tracer provider exporting spans in batches
+func startOTLPTraces(ctx context.Context, cfg otlpConfig) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Protocol {
+	case "http":
+		opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(cfg.Headers)}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithHeaders(cfg.Headers)}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(otlpResource()),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Traces.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	slog.Info("Exporting traces over OTLP", "endpoint", cfg.Endpoint, "protocol", cfg.Protocol, "sample_ratio", cfg.Traces.SampleRatio)
+	return provider.Shutdown, nil
+}
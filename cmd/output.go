@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Formats results are written in, set with output.format or --output
+const (
+	// outputLog records each result as a structured log entry, the default
+	// of the exporter
+	outputLog = "log"
+	// outputText writes a block per result, the default of the check command
+	outputText  = "text"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+	outputNone  = "none"
+)
+
+// resultOutput writes the results of a cycle or of a single check
+type resultOutput interface {
+	write(w io.Writer, results []chartResult) error
+}
+
+// output returns the writer of the configured format, or of fallback when
+// none is configured
+func (o outputConfig) output(fallback string) resultOutput {
+	switch valueOr(o.Format, fallback) {
+	case outputText:
+		return textOutput{}
+	case outputJSON:
+		return jsonOutput{}
+	case outputYAML:
+		return yamlOutput{}
+	case outputTable:
+		columns := o.Columns
+		if len(columns) == 0 {
+			columns = defaultTableColumns
+		}
+		return tableOutput{columns: columns, sortBy: valueOr(o.Sort, "application")}
+	case outputNone:
+		return noOutput{}
+	}
+	if o.ChangesOnly {
+		return slogOutput{level: slog.LevelDebug}
+	}
+	return slogOutput{level: slog.LevelInfo}
+}
+
+// validate reports unknown formats and columns
+func (o outputConfig) validate() error {
+	switch o.Format {
+	case "", outputLog, outputText, outputJSON, outputYAML, outputTable, outputNone:
+	default:
+		return fmt.Errorf("format must be log, text, json, yaml, table or none, got %q", o.Format)
+	}
+	if o.ChangesOnly && o.Format != "" && o.Format != outputLog {
+		return fmt.Errorf("changesOnly requires the log format, got %q", o.Format)
+	}
+	if err := validateTableColumns(o.Columns); err != nil {
+		return fmt.Errorf("columns: %w", err)
+	}
+	if o.Sort != "" {
+		if err := validateTableColumns([]string{o.Sort}); err != nil {
+			return fmt.Errorf("sort: %w", err)
+		}
+	}
+	return nil
+}
+
+// slogOutput logs results at level instead of writing them
+type slogOutput struct {
+	level slog.Level
+}
+
+func (o slogOutput) write(_ io.Writer, results []chartResult) error {
+	for _, r := range results {
+		logResult(o.level, r)
+	}
+	return nil
+}
+
+// logStatusEvents logs the changes in the status of charts, which is all the
+// exporter logs of results with output.changesOnly
+func logStatusEvents(events []statusEvent) {
+	for _, e := range events {
+		r := e.Result
+		log := slog.With("cluster", r.Cluster, "instance", r.Instance, "application", r.Application, "source", r.Source, "chart", r.Chart, "repo_url", r.RepoURL)
+		switch e.Type {
+		case eventOutdated:
+			log.Info("Chart became outdated", "current_version", r.CurrentVersion, "latest_version", r.LatestVersion)
+		case eventUpToDate:
+			log.Info("Chart became up to date", "current_version", r.CurrentVersion, "latest_version", r.LatestVersion)
+		case eventNewVersion:
+			log.Info("New latest version of outdated chart", "current_version", r.CurrentVersion, "latest_version", r.LatestVersion, "previous_latest_version", e.Previous.LatestVersion)
+		case eventFailing:
+			log.Info("Chart check started failing", "error", e.Error)
+		case eventRecovered:
+			log.Info("Chart check recovered", "current_version", r.CurrentVersion, "latest_version", r.LatestVersion, "previous_error", e.Error)
+		}
+	}
+}
+
+type textOutput struct{}
+
+func (textOutput) write(w io.Writer, results []chartResult) error {
+	for _, r := range results {
+		writeTextResult(w, r)
+	}
+	return nil
+}
+
+// jsonOutput writes a report as one JSON document per call, so the output
+// of the exporter is a stream of reports
+type jsonOutput struct{}
+
+func (jsonOutput) write(w io.Writer, results []chartResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newReport(results))
+}
+
+// yamlOutput writes a report as a YAML document per call
+type yamlOutput struct{}
+
+func (yamlOutput) write(w io.Writer, results []chartResult) error {
+	data, err := yaml.Marshal(newReport(results))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "---\n%s", data)
+	return err
+}
+
+type tableOutput struct {
+	columns    []string
+	sortBy     string
+	descending bool
+}
+
+func (t tableOutput) write(w io.Writer, results []chartResult) error {
+	return writeTable(w, results, t.columns, t.sortBy, t.descending)
+}
+
+// noOutput drops results, which remain available as metrics and reports
+type noOutput struct{}
+
+func (noOutput) write(io.Writer, []chartResult) error { return nil }
+
+// newReport returns a report of results generated now
+func newReport(results []chartResult) report {
+	if results == nil {
+		results = []chartResult{}
+	}
+	return report{GeneratedAt: time.Now().UTC().Truncate(time.Second), Results: results}
+}
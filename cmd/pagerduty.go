@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// pagerDutyEventsURL is the endpoint of the PagerDuty Events API v2
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string      `json:"summary"`
+	Source        string      `json:"source"`
+	Severity      string      `json:"severity"`
+	Component     string      `json:"component"`
+	Group         string      `json:"group"`
+	CustomDetails chartResult `json:"custom_details"`
+}
+
+func (p pagerDutyConfig) policy() incidentPolicy {
+	return p.Policy
+}
+
+// open triggers an incident, which PagerDuty deduplicates by the result key
+func (p pagerDutyConfig) open(r chartResult) error {
+	severity := p.Severity
+	if severity == "" {
+		severity = "error"
+	}
+	return p.send(pagerDutyEvent{
+		EventAction: "trigger",
+		DedupKey:    resultKey(r),
+		Payload: &pagerDutyPayload{
+			Summary:       incidentSummary(r),
+			Source:        incidentSource,
+			Severity:      severity,
+			Component:     r.Chart,
+			Group:         r.Application,
+			CustomDetails: r,
+		},
+	})
+}
+
+func (p pagerDutyConfig) resolve(r chartResult) error {
+	return p.send(pagerDutyEvent{EventAction: "resolve", DedupKey: resultKey(r)})
+}
+
+func (p pagerDutyConfig) send(event pagerDutyEvent) error {
+	key, err := p.routingKey()
+	if err != nil {
+		return err
+	}
+	event.RoutingKey = key
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return postWebhook(webhookConfig{URL: pagerDutyEventsURL}, body)
+}
+
+// routingKey returns the integration key, reading the key file if set
+func (p pagerDutyConfig) routingKey() (string, error) {
+	if p.RoutingKeyFile == "" {
+		return p.RoutingKey, nil
+	}
+	data, err := os.ReadFile(p.RoutingKeyFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (p pagerDutyConfig) validate() error {
+	if p.RoutingKey == "" && p.RoutingKeyFile == "" {
+		return errors.New("routingKey or routingKeyFile is required")
+	}
+	switch p.Severity {
+	case "", "critical", "error", "warning", "info":
+	default:
+		return fmt.Errorf("severity must be critical, error, warning or info, got %q", p.Severity)
+	}
+	return p.Policy.validate()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/caseyrobb/helm-version-check/pkg/policy"
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// maxCompiledRules bounds compiledRules, which is emptied when full so the
+// expressions of removed resources and past configs do not pile up
+const maxCompiledRules = 1024
+
+// compiledRules caches rules by expression, as configs are validated on
+// every reload and each HelmVersionCheck resource has its own
+var compiledRules = &ruleCache{rules: map[string]*policy.Rule{}}
+
+type ruleCache struct {
+	mu    sync.Mutex
+	rules map[string]*policy.Rule
+}
+
+// compile returns the rule of expr, compiling it when not cached
+func (c *ruleCache) compile(expr string) (*policy.Rule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rule, ok := c.rules[expr]; ok {
+		return rule, nil
+	}
+	rule, err := policy.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.rules) >= maxCompiledRules {
+		clear(c.rules)
+	}
+	c.rules[expr] = rule
+	return rule, nil
+}
+
+// policyRules compiles the policy rules exprs
+func policyRules(exprs []string) ([]*policy.Rule, error) {
+	rules := make([]*policy.Rule, 0, len(exprs))
+	for i, expr := range exprs {
+		rule, err := compiledRules.compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// versionAcceptor returns whether versions of charts in repoURL are out of
+// the grace period and satisfy the policy rules for the application checked
+// with ctx, nil without either. A rule failing to evaluate, such as on a
+// missing label, rejects the version.
+func versionAcceptor(ctx context.Context, repoURL string) func(chartName, current string, candidate repository.Entry) bool {
+	cfg := contextConfig(ctx).Policy
+	rules, err := policyRules(cfg.Rules)
+	if err != nil || len(rules) == 0 && cfg.GracePeriod <= 0 {
+		return nil
+	}
+	app := contextApplication(ctx)
+	cluster := contextCluster(ctx).name
+	now := time.Now()
+	return func(chartName, current string, candidate repository.Entry) bool {
+		if inGracePeriod(candidate, cfg.GracePeriod, now) {
+			slog.Debug("Version within grace period", "application", app.Application, "chart", chartName,
+				"version", candidate.Version, "created", candidate.Created.Format(time.RFC3339))
+			return false
+		}
+		in := policy.Input{
+			Candidate:   candidate,
+			Chart:       chartName,
+			RepoURL:     repoURL,
+			Current:     current,
+			Application: app,
+			Cluster:     cluster,
+			Now:         now,
+		}
+		for _, rule := range rules {
+			accepted, err := rule.Accepts(in)
+			if err != nil {
+				slog.Warn("Error evaluating policy rule, rejecting version", "rule", rule.String(), "application", app.Application,
+					"chart", chartName, "version", candidate.Version, "error", err)
+				return false
+			}
+			if !accepted {
+				slog.Debug("Version rejected by policy rule", "rule", rule.String(), "application", app.Application,
+					"chart", chartName, "version", candidate.Version)
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// inGracePeriod reports whether a version was published less than grace
+// before now; versions without a created date never are
+func inGracePeriod(v repository.Entry, grace time.Duration, now time.Time) bool {
+	return grace > 0 && !v.Created.IsZero() && now.Sub(v.Created) < grace
+}
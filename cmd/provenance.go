@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"gopkg.in/yaml.v2"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// loadKeyring reads a PGP public keyring in either armored or binary form
+func loadKeyring(keyringPath string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(keyringPath)
+	if err != nil {
+		return nil, err
+	}
+	if keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data)); err == nil {
+		return keyring, nil
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+// resolveChartURL turns a (possibly relative) URL from index.yaml into an absolute one
+func resolveChartURL(repoURL, chartURL string) (string, error) {
+	base, err := url.Parse(repoURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(chartURL)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// maxDownloadSize bounds the chart archives and provenance files downloaded,
+// well above the size of any chart
+const maxDownloadSize = 64 << 20
+
+// download fetches url and returns the response body
+func download(ctx context.Context, url string) ([]byte, error) {
+	resp, err := repoGet(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s fetching %s", resp.Status, url)
+	}
+	if resp.ContentLength > maxDownloadSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, maxDownloadSize)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDownloadSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, maxDownloadSize)
+	}
+	return data, nil
+}
+
+// verifyProvenance downloads a chart archive and its .prov file, checks the
+// PGP signature against the keyring and the archive digest against the
+// signed files section
+func verifyProvenance(ctx context.Context, repoURL string, entry repository.Entry, keyring openpgp.EntityList) error {
+	if len(entry.URLs) == 0 {
+		return fmt.Errorf("no download URL for version %s", entry.Version)
+	}
+	chartURL, err := resolveChartURL(repoURL, entry.URLs[0])
+	if err != nil {
+		return err
+	}
+	slog.Debug("Verifying provenance", "url", chartURL)
+
+	archive, err := download(ctx, chartURL)
+	if err != nil {
+		return err
+	}
+	prov, err := download(ctx, chartURL+".prov")
+	if err != nil {
+		return err
+	}
+
+	block, _ := clearsign.Decode(prov)
+	if block == nil {
+		return fmt.Errorf("no signed block found in %s.prov", chartURL)
+	}
+	signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body, nil)
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	for name := range signer.Identities {
+		slog.Debug("Provenance signed", "url", chartURL, "identity", name)
+	}
+
+	// The signed message is the Chart.yaml followed by a "..." separator
+	// and a YAML document listing the archive digests
+	parts := strings.SplitN(string(block.Plaintext), "\n...\n", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("malformed provenance file for %s", chartURL)
+	}
+	var sums struct {
+		Files map[string]string `yaml:"files"`
+	}
+	if err := yaml.Unmarshal([]byte(parts[1]), &sums); err != nil {
+		return fmt.Errorf("malformed provenance file for %s: %w", chartURL, err)
+	}
+	expected, ok := sums.Files[path.Base(chartURL)]
+	if !ok {
+		return fmt.Errorf("provenance file does not list %s", path.Base(chartURL))
+	}
+	sum := sha256.Sum256(archive)
+	if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != expected {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// maxProvenanceResults bounds provenanceResults, which is emptied when full
+const maxProvenanceResults = 4096
+
+// provenanceResults remembers the provenance verification of chart versions
+// by digest, so archives are not downloaded again every cycle. Versions
+// whose index entry has no digest are verified every time, as their archive
+// may be replaced.
+var provenanceResults = &provenanceCache{results: map[string]provenanceResult{}}
+
+type provenanceResult struct {
+	err error
+	// until is when a failed verification is retried, zero for verified ones
+	until time.Time
+}
+
+type provenanceCache struct {
+	mu      sync.Mutex
+	results map[string]provenanceResult
+}
+
+// Verify returns the cached verification of entry, verifying it against the
+// keyring when unknown. Failures are retried after the negative TTL.
+func (c *provenanceCache) Verify(ctx context.Context, repoURL, chartName string, entry repository.Entry) error {
+	keyring := contextConfig(ctx).keyring
+	if entry.Digest == "" {
+		return verifyProvenance(ctx, repoURL, entry, keyring)
+	}
+	key := strings.Join([]string{strings.TrimSuffix(repoURL, "/"), chartName, entry.Version, entry.Digest}, "|")
+	c.mu.Lock()
+	result, ok := c.results[key]
+	c.mu.Unlock()
+	if ok && (result.until.IsZero() || time.Now().Before(result.until)) {
+		return result.err
+	}
+
+	err := verifyProvenance(ctx, repoURL, entry, keyring)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	result = provenanceResult{err: err}
+	if err != nil {
+		ttl := contextConfig(ctx).Cache.NegativeTTL
+		if ttl <= 0 {
+			return err
+		}
+		result.until = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.results) >= maxProvenanceResults {
+		clear(c.results)
+	}
+	c.results[key] = result
+	return err
+}
+
+// reset forgets every verification, as when the keyring changes
+func (c *provenanceCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.results)
+}
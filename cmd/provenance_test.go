@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+func TestProvenanceResults(t *testing.T) {
+	signer, err := openpgp.NewEntity("charts", "", "charts@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	var entries []repository.Entry
+	// 3.0.0 is unsigned and 2.0.0 signed for another archive
+	for _, version := range []string{"3.0.0", "2.0.0", "1.0.0", "0.9.0"} {
+		name := fmt.Sprintf("nginx-%s.tgz", version)
+		archive := []byte("archive of " + version)
+		sum := sha256.Sum256(archive)
+		files[name] = archive
+		entries = append(entries, repository.Entry{Version: version, URLs: []string{name}, Digest: hex.EncodeToString(sum[:])})
+		if version == "3.0.0" {
+			continue
+		}
+		signed := sum
+		if version == "2.0.0" {
+			signed = sha256.Sum256([]byte("another archive"))
+		}
+		var prov bytes.Buffer
+		w, err := clearsign.Encode(&prov, signer.PrivateKey, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(w, "name: nginx\nversion: %s\n\n...\nfiles:\n  %s: sha256:%s\n", version, name, hex.EncodeToString(signed[:]))
+		w.Close()
+		files[name+".prov"] = prov.Bytes()
+	}
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		mu.Lock()
+		requests[name]++
+		mu.Unlock()
+		data, ok := files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	cfg := defaultConfig()
+	cfg.keyring = openpgp.EntityList{signer}
+	cfg.Provenance.Require = true
+	activeConfig.Store(&cfg)
+	provenanceResults.reset()
+
+	resolver := check.Resolver{
+		Checker: check.Checker{
+			Resolver: repository.ResolverFunc(func(context.Context, string, string) ([]repository.Entry, error) {
+				return entries, nil
+			}),
+		},
+		Verifier: provenanceResults,
+	}
+	repoURL := server.URL + "/"
+	tests := []struct {
+		name         string
+		current      string
+		want         string
+		wantVerified bool
+	}{
+		{name: "steps back to signed version", current: "0.9.0", want: "1.0.0"},
+		{name: "cached", current: "0.9.0", want: "1.0.0"},
+		{name: "keeps current version", current: "1.0.0", want: "1.0.0"},
+		{name: "falls back to unsigned current version", current: "2.0.0", want: "2.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.Latest(context.Background(), repoURL, "nginx", tt.current)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Latest.Version != tt.want || got.Newest != "3.0.0" || got.Verified != tt.wantVerified {
+				t.Errorf("Latest() = %s, %s, %t, want %s, 3.0.0, %t", got.Latest.Version, got.Newest, got.Verified, tt.want, tt.wantVerified)
+			}
+		})
+	}
+	if n := requests["nginx-1.0.0.tgz"]; n != 1 {
+		t.Errorf("nginx-1.0.0.tgz downloaded %d times, want once", n)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// pushMetrics replaces the job's metrics on the Pushgateway with the current
+// gauge values, so charts that disappeared are dropped as well
+func pushMetrics(ctx context.Context, cfg pushgatewayConfig) error {
+	pusher := push.New(cfg.URL, cfg.Job).
+		Collector(helmVersionGauge).
+		Collector(provenanceGauge).
+		Collector(signatureGauge)
+	if cfg.BasicAuth.Username != "" {
+		username, password, err := cfg.BasicAuth.credentials()
+		if err != nil {
+			return err
+		}
+		pusher = pusher.BasicAuth(username, password)
+	}
+	if err := pusher.PushContext(ctx); err != nil {
+		return err
+	}
+	slog.Debug("Pushed metrics", "url", cfg.URL, "job", cfg.Job)
+	return nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// repoLimiters holds a token bucket per repository host
+var repoLimiters = &hostLimiters{limiters: map[string]*rate.Limiter{}}
+
+type hostLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// wait blocks until a request to host is allowed by the configured rate limit,
+// adjusting existing buckets when the limit is changed by a config reload
+func (h *hostLimiters) wait(ctx context.Context, host string, cfg rateLimitConfig) error {
+	if cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+	limit := rate.Limit(cfg.RequestsPerSecond)
+	burst := max(cfg.Burst, 1)
+
+	h.mu.Lock()
+	l, ok := h.limiters[host]
+	if !ok {
+		l = rate.NewLimiter(limit, burst)
+		h.limiters[host] = l
+	} else if l.Limit() != limit || l.Burst() != burst {
+		l.SetLimit(limit)
+		l.SetBurst(burst)
+	}
+	h.mu.Unlock()
+	return l.Wait(ctx)
+}
+
+// requestPacer spreads the repository requests of a cycle over a window
+type requestPacer struct {
+	limiter  *rate.Limiter
+	requests atomic.Int64
+}
+
+type pacerKey struct{}
+
+// lastCycleRequests is the number of repository requests of the last paced
+// cycle, which the next one expects to send
+var lastCycleRequests atomic.Int64
+
+// newRequestPacer returns a pacer sending as many requests as the last cycle,
+// capped by the request budget, evenly over the spread fraction of the
+// interval or the whole interval. The budget caps the rate only: requests
+// past it wait their turn rather than fail. It returns nil without a budget
+// or when cycles are driven by scrapes.
+func newRequestPacer(cfg *config) *requestPacer {
+	budget := int64(cfg.Schedule.RequestBudget)
+	if budget <= 0 || cfg.Collection == collectionScrape {
+		return nil
+	}
+	window := cyclePeriod(cfg, time.Now())
+	if cfg.Schedule.Spread > 0 {
+		window = time.Duration(float64(window) * cfg.Schedule.Spread)
+	}
+	requests := budget
+	if last := lastCycleRequests.Load(); last > 0 {
+		requests = min(last, budget)
+	}
+	slog.Debug("Pacing repository requests", "requests", requests, "window", window)
+	return &requestPacer{limiter: rate.NewLimiter(rate.Limit(float64(requests)/window.Seconds()), 1)}
+}
+
+// withRequestPacer paces the repository requests made with ctx
+func withRequestPacer(ctx context.Context, pacer *requestPacer) context.Context {
+	if pacer == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, pacerKey{}, pacer)
+}
+
+// pace waits for the turn of a request made with ctx
+func pace(ctx context.Context) error {
+	pacer, ok := ctx.Value(pacerKey{}).(*requestPacer)
+	if !ok {
+		return nil
+	}
+	pacer.requests.Add(1)
+	return pacer.limiter.Wait(ctx)
+}
+
+// finish records the requests of a completed cycle for the next one
+func (p *requestPacer) finish() {
+	if p != nil {
+		lastCycleRequests.Store(p.requests.Load())
+	}
+}
+
+// defaultRateLimitBackoff is how long a host answering 429 without saying
+// when to retry is skipped
+const defaultRateLimitBackoff = time.Minute
+
+// maxRateLimitBackoff bounds how long a host is skipped, so a bogus or
+// hostile reset time cannot stop checks of its charts for good
+const maxRateLimitBackoff = time.Hour
+
+var (
+	// errRateLimited is returned for requests to a host that asked to back off
+	errRateLimited = errors.New("rate limited")
+
+	rateLimitRemainingGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_repository_ratelimit_remaining",
+			Help: "Requests left in the current window as reported by a repository host's rate limit headers",
+		},
+		[]string{"host"},
+	)
+	rateLimitLimitGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_repository_ratelimit_limit",
+			Help: "Requests allowed per window as reported by a repository host's rate limit headers",
+		},
+		[]string{"host"},
+	)
+	rateLimitBackoffGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_repository_ratelimit_backoff_until_timestamp_seconds",
+			Help: "Unix time until which requests to a rate limited repository host are skipped, 0 when not backing off",
+		},
+		[]string{"host"},
+	)
+
+	// repoBackoffs tracks the hosts that asked to back off
+	repoBackoffs = &hostBackoffs{until: map[string]time.Time{}}
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitRemainingGauge, rateLimitLimitGauge, rateLimitBackoffGauge)
+}
+
+type hostBackoffs struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// allow returns errRateLimited until the reset time of a host that answered
+// 429 or ran out of requests
+func (h *hostBackoffs) allow(host string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	until, ok := h.until[host]
+	if !ok {
+		return nil
+	}
+	if time.Now().Before(until) {
+		return fmt.Errorf("skipping %s until %s: %w", host, until.Format(time.RFC3339), errRateLimited)
+	}
+	delete(h.until, host)
+	rateLimitBackoffGauge.WithLabelValues(host).Set(0)
+	return nil
+}
+
+// record exports the rate limit headers of a response and backs off from
+// host when it answered 429 or has no requests left
+func (h *hostBackoffs) record(host string, resp *http.Response) {
+	remaining, hasRemaining := rateLimitHeader(resp.Header, "RateLimit-Remaining", "X-RateLimit-Remaining")
+	if hasRemaining {
+		rateLimitRemainingGauge.WithLabelValues(host).Set(float64(remaining))
+	}
+	if limit, ok := rateLimitHeader(resp.Header, "RateLimit-Limit", "X-RateLimit-Limit"); ok {
+		rateLimitLimitGauge.WithLabelValues(host).Set(float64(limit))
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && !(hasRemaining && remaining == 0) {
+		return
+	}
+	until, ok := retryAfter(resp.Header, time.Now())
+	if !ok {
+		if resp.StatusCode != http.StatusTooManyRequests {
+			// Out of requests without a reset time: the next one will tell
+			return
+		}
+		until = time.Now().Add(defaultRateLimitBackoff)
+	}
+	h.mu.Lock()
+	h.until[host] = until
+	h.mu.Unlock()
+	slog.Warn("Backing off from rate limited repository", "host", host, "until", until.Format(time.RFC3339), "status", resp.StatusCode)
+	rateLimitBackoffGauge.WithLabelValues(host).Set(float64(until.Unix()))
+}
+
+// rateLimitHeader returns the count of the first header present, ignoring
+// parameters such as the window in Docker Hub's "100;w=21600"
+func rateLimitHeader(header http.Header, names ...string) (int64, bool) {
+	for _, name := range names {
+		value, _, _ := strings.Cut(header.Get(name), ";")
+		if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// retryAfter returns when requests may resume according to Retry-After, in
+// seconds or as a date, or to a reset header in seconds or as a Unix time,
+// at most maxRateLimitBackoff after now
+func retryAfter(header http.Header, now time.Time) (time.Time, bool) {
+	until, ok := time.Time{}, false
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			until, ok = afterSeconds(now, seconds), true
+		} else if date, err := http.ParseTime(value); err == nil {
+			until, ok = date, true
+		}
+	}
+	if reset, found := rateLimitHeader(header, "RateLimit-Reset", "X-RateLimit-Reset"); !ok && found {
+		// Values beyond a year of seconds are Unix times, as sent by GitHub
+		if reset > 365*24*60*60 {
+			until = time.Unix(reset, 0)
+		} else {
+			until = afterSeconds(now, reset)
+		}
+		ok = true
+	}
+	if limit := now.Add(maxRateLimitBackoff); until.After(limit) {
+		until = limit
+	}
+	return until, ok
+}
+
+// afterSeconds returns seconds after now, without overflowing a duration
+func afterSeconds(now time.Time, seconds int64) time.Time {
+	if seconds > int64(maxRateLimitBackoff/time.Second) {
+		return now.Add(maxRateLimitBackoff)
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Time
+		wantOK bool
+	}{
+		{name: "none", header: http.Header{}},
+		{name: "seconds", header: http.Header{"Retry-After": {"120"}}, want: now.Add(2 * time.Minute), wantOK: true},
+		{name: "date", header: http.Header{"Retry-After": {now.Add(10 * time.Minute).Format(http.TimeFormat)}}, want: now.Add(10 * time.Minute), wantOK: true},
+		{name: "seconds clamped", header: http.Header{"Retry-After": {"86400"}}, want: now.Add(maxRateLimitBackoff), wantOK: true},
+		{name: "seconds overflowing", header: http.Header{"Retry-After": {"9223372036854775807"}}, want: now.Add(maxRateLimitBackoff), wantOK: true},
+		{name: "date clamped", header: http.Header{"Retry-After": {"Fri, 31 Dec 9999 23:59:59 GMT"}}, want: now.Add(maxRateLimitBackoff), wantOK: true},
+		{name: "reset seconds", header: http.Header{"Ratelimit-Reset": {"30"}}, want: now.Add(30 * time.Second), wantOK: true},
+		{name: "reset unix time", header: http.Header{"X-Ratelimit-Reset": {"1704110700"}}, want: time.Unix(1704110700, 0), wantOK: true},
+		{name: "reset unix time clamped", header: http.Header{"X-Ratelimit-Reset": {"4102444800"}}, want: now.Add(maxRateLimitBackoff), wantOK: true},
+		{name: "invalid retry after falls back to reset", header: http.Header{"Retry-After": {"soon"}, "Ratelimit-Reset": {"30"}}, want: now.Add(30 * time.Second), wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.header, now)
+			if !got.Equal(tt.want) || ok != tt.wantOK {
+				t.Errorf("retryAfter() = %s, %t, want %s, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// receiverEvent is the payload of an inbound webhook, sent by a chart
+// repository's publish pipeline or an Argo CD notification template. Either
+// field identifies what to check again.
+type receiverEvent struct {
+	RepoURL     string `json:"repoURL"`
+	Chart       string `json:"chart"`
+	Application string `json:"application"`
+}
+
+// receiverHandler serves POST /webhook. An event naming a repository drops
+// its cached index and queues a check of the applications that used it, or
+// only of those using chart when given, in the latest results; an event
+// naming an application queues a check of it. It requires the receiver
+// bearer token.
+func receiverHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkBearerToken(w, r, currentConfig().Receiver.token) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var event receiverEvent
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&event); err != nil {
+		http.Error(w, fmt.Sprintf("decoding event: %v", err), http.StatusBadRequest)
+		return
+	}
+	if event.RepoURL == "" && event.Application == "" {
+		http.Error(w, "repoURL or application is required", http.StatusBadRequest)
+		return
+	}
+
+	apps := []string{event.Application}
+	if event.RepoURL != "" {
+		repoURL := event.RepoURL
+		if !isOCIRepo(repoURL) && !strings.HasSuffix(repoURL, "/") {
+			repoURL += "/"
+		}
+		// The index is cached under the URL it is fetched from
+		invalidated := repoIndexCache.invalidate(repoURL)
+		if mirror := currentConfig().mirrorFor(repoURL); mirror != repoURL {
+			invalidated = repoIndexCache.invalidate(mirror) || invalidated
+		}
+		failures := failedCharts.invalidate(repoURL)
+		slog.Debug("Invalidated index cache", "repo_url", repoURL, "cached", invalidated, "failures", failures)
+		if event.Application == "" {
+			apps = latestResults.applications(repoURL, event.Chart)
+		}
+	}
+
+	slog.Info("Webhook received", "repo_url", event.RepoURL, "chart", event.Chart, "application", event.Application,
+		"applications", len(apps), "remote", r.RemoteAddr)
+	for _, app := range apps {
+		// A check of all Applications covers those not queued
+		if !queueReconcile(app) && !queueReconcile("") {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "too many reconciles queued", http.StatusTooManyRequests)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "reconcile of %d applications queued\n", len(apps))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// reconcileRequests queues requests for an immediate check: an application
+// name, or an empty string for all Applications
+var reconcileRequests = make(chan string, 16)
+
+// reconcileHandler serves POST /reconcile, which queues an immediate check
+// of all Applications, or only of the one named by ?app=. It requires the
+// admin bearer token.
+func reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkBearerToken(w, r, currentConfig().Admin.token) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	app := r.URL.Query().Get("app")
+	if !queueReconcile(app) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "too many reconciles queued", http.StatusTooManyRequests)
+		return
+	}
+	slog.Info("Reconcile requested", "application", app, "remote", r.RemoteAddr)
+	w.WriteHeader(http.StatusAccepted)
+	if app == "" {
+		fmt.Fprintln(w, "reconcile of all applications queued")
+	} else {
+		fmt.Fprintf(w, "reconcile of %s queued\n", app)
+	}
+}
+
+// queueReconcile queues a check of app, or of all Applications when app is
+// empty, reporting false when the queue is full
+func queueReconcile(app string) bool {
+	select {
+	case reconcileRequests <- app:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitForCycle waits until interval has passed or a reconcile of all
+// Applications is requested, checking single Applications as requested
+// meanwhile. It returns early when ctx is cancelled.
+func waitForCycle(ctx context.Context, interval time.Duration, checkApplication func(context.Context, string)) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case app := <-reconcileRequests:
+			if app == "" {
+				return
+			}
+			checkApplication(ctx, app)
+		}
+	}
+}
+
+// reconcileApplication checks the Application named app in every configured
+// cluster and namespace outside a cycle. Its results replace the previous
+// ones in the report and status changes are notified as after a cycle.
+func reconcileApplication(ctx context.Context, clusters []clusterClient, cacheDir, app string) {
+	cfg := currentConfig()
+	if !cfg.Sharding.owns(app) {
+		slog.Info("Ignoring reconcile of an application owned by another shard", "application", app)
+		return
+	}
+
+	errs := &checkErrors{}
+	ctx = withCheckErrors(ctx, errs)
+	found := false
+	var results []chartResult
+	for _, cluster := range clusters {
+		scopes, err := checkScopes(ctx, cluster, cfg)
+		if err != nil {
+			slog.Error("Error listing check scopes", "cluster", cluster.name, "error", err)
+			continue
+		}
+		for _, scope := range scopes {
+			selector, err := labels.Parse(scope.cfg.AppSelector)
+			if err != nil {
+				continue
+			}
+			for _, namespace := range scope.namespaces(cluster) {
+				obj, err := getApplication(ctx, cluster, namespace, app, cfg)
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				if err != nil {
+					slog.Error("Error getting application", "cluster", cluster.name, "namespace", namespace, "application", app, "error", err)
+					continue
+				}
+				if !selector.Matches(labels.Set(obj.GetLabels())) {
+					continue
+				}
+				found = true
+				results = append(results, checkApplication(ctx, cluster, scope, *obj)...)
+			}
+		}
+	}
+	if !found {
+		slog.Warn("Application to reconcile not found", "application", app)
+		return
+	}
+
+	if err := cfg.Output.output(outputLog).write(os.Stdout, results); err != nil {
+		slog.Error("Error writing results", "error", err)
+	}
+	previous := latestResults.replaceApplication(app, results)
+	recordNewVersions(previous, results)
+	events := statusEvents(previous, results)
+	notifyStatusChanges(ctx, cfg, events)
+	published := eventStream.publish(events, errs.list(), results, app)
+	if cfg.Output.ChangesOnly {
+		logStatusEvents(published)
+	}
+	manageIncidents(cfg.Notifiers, previous, results)
+	if cfg.Reports.Resources {
+		writeReports(ctx, clusters, results)
+	}
+	if cacheDir != "" {
+		persistState(cacheDir)
+	}
+	slog.Debug("Reconciled application", "application", app, "results", len(results))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// configReloader re-reads the config file on SIGHUP or when its content
+// changes, applying it without restarting the process
+type configReloader struct {
+	path      string
+	overrides func(*config)
+	interval  time.Duration
+	lastSum   []byte
+}
+
+func newConfigReloader(path string, overrides func(*config)) *configReloader {
+	r := &configReloader{path: path, overrides: overrides, interval: 10 * time.Second}
+	r.lastSum, _ = r.checksum()
+	return r
+}
+
+func (r *configReloader) checksum() ([]byte, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// run watches for SIGHUP and polls the file until ctx is cancelled; ConfigMap
+// volumes update files through symlink swaps, so comparing content is more
+// reliable than events
+func (r *configReloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading config")
+			r.reload()
+		case <-ticker.C:
+			sum, err := r.checksum()
+			if err != nil || bytes.Equal(sum, r.lastSum) {
+				continue
+			}
+			slog.Info("Config file changed, reloading", "path", r.path)
+			r.reload()
+		}
+	}
+}
+
+// reload applies the config file, keeping the previous settings on error
+func (r *configReloader) reload() {
+	sum, _ := r.checksum()
+	r.lastSum = sum
+
+	cfg, err := loadConfig(r.path)
+	if err != nil {
+		slog.Error("Error reloading config, keeping previous settings", "error", err)
+		return
+	}
+	r.overrides(cfg)
+	if err := cfg.validate(); err != nil {
+		slog.Error("Invalid config, keeping previous settings", "error", err)
+		return
+	}
+	if err := applyConfig(cfg); err != nil {
+		slog.Error("Error applying config, keeping previous settings", "error", err)
+		return
+	}
+	slog.Info("Reloaded config", "path", r.path)
+}
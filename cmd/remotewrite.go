@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var remoteWriteClient = &http.Client{Timeout: 30 * time.Second}
+
+// remoteWriteLabel and remoteWriteSeries mirror the prometheus.WriteRequest
+// protobuf messages of the remote write 1.0 protocol
+type remoteWriteLabel struct {
+	name, value string
+}
+
+type remoteWriteSeries struct {
+	labels []remoteWriteLabel
+	value  float64
+}
+
+// remoteWriteMetrics sends the current gauge values to a remote_write endpoint
+func remoteWriteMetrics(ctx context.Context, cfg remoteWriteConfig) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(helmVersionGauge, provenanceGauge, signatureGauge)
+	families, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+	series := remoteWriteSeriesOf(families, cfg)
+	if len(series) == 0 {
+		return nil
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(series, time.Now().UnixMilli()))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if err := cfg.authorize(req); err != nil {
+		return err
+	}
+	resp, err := tracedDo(remoteWriteClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	slog.Debug("Sent metrics via remote write", "url", cfg.URL, "series", len(series))
+	return nil
+}
+
+// authorize adds bearer or basic credentials to a remote write request
+func (c remoteWriteConfig) authorize(req *http.Request) error {
+	token := c.BearerToken
+	if c.BearerTokenFile != "" {
+		data, err := os.ReadFile(c.BearerTokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if c.BasicAuth.Username != "" {
+		username, password, err := c.BasicAuth.credentials()
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(username, password)
+	}
+	return nil
+}
+
+// remoteWriteSeriesOf flattens gathered gauges into series with sorted labels,
+// adding the job and external labels
+func remoteWriteSeriesOf(families []*dto.MetricFamily, cfg remoteWriteConfig) []remoteWriteSeries {
+	var series []remoteWriteSeries
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if m.GetGauge() == nil {
+				continue
+			}
+			labels := []remoteWriteLabel{{"__name__", family.GetName()}, {"job", cfg.Job}}
+			for name, value := range cfg.ExternalLabels {
+				labels = append(labels, remoteWriteLabel{name, value})
+			}
+			for _, pair := range m.GetLabel() {
+				labels = append(labels, remoteWriteLabel{pair.GetName(), pair.GetValue()})
+			}
+			sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+			series = append(series, remoteWriteSeries{labels: labels, value: m.GetGauge().GetValue()})
+		}
+	}
+	return series
+}
+
+// encodeWriteRequest marshals a WriteRequest with one sample per series
+func encodeWriteRequest(series []remoteWriteSeries, timestamp int64) []byte {
+	var buf []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"helm.sh/helm/v3/pkg/chartutil"
+
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+)
+
+var removedAPIsGauge = metrics.NewExpiringGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_chart_latest_removed_apis",
+		Help: "Manifests of the latest chart version, rendered with the application's values, using APIs removed in the target Kubernetes version",
+	},
+	[]string{"application", "chart", "repo_url", "latest_version", "kube_version", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source"},
+	15*time.Minute,
+)
+
+func init() {
+	prometheus.MustRegister(removedAPIsGauge)
+}
+
+// removedAPIVersions lists the Kubernetes APIs removed from a minor version
+// on, by apiVersion and kind; an empty kind stands for every kind of the
+// group version
+var removedAPIVersions = []struct {
+	apiVersion, kind, removedIn string
+}{
+	{"extensions/v1beta1", "Deployment", "1.16"},
+	{"extensions/v1beta1", "DaemonSet", "1.16"},
+	{"extensions/v1beta1", "ReplicaSet", "1.16"},
+	{"extensions/v1beta1", "NetworkPolicy", "1.16"},
+	{"extensions/v1beta1", "PodSecurityPolicy", "1.16"},
+	{"apps/v1beta1", "", "1.16"},
+	{"apps/v1beta2", "", "1.16"},
+	{"extensions/v1beta1", "Ingress", "1.22"},
+	{"networking.k8s.io/v1beta1", "", "1.22"},
+	{"admissionregistration.k8s.io/v1beta1", "", "1.22"},
+	{"apiextensions.k8s.io/v1beta1", "", "1.22"},
+	{"apiregistration.k8s.io/v1beta1", "", "1.22"},
+	{"authentication.k8s.io/v1beta1", "", "1.22"},
+	{"authorization.k8s.io/v1beta1", "", "1.22"},
+	{"certificates.k8s.io/v1beta1", "", "1.22"},
+	{"coordination.k8s.io/v1beta1", "", "1.22"},
+	{"rbac.authorization.k8s.io/v1beta1", "", "1.22"},
+	{"scheduling.k8s.io/v1beta1", "", "1.22"},
+	{"storage.k8s.io/v1beta1", "CSIDriver", "1.22"},
+	{"storage.k8s.io/v1beta1", "CSINode", "1.22"},
+	{"storage.k8s.io/v1beta1", "StorageClass", "1.22"},
+	{"storage.k8s.io/v1beta1", "VolumeAttachment", "1.22"},
+	{"batch/v1beta1", "", "1.25"},
+	{"discovery.k8s.io/v1beta1", "", "1.25"},
+	{"events.k8s.io/v1beta1", "", "1.25"},
+	{"autoscaling/v2beta1", "", "1.25"},
+	{"policy/v1beta1", "", "1.25"},
+	{"node.k8s.io/v1beta1", "", "1.25"},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", "", "1.26"},
+	{"autoscaling/v2beta2", "", "1.26"},
+	{"storage.k8s.io/v1beta1", "CSIStorageCapacity", "1.27"},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "", "1.29"},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "", "1.32"},
+}
+
+// removedAPI is a manifest of a chart using an API removed in the target
+// Kubernetes version
+type removedAPI struct {
+	Template   string `json:"template"`
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name,omitempty"`
+	RemovedIn  string `json:"removedIn"`
+}
+
+// removedAPIsCheck is the outcome of rendering the latest version of a chart
+// for a Kubernetes version
+type removedAPIsCheck struct {
+	KubeVersion string       `json:"kubeVersion"`
+	Manifests   []removedAPI `json:"manifests"`
+}
+
+// removedAPIsCache memoizes checks; chart versions are immutable so entries
+// only depend on the version, the values and the Kubernetes version
+var removedAPIsCache = struct {
+	sync.Mutex
+	checks map[string][]removedAPI
+}{checks: map[string][]removedAPI{}}
+
+// removedIn returns the version an API was removed in when it is removed in
+// kubeVersion
+func removedIn(apiVersion, kind string, kubeVersion *semver.Version) (string, bool) {
+	for _, api := range removedAPIVersions {
+		if api.apiVersion != apiVersion || (api.kind != "" && api.kind != kind) {
+			continue
+		}
+		removed := semver.MustParse(api.removedIn)
+		if kubeVersion.Major() > removed.Major() || (kubeVersion.Major() == removed.Major() && kubeVersion.Minor() >= removed.Minor()) {
+			return api.removedIn, true
+		}
+	}
+	return "", false
+}
+
+// targetKubeVersion returns the Kubernetes version the latest versions of
+// charts are rendered for: the configured one, or else that of the cluster
+func targetKubeVersion(cfg removedAPIsConfig, cluster clusterClient) string {
+	if cfg.KubeVersion != "" {
+		return cfg.KubeVersion
+	}
+	return cluster.kubeVersion
+}
+
+// checkRemovedAPIs renders the latest version of an outdated chart with the
+// Application's values for the target Kubernetes version and returns the
+// manifests using APIs removed in it, nil when it cannot tell
+func checkRemovedAPIs(ctx context.Context, appName, namespace string, source map[string]interface{}, result chartResult) *removedAPIsCheck {
+	cfg := contextConfig(ctx).RemovedAPIs
+	target := targetKubeVersion(cfg, contextCluster(ctx))
+	if target == "" {
+		slog.Debug("Unknown Kubernetes version, not checking removed APIs", "application", appName)
+		return nil
+	}
+	kubeVersion, err := semver.NewVersion(target)
+	if err != nil {
+		slog.Warn("Invalid Kubernetes version, not checking removed APIs", "kube_version", target, "error", err)
+		return nil
+	}
+	vals, err := applicationValues(source)
+	if err != nil {
+		slog.Warn("Error reading Helm values", "application", appName, "error", err)
+		return nil
+	}
+	releaseName, namespace := releaseOptions(appName, namespace, source)
+	manifests, err := renderRemovedAPIs(ctx, result.RepoURL, result.Chart, result.LatestVersion, releaseName, namespace, vals, kubeVersion)
+	if err != nil {
+		slog.Warn("Error checking the latest version for removed APIs", "application", appName, "chart", result.Chart, "version", result.LatestVersion, "error", err)
+		return nil
+	}
+	if len(manifests) > 0 {
+		slog.Warn("Latest version uses removed Kubernetes APIs", "application", appName, "chart", result.Chart, "version", result.LatestVersion, "kube_version", target, "manifests", len(manifests))
+	}
+	return &removedAPIsCheck{KubeVersion: target, Manifests: manifests}
+}
+
+// renderRemovedAPIs templates a chart version with the capabilities of
+// kubeVersion and returns the manifests using APIs removed in it
+func renderRemovedAPIs(ctx context.Context, repoURL, chartName, version, releaseName, namespace string, vals map[string]interface{}, kubeVersion *semver.Version) ([]removedAPI, error) {
+	encoded, err := json.Marshal(vals)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(encoded)
+	key := strings.Join([]string{repoURL, chartName, version, releaseName, namespace, kubeVersion.String(), hex.EncodeToString(sum[:])}, "|")
+	removedAPIsCache.Lock()
+	cached, ok := removedAPIsCache.checks[key]
+	removedAPIsCache.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	archive, err := downloadChartArchive(ctx, repoURL, chartName, version)
+	if err != nil {
+		return nil, err
+	}
+	caps := *chartutil.DefaultCapabilities
+	caps.KubeVersion = chartutil.KubeVersion{
+		Version: "v" + kubeVersion.String(),
+		Major:   fmt.Sprint(kubeVersion.Major()),
+		Minor:   fmt.Sprint(kubeVersion.Minor()),
+	}
+	manifests := []removedAPI{}
+	err = renderManifests(archive, releaseName, namespace, vals, &caps, func(template string, doc interface{}) {
+		m, ok := doc.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		apiVersion, _ := m["apiVersion"].(string)
+		kind, _ := m["kind"].(string)
+		removed, ok := removedIn(apiVersion, kind, kubeVersion)
+		if !ok {
+			return
+		}
+		api := removedAPI{Template: template, APIVersion: apiVersion, Kind: kind, RemovedIn: removed}
+		if meta, ok := m["metadata"].(map[interface{}]interface{}); ok {
+			api.Name, _ = meta["name"].(string)
+		}
+		manifests = append(manifests, api)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rendering %s %s: %w", chartName, version, err)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		if manifests[i].Template != manifests[j].Template {
+			return manifests[i].Template < manifests[j].Template
+		}
+		return manifests[i].Name < manifests[j].Name
+	})
+
+	removedAPIsCache.Lock()
+	removedAPIsCache.checks[key] = manifests
+	removedAPIsCache.Unlock()
+	return manifests, nil
+}
+
+// recordRemovedAPIs sets the number of manifests of the latest version of a
+// chart using removed APIs
+func recordRemovedAPIs(r chartResult) {
+	c := r.RemovedAPIs
+	if c == nil {
+		return
+	}
+	removedAPIsGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.LatestVersion, c.KubeVersion, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source).Set(float64(len(c.Manifests)))
+}
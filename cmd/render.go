@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// imageChange is a container image whose tag or digest differs between chart versions
+type imageChange struct {
+	Image string `json:"image"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// imageDiff lists container image changes between two rendered chart versions
+type imageDiff struct {
+	Added   []string      `json:"added"`
+	Removed []string      `json:"removed"`
+	Changed []imageChange `json:"changed"`
+}
+
+// renderedImageCache memoizes image diffs; chart versions are immutable so
+// entries only depend on the versions and the values used to render them
+var renderedImageCache = struct {
+	sync.Mutex
+	diffs map[string]*imageDiff
+}{diffs: make(map[string]*imageDiff)}
+
+// applicationValues builds the values an Application passes to its chart
+// from spec.source.helm, applying Argo CD's precedence of parameters over
+// valuesObject over values. Value files are not resolved.
+func applicationValues(source map[string]interface{}) (map[string]interface{}, error) {
+	vals := map[string]interface{}{}
+	helm, _ := source["helm"].(map[string]interface{})
+	if helm == nil {
+		return vals, nil
+	}
+	if raw, ok := helm["values"].(string); ok && raw != "" {
+		parsed, err := chartutil.ReadValues([]byte(raw))
+		if err != nil {
+			return nil, fmt.Errorf("parsing helm.values: %w", err)
+		}
+		vals = parsed
+	}
+	if obj, ok := helm["valuesObject"].(map[string]interface{}); ok {
+		vals = chartutil.CoalesceTables(obj, vals)
+	}
+	if params, ok := helm["parameters"].([]interface{}); ok {
+		for _, p := range params {
+			param, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := param["name"].(string)
+			value, _ := param["value"].(string)
+			if name == "" {
+				continue
+			}
+			var err error
+			if force, _ := param["forceString"].(bool); force {
+				err = strvals.ParseIntoString(name+"="+value, vals)
+			} else {
+				err = strvals.ParseInto(name+"="+value, vals)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parsing helm parameter %s: %w", name, err)
+			}
+		}
+	}
+	return vals, nil
+}
+
+// checkImageChanges renders the current and latest version of an outdated
+// chart with the Application's values and returns the image changes
+func checkImageChanges(ctx context.Context, appName, namespace string, source map[string]interface{}, result chartResult) *imageDiff {
+	vals, err := applicationValues(source)
+	if err != nil {
+		slog.Warn("Error reading Helm values", "application", appName, "error", err)
+		return nil
+	}
+	releaseName, namespace := releaseOptions(appName, namespace, source)
+	diff, err := diffRenderedImages(ctx, result.RepoURL, result.Chart, result.CurrentVersion, result.LatestVersion, releaseName, namespace, vals)
+	if err != nil {
+		slog.Warn("Error comparing rendered images", "application", appName, "error", err)
+		return nil
+	}
+	return diff
+}
+
+// releaseOptions returns the release name of an Application source, the
+// Application name unless helm.releaseName is set, and its namespace
+func releaseOptions(appName, namespace string, source map[string]interface{}) (string, string) {
+	releaseName := appName
+	if helm, ok := source["helm"].(map[string]interface{}); ok {
+		if name, ok := helm["releaseName"].(string); ok && name != "" {
+			releaseName = name
+		}
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	return releaseName, namespace
+}
+
+// diffRenderedImages templates two versions of a chart with the same values
+// and compares the container images referenced by the rendered manifests
+func diffRenderedImages(ctx context.Context, repoURL, chartName, from, to, releaseName, namespace string, vals map[string]interface{}) (*imageDiff, error) {
+	encoded, err := json.Marshal(vals)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(encoded)
+	key := strings.Join([]string{repoURL, chartName, from, to, releaseName, namespace, hex.EncodeToString(sum[:])}, "|")
+	renderedImageCache.Lock()
+	cached, ok := renderedImageCache.diffs[key]
+	renderedImageCache.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	images := make([]map[string]string, 2)
+	for i, version := range []string{from, to} {
+		archive, err := downloadChartArchive(ctx, repoURL, chartName, version)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := renderImages(archive, releaseName, namespace, vals)
+		if err != nil {
+			return nil, fmt.Errorf("rendering %s %s: %w", chartName, version, err)
+		}
+		images[i] = make(map[string]string)
+		for _, ref := range refs {
+			name, tag := splitImageReference(ref)
+			images[i][name] = tag
+		}
+		slog.Debug("Rendered chart", "chart", chartName, "version", version, "images", refs)
+	}
+
+	diff := &imageDiff{Added: []string{}, Removed: []string{}, Changed: []imageChange{}}
+	for name, oldVersion := range images[0] {
+		newVersion, ok := images[1][name]
+		if !ok {
+			diff.Removed = append(diff.Removed, joinImageReference(name, oldVersion))
+		} else if newVersion != oldVersion {
+			diff.Changed = append(diff.Changed, imageChange{Image: name, From: oldVersion, To: newVersion})
+		}
+	}
+	for name, newVersion := range images[1] {
+		if _, ok := images[0][name]; !ok {
+			diff.Added = append(diff.Added, joinImageReference(name, newVersion))
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Image < diff.Changed[j].Image })
+
+	renderedImageCache.Lock()
+	renderedImageCache.diffs[key] = diff
+	renderedImageCache.Unlock()
+	return diff, nil
+}
+
+// renderImages templates a packaged chart and returns the distinct container
+// images of all pod specs in the output
+func renderImages(archive []byte, releaseName, namespace string, vals map[string]interface{}) ([]string, error) {
+	seen := make(map[string]bool)
+	err := renderManifests(archive, releaseName, namespace, vals, chartutil.DefaultCapabilities, func(_ string, doc interface{}) {
+		collectImages(doc, seen)
+	})
+	if err != nil {
+		return nil, err
+	}
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// renderManifests templates a packaged chart with the capabilities of a
+// cluster and calls fn with every document of the YAML templates rendered
+func renderManifests(archive []byte, releaseName, namespace string, vals map[string]interface{}, caps *chartutil.Capabilities, fn func(template string, doc interface{})) error {
+	chrt, err := loader.LoadArchive(bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	options := chartutil.ReleaseOptions{Name: releaseName, Namespace: namespace, Revision: 1, IsInstall: true}
+	renderValues, err := chartutil.ToRenderValues(chrt, vals, options, caps)
+	if err != nil {
+		return err
+	}
+	manifests, err := engine.Render(chrt, renderValues)
+	if err != nil {
+		return err
+	}
+
+	for name, content := range manifests {
+		if ext := path.Ext(name); ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		dec := yaml.NewDecoder(strings.NewReader(content))
+		for {
+			var doc interface{}
+			if err := dec.Decode(&doc); err != nil {
+				if err != io.EOF {
+					slog.Debug("Skipping unparseable manifest", "template", name, "error", err)
+				}
+				break
+			}
+			fn(name, doc)
+		}
+	}
+	return nil
+}
+
+// collectImages walks a manifest and records the image of every entry in a
+// containers, initContainers or ephemeralContainers list
+func collectImages(node interface{}, seen map[string]bool) {
+	switch n := node.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range n {
+			switch k {
+			case "containers", "initContainers", "ephemeralContainers":
+				if list, ok := v.([]interface{}); ok {
+					for _, c := range list {
+						if container, ok := c.(map[interface{}]interface{}); ok {
+							if image, ok := container["image"].(string); ok && image != "" {
+								seen[image] = true
+							}
+						}
+					}
+				}
+			}
+			collectImages(v, seen)
+		}
+	case []interface{}:
+		for _, v := range n {
+			collectImages(v, seen)
+		}
+	}
+}
+
+// splitImageReference splits an image into its name and its tag or digest
+func splitImageReference(ref string) (string, string) {
+	if name, digest, ok := strings.Cut(ref, "@"); ok {
+		return name, "@" + digest
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// joinImageReference reverses splitImageReference
+func joinImageReference(name, version string) string {
+	if strings.HasPrefix(version, "@") {
+		return name + version
+	}
+	return name + ":" + version
+}
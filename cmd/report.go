@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// chartResult is the outcome of checking one Helm source of an application
+type chartResult struct {
+	Cluster                string                  `json:"cluster,omitempty"`
+	Instance               string                  `json:"instance,omitempty"`
+	Check                  string                  `json:"check,omitempty"`
+	Application            string                  `json:"application"`
+	Namespace              string                  `json:"namespace,omitempty"`
+	Project                string                  `json:"project,omitempty"`
+	File                   string                  `json:"file,omitempty"`
+	Line                   int                     `json:"line,omitempty"`
+	Labels                 map[string]string       `json:"labels,omitempty"`
+	DestinationCluster     string                  `json:"destinationCluster,omitempty"`
+	DestinationNamespace   string                  `json:"destinationNamespace,omitempty"`
+	Source                 string                  `json:"source,omitempty"`
+	Chart                  string                  `json:"chart"`
+	RepoURL                string                  `json:"repoURL"`
+	CurrentVersion         string                  `json:"currentVersion"`
+	TargetRevision         string                  `json:"targetRevision,omitempty"`
+	LatestVersion          string                  `json:"latestVersion"`
+	UpToDate               bool                    `json:"upToDate"`
+	Ahead                  bool                    `json:"ahead,omitempty"`
+	Deprecated             bool                    `json:"deprecated,omitempty"`
+	Behind                 *check.Delta            `json:"behind,omitempty"`
+	Missing                bool                    `json:"missing,omitempty"`
+	ProvenanceVerified     *bool                   `json:"provenanceVerified,omitempty"`
+	NewestPublishedVersion string                  `json:"newestPublishedVersion,omitempty"`
+	SignatureVerified      *bool                   `json:"signatureVerified,omitempty"`
+	ArtifactHub            *artifactHubPackage     `json:"artifactHub,omitempty"`
+	VendoredChart          *vendoredChart          `json:"vendoredChart,omitempty"`
+	Kustomization          string                  `json:"kustomization,omitempty"`
+	Changes                []chartChange           `json:"changes,omitempty"`
+	Links                  []string                `json:"links,omitempty"`
+	ImageChanges           *imageDiff              `json:"imageChanges,omitempty"`
+	Scan                   *repository.ScanSummary `json:"scan,omitempty"`
+	Update                 *applicationUpdate      `json:"update,omitempty"`
+	Approval               *approvalStatus         `json:"approval,omitempty"`
+	OutdatedSince          *time.Time              `json:"outdatedSince,omitempty"`
+	LatestReleased         *time.Time              `json:"latestReleased,omitempty"`
+	Stale                  bool                    `json:"stale,omitempty"`
+	ImageTags              []imageTagStatus        `json:"imageTags,omitempty"`
+	RemovedAPIs            *removedAPIsCheck       `json:"removedAPIs,omitempty"`
+	// CarriedOver is the error of a check that failed, the rest of the result
+	// being that of the last successful check
+	CarriedOver string `json:"carriedOver,omitempty"`
+}
+
+// outdated reports whether a newer version than the deployed one is available
+func (r chartResult) outdated() bool {
+	return !r.UpToDate && !r.Ahead
+}
+
+// resultStore holds the results of the most recently completed cycle
+type resultStore struct {
+	mu          sync.RWMutex
+	generatedAt time.Time
+	results     []chartResult
+}
+
+var latestResults = &resultStore{}
+
+// swap replaces the stored results with those of a completed cycle and
+// returns the previous results, or false if there were none
+func (s *resultStore) swap(results []chartResult) ([]chartResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, ok := s.results, !s.generatedAt.IsZero()
+	s.generatedAt = time.Now()
+	s.results = results
+	return previous, ok
+}
+
+// restore sets results persisted by a previous process
+func (s *resultStore) restore(results []chartResult, generatedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generatedAt = generatedAt
+	s.results = results
+}
+
+// replaceApplication replaces the results of an application checked outside
+// a cycle and returns its previous results
+func (s *resultStore) replaceApplication(app string, results []chartResult) []chartResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var previous, kept []chartResult
+	for _, r := range s.results {
+		if r.Application == app {
+			previous = append(previous, r)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	s.results = append(kept, results...)
+	return previous
+}
+
+// applications returns the names of the applications whose latest results
+// use chart from repoURL, or any chart from it when chart is empty
+func (s *resultStore) applications(repoURL, chart string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	repoURL = strings.TrimSuffix(repoURL, "/")
+	seen := make(map[string]bool)
+	var apps []string
+	for _, r := range s.results {
+		if strings.TrimSuffix(r.RepoURL, "/") != repoURL || (chart != "" && r.Chart != chart) || seen[r.Application] {
+			continue
+		}
+		seen[r.Application] = true
+		apps = append(apps, r.Application)
+	}
+	return apps
+}
+
+// previous returns the latest result of a chart of an application in a
+// cluster
+func (s *resultStore) previous(cluster, app, chart, repoURL string) (chartResult, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	repoURL = strings.TrimSuffix(repoURL, "/")
+	for _, r := range s.results {
+		if r.Cluster == cluster && r.Application == app && r.Chart == chart && strings.TrimSuffix(r.RepoURL, "/") == repoURL {
+			return r, true
+		}
+	}
+	return chartResult{}, false
+}
+
+// find returns the latest result for an application, optionally narrowed to
+// a cluster and chart
+func (s *resultStore) find(cluster, app, chart string) (chartResult, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.results {
+		if r.Application == app && (cluster == "" || r.Cluster == cluster) && (chart == "" || r.Chart == chart) {
+			return r, true
+		}
+	}
+	return chartResult{}, false
+}
+
+// ServeHTTP writes the latest results as a JSON report
+func (s *resultStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.writeJSON(w); err != nil {
+		slog.Error("Error writing report", "error", err)
+	}
+}
+
+// writeJSON encodes a report of the stored results
+func (s *resultStore) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.report())
+}
+
+// marshal encodes the stored results compactly for persistence
+func (s *resultStore) marshal() ([]byte, error) {
+	return json.Marshal(s.report())
+}
+
+type report struct {
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Results     []chartResult `json:"results"`
+}
+
+func (s *resultStore) report() report {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := report{s.generatedAt, s.results}
+	if r.Results == nil {
+		r.Results = []chartResult{}
+	}
+	return r
+}
+
+// logResult records a result as a structured log entry at level
+func logResult(level slog.Level, r chartResult) {
+	attrs := []any{
+		"cluster", r.Cluster,
+		"check", r.Check,
+		"application", r.Application,
+		"destination_cluster", r.DestinationCluster,
+		"destination_namespace", r.DestinationNamespace,
+		"source", r.Source,
+		"chart", r.Chart,
+		"repo_url", r.RepoURL,
+		"current_version", r.CurrentVersion,
+		"latest_version", r.LatestVersion,
+		"up_to_date", r.UpToDate,
+		"ahead", r.Ahead,
+	}
+	if r.Instance != "" {
+		attrs = append(attrs, "instance", r.Instance)
+	}
+	if r.CarriedOver != "" {
+		attrs = append(attrs, "carried_over", r.CarriedOver)
+	}
+	if r.ProvenanceVerified != nil {
+		attrs = append(attrs, "provenance_verified", *r.ProvenanceVerified)
+	}
+	if r.SignatureVerified != nil {
+		attrs = append(attrs, "newest_published_version", r.NewestPublishedVersion, "signature_verified", *r.SignatureVerified)
+	}
+	if r.Stale {
+		attrs = append(attrs, "stale", r.Stale, "latest_released", r.LatestReleased.Format(time.RFC3339))
+	}
+	if a := r.Approval; a != nil {
+		attrs = append(attrs, "approved", a.Approved, "latest_published_version", a.LatestPublished)
+	}
+	if ah := r.ArtifactHub; ah != nil {
+		attrs = append(attrs, "artifacthub_url", ah.URL, "security_report", formatSecurityReport(ah.SecurityReport))
+	}
+	if len(r.Changes) > 0 {
+		attrs = append(attrs, "changes", len(r.Changes))
+	}
+	if c := r.RemovedAPIs; c != nil && len(c.Manifests) > 0 {
+		attrs = append(attrs, "removed_apis", len(c.Manifests), "kube_version", c.KubeVersion)
+	}
+	if d := r.ImageChanges; d != nil {
+		attrs = append(attrs, "images_changed", len(d.Changed), "images_added", len(d.Added), "images_removed", len(d.Removed))
+	}
+	slog.Log(context.Background(), level, "Checked chart", attrs...)
+}
+
+// writeTextResult writes a human readable block for a result
+func writeTextResult(w io.Writer, r chartResult) {
+	fmt.Fprintf(w, "Application: %s\n", r.Application)
+	if r.Cluster != "" {
+		fmt.Fprintf(w, "  Cluster: %s\n", r.Cluster)
+	}
+	if r.Instance != "" {
+		fmt.Fprintf(w, "  Instance: %s\n", r.Instance)
+	}
+	if r.Check != "" {
+		fmt.Fprintf(w, "  Check: %s\n", r.Check)
+	}
+	if r.File != "" {
+		fmt.Fprintf(w, "  File: %s:%d\n", r.File, r.Line)
+	}
+	if r.DestinationCluster != "" || r.DestinationNamespace != "" {
+		fmt.Fprintf(w, "  Destination: %s %s\n", r.DestinationCluster, r.DestinationNamespace)
+	}
+	if r.Source != "" {
+		fmt.Fprintf(w, "  Source: %s\n", r.Source)
+	}
+	fmt.Fprintf(w, "  Chart Name: %s\n", r.Chart)
+	if r.CarriedOver != "" {
+		fmt.Fprintf(w, "  Carried Over: %s\n", r.CarriedOver)
+	}
+	if v := r.VendoredChart; v != nil && r.RepoURL == v.RepoURL {
+		fmt.Fprintf(w, "  Vendored At: %s\n", v.Path)
+	} else if v != nil {
+		fmt.Fprintf(w, "  Dependency Of: %s %s (%s %s)\n", v.Name, v.Version, v.RepoURL, v.Path)
+	}
+	if r.Kustomization != "" {
+		fmt.Fprintf(w, "  Kustomization: %s\n", r.Kustomization)
+	}
+	fmt.Fprintf(w, "  Repository URL: %s\n", r.RepoURL)
+	fmt.Fprintf(w, "  Current Version: %s\n", r.CurrentVersion)
+	if r.TargetRevision != "" {
+		fmt.Fprintf(w, "  Target Revision: %s\n", r.TargetRevision)
+	}
+	fmt.Fprintf(w, "  Latest Version: %s\n", r.LatestVersion)
+	fmt.Fprintf(w, "  Up-to-date: %v\n", r.UpToDate)
+	if r.Ahead {
+		fmt.Fprintf(w, "  Ahead: %v\n", r.Ahead)
+	}
+	if r.Deprecated {
+		fmt.Fprintf(w, "  Deprecated: %v\n", r.Deprecated)
+	}
+	if r.LatestReleased != nil {
+		fmt.Fprintf(w, "  Latest Released: %s\n", r.LatestReleased.Format(time.RFC3339))
+	}
+	if r.Stale {
+		fmt.Fprintf(w, "  Stale: %v\n", r.Stale)
+	}
+	if r.Missing {
+		fmt.Fprintf(w, "  Missing From Repository: %v\n", r.Missing)
+	}
+	if d := r.Behind; d != nil && r.outdated() {
+		fmt.Fprintf(w, "  Behind: %d major, %d minor, %d patch\n", d.Major, d.Minor, d.Patch)
+	}
+	if r.OutdatedSince != nil {
+		fmt.Fprintf(w, "  Outdated Since: %s\n", r.OutdatedSince.Format(time.RFC3339))
+	}
+	if u := r.Update; u != nil {
+		fmt.Fprintf(w, "  Pending Update (dry run): %s\n", u.Patch)
+	}
+	if a := r.Approval; a != nil {
+		fmt.Fprintf(w, "  Approved: %v\n", a.Approved)
+		fmt.Fprintf(w, "  Latest Published Version: %s\n", a.LatestPublished)
+	}
+	if r.ProvenanceVerified != nil {
+		fmt.Fprintf(w, "  Provenance Verified: %v\n", *r.ProvenanceVerified)
+	}
+	if r.SignatureVerified != nil {
+		fmt.Fprintf(w, "  Newest Published Version: %s\n", r.NewestPublishedVersion)
+		fmt.Fprintf(w, "  Signature Verified: %v\n", *r.SignatureVerified)
+	}
+	if ah := r.ArtifactHub; ah != nil {
+		fmt.Fprintf(w, "  ArtifactHub: %s\n", ah.URL)
+		fmt.Fprintf(w, "  Verified Publisher: %v\n", ah.VerifiedPublisher)
+		fmt.Fprintf(w, "  Official: %v\n", ah.Official)
+		fmt.Fprintf(w, "  Security Report: %s\n", formatSecurityReport(ah.SecurityReport))
+	}
+	if s := r.Scan; s != nil {
+		fmt.Fprintf(w, "  Scan: %s, severity %s, %d vulnerabilities (%d fixable)\n", s.Status, s.Severity, s.Total, s.Fixable)
+	}
+	for _, c := range r.Changes {
+		if c.Kind != "" {
+			fmt.Fprintf(w, "  Change (%s): %s\n", c.Kind, c.Description)
+		} else {
+			fmt.Fprintf(w, "  Change: %s\n", c.Description)
+		}
+	}
+	for _, link := range r.Links {
+		fmt.Fprintf(w, "  Link: %s\n", link)
+	}
+	for _, t := range r.ImageTags {
+		fmt.Fprintf(w, "  Image Tag: %s %s:%s (latest %s)\n", t.Path, t.Image, t.Tag, t.LatestTag)
+	}
+	if c := r.RemovedAPIs; c != nil {
+		for _, m := range c.Manifests {
+			fmt.Fprintf(w, "  Removed API: %s %s %s/%s (removed in %s, target %s)\n", m.Template, m.Kind, m.APIVersion, m.Name, m.RemovedIn, c.KubeVersion)
+		}
+	}
+	if d := r.ImageChanges; d != nil {
+		for _, c := range d.Changed {
+			fmt.Fprintf(w, "  Image Changed: %s %s -> %s\n", c.Image, c.From, c.To)
+		}
+		for _, image := range d.Added {
+			fmt.Fprintf(w, "  Image Added: %s\n", image)
+		}
+		for _, image := range d.Removed {
+			fmt.Fprintf(w, "  Image Removed: %s\n", image)
+		}
+	}
+	fmt.Fprintln(w, "---")
+}
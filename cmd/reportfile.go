@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// reportFilePrefix and reportFileLayout name report files after the time
+	// of their cycle, such as helm-version-report-20240102T150405Z.json
+	reportFilePrefix = "helm-version-report-"
+	reportFileLayout = "20060102T150405Z"
+)
+
+// writeReportFile writes the results of a cycle to a timestamped JSON file in
+// the report directory and removes the files older than the retention
+func writeReportFile(cfg reportDirectoryConfig, results []chartResult, errs []checkError, now time.Time) error {
+	now = now.UTC().Truncate(time.Second)
+	data, err := marshalFileReport(results, errs, now)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(cfg.Path, reportFileName(now))
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	slog.Debug("Wrote report file", "path", path, "results", len(results))
+	if cfg.Retention > 0 {
+		return pruneReportFiles(cfg.Path, now.Add(-cfg.Retention))
+	}
+	return nil
+}
+
+// fileReport is the content of a report file: the report ConfigMap along with
+// the charts that could not be checked
+type fileReport struct {
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Summary     reportSummary `json:"summary"`
+	Results     []chartResult `json:"results"`
+	Errors      []checkError  `json:"errors,omitempty"`
+}
+
+// marshalFileReport returns the report file of a cycle completed at now
+func marshalFileReport(results []chartResult, errs []checkError, now time.Time) ([]byte, error) {
+	report := fileReport{GeneratedAt: now, Results: results, Errors: errs}
+	if report.Results == nil {
+		report.Results = []chartResult{}
+	}
+	for _, r := range results {
+		report.Summary.add(r)
+	}
+	return json.MarshalIndent(report, "", "  ")
+}
+
+// reportFileName names the report file of a cycle completed at now
+func reportFileName(now time.Time) string {
+	return reportFilePrefix + now.UTC().Format(reportFileLayout) + ".json"
+}
+
+// pruneReportFiles removes the report files in dir of cycles before cutoff,
+// leaving other files alone
+func pruneReportFiles(dir string, cutoff time.Time) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutPrefix(e.Name(), reportFilePrefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		at, err := time.Parse(reportFileLayout, strings.TrimSuffix(name, ".json"))
+		if err != nil || !at.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("removing expired report: %w", err)
+		}
+		slog.Debug("Removed expired report file", "file", e.Name())
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"sigs.k8s.io/yaml"
+)
+
+// rulesOptions tune the alerts of the rules command
+type rulesOptions struct {
+	name      string
+	namespace string
+	labels    map[string]string
+	plain     bool
+	// outdatedFor is how long a chart is outdated before a warning
+	outdatedFor time.Duration
+	// criticalAfter raises outdated charts to critical; zero disables it
+	criticalAfter time.Duration
+	// outdatedRatio alerts when this fraction of charts is outdated; zero
+	// disables it
+	outdatedRatio float64
+	// indexStaleAfter is the age of an index.yaml telling a dead mirror from
+	// a quiet repository; zero disables it
+	indexStaleAfter time.Duration
+}
+
+type prometheusRule struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Metadata   prometheusMetadata `json:"metadata"`
+	Spec       prometheusRules    `json:"spec"`
+}
+
+type prometheusMetadata struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type prometheusRules struct {
+	Groups []ruleGroup `json:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string      `json:"name"`
+	Rules []alertRule `json:"rules"`
+}
+
+type alertRule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// alertRules returns the alerts for the metrics exported with cfg. Pending
+// periods and windows scale with the check interval, summaries name the
+// cluster when several are checked, and the stalled cycle alert is left out
+// when checks are driven by scrapes.
+func alertRules(cfg *config, opts rulesOptions) []alertRule {
+	// A failed check is retried next cycle, so wait for a few of them
+	settle := max(3*cfg.Interval, 15*time.Minute)
+	app := "{{ $labels.application }}"
+	if len(cfg.Clusters) > 0 {
+		app = "{{ $labels.cluster }}/" + app
+	}
+	outdated := "Application " + app + " deploys chart {{ $labels.chart }} {{ $labels.current_version }}, {{ $labels.latest_version }} is available in {{ $labels.repo_url }}."
+
+	rules := []alertRule{{
+		Alert:       "HelmChartOutdated",
+		Expr:        "helm_chart_version_status == 0",
+		For:         promDuration(opts.outdatedFor),
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Helm chart is outdated", "description": outdated},
+	}, {
+		Alert:  "HelmChartMajorBehind",
+		Expr:   `helm_chart_versions_behind{level="major"} > 0`,
+		For:    promDuration(opts.outdatedFor),
+		Labels: map[string]string{"severity": "critical"},
+		Annotations: map[string]string{
+			"summary":     "Helm chart is a major version behind",
+			"description": "Application " + app + " deploys chart {{ $labels.chart }} from {{ $labels.repo_url }} {{ $value }} major versions behind the latest.",
+		},
+	}}
+	rules = append(rules, alertRule{
+		Alert:  "HelmChartVersionMissing",
+		Expr:   "helm_chart_version_missing == 1",
+		For:    promDuration(settle),
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "Deployed Helm chart version was removed from its repository",
+			"description": "Application " + app + " deploys chart {{ $labels.chart }} {{ $labels.current_version }}, which {{ $labels.repo_url }} no longer publishes, so it cannot be deployed again as is.",
+		},
+	})
+	if opts.criticalAfter > 0 {
+		rules = append(rules, alertRule{
+			Alert: "HelmChartOutdatedCritical",
+			// Upgrading changes current_version, which starts a new series
+			Expr:        fmt.Sprintf("max_over_time(helm_chart_version_status[%s]) == 0 and helm_chart_version_status offset %s", promDuration(opts.criticalAfter), promDuration(opts.criticalAfter)),
+			Labels:      map[string]string{"severity": "critical"},
+			Annotations: map[string]string{"summary": "Helm chart has been outdated for " + promDuration(opts.criticalAfter), "description": outdated},
+		})
+	}
+	if opts.outdatedRatio > 0 {
+		rules = append(rules, alertRule{
+			Alert:  "HelmChartsMostlyOutdated",
+			Expr:   fmt.Sprintf("sum(helm_chart_version_status == bool 0) / count(helm_chart_version_status) > %g", opts.outdatedRatio),
+			For:    promDuration(opts.outdatedFor),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("More than %g%% of Helm charts are outdated", opts.outdatedRatio*100),
+				"description": "{{ $value | humanizePercentage }} of the checked Helm charts are outdated.",
+			},
+		})
+	}
+	if cfg.SLO.Level != "" {
+		rules = append(rules, alertRule{
+			Alert:  "HelmChartsSLOBreached",
+			Expr:   `helm_charts_slo_ratio < on() group_left() helm_charts_slo_target`,
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Helm chart version SLO is not met",
+				"description": fmt.Sprintf("Only {{ $value | humanizePercentage }} of the charts{{ with $labels.label }} of {{ . }} {{ $labels.value }}{{ end }} are within %d %s versions of the latest over %s.", cfg.SLO.Versions, cfg.SLO.Level, promDuration(cfg.SLO.Window)),
+			},
+		})
+	}
+	rules = append(rules,
+		alertRule{
+			Alert:  "HelmRepositoryDown",
+			Expr:   "helm_repo_up == 0",
+			For:    promDuration(settle),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Helm repository is unreachable",
+				"description": "Requests to {{ $labels.repo_url }} have failed for " + promDuration(settle) + ", so its charts are not checked.",
+			},
+		},
+		alertRule{
+			Alert:  "HelmRepositoryStale",
+			Expr:   fmt.Sprintf("time() - helm_repo_last_success_timestamp_seconds > %d", int(max(24*time.Hour, 10*cfg.Interval).Seconds())),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Helm repository has not answered for a long time",
+				"description": "The last successful request to {{ $labels.repo_url }} was {{ $value | humanizeDuration }} ago.",
+			},
+		},
+		alertRule{
+			Alert:  "HelmRepositoryCircuitOpen",
+			Expr:   "helm_repository_circuit_open == 1",
+			For:    promDuration(settle),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Requests to a repository host are skipped",
+				"description": "The circuit breaker for {{ $labels.host }} has been open for " + promDuration(settle) + " after repeated failures.",
+			},
+		},
+	)
+	if opts.indexStaleAfter > 0 {
+		rules = append(rules, alertRule{
+			Alert:  "HelmRepositoryIndexStale",
+			Expr:   fmt.Sprintf("time() - helm_repository_index_generated_timestamp_seconds > %d", int(opts.indexStaleAfter.Seconds())),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Helm repository index has not been regenerated for a long time",
+				"description": "The index.yaml of {{ $labels.repo_url }} was generated {{ $value | humanizeDuration }} ago, so the repository or mirror may no longer be updated.",
+			},
+		})
+	}
+	if cfg.Collection == collectionInterval {
+		window := promDuration(max(3*cfg.Interval, 10*time.Minute))
+		rules = append(rules, alertRule{
+			Alert:  "HelmVersionCheckStalled",
+			Expr:   fmt.Sprintf(`increase(helm_check_cycles_total{result="completed"}[%s]) == 0`, window),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "helm-version-check is not completing check cycles",
+				"description": "No check cycle completed in " + window + ", so chart versions are not up to date.",
+			},
+		})
+	}
+	return rules
+}
+
+// writePrometheusRule writes the alerts as a PrometheusRule manifest, or as a
+// plain Prometheus rules file
+func writePrometheusRule(w io.Writer, cfg *config, opts rulesOptions) error {
+	groups := prometheusRules{Groups: []ruleGroup{{Name: "helm-version-check", Rules: alertRules(cfg, opts)}}}
+	var doc any = groups
+	if !opts.plain {
+		labels := map[string]string{"app": "helm-version-check"}
+		for k, v := range opts.labels {
+			labels[k] = v
+		}
+		doc = prometheusRule{
+			APIVersion: "monitoring.coreos.com/v1",
+			Kind:       "PrometheusRule",
+			Metadata:   prometheusMetadata{Name: opts.name, Namespace: opts.namespace, Labels: labels},
+			Spec:       groups,
+		}
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// promDuration formats a duration as Prometheus does, e.g. 1h30m or 7d
+func promDuration(d time.Duration) string {
+	return strings.TrimSpace(model.Duration(d).String())
+}
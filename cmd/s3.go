@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	// s3Config is the AWS configuration from the default credential chain,
+	// loaded on first use
+	s3Config     aws.Config
+	s3ConfigErr  error
+	s3ConfigOnce sync.Once
+	// s3Regions remembers the region of each bucket
+	s3Regions sync.Map
+)
+
+// fetchS3Index reads the index.yaml of an s3://bucket/prefix repository, as
+// published by the helm-s3 plugin, with the default AWS credential chain
+func fetchS3Index(ctx context.Context, repoURL, etag string) (*indexResponse, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	bucket := u.Host
+	key := strings.TrimPrefix(path.Join(u.Path, repoIndexPath(repoURL)), "/")
+
+	client, err := s3Client(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	input := &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+	out, err := client.GetObject(ctx, input)
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+		return &indexResponse{notModified: true}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading s3://%s/%s: %w", bucket, key, err)
+	}
+	return &indexResponse{body: out.Body, etag: aws.ToString(out.ETag)}, nil
+}
+
+// s3Client returns a client for the region of bucket
+func s3Client(ctx context.Context, bucket string) (*s3.Client, error) {
+	s3ConfigOnce.Do(func() {
+		s3Config, s3ConfigErr = awsconfig.LoadDefaultConfig(context.Background())
+	})
+	if s3ConfigErr != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", s3ConfigErr)
+	}
+	return s3ClientWith(ctx, s3Config, bucket)
+}
+
+// s3ClientWith returns a client for the region of bucket using base
+func s3ClientWith(ctx context.Context, base aws.Config, bucket string) (*s3.Client, error) {
+	region, ok := s3Regions.Load(bucket)
+	if !ok {
+		cfg := base.Copy()
+		if cfg.Region == "" {
+			cfg.Region = "us-east-1"
+		}
+		r, err := manager.GetBucketRegion(ctx, s3.NewFromConfig(cfg), bucket)
+		if err != nil {
+			return nil, fmt.Errorf("finding region of bucket %s: %w", bucket, err)
+		}
+		s3Regions.Store(bucket, r)
+		region = r
+	}
+	return s3.NewFromConfig(base, func(o *s3.Options) {
+		o.Region = region.(string)
+	}), nil
+}
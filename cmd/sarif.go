@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sarifRuleID identifies outdated charts in SARIF logs
+const sarifRuleID = "outdated-helm-chart"
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	FullDescription  sarifMessage `json:"fullDescription"`
+	Help             sarifMessage `json:"help"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations,omitempty"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          chartResult       `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// writeSARIF writes the outdated charts of results as a SARIF 2.1.0 log.
+// Charts of a major version behind are errors, of a minor version warnings
+// and others notes. Results read from manifests point at the targetRevision
+// of their source, others at the Application.
+func writeSARIF(w io.Writer, results []chartResult) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "helm-version-check",
+			Version:        version,
+			InformationURI: "https://github.com/caseyrobb/helm-version-check",
+			Rules: []sarifRule{{
+				ID:               sarifRuleID,
+				Name:             "OutdatedHelmChart",
+				ShortDescription: sarifMessage{Text: "Helm chart is outdated"},
+				FullDescription:  sarifMessage{Text: "The Argo CD Application deploys a Helm chart version older than the latest one in its repository."},
+				Help:             sarifMessage{Text: "Update the targetRevision of the source to the latest chart version after reviewing its changes."},
+			}},
+		}},
+		Results: []sarifResult{},
+	}
+	for _, r := range results {
+		if !r.outdated() {
+			continue
+		}
+		level := "note"
+		switch {
+		case versionBehind(r.CurrentVersion, r.LatestVersion, "major"):
+			level = "error"
+		case versionBehind(r.CurrentVersion, r.LatestVersion, "minor"):
+			level = "warning"
+		}
+		result := sarifResult{
+			RuleID:  sarifRuleID,
+			Level:   level,
+			Message: sarifMessage{Text: incidentSummary(r)},
+			// Code scanning tracks alerts across runs by their fingerprint
+			PartialFingerprints: map[string]string{"helmVersionCheck/v1": resultKey(r)},
+			Properties:          r,
+		}
+		if r.File != "" {
+			location := &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: strings.TrimPrefix(r.File, "./")}}
+			if r.Line > 0 {
+				location.Region = &sarifRegion{StartLine: r.Line}
+			}
+			result.Locations = []sarifLocation{{PhysicalLocation: location}}
+		} else {
+			name := r.Application
+			if r.Namespace != "" {
+				name = r.Namespace + "/" + name
+			}
+			if r.Cluster != "" {
+				name = r.Cluster + "/" + name
+			}
+			result.Locations = []sarifLocation{{LogicalLocations: []sarifLogicalLocation{{
+				Name:               r.Application,
+				FullyQualifiedName: name,
+				Kind:               "resource",
+			}}}}
+		}
+		run.Results = append(run.Results, result)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}); err != nil {
+		return fmt.Errorf("writing SARIF: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// validateCron checks the cron expression and time zone of the schedule
+func (s scheduleConfig) validateCron() error {
+	if _, err := cron.ParseStandard(s.Cron); err != nil {
+		return fmt.Errorf("schedule.cron: %w", err)
+	}
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return fmt.Errorf("schedule.timeZone: %w", err)
+	}
+	return nil
+}
+
+// nextCycle returns when the cycle after now starts: when the cron schedule
+// next fires in its time zone, or else after the jittered interval
+func nextCycle(cfg *config, now time.Time) time.Time {
+	if cfg.Schedule.Cron == "" {
+		return now.Add(jittered(cfg.Interval, cfg.Schedule.Jitter))
+	}
+	schedule, err := cron.ParseStandard(cfg.Schedule.Cron)
+	if err != nil {
+		return now.Add(cfg.Interval)
+	}
+	loc, err := time.LoadLocation(cfg.Schedule.TimeZone)
+	if err != nil {
+		loc = time.Local
+	}
+	return schedule.Next(now.In(loc))
+}
+
+// gaugeTTL returns how long the gauges of a cycle starting at now are kept
+// without being set again: twice the time until the next cycle, which with a
+// cron schedule may be far longer than the 15 minutes they are created with
+func gaugeTTL(cfg *config, now time.Time) time.Duration {
+	return 2 * nextCycle(cfg, now).Sub(now)
+}
+
+// cyclePeriod returns the time between cycles that spreading and request
+// pacing fill: the interval, or with a cron schedule the time between its
+// next two firings
+func cyclePeriod(cfg *config, now time.Time) time.Duration {
+	if cfg.Schedule.Cron == "" {
+		return cfg.Interval
+	}
+	next := nextCycle(cfg, now)
+	return nextCycle(cfg, next).Sub(next)
+}
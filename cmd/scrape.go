@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// collectionInterval checks charts on a timer
+	collectionInterval = "interval"
+	// collectionScrape checks charts when metrics are scraped
+	collectionScrape = "scrape"
+)
+
+// scrapeCollector runs a check cycle when it is collected and the last
+// successful cycle is older than the configured interval, then collects the
+// chart gauges, so the scrape interval controls freshness
+type scrapeCollector struct {
+	ctx        context.Context
+	cycle      func(context.Context) error
+	collectors []prometheus.Collector
+	mu         sync.Mutex
+	last       time.Time
+}
+
+// registerScrapeCollector replaces the chart gauges in the default registry
+// with a scrapeCollector wrapping them
+func registerScrapeCollector(ctx context.Context, cycle func(context.Context) error) (*scrapeCollector, error) {
+	c := &scrapeCollector{
+		ctx:        ctx,
+		cycle:      cycle,
+		collectors: []prometheus.Collector{helmVersionGauge, provenanceGauge, signatureGauge},
+	}
+	for _, collector := range c.collectors {
+		prometheus.Unregister(collector)
+	}
+	return c, prometheus.Register(c)
+}
+
+// Describe implements the prometheus.Collector interface
+func (c *scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements the prometheus.Collector interface
+func (c *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.refresh()
+	for _, collector := range c.collectors {
+		collector.Collect(ch)
+	}
+}
+
+// refresh runs a cycle if the results are stale. Scrapes arriving while a
+// cycle runs, including the Pushgateway and remote write gathers of the cycle
+// itself, get the previous values instead of waiting.
+func (c *scrapeCollector) refresh() {
+	if !c.mu.TryLock() {
+		return
+	}
+	defer c.mu.Unlock()
+	if time.Since(c.last) < currentConfig().Interval || c.ctx.Err() != nil {
+		return
+	}
+	c.check()
+}
+
+// serveReconciles handles reconcile requests until the context is cancelled,
+// waiting for a cycle triggered by a scrape to finish first
+func (c *scrapeCollector) serveReconciles(checkApplication func(context.Context, string)) {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case app := <-reconcileRequests:
+			c.mu.Lock()
+			if app == "" {
+				c.check()
+			} else {
+				checkApplication(c.ctx, app)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// check runs a cycle; callers hold the lock
+func (c *scrapeCollector) check() {
+	start := time.Now()
+	if err := c.cycle(c.ctx); err != nil {
+		slog.Error("Error checking charts on scrape", "error", err)
+		return
+	}
+	c.last = start
+	slog.Debug("Checked charts on scrape", "duration", time.Since(start))
+}
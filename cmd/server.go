@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newMetricsServer builds the HTTP server for metrics, reports and admin
+// endpoints. The listener and TLS settings are fixed at startup; basic auth
+// credentials are read from the active config on every request.
+func newMetricsServer(cfg metricsConfig) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, requireBasicAuth(promhttp.Handler()))
+	mux.Handle("/report", requireBasicAuth(latestResults))
+	mux.Handle("/summary", requireBasicAuth(summaryHandler()))
+	// /diff uses basic auth for applications and the admin token otherwise
+	mux.Handle("/diff", diffHandler())
+	mux.Handle("/api/v1/charts", requireBasicAuth(groupedHandler("chart")))
+	mux.Handle("/api/v1/repositories", requireBasicAuth(groupedHandler("repository")))
+	mux.Handle("/api/v1/history", requireBasicAuth(checkHistory))
+	mux.Handle("/api/v1/events", requireBasicAuth(eventStream))
+	// /loglevel and /reconcile use the admin bearer token
+	mux.HandleFunc("/loglevel", logLevelHandler)
+	mux.HandleFunc("/reconcile", reconcileHandler)
+	// /webhook uses the receiver bearer token
+	mux.HandleFunc("/webhook", receiverHandler)
+	// /extensions/application uses the Argo CD extension bearer token
+	mux.HandleFunc("/extensions/application", extensionHandler)
+
+	tlsConfig, err := serverTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Server{Addr: cfg.addr(), Handler: mux, TLSConfig: tlsConfig}, nil
+}
+
+// serverTLSConfig returns the TLS settings of the metrics and gRPC servers,
+// nil without a certificate
+func serverTLSConfig(cfg tlsConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+	certs := &certificateLoader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if _, err := certs.getCertificate(nil); err != nil {
+		return nil, fmt.Errorf("loading metrics TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.getCertificate,
+	}
+	if cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// checkBearerToken verifies the bearer token of a request against the one
+// returned by token, writing an error response when it is missing or wrong.
+// Without a configured token the endpoint is disabled and responds with 404.
+func checkBearerToken(w http.ResponseWriter, r *http.Request, token func() (string, error)) bool {
+	expected, err := token()
+	if err != nil {
+		slog.Error("Error reading token", "path", r.URL.Path, "error", err)
+		http.Error(w, "token unavailable", http.StatusInternalServerError)
+		return false
+	}
+	if expected == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// serveMetrics listens with or without TLS depending on the server config
+func serveMetrics(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// requireBasicAuth rejects requests without the configured metrics
+// credentials; it passes everything through when no username is set
+func requireBasicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := currentConfig().Metrics.BasicAuth
+		if auth.Username == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		username, password, err := auth.credentials()
+		if err != nil {
+			slog.Error("Error reading metrics basic auth password", "error", err)
+			http.Error(w, "credentials unavailable", http.StatusInternalServerError)
+			return
+		}
+		user, pass, ok := r.BasicAuth()
+		userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+		if !ok || !userMatch || !passMatch {
+			w.Header().Set("WWW-Authenticate", `Basic realm="helm-version-check"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// certificateLoader reloads the serving certificate when its files change so
+// rotated certificates (e.g. from cert-manager) are picked up without a restart
+type certificateLoader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (l *certificateLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	modTime := l.modTime
+	for _, name := range []string{l.certFile, l.keyFile} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if l.cert != nil && !modTime.After(l.modTime) {
+		return l.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		if l.cert != nil {
+			slog.Error("Error reloading metrics TLS certificate, keeping previous", "error", err)
+			return l.cert, nil
+		}
+		return nil, err
+	}
+	l.cert, l.modTime = &cert, modTime
+	return l.cert, nil
+}
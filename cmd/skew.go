@@ -0,0 +1,100 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+var versionSkewGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_chart_versions_in_use",
+		Help: "Distinct versions of a chart deployed by the applications of all clusters, with the oldest and newest of them; 1 when they have converged",
+	},
+	[]string{"chart", "repo_url", "min_version", "max_version"},
+)
+
+func init() {
+	prometheus.MustRegister(versionSkewGauge)
+}
+
+// chartSkew is the spread of the versions of a chart deployed by several
+// applications
+type chartSkew struct {
+	Chart        string `json:"chart"`
+	RepoURL      string `json:"repoURL"`
+	Applications int    `json:"applications"`
+	// Versions are the distinct deployed versions, oldest first
+	Versions   []string `json:"versions"`
+	MinVersion string   `json:"minVersion"`
+	MaxVersion string   `json:"maxVersion"`
+}
+
+// versionSkew returns the spread of the deployed versions of the charts used
+// by more than one application across clusters, those with the most
+// distinct versions first
+func versionSkew(results []chartResult) []chartSkew {
+	type chartKey struct{ chart, repoURL string }
+	apps := map[chartKey]map[string]bool{}
+	versions := map[chartKey][]string{}
+	repoURLs := map[chartKey]string{}
+	var keys []chartKey
+	for _, r := range results {
+		if r.VendoredChart != nil || r.CurrentVersion == "" {
+			continue
+		}
+		key := chartKey{r.Chart, strings.TrimSuffix(r.RepoURL, "/")}
+		if apps[key] == nil {
+			apps[key] = map[string]bool{}
+			repoURLs[key] = r.RepoURL
+			keys = append(keys, key)
+		}
+		apps[key][r.Cluster+"/"+r.Namespace+"/"+r.Application] = true
+		if !slices.ContainsFunc(versions[key], func(v string) bool { return check.Same(v, r.CurrentVersion) }) {
+			versions[key] = append(versions[key], r.CurrentVersion)
+		}
+	}
+	var skews []chartSkew
+	for _, key := range keys {
+		if len(apps[key]) < 2 {
+			continue
+		}
+		vs := versions[key]
+		slices.SortFunc(vs, func(a, b string) int {
+			cmp, _ := check.Compare(repository.Entry{Version: a}, repository.Entry{Version: b}, check.OrderingLenient)
+			return cmp
+		})
+		skews = append(skews, chartSkew{
+			Chart:        key.chart,
+			RepoURL:      repoURLs[key],
+			Applications: len(apps[key]),
+			Versions:     vs,
+			MinVersion:   vs[0],
+			MaxVersion:   vs[len(vs)-1],
+		})
+	}
+	sort.SliceStable(skews, func(i, j int) bool {
+		if len(skews[i].Versions) != len(skews[j].Versions) {
+			return len(skews[i].Versions) > len(skews[j].Versions)
+		}
+		if skews[i].Chart != skews[j].Chart {
+			return skews[i].Chart < skews[j].Chart
+		}
+		return skews[i].RepoURL < skews[j].RepoURL
+	})
+	return skews
+}
+
+// recordVersionSkew exports the spread of the versions of the charts of a
+// cycle, replacing that of charts no longer shared
+func recordVersionSkew(results []chartResult) {
+	versionSkewGauge.Reset()
+	for _, s := range versionSkew(results) {
+		versionSkewGauge.WithLabelValues(s.Chart, s.RepoURL, s.MinVersion, s.MaxVersion).Set(float64(len(s.Versions)))
+	}
+}
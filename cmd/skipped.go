@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/caseyrobb/helm-version-check/pkg/argocd"
+)
+
+// Reasons for which charts and Applications are not checked
+const (
+	skipExcluded         = "excluded"
+	skipIncompleteSource = "incomplete_source"
+	skipRepoFilter       = "repo_filter"
+	skipFailedRecently   = "failed_recently"
+	skipCircuitOpen      = "circuit_open"
+	skipRateLimited      = "rate_limited"
+	skipNonSemver        = "non_semver"
+)
+
+// checksSkippedCounter counts the charts and Applications left out of checks
+// by reason, so gaps in coverage can be quantified. Charts whose versions are
+// not semver are counted as non_semver while still being reported.
+var checksSkippedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "helm_chart_checks_skipped_total",
+		Help: "Charts or applications skipped by checks, by reason",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	prometheus.MustRegister(checksSkippedCounter)
+	// every reason is exported from the start so rates can be computed
+	for _, reason := range []string{
+		skipExcluded, skipIncompleteSource, skipRepoFilter, skipFailedRecently,
+		skipCircuitOpen, skipRateLimited, skipNonSemver, argocd.SkipDisabled, argocd.SkipFiltered,
+	} {
+		checksSkippedCounter.WithLabelValues(reason)
+	}
+}
+
+// skipCheck counts a chart or Application skipped for reason
+func skipCheck(reason string) {
+	checksSkippedCounter.WithLabelValues(reason).Inc()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	sloRatioGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_charts_slo_ratio",
+			Help: "Share of charts within the objective's version lag over the SLO window, overall (empty label) and per value of each SLO label",
+		},
+		[]string{"label", "value"},
+	)
+	sloTargetGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "helm_charts_slo_target",
+		Help: "Share of charts the SLO requires within the objective's version lag",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(sloRatioGauge)
+	prometheus.MustRegister(sloTargetGauge)
+}
+
+// sloCounts are the charts meeting the objective and all charts of a group
+type sloCounts struct {
+	met, total int
+}
+
+// sloGroup is a group of charts: all of them with an empty label, or those
+// whose application label has value
+type sloGroup struct {
+	label, value string
+}
+
+// sloSample holds the counts of a cycle
+type sloSample struct {
+	at     time.Time
+	counts map[sloGroup]sloCounts
+}
+
+// sloSamples keeps the samples of the cycles within the SLO window
+var sloSamples = &sloHistory{}
+
+type sloHistory struct {
+	mu      sync.Mutex
+	samples []sloSample
+}
+
+// record adds the results of a cycle and exports the ratios over the
+// window, replacing those of groups no longer seen
+func (h *sloHistory) record(cfg sloConfig, results []chartResult, now time.Time) {
+	if cfg.Level == "" {
+		return
+	}
+	sample := sloSample{at: now, counts: map[sloGroup]sloCounts{}}
+	for _, r := range results {
+		met := 0
+		if cfg.meets(r) {
+			met = 1
+		}
+		groups := []sloGroup{{}}
+		for _, label := range cfg.Labels {
+			groups = append(groups, sloGroup{label: label, value: r.Labels[label]})
+		}
+		for _, g := range groups {
+			c := sample.counts[g]
+			sample.counts[g] = sloCounts{met: c.met + met, total: c.total + 1}
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples = append(h.samples, sample)
+	for len(h.samples) > 1 && now.Sub(h.samples[0].at) > cfg.Window {
+		h.samples = h.samples[1:]
+	}
+	totals := map[sloGroup]sloCounts{}
+	for _, s := range h.samples {
+		for g, c := range s.counts {
+			t := totals[g]
+			totals[g] = sloCounts{met: t.met + c.met, total: t.total + c.total}
+		}
+	}
+	sloRatioGauge.Reset()
+	for g, c := range totals {
+		sloRatioGauge.WithLabelValues(g.label, g.value).Set(float64(c.met) / float64(c.total))
+	}
+	sloTargetGauge.Set(cfg.Target)
+}
+
+// meets reports whether a result lags the latest version by at most
+// Versions at Level and by nothing at higher levels. Ahead versions meet
+// it, as do versions that are not semver when up to date.
+func (s sloConfig) meets(r chartResult) bool {
+	if !r.outdated() {
+		return true
+	}
+	d := r.Behind
+	if d == nil {
+		return false
+	}
+	lag := uint64(s.Versions)
+	switch s.Level {
+	case "major":
+		return d.Major <= lag
+	case "minor":
+		return d.Major == 0 && d.Minor <= lag
+	}
+	return d.Major == 0 && d.Minor == 0 && d.Patch <= lag
+}
+
+// validate checks the level, lag, target and window
+func (s sloConfig) validate() error {
+	switch s.Level {
+	case "", "major", "minor", "patch":
+	default:
+		return fmt.Errorf("level must be major, minor or patch, got %q", s.Level)
+	}
+	if s.Versions < 0 {
+		return errors.New("versions must not be negative")
+	}
+	if s.Target < 0 || s.Target > 1 {
+		return fmt.Errorf("target must be between 0 and 1, got %g", s.Target)
+	}
+	if s.Window < 0 {
+		return errors.New("window must not be negative")
+	}
+	return nil
+}
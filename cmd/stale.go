@@ -0,0 +1,43 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+var staleChartGauge = metrics.NewExpiringGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_chart_stale",
+		Help: "Whether the latest version of the chart was released longer ago than the stale threshold, hinting the chart is unmaintained upstream (1 = stale, 0 = maintained)",
+	},
+	[]string{"application", "chart", "repo_url", "latest_version", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source"},
+	15*time.Minute,
+)
+
+func init() {
+	prometheus.MustRegister(staleChartGauge)
+}
+
+// latestRelease returns when the latest version was created, nil when the
+// repository does not tell, and whether it is older than staleAfter. Nothing
+// is stale when staleAfter is zero.
+func latestRelease(latest repository.Entry, staleAfter time.Duration, now time.Time) (*time.Time, bool) {
+	if latest.Created.IsZero() {
+		return nil, false
+	}
+	released := latest.Created.UTC()
+	return &released, staleAfter > 0 && now.Sub(released) > staleAfter
+}
+
+// recordStale sets whether the latest version of a chart is stale, for
+// charts whose release time is known while a threshold is set
+func recordStale(r chartResult) {
+	if r.LatestReleased == nil || currentConfig().StaleAfter <= 0 {
+		return
+	}
+	staleChartGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.LatestVersion, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source).Set(boolValue(r.Stale))
+}
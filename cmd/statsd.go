@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsdMaxPacket keeps datagrams within the MTU of most networks, as the
+// DogStatsD agent recommends
+const statsdMaxPacket = 1432
+
+// statsdMetrics sends the current values of the helm_ gauges to a StatsD or
+// DogStatsD agent, several lines per datagram
+func statsdMetrics(ctx context.Context, cfg statsdConfig) error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return err
+	}
+	lines := statsdLines(families, cfg)
+	if len(lines) == 0 {
+		return nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", cfg.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacket {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+	slog.Debug("Sent metrics to StatsD", "address", cfg.Address, "metrics", len(lines))
+	return nil
+}
+
+// statsdLines formats the gauges of the gathered helm_ families. DogStatsD
+// carries labels and the configured tags as tags; plain StatsD appends the
+// labels with their names to the metric name, sorted by name.
+func statsdLines(families []*dto.MetricFamily, cfg statsdConfig) []string {
+	var lines []string
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "helm_") {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if m.GetGauge() == nil {
+				continue
+			}
+			pairs := m.GetLabel()
+			sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+			name := cfg.Prefix + family.GetName()
+			value := strconv.FormatFloat(m.GetGauge().GetValue(), 'f', -1, 64)
+			if cfg.Format == "statsd" {
+				for _, pair := range pairs {
+					if pair.GetValue() != "" {
+						name += "." + pair.GetName() + "." + statsdPathSegment(pair.GetValue())
+					}
+				}
+				lines = append(lines, name+":"+value+"|g")
+				continue
+			}
+			tags := append([]string(nil), cfg.Tags...)
+			for _, pair := range pairs {
+				if pair.GetValue() != "" {
+					tags = append(tags, pair.GetName()+":"+statsdTagValue(pair.GetValue()))
+				}
+			}
+			line := name + ":" + value + "|g"
+			if len(tags) > 0 {
+				line += "|#" + strings.Join(tags, ",")
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// statsdTagValue replaces the characters separating DogStatsD fields and tags
+func statsdTagValue(v string) string {
+	return strings.NewReplacer("|", "_", ",", "_", "\n", "_").Replace(v)
+}
+
+// statsdPathSegment keeps a label value to one segment of a StatsD metric
+// name, such as charts_bitnami_com for charts.bitnami.com
+func statsdPathSegment(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, v)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment, so proxies
+// do not close it
+const streamHeartbeat = 30 * time.Second
+
+// eventStream sends the status changes of every cycle and reconcile to the
+// clients of /api/v1/events
+var eventStream = &eventBroker{
+	subscribers: map[chan statusEvent]struct{}{},
+	failing:     map[string]checkError{},
+	closed:      make(chan struct{}),
+}
+
+// eventBroker fans events out to subscribers and tracks which charts are
+// failing to report when their error state changes
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan statusEvent]struct{}
+	failing     map[string]checkError
+	// closed ends the streams on shutdown, which waits for open requests
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// errorKey identifies a chart of an application in errors and results,
+// whose name may repeat across Argo CD instances of a cluster
+func errorKey(cluster, instance, app, chart string) string {
+	return cluster + "|" + instance + "|" + app + "|" + chart
+}
+
+// publish sends the status events and the changes in error state of a cycle,
+// or of the application app when it is not empty, whose previous errors are
+// the only ones replaced, and returns all of them
+func (b *eventBroker) publish(events []statusEvent, errs []checkError, results []chartResult, app string) []statusEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	failing := make(map[string]checkError, len(b.failing))
+	for key, e := range b.failing {
+		if app != "" && e.Application != app {
+			failing[key] = e
+		}
+	}
+	for _, e := range errs {
+		key := errorKey(e.Cluster, e.Instance, e.Application, e.Chart)
+		if _, ok := b.failing[key]; !ok {
+			result := chartResult{Cluster: e.Cluster, Instance: e.Instance, Application: e.Application, Chart: e.Chart, RepoURL: e.RepoURL}
+			events = append(events, statusEvent{Type: eventFailing, Result: result, Error: e.Error})
+		}
+		failing[key] = e
+	}
+	for _, r := range results {
+		if e, ok := b.failing[errorKey(r.Cluster, r.Instance, r.Application, r.Chart)]; ok {
+			if _, still := failing[errorKey(r.Cluster, r.Instance, r.Application, r.Chart)]; !still {
+				events = append(events, statusEvent{Type: eventRecovered, Result: r, Error: e.Error})
+			}
+		}
+	}
+	b.failing = failing
+
+	for _, event := range events {
+		for ch := range b.subscribers {
+			select {
+			case ch <- event:
+			default:
+				slog.Debug("Dropping event for slow stream client", "type", event.Type, "application", event.Result.Application)
+			}
+		}
+	}
+	return events
+}
+
+func (b *eventBroker) subscribe() chan statusEvent {
+	ch := make(chan statusEvent, 64)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan statusEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+func (b *eventBroker) close() {
+	b.closeOnce.Do(func() { close(b.closed) })
+}
+
+// ServeHTTP streams events as server-sent events named after their type,
+// optionally only those of the app, cluster and chart query parameters
+func (b *eventBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	q := r.URL.Query()
+	app, cluster, chart := q.Get("app"), q.Get("cluster"), q.Get("chart")
+
+	ch := b.subscribe()
+	defer b.unsubscribe(ch)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.closed:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event := <-ch:
+			res := event.Result
+			if (app != "" && res.Application != app) || (cluster != "" && res.Cluster != cluster) || (chart != "" && res.Chart != chart) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("Error encoding event", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
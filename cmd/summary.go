@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+)
+
+// defaultSummaryTop is the number of worst offenders listed per group
+const defaultSummaryTop = 5
+
+// summaryCounts are the totals of a set of results
+type summaryCounts struct {
+	Applications  int     `json:"applications"`
+	Charts        int     `json:"charts"`
+	Outdated      int     `json:"outdated"`
+	Missing       int     `json:"missing"`
+	UpToDateRatio float64 `json:"upToDateRatio"`
+}
+
+// summaryOffender is an outdated chart, the furthest behind first
+type summaryOffender struct {
+	Cluster        string       `json:"cluster,omitempty"`
+	Application    string       `json:"application"`
+	Chart          string       `json:"chart"`
+	RepoURL        string       `json:"repoURL"`
+	CurrentVersion string       `json:"currentVersion"`
+	LatestVersion  string       `json:"latestVersion"`
+	Behind         *check.Delta `json:"behind,omitempty"`
+}
+
+// summaryGroup rolls up the results sharing a repository, project or label
+// value
+type summaryGroup struct {
+	Name string `json:"name"`
+	summaryCounts
+	Worst []summaryOffender `json:"worst"`
+}
+
+// summary is a compact rollup of the latest results for dashboards
+type summary struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	summaryCounts
+	Repositories []summaryGroup            `json:"repositories"`
+	Projects     []summaryGroup            `json:"projects"`
+	Labels       map[string][]summaryGroup `json:"labels,omitempty"`
+	// Skew lists the charts deployed by several applications with the
+	// versions in use
+	Skew []chartSkew `json:"skew,omitempty"`
+}
+
+// summaryHandler serves a rollup of the latest results by repository, Argo
+// CD project and the application labels named with label, such as team,
+// listing the top worst offenders of each group
+func summaryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		top := defaultSummaryTop
+		if v := q.Get("top"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "top must be a non-negative number", http.StatusBadRequest)
+				return
+			}
+			top = n
+		}
+		report := latestResults.report()
+		s := summarize(report.Results, q["label"], top)
+		s.GeneratedAt = report.GeneratedAt
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s); err != nil {
+			slog.Error("Error writing summary", "error", err)
+		}
+	}
+}
+
+// summarize rolls up results by repository, project and each of labels
+func summarize(results []chartResult, labels []string, top int) summary {
+	s := summary{
+		summaryCounts: countResults(results),
+		Repositories:  groupResults(results, top, func(r chartResult) string { return r.RepoURL }),
+		Projects:      groupResults(results, top, func(r chartResult) string { return r.Project }),
+		Skew:          versionSkew(results),
+	}
+	for _, label := range labels {
+		if s.Labels == nil {
+			s.Labels = map[string][]summaryGroup{}
+		}
+		s.Labels[label] = groupResults(results, top, func(r chartResult) string { return r.Labels[label] })
+	}
+	return s
+}
+
+// groupResults rolls up results by the value key returns, the groups with
+// the most outdated charts first
+func groupResults(results []chartResult, top int, key func(chartResult) string) []summaryGroup {
+	byKey := map[string][]chartResult{}
+	for _, r := range results {
+		k := key(r)
+		byKey[k] = append(byKey[k], r)
+	}
+	groups := make([]summaryGroup, 0, len(byKey))
+	for name, members := range byKey {
+		groups = append(groups, summaryGroup{Name: name, summaryCounts: countResults(members), Worst: worstOffenders(members, top)})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Outdated != groups[j].Outdated {
+			return groups[i].Outdated > groups[j].Outdated
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// countResults totals results; without results the ratio is 1, as for the
+// helm_charts_up_to_date_ratio gauge
+func countResults(results []chartResult) summaryCounts {
+	c := summaryCounts{Charts: len(results), UpToDateRatio: 1}
+	apps := map[string]bool{}
+	for _, r := range results {
+		apps[r.Cluster+"/"+r.Application] = true
+		if r.outdated() {
+			c.Outdated++
+		}
+		if r.Missing {
+			c.Missing++
+		}
+	}
+	c.Applications = len(apps)
+	if c.Charts > 0 {
+		c.UpToDateRatio = float64(c.Charts-c.Outdated) / float64(c.Charts)
+	}
+	return c
+}
+
+// worstOffenders returns up to top outdated results, the most major, minor
+// and then patch versions behind first
+func worstOffenders(results []chartResult, top int) []summaryOffender {
+	var outdated []chartResult
+	for _, r := range results {
+		if r.outdated() {
+			outdated = append(outdated, r)
+		}
+	}
+	sort.SliceStable(outdated, func(i, j int) bool {
+		return behindMore(outdated[i].Behind, outdated[j].Behind)
+	})
+	offenders := []summaryOffender{}
+	for _, r := range outdated {
+		if len(offenders) == top {
+			break
+		}
+		offenders = append(offenders, summaryOffender{
+			Cluster:        r.Cluster,
+			Application:    r.Application,
+			Chart:          r.Chart,
+			RepoURL:        r.RepoURL,
+			CurrentVersion: r.CurrentVersion,
+			LatestVersion:  r.LatestVersion,
+			Behind:         r.Behind,
+		})
+	}
+	return offenders
+}
+
+// behindMore reports whether a lags by more versions than b, with unknown
+// deltas, such as of versions that are not semver, last
+func behindMore(a, b *check.Delta) bool {
+	switch {
+	case a == nil || b == nil:
+		return a != nil
+	case a.Major != b.Major:
+		return a.Major > b.Major
+	case a.Minor != b.Minor:
+		return a.Minor > b.Minor
+	}
+	return a.Patch > b.Patch
+}
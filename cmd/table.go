@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+)
+
+// tableColumn is a column of the list table
+type tableColumn struct {
+	header string
+	value  func(chartResult) string
+	// compare orders values of the column, by default as strings
+	compare func(a, b string) int
+}
+
+// tableColumns are the columns the list command can show, by name
+var tableColumns = map[string]tableColumn{
+	"application": {header: "APPLICATION", value: func(r chartResult) string { return r.Application }},
+	"cluster":     {header: "CLUSTER", value: func(r chartResult) string { return r.Cluster }},
+	"instance":    {header: "INSTANCE", value: func(r chartResult) string { return r.Instance }},
+	"namespace":   {header: "NAMESPACE", value: func(r chartResult) string { return r.Namespace }},
+	"check":       {header: "CHECK", value: func(r chartResult) string { return r.Check }},
+	"destination": {header: "DESTINATION", value: func(r chartResult) string {
+		return strings.TrimSpace(r.DestinationCluster + " " + r.DestinationNamespace)
+	}},
+	"source":  {header: "SOURCE", value: func(r chartResult) string { return r.Source }},
+	"chart":   {header: "CHART", value: func(r chartResult) string { return r.Chart }},
+	"repo":    {header: "REPOSITORY", value: func(r chartResult) string { return r.RepoURL }},
+	"current": {header: "CURRENT", value: func(r chartResult) string { return r.CurrentVersion }, compare: check.CompareLenient},
+	"latest":  {header: "LATEST", value: func(r chartResult) string { return r.LatestVersion }, compare: check.CompareLenient},
+	"status":  {header: "STATUS", value: resultStatus},
+}
+
+// defaultTableColumns are shown without --columns
+var defaultTableColumns = []string{"application", "chart", "current", "latest", "status"}
+
+// resultStatus describes a result in a word or two
+func resultStatus(r chartResult) string {
+	switch {
+	case r.Missing:
+		return "missing"
+	case r.Ahead:
+		return "ahead"
+	case r.UpToDate:
+		return "up to date"
+	case versionBehind(r.CurrentVersion, r.LatestVersion, "major"):
+		return "major behind"
+	case versionBehind(r.CurrentVersion, r.LatestVersion, "minor"):
+		return "minor behind"
+	}
+	return "outdated"
+}
+
+// validateTableColumns reports unknown column names
+func validateTableColumns(columns []string) error {
+	for _, name := range columns {
+		if _, ok := tableColumns[name]; !ok {
+			return fmt.Errorf("unknown column %q, expected one of %s", name, strings.Join(tableColumnNames(), ", "))
+		}
+	}
+	return nil
+}
+
+func tableColumnNames() []string {
+	names := make([]string, 0, len(tableColumns))
+	for name := range tableColumns {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// writeTable writes results as aligned columns sorted by the sortBy column,
+// then by application and chart
+func writeTable(w io.Writer, results []chartResult, columns []string, sortBy string, descending bool) error {
+	sorted := slices.Clone(results)
+	key := tableColumns[sortBy]
+	slices.SortStableFunc(sorted, func(a, b chartResult) int {
+		compare := key.compare
+		if compare == nil {
+			compare = strings.Compare
+		}
+		cmp := compare(key.value(a), key.value(b))
+		if descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+		if cmp = strings.Compare(a.Application, b.Application); cmp != 0 {
+			return cmp
+		}
+		return strings.Compare(a.Chart, b.Chart)
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	row := make([]string, len(columns))
+	for i, name := range columns {
+		row[i] = tableColumns[name].header
+	}
+	fmt.Fprintln(tw, strings.Join(row, "\t"))
+	for _, r := range sorted {
+		for i, name := range columns {
+			row[i] = tableColumns[name].value(r)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+)
+
+var (
+	outdatedSecondsGauge = metrics.NewExpiringGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "helm_chart_outdated_seconds",
+			Help: "How long a newer version of the chart has been available without being adopted, 0 when up to date",
+		},
+		[]string{"application", "chart", "repo_url", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source"},
+		15*time.Minute,
+	)
+	upgradeLatencyHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "helm_chart_upgrade_latency_seconds",
+			Help: "Time applications took to get up to date after a newer version of their chart was first observed",
+			// An hour to half a year
+			Buckets: []float64{3600, 6 * 3600, 86400, 3 * 86400, 7 * 86400, 14 * 86400, 30 * 86400, 60 * 86400, 90 * 86400, 180 * 86400},
+		},
+		[]string{"chart", "cluster", "argocd_instance"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(outdatedSecondsGauge)
+	prometheus.MustRegister(upgradeLatencyHistogram)
+}
+
+// outdatedCharts remembers when each outdated chart of an application was
+// first seen outdated
+var outdatedCharts = &outdatedTracker{since: map[string]time.Time{}}
+
+type outdatedTracker struct {
+	mu    sync.Mutex
+	since map[string]time.Time
+}
+
+// observe returns since when r has been outdated, nil when it is up to date.
+// A chart getting up to date records the time it took; the time of a
+// restored result is kept.
+func (t *outdatedTracker) observe(r chartResult) *time.Time {
+	key := resultKey(r)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	since, ok := t.since[key]
+	if !r.outdated() {
+		if ok {
+			delete(t.since, key)
+			upgradeLatencyHistogram.WithLabelValues(r.Chart, r.Cluster, r.Instance).Observe(time.Since(since).Seconds())
+		}
+		return nil
+	}
+	if !ok {
+		since = time.Now().UTC().Truncate(time.Second)
+		if r.OutdatedSince != nil {
+			since = *r.OutdatedSince
+		}
+		t.since[key] = since
+	}
+	return &since
+}
+
+// prune forgets the charts not among the results of a cycle, such as those
+// of deleted applications
+func (t *outdatedTracker) prune(results []chartResult) {
+	keep := make(map[string]bool, len(results))
+	for _, r := range results {
+		keep[resultKey(r)] = true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.since {
+		if !keep[key] {
+			delete(t.since, key)
+		}
+	}
+}
+
+// recordOutdatedSeconds sets how long a result has been outdated
+func recordOutdatedSeconds(r chartResult) {
+	seconds := 0.0
+	if r.OutdatedSince != nil {
+		seconds = time.Since(*r.OutdatedSince).Seconds()
+	}
+	outdatedSecondsGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source).Set(seconds)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/oauth2/google"
+)
+
+var uploadClient = &http.Client{Timeout: time.Minute}
+
+// reportUploads remembers when a report was last uploaded, for the upload
+// interval
+var reportUploads = &uploadSchedule{}
+
+type uploadSchedule struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// due reports whether interval has passed since the last upload
+func (s *uploadSchedule) due(interval time.Duration, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last.IsZero() || now.Sub(s.last) >= interval
+}
+
+// uploaded records a successful upload at now, so failed ones are retried
+// the next cycle
+func (s *uploadSchedule) uploaded(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = now
+}
+
+// uploadReport uploads the report file of a cycle to the bucket of cfg, under
+// the prefix and the name of the exporter, once the interval has passed
+func uploadReport(ctx context.Context, cfg reportUploadConfig, results []chartResult, errs []checkError, now time.Time) error {
+	now = now.UTC().Truncate(time.Second)
+	if !reportUploads.due(cfg.Interval, now) {
+		return nil
+	}
+	data, err := marshalFileReport(results, errs, now)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return err
+	}
+	name := cfg.Name
+	if name == "" {
+		if name, err = os.Hostname(); err != nil {
+			return fmt.Errorf("naming the report folder: %w", err)
+		}
+	}
+	key := strings.TrimPrefix(path.Join(u.Path, name, reportFileName(now)), "/")
+	switch u.Scheme {
+	case "s3":
+		err = uploadS3(ctx, cfg, u.Host, key, data)
+	case "gs":
+		err = uploadGCS(ctx, cfg, u.Host, key, data)
+	default:
+		err = fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return err
+	}
+	reportUploads.uploaded(now)
+	slog.Debug("Uploaded report", "bucket", u.Host, "key", key, "results", len(results))
+	return nil
+}
+
+// validate checks the bucket URL and interval
+func (c reportUploadConfig) validate() error {
+	if c.URL != "" {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return fmt.Errorf("url: %w", err)
+		}
+		if (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+			return fmt.Errorf("url must be s3://bucket/prefix or gs://bucket/prefix, got %q", c.URL)
+		}
+	}
+	if c.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	return nil
+}
+
+// uploadS3 writes an object with the AWS credentials of cfg, by default
+// those of the default credential chain
+func uploadS3(ctx context.Context, cfg reportUploadConfig, bucket, key string, data []byte) error {
+	var (
+		client *s3.Client
+		err    error
+	)
+	if cfg.CredentialsFile == "" && cfg.Profile == "" {
+		client, err = s3Client(ctx, bucket)
+	} else {
+		var opts []func(*awsconfig.LoadOptions) error
+		if cfg.CredentialsFile != "" {
+			opts = append(opts, awsconfig.WithSharedCredentialsFiles([]string{cfg.CredentialsFile}))
+		}
+		if cfg.Profile != "" {
+			opts = append(opts, awsconfig.WithSharedConfigProfile(cfg.Profile))
+		}
+		var base aws.Config
+		if base, err = awsconfig.LoadDefaultConfig(ctx, opts...); err != nil {
+			return fmt.Errorf("loading AWS config: %w", err)
+		}
+		client, err = s3ClientWith(ctx, base, bucket)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("writing s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
+
+// uploadGCS writes an object through the Cloud Storage XML API with the
+// service account key of cfg, by default application default credentials
+func uploadGCS(ctx context.Context, cfg reportUploadConfig, bucket, object string, data []byte) error {
+	var token string
+	if cfg.CredentialsFile == "" {
+		var err error
+		if token, err = googleTokens.accessToken(); err != nil {
+			return err
+		}
+	} else {
+		key, err := os.ReadFile(cfg.CredentialsFile)
+		if err != nil {
+			return fmt.Errorf("reading credentials file: %w", err)
+		}
+		creds, err := google.CredentialsFromJSON(ctx, key, googleScope)
+		if err != nil {
+			return fmt.Errorf("parsing credentials file: %w", err)
+		}
+		t, err := creds.TokenSource.Token()
+		if err != nil {
+			return fmt.Errorf("getting Google access token: %w", err)
+		}
+		token = t.AccessToken
+	}
+	endpoint := "https://storage.googleapis.com/" + bucket + "/" + (&url.URL{Path: object}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := tracedDo(uploadClient, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s writing gs://%s/%s", resp.Status, bucket, object)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// probeTimeout bounds each connectivity check of the validate command
+const probeTimeout = 30 * time.Second
+
+// probe is the outcome of a connectivity check of the validate command
+type probe struct {
+	kind   string
+	target string
+	detail string
+	err    error
+}
+
+// probeConnectivity resolves the credentials of the configured repositories,
+// lists the charts in use in every cluster and namespace, and reads the
+// versions of one chart of each repository they come from
+func probeConnectivity(ctx context.Context, kubeconfig string, cfg *config) []probe {
+	var probes []probe
+	for i := range cfg.Repositories {
+		repo := &cfg.Repositories[i]
+		var err error
+		if repo.OAuth2.TokenURL != "" {
+			_, err = oauth2Tokens.accessToken(&repo.OAuth2)
+		} else if repo.hasToken() {
+			_, err = repo.token()
+		} else {
+			_, _, err = repo.credentials()
+		}
+		probes = append(probes, probe{kind: "credentials", target: repo.URL, err: err})
+	}
+
+	clusters, err := newClusterClients(kubeconfig, cfg.Clusters)
+	if err != nil {
+		return append(probes, probe{kind: "cluster", target: "kubeconfig", err: err})
+	}
+	// charts maps each repository in use to a chart deployed from it
+	charts := map[string]string{}
+	for _, cluster := range clusters {
+		target := valueOr(cluster.name, "local")
+		probes = append(probes, probe{kind: "cluster", target: target, detail: "Kubernetes " + valueOr(cluster.kubeVersion, "version unknown")})
+		scopeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		scopes, err := checkScopes(scopeCtx, cluster, cfg)
+		cancel()
+		if err != nil {
+			probes = append(probes, probe{kind: "applications", target: target, err: err})
+			continue
+		}
+		for _, scope := range scopes {
+			for _, namespace := range scope.namespaces(cluster) {
+				p := probe{kind: "applications", target: target + "/" + valueOr(namespace, "*")}
+				provider, err := applicationProvider(cluster, scope, namespace, cfg)
+				if err == nil {
+					listCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+					inUse, listErr := provider.ListChartsInUse(listCtx)
+					cancel()
+					err = listErr
+					for _, c := range inUse {
+						if c.Chart != "" && c.RepoURL != "" && charts[c.RepoURL] == "" {
+							charts[c.RepoURL] = c.Chart
+						}
+					}
+					p.detail = fmt.Sprintf("%d charts in use", len(inUse))
+				}
+				p.err = err
+				probes = append(probes, p)
+			}
+		}
+	}
+
+	repoURLs := make([]string, 0, len(charts))
+	for repoURL := range charts {
+		repoURLs = append(repoURLs, repoURL)
+	}
+	sort.Strings(repoURLs)
+	for _, repoURL := range repoURLs {
+		repoCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		count, err := probeRepository(repoCtx, cfg, repoURL, charts[repoURL])
+		cancel()
+		probes = append(probes, probe{kind: "repository", target: repoURL, detail: fmt.Sprintf("%d versions of %s", count, charts[repoURL]), err: err})
+	}
+	return probes
+}
+
+// probeRepository reads the versions of a chart as checks do, through the
+// mirror and alias of the repository
+func probeRepository(ctx context.Context, cfg *config, repoURL, chartName string) (int, error) {
+	if alias, ok := helmRepoAlias(repoURL); ok {
+		resolved, err := resolveHelmRepoAlias(cfg.Credentials, alias)
+		if err != nil {
+			return 0, err
+		}
+		repoURL = resolved
+	}
+	if !isOCIRepo(repoURL) && !strings.HasSuffix(repoURL, "/") {
+		repoURL += "/"
+	}
+	fetchURL := cfg.mirrorFor(repoURL)
+	if isOCIRepo(fetchURL) {
+		ref, err := parseOCIReference(fetchURL, chartName)
+		if err != nil {
+			return 0, err
+		}
+		versions, err := listOCIChartVersions(ctx, ref)
+		return len(versions), err
+	}
+	versions, err := getChartVersions(ctx, fetchURL, chartName)
+	return len(versions), err
+}
+
+// writeProbes writes a line per probe and returns how many failed
+func writeProbes(w io.Writer, probes []probe) int {
+	failed := 0
+	for _, p := range probes {
+		status, detail := "ok", p.detail
+		if p.err != nil {
+			status, detail = "FAIL", p.err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%-4s  %-12s  %s", status, p.kind, p.target)
+		if detail != "" {
+			fmt.Fprintf(w, ": %s", detail)
+		}
+		fmt.Fprintln(w)
+	}
+	return failed
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+var buildInfoGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_version_check_build_info",
+		Help: "Build information of the running exporter, always 1",
+	},
+	[]string{"version", "commit", "date", "goversion"},
+)
+
+func init() {
+	// go build records the VCS state when ldflags did not provide it
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && commit == "":
+				commit = s.Value
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+	buildInfoGauge.WithLabelValues(version, commit, date, runtime.Version()).Set(1)
+	prometheus.MustRegister(buildInfoGauge)
+}
+
+// versionString describes the build for --version
+func versionString() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s)", version, valueOr(commit, "unknown"), valueOr(date, "unknown"), runtime.Version())
+}
+
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var helmVersionReportsGVR = schema.GroupVersionResource{
+	Group:    "helmversioncheck.io",
+	Version:  "v1alpha1",
+	Resource: "helmversionreports",
+}
+
+const (
+	// fieldManager owns the fields of written resources and marks them as
+	// managed by the exporter
+	fieldManager   = "helm-version-check"
+	managedByLabel = "app.kubernetes.io/managed-by"
+	reportAppLabel = "helmversioncheck.io/application"
+)
+
+// versionReport is the report of a HelmVersionReport resource, named after
+// the Application and created in its namespace
+type versionReport struct {
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Summary     reportSummary `json:"summary"`
+	Charts      []chartResult `json:"charts"`
+}
+
+// reportSummary counts results by status
+type reportSummary struct {
+	Charts   int `json:"charts"`
+	UpToDate int `json:"upToDate"`
+	Outdated int `json:"outdated"`
+	Ahead    int `json:"ahead"`
+}
+
+func (s *reportSummary) add(r chartResult) {
+	s.Charts++
+	switch {
+	case r.Ahead:
+		s.Ahead++
+	case r.UpToDate:
+		s.UpToDate++
+	default:
+		s.Outdated++
+	}
+}
+
+// publishReports writes a HelmVersionReport for every checked Application
+// and deletes the reports of Applications no longer checked by this replica
+func publishReports(ctx context.Context, clusters []clusterClient, cfg *config, results []chartResult) {
+	written := writeReports(ctx, clusters, results)
+	checked := make(map[string]bool)
+	for _, r := range results {
+		checked[r.Cluster] = true
+	}
+	for _, cluster := range clusters {
+		// A cluster without results most likely failed to list Applications
+		if !checked[cluster.name] {
+			continue
+		}
+		list, err := cluster.client.Resource(helmVersionReportsGVR).Namespace("").List(ctx, metav1.ListOptions{
+			LabelSelector: managedByLabel + "=" + fieldManager,
+		})
+		if err != nil {
+			slog.Error("Error listing HelmVersionReports", "cluster", cluster.name, "error", err)
+			continue
+		}
+		for _, obj := range list.Items {
+			// Reports of other shards are left to them
+			if written[reportKey(cluster.name, obj.GetNamespace(), obj.GetName())] || !cfg.Sharding.owns(obj.GetName()) {
+				continue
+			}
+			err := cluster.client.Resource(helmVersionReportsGVR).Namespace(obj.GetNamespace()).Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+			if err != nil {
+				slog.Error("Error deleting HelmVersionReport", "cluster", cluster.name, "namespace", obj.GetNamespace(), "name", obj.GetName(), "error", err)
+				continue
+			}
+			slog.Debug("Deleted HelmVersionReport", "cluster", cluster.name, "namespace", obj.GetNamespace(), "name", obj.GetName())
+		}
+	}
+}
+
+// writeReports applies a HelmVersionReport for every Application in results
+// and returns the keys of those written
+func writeReports(ctx context.Context, clusters []clusterClient, results []chartResult) map[string]bool {
+	clients := make(map[string]clusterClient, len(clusters))
+	for _, cluster := range clusters {
+		clients[cluster.name] = cluster
+	}
+	reports := make(map[string]*versionReport)
+	var keys []string
+	for _, r := range results {
+		key := reportKey(r.Cluster, r.Namespace, r.Application)
+		report := reports[key]
+		if report == nil {
+			report = &versionReport{GeneratedAt: time.Now().UTC().Truncate(time.Second)}
+			reports[key] = report
+			keys = append(keys, key)
+		}
+		report.Charts = append(report.Charts, r)
+		report.Summary.add(r)
+	}
+
+	written := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		report := reports[key]
+		first := report.Charts[0]
+		cluster, ok := clients[first.Cluster]
+		if !ok || first.Namespace == "" {
+			continue
+		}
+		obj := map[string]any{
+			"apiVersion": helmVersionReportsGVR.GroupVersion().String(),
+			"kind":       "HelmVersionReport",
+			"metadata": map[string]any{
+				"name":      first.Application,
+				"namespace": first.Namespace,
+				"labels": map[string]string{
+					managedByLabel: fieldManager,
+					reportAppLabel: first.Application,
+				},
+			},
+			"report": report,
+		}
+		data, err := json.Marshal(obj)
+		if err != nil {
+			slog.Error("Error encoding HelmVersionReport", "application", first.Application, "error", err)
+			continue
+		}
+		force := true
+		_, err = cluster.client.Resource(helmVersionReportsGVR).Namespace(first.Namespace).Patch(ctx, first.Application,
+			types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+		if err != nil {
+			slog.Error("Error writing HelmVersionReport", "cluster", first.Cluster, "namespace", first.Namespace,
+				"application", first.Application, "error", err)
+			continue
+		}
+		written[key] = true
+	}
+	return written
+}
+
+func reportKey(cluster, namespace, app string) string {
+	return cluster + "|" + namespace + "/" + app
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/caseyrobb/helm-version-check/pkg/check"
+	"github.com/caseyrobb/helm-version-check/pkg/metrics"
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+var nonSemverGauge = metrics.NewExpiringGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_chart_nonsemver_versions",
+		Help: "Versions of a chart in its repository that are not valid semver",
+	},
+	[]string{"chart", "repo_url"},
+	15*time.Minute,
+)
+
+var versionsBehindGauge = metrics.NewExpiringGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "helm_chart_versions_behind",
+		Help: "Major, minor and patch levels the current chart version lags the latest, by level",
+	},
+	[]string{"application", "chart", "repo_url", "cluster", "argocd_instance", "destination_cluster", "destination_namespace", "source", "level"},
+	15*time.Minute,
+)
+
+// newVersionsCounter counts the latest versions seen for the first time,
+// for dashboards of the rate of upstream releases and alerts on them
+var newVersionsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "helm_chart_new_version_detected_total",
+		Help: "Times a newer latest version of the chart of an application was detected than in the previous check",
+	},
+	[]string{"application", "chart", "repo_url", "cluster", "argocd_instance", "source"},
+)
+
+func init() {
+	prometheus.MustRegister(nonSemverGauge)
+	prometheus.MustRegister(versionsBehindGauge)
+	prometheus.MustRegister(newVersionsCounter)
+}
+
+// recordNewVersions counts the charts whose latest version is newer than in
+// previous; charts seen for the first time are not counted
+func recordNewVersions(previous, current []chartResult) {
+	before := make(map[string]string, len(previous))
+	for _, r := range previous {
+		before[resultKey(r)] = r.LatestVersion
+	}
+	for _, r := range current {
+		latest, seen := before[resultKey(r)]
+		if !seen || latest == "" || check.Same(latest, r.LatestVersion) {
+			continue
+		}
+		cmp, ok := check.Compare(repository.Entry{Version: r.LatestVersion}, repository.Entry{Version: latest}, check.OrderingLenient)
+		if ok && cmp > 0 {
+			newVersionsCounter.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.Cluster, r.Instance, r.Source).Inc()
+		}
+	}
+}
+
+// recordVersionsBehind sets the gauge of the levels a result lags by
+func recordVersionsBehind(r chartResult) {
+	d := r.Behind
+	if d == nil {
+		return
+	}
+	for level, n := range map[string]uint64{"major": d.Major, "minor": d.Minor, "patch": d.Patch} {
+		versionsBehindGauge.WithLabelValues(r.Application, r.Chart, r.RepoURL, r.Cluster, r.Instance, r.DestinationCluster, r.DestinationNamespace, r.Source, level).Set(float64(n))
+	}
+}
+
+// recordNonSemver sets the gauge of versions of a chart that policy cannot
+// parse as semver
+func recordNonSemver(repoURL, chartName string, versions []repository.Entry, policy check.SemverPolicy) {
+	count := 0
+	for _, v := range versions {
+		if _, err := policy.Parse(v.Version); err != nil {
+			count++
+		}
+	}
+	nonSemverGauge.WithLabelValues(chartName, repoURL).Set(float64(count))
+}
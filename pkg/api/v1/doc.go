@@ -0,0 +1,4 @@
+// Package apiv1 is the gRPC API of check results, generated from check.proto
+package apiv1
+
+//go:generate protoc -I ../../.. --go_out=../../.. --go_opt=paths=source_relative --go-grpc_out=../../.. --go-grpc_opt=paths=source_relative pkg/api/v1/check.proto
@@ -0,0 +1,100 @@
+// Package argocd reads the sources and destinations of Argo CD Applications
+package argocd
+
+import (
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/caseyrobb/helm-version-check/pkg/source"
+)
+
+// ApplicationsGVR is the resource of Argo CD Applications
+var ApplicationsGVR = schema.GroupVersionResource{
+	Group:    "argoproj.io",
+	Version:  "v1alpha1",
+	Resource: "applications",
+}
+
+// EnabledAnnotation set to false on an Application opts it out of checks
+const EnabledAnnotation = "helm-version-check/enabled"
+
+// Enabled reports whether app is left in checks by its annotation, with an
+// error when the annotation is not a boolean
+func Enabled(app unstructured.Unstructured) (bool, error) {
+	value, ok := app.GetAnnotations()[EnabledAnnotation]
+	if !ok {
+		return true, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// Destination returns the cluster an Application deploys to, by name or else
+// server URL, and its namespace
+func Destination(app unstructured.Unstructured) (cluster, namespace string) {
+	spec, _ := app.Object["spec"].(map[string]interface{})
+	if destination, ok := spec["destination"].(map[string]interface{}); ok {
+		namespace, _ = destination["namespace"].(string)
+		if cluster, _ = destination["name"].(string); cluster == "" {
+			cluster, _ = destination["server"].(string)
+		}
+	}
+	return cluster, namespace
+}
+
+// Sources returns spec.source and the entries of spec.sources of an
+// Application, skipping those that are not objects
+func Sources(app unstructured.Unstructured) []map[string]interface{} {
+	spec, _ := app.Object["spec"].(map[string]interface{})
+	var sources []map[string]interface{}
+	if source, ok := spec["source"].(map[string]interface{}); ok {
+		sources = append(sources, source)
+	}
+	if list, ok := spec["sources"].([]interface{}); ok {
+		for _, src := range list {
+			if source, ok := src.(map[string]interface{}); ok {
+				sources = append(sources, source)
+			}
+		}
+	}
+	return sources
+}
+
+// Charts returns the charts deployed by the sources of an Application, with
+// Chart empty for sources deploying a path. Entries of spec.sources are
+// identified by their name, or else their position.
+func Charts(app unstructured.Unstructured) []source.Chart {
+	destCluster, destNamespace := Destination(app)
+	spec, _ := app.Object["spec"].(map[string]interface{})
+	project, _ := spec["project"].(string)
+	first := 0
+	if _, ok := spec["source"].(map[string]interface{}); ok {
+		first = 1
+	}
+	var charts []source.Chart
+	for i, src := range Sources(app) {
+		chart := source.Chart{
+			Application:          app.GetName(),
+			Namespace:            app.GetNamespace(),
+			Labels:               app.GetLabels(),
+			Project:              project,
+			DestinationCluster:   destCluster,
+			DestinationNamespace: destNamespace,
+			Source:               src,
+		}
+		chart.Chart, _ = src["chart"].(string)
+		chart.RepoURL, _ = src["repoURL"].(string)
+		chart.Version, _ = src["targetRevision"].(string)
+		if i < first {
+			chart.Path = "/spec/source"
+		} else {
+			chart.Path = "/spec/sources/" + strconv.Itoa(i-first)
+			if chart.SourceID, _ = src["name"].(string); chart.SourceID == "" {
+				chart.SourceID = strconv.Itoa(i - first)
+			}
+		}
+		charts = append(charts, chart)
+	}
+	return charts
+}
@@ -0,0 +1,93 @@
+package argocd
+
+import (
+	"context"
+	"log/slog"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/caseyrobb/helm-version-check/pkg/source"
+)
+
+// Provider lists the charts of the Argo CD Applications of a namespace
+type Provider struct {
+	Client dynamic.Interface
+	// Namespace is empty for all namespaces
+	Namespace string
+	Selector  string
+	// PageSize bounds the Applications listed per request; zero lists all
+	PageSize int64
+	// Filter, when set, skips the Applications it returns false for
+	Filter func(unstructured.Unstructured) bool
+	// Skipped, when set, is called for each Application skipped, with the
+	// reason: disabled by annotation or filtered
+	Skipped func(reason string)
+}
+
+var _ source.ApplicationWalker = (*Provider)(nil)
+
+// Reasons for which providers skip Applications
+const (
+	SkipDisabled = "disabled"
+	SkipFiltered = "filtered"
+)
+
+// ListChartsInUse returns the charts of the Applications that are not
+// disabled by annotation
+func (p *Provider) ListChartsInUse(ctx context.Context) ([]source.Chart, error) {
+	var charts []source.Chart
+	err := p.WalkApplications(ctx, func(_ string, app []source.Chart) error {
+		charts = append(charts, app...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return charts, nil
+}
+
+// WalkApplications calls fn with the charts of each Application that is not
+// disabled by annotation, listed in pages so only one is held in memory
+func (p *Provider) WalkApplications(ctx context.Context, fn func(name string, charts []source.Chart) error) error {
+	opts := metav1.ListOptions{LabelSelector: p.Selector, Limit: p.PageSize}
+	for {
+		list, err := p.Client.Resource(ApplicationsGVR).Namespace(p.Namespace).List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, app := range list.Items {
+			if !included(app, p.Filter, p.Skipped) {
+				continue
+			}
+			if err := fn(app.GetName(), Charts(app)); err != nil {
+				return err
+			}
+		}
+		if opts.Continue = list.GetContinue(); opts.Continue == "" {
+			return nil
+		}
+	}
+}
+
+// included reports whether app is checked: not disabled by annotation and
+// not skipped by filter, reporting why to skipped otherwise
+func included(app unstructured.Unstructured, filter func(unstructured.Unstructured) bool, skipped func(string)) bool {
+	if enabled, err := Enabled(app); err != nil {
+		slog.Warn("Ignoring invalid annotation", "application", app.GetName(), "annotation", EnabledAnnotation, "value", app.GetAnnotations()[EnabledAnnotation])
+	} else if !enabled {
+		slog.Debug("Skipping application disabled by annotation", "application", app.GetName())
+		if skipped != nil {
+			skipped(SkipDisabled)
+		}
+		return false
+	}
+	if filter != nil && !filter(app) {
+		if skipped != nil {
+			skipped(SkipFiltered)
+		}
+		return false
+	}
+	return true
+}
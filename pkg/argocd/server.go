@@ -0,0 +1,146 @@
+package argocd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/caseyrobb/helm-version-check/pkg/source"
+)
+
+// ServerProvider lists the charts of the Applications of a namespace through
+// the Argo CD API server with an account token, for setups where the
+// Kubernetes API cannot be used to read Applications
+type ServerProvider struct {
+	// URL is the base URL of the API server, such as https://argocd.example.com
+	URL string
+	// Token returns the bearer token of each request, such as that of a
+	// local account with get permission on applications
+	Token func() (string, error)
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+	// Namespace is empty for the Applications of every namespace the server
+	// watches
+	Namespace string
+	Selector  string
+	// Filter, when set, skips the Applications it returns false for
+	Filter func(unstructured.Unstructured) bool
+	// Skipped, when set, is called for each Application skipped, with the
+	// reason: disabled by annotation or filtered
+	Skipped func(reason string)
+}
+
+var _ source.ApplicationWalker = (*ServerProvider)(nil)
+
+// ListChartsInUse returns the charts of the Applications that are not
+// disabled by annotation
+func (p *ServerProvider) ListChartsInUse(ctx context.Context) ([]source.Chart, error) {
+	var charts []source.Chart
+	err := p.WalkApplications(ctx, func(_ string, app []source.Chart) error {
+		charts = append(charts, app...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return charts, nil
+}
+
+// WalkApplications calls fn with the charts of each Application that is not
+// disabled by annotation; the server lists them in a single response
+func (p *ServerProvider) WalkApplications(ctx context.Context, fn func(name string, charts []source.Chart) error) error {
+	query := url.Values{}
+	if p.Selector != "" {
+		query.Set("selector", p.Selector)
+	}
+	if p.Namespace != "" {
+		query.Set("appNamespace", p.Namespace)
+	}
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := p.get(ctx, "/api/v1/applications", query, &list); err != nil {
+		return err
+	}
+	for _, obj := range list.Items {
+		app := unstructured.Unstructured{Object: obj}
+		if !included(app, p.Filter, p.Skipped) {
+			continue
+		}
+		if err := fn(app.GetName(), Charts(app)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns the Application name, with a Kubernetes not found error when
+// the server does not know it
+func (p *ServerProvider) Get(ctx context.Context, name string) (*unstructured.Unstructured, error) {
+	query := url.Values{}
+	if p.Namespace != "" {
+		query.Set("appNamespace", p.Namespace)
+	}
+	var obj map[string]interface{}
+	if err := p.get(ctx, "/api/v1/applications/"+url.PathEscape(name), query, &obj); err != nil {
+		var status *statusError
+		if errors.As(err, &status) && status.code == http.StatusNotFound {
+			return nil, apierrors.NewNotFound(ApplicationsGVR.GroupResource(), name)
+		}
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// get decodes the JSON response of an API server path into v
+func (p *ServerProvider) get(ctx context.Context, path string, query url.Values, v any) error {
+	token, err := p.Token()
+	if err != nil {
+		return fmt.Errorf("reading Argo CD token: %w", err)
+	}
+	endpoint := strings.TrimSuffix(p.URL, "/") + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &statusError{code: resp.StatusCode, status: resp.Status, message: strings.TrimSpace(string(body))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// statusError is an unexpected status of the API server
+type statusError struct {
+	code            int
+	status, message string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("argo cd server returned %s: %s", e.status, e.message)
+}
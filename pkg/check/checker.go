@@ -0,0 +1,157 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// Checker finds the latest version of charts and compares deployed versions
+// with it
+type Checker struct {
+	Resolver repository.Resolver
+	// Ordering orders versions that are not semver, one of the orderings or
+	// empty to only order semver versions
+	Ordering string
+	// ByCreated takes the newest created version as the latest, such as for
+	// repositories whose versions are not ordered
+	ByCreated bool
+	// IgnorePrereleases leaves out prereleases, by semver or annotation
+	IgnorePrereleases bool
+	Semver            SemverPolicy
+	// ChannelAnnotation is the annotation of index entries naming their
+	// channel, empty to ignore channels
+	ChannelAnnotation string
+	// Channels are those versions are taken from, by default the channel of
+	// the current version; versions without a channel are always taken
+	Channels []string
+	// Accept, when set, tells whether a version of chartName may be taken as
+	// the latest when current is deployed; the current version always may
+	Accept func(chartName, current string, candidate repository.Entry) bool
+}
+
+// PrereleaseAnnotation marks an index entry as a prerelease whatever its
+// version, as Artifact Hub does
+const PrereleaseAnnotation = "artifacthub.io/prerelease"
+
+// IsPrerelease reports whether v is a semver prerelease or annotated as one
+func IsPrerelease(v repository.Entry) bool {
+	if v.Annotations[PrereleaseAnnotation] == "true" {
+		return true
+	}
+	parsed, err := semver.NewVersion(v.Version)
+	return err == nil && parsed.Prerelease() != ""
+}
+
+// Latest returns the latest version of chartName in repoURL and whether
+// current is still published
+func (c Checker) Latest(ctx context.Context, repoURL, chartName, current string) (repository.Entry, bool, error) {
+	versions, err := c.Resolver.Versions(ctx, repoURL, chartName)
+	if err != nil {
+		return repository.Entry{}, false, err
+	}
+	if len(versions) == 0 {
+		return repository.Entry{}, false, fmt.Errorf("chart %s has no versions", chartName)
+	}
+	i := slices.IndexFunc(versions, func(v repository.Entry) bool { return Same(v.Version, current) })
+	published := i >= 0
+	var deployed repository.Entry
+	if published {
+		deployed = versions[i]
+	}
+
+	if channels := c.channels(deployed); len(channels) > 0 {
+		var inChannel []repository.Entry
+		for _, v := range versions {
+			if ch := v.Annotations[c.ChannelAnnotation]; ch == "" || slices.Contains(channels, ch) {
+				inChannel = append(inChannel, v)
+			}
+		}
+		if len(inChannel) == 0 {
+			return repository.Entry{}, false, fmt.Errorf("chart %s has no versions in channels %s", chartName, strings.Join(channels, ", "))
+		}
+		versions = inChannel
+	}
+
+	if c.IgnorePrereleases || c.Semver.StableTracksStable && c.Semver.isStable(current) && !(published && IsPrerelease(deployed)) {
+		var stable []repository.Entry
+		for _, v := range versions {
+			if !IsPrerelease(v) {
+				stable = append(stable, v)
+			}
+		}
+		if len(stable) == 0 {
+			return repository.Entry{}, false, fmt.Errorf("chart %s has no stable versions", chartName)
+		}
+		versions = stable
+	}
+
+	if c.Accept != nil {
+		var accepted []repository.Entry
+		for _, v := range versions {
+			if Same(v.Version, current) || c.Accept(chartName, current, v) {
+				accepted = append(accepted, v)
+			}
+		}
+		if len(accepted) == 0 {
+			return repository.Entry{}, false, fmt.Errorf("chart %s has no versions accepted by policy", chartName)
+		}
+		versions = accepted
+	}
+
+	if c.ByCreated {
+		if latest, ok := NewestCreated(versions); ok {
+			slog.Debug("Determined latest version by created date", "chart", chartName, "version", latest.Version)
+			return latest, published, nil
+		}
+		slog.Debug("No created dates, ordering by version", "chart", chartName, "repo_url", repoURL)
+	}
+
+	latest := versions[0]
+	for _, v := range versions[1:] {
+		if cmp, ok := c.Semver.Compare(v, latest, c.Ordering); ok && cmp > 0 {
+			latest = v
+		}
+	}
+	slog.Debug("Determined latest version", "chart", chartName, "version", latest.Version)
+	return latest, published, nil
+}
+
+// Status reports whether current is the latest version or ahead of it, with
+// ok false when the versions cannot be compared
+func (c Checker) Status(current string, latest repository.Entry) (upToDate, ahead, ok bool) {
+	if c.ByCreated && !latest.Created.IsZero() {
+		// A lower version published later is the latest, so nothing is ahead
+		cmp, ok := c.Semver.Compare(repository.Entry{Version: current}, repository.Entry{Version: latest.Version}, "")
+		return ok && cmp == 0, false, true
+	}
+	cmp, ok := c.Semver.Compare(repository.Entry{Version: current}, latest, c.Ordering)
+	return ok && cmp == 0, ok && cmp > 0, ok
+}
+
+// channels returns the channels versions are taken from when deployed is
+// the entry of the current version
+func (c Checker) channels(deployed repository.Entry) []string {
+	if c.ChannelAnnotation == "" {
+		return nil
+	}
+	if len(c.Channels) > 0 {
+		return c.Channels
+	}
+	if ch := deployed.Annotations[c.ChannelAnnotation]; ch != "" {
+		return []string{ch}
+	}
+	return nil
+}
+
+// isStable reports whether version is semver without a prerelease
+func (p SemverPolicy) isStable(version string) bool {
+	v, err := p.Parse(version)
+	return err == nil && v.Prerelease() == ""
+}
@@ -0,0 +1,38 @@
+package check
+
+import (
+	"context"
+	"testing"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+func TestCheckerLatest(t *testing.T) {
+	published := repository.ResolverFunc(func(context.Context, string, string) ([]repository.Entry, error) {
+		return []repository.Entry{{Version: "1.2.3"}, {Version: "1.3.0-rc.1"}, {Version: "1.2.4"}}, nil
+	})
+	tests := []struct {
+		name    string
+		policy  SemverPolicy
+		current string
+		want    string
+	}{
+		{name: "prereleases taken", current: "1.2.3", want: "1.3.0-rc.1"},
+		{name: "stable tracks stable", policy: SemverPolicy{StableTracksStable: true}, current: "1.2.3", want: "1.2.4"},
+		{name: "prerelease tracks prereleases", policy: SemverPolicy{StableTracksStable: true}, current: "1.3.0-rc.0", want: "1.3.0-rc.1"},
+		{name: "lenient stable tracks stable", policy: SemverPolicy{StableTracksStable: true, Lenient: true}, current: "V1.2.3", want: "1.2.4"},
+		{name: "lenient four segments", policy: SemverPolicy{StableTracksStable: true, Lenient: true}, current: "1.2.3.1", want: "1.2.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Checker{Resolver: published, Semver: tt.policy}
+			got, _, err := c.Latest(context.Background(), "https://charts.example.com", "nginx", tt.current)
+			if err != nil {
+				t.Fatalf("Latest() error = %v", err)
+			}
+			if got.Version != tt.want {
+				t.Errorf("Latest(%q) = %s, want %s", tt.current, got.Version, tt.want)
+			}
+		})
+	}
+}
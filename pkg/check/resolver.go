@@ -0,0 +1,80 @@
+package check
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// Verifier tells whether a chart version is trusted, such as by a provenance
+// file or a cosign signature, returning why not otherwise
+type Verifier interface {
+	Verify(ctx context.Context, repoURL, chart string, version repository.Entry) error
+}
+
+// VerifierFunc adapts a function to a Verifier
+type VerifierFunc func(ctx context.Context, repoURL, chart string, version repository.Entry) error
+
+// Verify calls f
+func (f VerifierFunc) Verify(ctx context.Context, repoURL, chart string, version repository.Entry) error {
+	return f(ctx, repoURL, chart, version)
+}
+
+// Resolution is the latest version of a chart a Resolver found
+type Resolution struct {
+	Latest repository.Entry
+	// Newest is the latest version before verification, and Verified
+	// whether it verified; both are unset without a verifier
+	Newest   string
+	Verified bool
+	// Published is whether the current version is still published
+	Published bool
+}
+
+// Resolver finds the latest version of charts as Checker does. With a
+// Verifier only versions that verify are upgrade candidates: it steps back
+// from the latest version to the newest one that verifies, falling back to
+// the current version.
+type Resolver struct {
+	Checker  Checker
+	Verifier Verifier
+}
+
+// Latest returns the latest version of chartName in repoURL that may be
+// upgraded to from current
+func (r Resolver) Latest(ctx context.Context, repoURL, chartName, current string) (Resolution, error) {
+	latest, published, err := r.Checker.Latest(ctx, repoURL, chartName, current)
+	if err != nil || r.Verifier == nil {
+		return Resolution{Latest: latest, Published: published}, err
+	}
+	res := Resolution{Newest: latest.Version, Published: published}
+	checker := r.Checker
+	rejected := map[string]bool{}
+	checker.Accept = func(chartName, current string, candidate repository.Entry) bool {
+		return !rejected[candidate.Version] && (r.Checker.Accept == nil || r.Checker.Accept(chartName, current, candidate))
+	}
+	for {
+		err := r.Verifier.Verify(ctx, repoURL, chartName, latest)
+		if err == nil {
+			slog.Debug("Verified version", "chart", chartName, "version", latest.Version)
+			res.Latest, res.Verified = latest, latest.Version == res.Newest
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return Resolution{}, ctx.Err()
+		}
+		if upToDate, ahead, _ := checker.Status(current, latest); upToDate || ahead {
+			res.Latest = latest
+			return res, nil
+		}
+		slog.Warn("Version not verified, skipping it", "chart", chartName, "version", latest.Version, "error", err)
+		rejected[latest.Version] = true
+		latest, _, err = checker.Latest(ctx, repoURL, chartName, current)
+		if _, ahead, _ := checker.Status(current, latest); err != nil || ahead {
+			slog.Debug("No verified upgrade candidate", "chart", chartName, "version", current)
+			res.Latest = repository.Entry{Version: current}
+			return res, nil
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// fakeVerifier trusts the versions it lists and records those it was asked about
+type fakeVerifier struct {
+	trusted map[string]bool
+	asked   []string
+}
+
+func (f *fakeVerifier) Verify(_ context.Context, _, _ string, v repository.Entry) error {
+	f.asked = append(f.asked, v.Version)
+	if !f.trusted[v.Version] {
+		return errors.New("unsigned")
+	}
+	return nil
+}
+
+func TestResolverLatest(t *testing.T) {
+	published := repository.ResolverFunc(func(context.Context, string, string) ([]repository.Entry, error) {
+		return []repository.Entry{{Version: "1.0.0"}, {Version: "1.1.0"}, {Version: "1.2.0"}, {Version: "2.0.0"}}, nil
+	})
+	tests := []struct {
+		name         string
+		current      string
+		trusted      []string
+		noVerifier   bool
+		accept       func(chartName, current string, candidate repository.Entry) bool
+		want         string
+		wantNewest   string
+		wantVerified bool
+		wantAsked    int
+	}{
+		{name: "no verifier", current: "1.0.0", noVerifier: true, want: "2.0.0"},
+		{name: "newest verified", current: "1.0.0", trusted: []string{"2.0.0", "1.2.0"}, want: "2.0.0", wantNewest: "2.0.0", wantVerified: true, wantAsked: 1},
+		{name: "steps back", current: "1.0.0", trusted: []string{"1.1.0"}, want: "1.1.0", wantNewest: "2.0.0", wantAsked: 3},
+		{name: "keeps current", current: "1.1.0", trusted: []string{"1.0.0"}, want: "1.1.0", wantNewest: "2.0.0", wantAsked: 3},
+		{name: "current unpublished", current: "1.1.5", want: "1.1.5", wantNewest: "2.0.0", wantAsked: 2},
+		{
+			name:       "rejected by policy",
+			current:    "1.0.0",
+			trusted:    []string{"2.0.0", "1.2.0"},
+			accept:     func(_, _ string, v repository.Entry) bool { return v.Version != "2.0.0" },
+			want:       "1.2.0",
+			wantNewest: "1.2.0", wantVerified: true, wantAsked: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := &fakeVerifier{trusted: map[string]bool{}}
+			for _, v := range tt.trusted {
+				verifier.trusted[v] = true
+			}
+			r := Resolver{Checker: Checker{Resolver: published, Accept: tt.accept}}
+			if !tt.noVerifier {
+				r.Verifier = verifier
+			}
+			got, err := r.Latest(context.Background(), "https://charts.example.com", "nginx", tt.current)
+			if err != nil {
+				t.Fatalf("Latest() error = %v", err)
+			}
+			if got.Latest.Version != tt.want || got.Newest != tt.wantNewest || got.Verified != tt.wantVerified {
+				t.Errorf("Latest() = %s, %q, %t, want %s, %q, %t", got.Latest.Version, got.Newest, got.Verified, tt.want, tt.wantNewest, tt.wantVerified)
+			}
+			if len(verifier.asked) != tt.wantAsked {
+				t.Errorf("Latest() verified %v, want %d versions", verifier.asked, tt.wantAsked)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+// Package check compares deployed chart versions with those published in
+// their repositories
+package check
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+// Orderings of chart versions that are not semver
+const (
+	// OrderingLenient compares numeric and other parts in turn, so 1.2.3.10
+	// is newer than 1.2.3.9
+	OrderingLenient = "lenient"
+	OrderingLexical = "lexical"
+	// OrderingCreated compares the created dates of index entries
+	OrderingCreated = "created"
+)
+
+// Delta is how far a version lags the latest. Lower levels count from the
+// latest version's value once a higher level differs, so 1.2.3 lags 2.0.1 by
+// 1 major, 0 minor and 1 patch levels.
+type Delta struct {
+	Major uint64 `json:"major"`
+	Minor uint64 `json:"minor"`
+	Patch uint64 `json:"patch"`
+}
+
+// SemverDelta returns how far current lags latest, zero when it does not, or
+// nil when either is not semver
+func SemverDelta(current, latest string) *Delta {
+	return SemverPolicy{}.Delta(current, latest)
+}
+
+// Delta is SemverDelta with versions parsed by the policy
+func (p SemverPolicy) Delta(current, latest string) *Delta {
+	cur, err := p.Parse(current)
+	if err != nil {
+		return nil
+	}
+	lat, err := p.Parse(latest)
+	if err != nil {
+		return nil
+	}
+	delta := &Delta{}
+	switch {
+	case !lat.GreaterThan(cur):
+	case lat.Major() != cur.Major():
+		delta.Major, delta.Minor, delta.Patch = lat.Major()-cur.Major(), lat.Minor(), lat.Patch()
+	case lat.Minor() != cur.Minor():
+		delta.Minor, delta.Patch = lat.Minor()-cur.Minor(), lat.Patch()
+	case lat.Patch() != cur.Patch():
+		delta.Patch = lat.Patch() - cur.Patch()
+	}
+	return delta
+}
+
+// SemverPolicy tunes how semver versions are ordered; the zero value orders
+// them as the semver specification does
+type SemverPolicy struct {
+	// PrereleasesAfter orders prereleases after the release of their base
+	// version, for publishers numbering later builds such as 1.2.0-hotfix.1
+	PrereleasesAfter bool
+	// CompareMetadata orders versions differing only by build metadata by
+	// comparing it leniently instead of treating them as equal
+	CompareMetadata bool
+	// StableTracksStable compares a stable current version only with stable
+	// versions, so it is never behind a prerelease
+	StableTracksStable bool
+	// Lenient parses versions that are not strict semver, such as V1.2.3,
+	// 1.2.3.4 or 1.2.3-rc.01, once normalized. A fourth segment becomes build
+	// metadata, which is then compared leniently so 1.2.3.10 is newer than
+	// 1.2.3.9.
+	Lenient bool
+}
+
+// Parse parses a semver version, normalizing it first when it is not strict
+// semver and the policy is lenient, in which case Original returns the
+// normalized string
+func (p SemverPolicy) Parse(version string) (*semver.Version, error) {
+	v, err := semver.NewVersion(version)
+	if err == nil || !p.Lenient {
+		return v, err
+	}
+	normalized, nerr := semver.NewVersion(Normalize(version))
+	if nerr != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// Normalize rewrites common non-strict versions as semver: it drops a
+// leading v or V and leading zeros of numeric identifiers, and moves a fourth
+// numeric segment to the front of the build metadata, so V01.2.3.4-rc.01
+// becomes 1.2.3-rc.1+4
+func Normalize(version string) string {
+	version = strings.TrimLeft(version, "vV")
+	version, metadata, hasMetadata := strings.Cut(version, "+")
+	core, prerelease, hasPrerelease := strings.Cut(version, "-")
+	segments := strings.Split(core, ".")
+	if len(segments) == 4 && isNumeric(segments[3]) {
+		extra := trimZeros(segments[3])
+		if hasMetadata {
+			metadata = extra + "." + metadata
+		} else {
+			metadata, hasMetadata = extra, true
+		}
+		segments = segments[:3]
+	}
+	for i, s := range segments {
+		segments[i] = trimZeros(s)
+	}
+	normalized := strings.Join(segments, ".")
+	if hasPrerelease {
+		ids := strings.Split(prerelease, ".")
+		for i, id := range ids {
+			ids[i] = trimZeros(id)
+		}
+		normalized += "-" + strings.Join(ids, ".")
+	}
+	if hasMetadata {
+		normalized += "+" + metadata
+	}
+	return normalized
+}
+
+// trimZeros drops the leading zeros of a numeric identifier
+func trimZeros(id string) string {
+	if !isNumeric(id) {
+		return id
+	}
+	if trimmed := strings.TrimLeft(id, "0"); trimmed != "" {
+		return trimmed
+	}
+	return "0"
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareSemver returns -1, 0 or 1 as a is older than, equal to or newer
+// than b under the policy
+func (p SemverPolicy) CompareSemver(a, b *semver.Version) int {
+	if p.PrereleasesAfter && (a.Prerelease() == "") != (b.Prerelease() == "") {
+		if cmp := semver.New(a.Major(), a.Minor(), a.Patch(), "", "").Compare(semver.New(b.Major(), b.Minor(), b.Patch(), "", "")); cmp != 0 {
+			return cmp
+		}
+		if a.Prerelease() != "" {
+			return 1
+		}
+		return -1
+	}
+	cmp := a.Compare(b)
+	if cmp == 0 && (p.CompareMetadata || p.Lenient) && a.Metadata() != b.Metadata() {
+		return CompareLenient(a.Metadata(), b.Metadata())
+	}
+	return cmp
+}
+
+// Compare returns -1, 0 or 1 as a is older than, equal to or newer than b.
+// Semver versions are compared as such and are newer than others. Without
+// an ordering other versions are only equal when identical, and ok is false
+// when they cannot be ordered.
+func Compare(a, b repository.Entry, ordering string) (cmp int, ok bool) {
+	return SemverPolicy{}.Compare(a, b, ordering)
+}
+
+// Compare is the package Compare with semver versions ordered by the policy
+func (p SemverPolicy) Compare(a, b repository.Entry, ordering string) (cmp int, ok bool) {
+	av, aErr := p.Parse(a.Version)
+	bv, bErr := p.Parse(b.Version)
+	switch {
+	case aErr == nil && bErr == nil:
+		return p.CompareSemver(av, bv), true
+	case a.Version == b.Version:
+		return 0, true
+	}
+	switch ordering {
+	case OrderingLenient:
+		return CompareLenient(a.Version, b.Version), true
+	case OrderingLexical:
+		return strings.Compare(a.Version, b.Version), true
+	case OrderingCreated:
+		// Entries without a date, such as deployed versions, compare leniently
+		if !a.Created.IsZero() && !b.Created.IsZero() && !a.Created.Equal(b.Created) {
+			if a.Created.After(b.Created) {
+				return 1, true
+			}
+			return -1, true
+		}
+		return CompareLenient(a.Version, b.Version), true
+	}
+	switch {
+	case aErr == nil:
+		return 1, true
+	case bErr == nil:
+		return -1, true
+	}
+	return 0, false
+}
+
+// CompareLenient compares versions as runs of digits, compared as numbers,
+// and runs of other characters, compared as strings, ignoring a leading v
+func CompareLenient(a, b string) int {
+	ap, bp := versionParts(strings.TrimPrefix(a, "v")), versionParts(strings.TrimPrefix(b, "v"))
+	for i := 0; i < len(ap) && i < len(bp); i++ {
+		an, aErr := strconv.ParseUint(ap[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bp[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an > bn {
+					return 1
+				}
+				return -1
+			}
+		case ap[i] != bp[i]:
+			return strings.Compare(ap[i], bp[i])
+		}
+	}
+	switch {
+	case len(ap) > len(bp):
+		return 1
+	case len(ap) < len(bp):
+		return -1
+	}
+	return 0
+}
+
+// versionParts splits a version into runs of digits and runs of letters,
+// dropping separators
+func versionParts(version string) []string {
+	var parts []string
+	start := -1
+	for i, r := range version {
+		if start >= 0 && (!isVersionRune(r) || unicode.IsDigit(r) != unicode.IsDigit(rune(version[start]))) {
+			parts = append(parts, version[start:i])
+			start = -1
+		}
+		if start < 0 && isVersionRune(r) {
+			start = i
+		}
+	}
+	if start >= 0 {
+		parts = append(parts, version[start:])
+	}
+	return parts
+}
+
+func isVersionRune(r rune) bool {
+	return unicode.IsDigit(r) || unicode.IsLetter(r)
+}
+
+// NewestCreated returns the entry with the newest created date, ignoring
+// entries without one
+func NewestCreated(versions []repository.Entry) (repository.Entry, bool) {
+	var newest repository.Entry
+	found := false
+	for _, v := range versions {
+		if !v.Created.IsZero() && (!found || v.Created.After(newest.Created)) {
+			newest, found = v, true
+		}
+	}
+	return newest, found
+}
+
+// Same reports whether two versions are identical or equal as semver, such
+// as v1.2.3 and 1.2.3
+func Same(a, b string) bool {
+	if a == b {
+		return true
+	}
+	av, aErr := semver.NewVersion(a)
+	bv, bErr := semver.NewVersion(b)
+	return aErr == nil && bErr == nil && av.Equal(bv) && av.Metadata() == bv.Metadata()
+}
+
+// IsConstraint reports whether a targetRevision is a range such as "*",
+// "1.x" or ">=2.0.0" rather than a single version
+func IsConstraint(revision string) bool {
+	if _, err := semver.NewVersion(revision); err == nil {
+		return false
+	}
+	_, err := semver.NewConstraint(revision)
+	return err == nil
+}
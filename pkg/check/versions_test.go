@@ -0,0 +1,77 @@
+package check
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+func TestSemverPolicyCompare(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(24 * time.Hour)
+	tests := []struct {
+		name     string
+		policy   SemverPolicy
+		a, b     repository.Entry
+		ordering string
+		want     int
+		wantOK   bool
+	}{
+		{name: "older patch", a: repository.Entry{Version: "1.2.3"}, b: repository.Entry{Version: "1.2.4"}, want: -1, wantOK: true},
+		{name: "numeric minor", a: repository.Entry{Version: "1.10.0"}, b: repository.Entry{Version: "1.9.0"}, want: 1, wantOK: true},
+		{name: "equal", a: repository.Entry{Version: "1.2.3"}, b: repository.Entry{Version: "v1.2.3"}, want: 0, wantOK: true},
+		{name: "prerelease before release", a: repository.Entry{Version: "1.2.0-rc.1"}, b: repository.Entry{Version: "1.2.0"}, want: -1, wantOK: true},
+		{name: "prerelease after release", policy: SemverPolicy{PrereleasesAfter: true}, a: repository.Entry{Version: "1.2.0-hotfix.1"}, b: repository.Entry{Version: "1.2.0"}, want: 1, wantOK: true},
+		{name: "prerelease after release of lower base", policy: SemverPolicy{PrereleasesAfter: true}, a: repository.Entry{Version: "1.2.0-hotfix.1"}, b: repository.Entry{Version: "1.3.0"}, want: -1, wantOK: true},
+		{name: "metadata ignored", a: repository.Entry{Version: "1.2.3+9"}, b: repository.Entry{Version: "1.2.3+10"}, want: 0, wantOK: true},
+		{name: "metadata compared", policy: SemverPolicy{CompareMetadata: true}, a: repository.Entry{Version: "1.2.3+9"}, b: repository.Entry{Version: "1.2.3+10"}, want: -1, wantOK: true},
+		{name: "lenient fourth segment", policy: SemverPolicy{Lenient: true}, a: repository.Entry{Version: "1.2.3.10"}, b: repository.Entry{Version: "1.2.3.9"}, want: 1, wantOK: true},
+		{name: "lenient capital v", policy: SemverPolicy{Lenient: true}, a: repository.Entry{Version: "V1.2.3"}, b: repository.Entry{Version: "1.2.3"}, want: 0, wantOK: true},
+		{name: "strict capital v", a: repository.Entry{Version: "V1.2.3"}, b: repository.Entry{Version: "1.2.3"}, want: -1, wantOK: true},
+		{name: "semver newer than other", a: repository.Entry{Version: "1.0.0"}, b: repository.Entry{Version: "latest"}, want: 1, wantOK: true},
+		{name: "identical other", a: repository.Entry{Version: "nightly"}, b: repository.Entry{Version: "nightly"}, want: 0, wantOK: true},
+		{name: "other without ordering", a: repository.Entry{Version: "r10"}, b: repository.Entry{Version: "r9"}, want: 0, wantOK: false},
+		{name: "other lenient", a: repository.Entry{Version: "r10"}, b: repository.Entry{Version: "r9"}, ordering: OrderingLenient, want: 1, wantOK: true},
+		{name: "other lexical", a: repository.Entry{Version: "r10"}, b: repository.Entry{Version: "r9"}, ordering: OrderingLexical, want: -1, wantOK: true},
+		{name: "other created", a: repository.Entry{Version: "r10", Created: older}, b: repository.Entry{Version: "r9", Created: newer}, ordering: OrderingCreated, want: -1, wantOK: true},
+		{name: "other created without date", a: repository.Entry{Version: "r10"}, b: repository.Entry{Version: "r9", Created: newer}, ordering: OrderingCreated, want: 1, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.policy.Compare(tt.a, tt.b, tt.ordering)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("Compare(%q, %q, %q) = %d, %t, want %d, %t", tt.a.Version, tt.b.Version, tt.ordering, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSemverPolicyDelta(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          SemverPolicy
+		current, latest string
+		want            *Delta
+	}{
+		{name: "major", current: "1.2.3", latest: "2.0.1", want: &Delta{Major: 1, Patch: 1}},
+		{name: "minor", current: "1.2.3", latest: "1.4.2", want: &Delta{Minor: 2, Patch: 2}},
+		{name: "patch", current: "1.2.3", latest: "1.2.5", want: &Delta{Patch: 2}},
+		{name: "prerelease of same version", current: "1.2.3-rc.1", latest: "1.2.3", want: &Delta{}},
+		{name: "up to date", current: "1.2.3", latest: "1.2.3", want: &Delta{}},
+		{name: "ahead", current: "2.0.0", latest: "1.9.0", want: &Delta{}},
+		{name: "not semver", current: "latest", latest: "1.0.0", want: nil},
+		{name: "strict capital v", current: "V1.2.3", latest: "1.3.0", want: nil},
+		{name: "lenient capital v", policy: SemverPolicy{Lenient: true}, current: "V1.2.3", latest: "1.3.0", want: &Delta{Minor: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.Delta(tt.current, tt.latest)
+			switch {
+			case got == nil && tt.want == nil:
+			case got == nil || tt.want == nil || *got != *tt.want:
+				t.Errorf("Delta(%q, %q) = %+v, want %+v", tt.current, tt.latest, got, tt.want)
+			}
+		})
+	}
+}
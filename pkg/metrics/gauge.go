@@ -0,0 +1,76 @@
+// Package metrics provides Prometheus collectors for results that can
+// disappear, such as charts of deleted Applications
+package metrics
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ExpiringGaugeVec wraps a GaugeVec with expiration logic
+type ExpiringGaugeVec struct {
+	gauge   *prometheus.GaugeVec
+	metrics map[string]struct {
+		lastSet time.Time
+	}
+	mu  sync.Mutex
+	ttl time.Duration
+}
+
+// minimumTTL extends the ttl of every ExpiringGaugeVec, see SetMinimumTTL
+var minimumTTL atomic.Int64
+
+// SetMinimumTTL keeps the series of every ExpiringGaugeVec for at least ttl,
+// for results set less often than the ttl the gauges were created with
+func SetMinimumTTL(ttl time.Duration) {
+	minimumTTL.Store(int64(ttl))
+}
+
+// NewExpiringGaugeVec returns a GaugeVec whose series are removed when not
+// set for ttl
+func NewExpiringGaugeVec(gaugeOpts prometheus.GaugeOpts, labelNames []string, ttl time.Duration) *ExpiringGaugeVec {
+	gauge := prometheus.NewGaugeVec(gaugeOpts, labelNames)
+	return &ExpiringGaugeVec{
+		gauge:   gauge,
+		metrics: make(map[string]struct{ lastSet time.Time }),
+		ttl:     ttl,
+	}
+}
+
+// WithLabelValues sets a gauge value and tracks its timestamp
+func (e *ExpiringGaugeVec) WithLabelValues(lvs ...string) prometheus.Gauge {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := strings.Join(lvs, "|")
+	e.metrics[key] = struct{ lastSet time.Time }{lastSet: time.Now()}
+	return e.gauge.WithLabelValues(lvs...)
+}
+
+// Collect implements the prometheus.Collector interface
+func (e *ExpiringGaugeVec) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	ttl := max(e.ttl, time.Duration(minimumTTL.Load()))
+	for key, meta := range e.metrics {
+		if now.Sub(meta.lastSet) > ttl {
+			// Remove expired metric
+			lvs := strings.Split(key, "|")
+			e.gauge.DeleteLabelValues(lvs...)
+			delete(e.metrics, key)
+			slog.Debug("Expired metric", "labels", lvs)
+		}
+	}
+	e.gauge.Collect(ch)
+}
+
+// Describe implements the prometheus.Collector interface
+func (e *ExpiringGaugeVec) Describe(ch chan<- *prometheus.Desc) {
+	e.gauge.Describe(ch)
+}
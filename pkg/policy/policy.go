@@ -0,0 +1,148 @@
+// Package policy evaluates CEL rules deciding whether a chart version is
+// accepted as an upgrade, such as production applications only accepting
+// versions published at least two weeks ago
+package policy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/cel-go/cel"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+	"github.com/caseyrobb/helm-version-check/pkg/source"
+)
+
+// Input is what a rule is evaluated against
+type Input struct {
+	// Candidate is the version considered
+	Candidate repository.Entry
+	Chart     string
+	RepoURL   string
+	// Current is the deployed version
+	Current string
+	// Application deploys the chart, empty for manifests
+	Application source.Chart
+	Cluster     string
+	Now         time.Time
+}
+
+// Rule is a compiled CEL expression returning whether a version is accepted
+type Rule struct {
+	expr    string
+	program cel.Program
+}
+
+// env declares the variables rules are evaluated with:
+//
+//   - version: version, major, minor, patch, prerelease, semver, created and
+//     age when the repository publishes dates, annotations and deprecated
+//   - chart: name, repoURL and current, the deployed version
+//   - app: name, namespace, project, labels, cluster, destinationCluster and
+//     destinationNamespace
+//   - now: the time of the check
+var env = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("version", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("chart", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("app", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("now", cel.TimestampType),
+	)
+})
+
+// costLimit bounds the work of evaluating a rule, so a rule comprehending
+// large lists or strings fails instead of stalling checks
+const costLimit = 1_000_000
+
+// Compile parses and type-checks expr, which must return a bool
+func Compile(expr string) (*Rule, error) {
+	e, err := env()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := e.Compile(expr)
+	if err := issues.Err(); err != nil {
+		return nil, err
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, fmt.Errorf("rule must return a bool, got %s", t)
+	}
+	program, err := e.Program(ast, cel.CostLimit(costLimit))
+	if err != nil {
+		return nil, err
+	}
+	return &Rule{expr: expr, program: program}, nil
+}
+
+// String returns the expression of the rule
+func (r *Rule) String() string {
+	return r.expr
+}
+
+// Accepts evaluates the rule against in
+func (r *Rule) Accepts(in Input) (bool, error) {
+	out, _, err := r.program.Eval(in.variables())
+	if err != nil {
+		return false, err
+	}
+	accepted, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("rule returned %v instead of a bool", out.Value())
+	}
+	return accepted, nil
+}
+
+// variables returns the values of the variables of env
+func (in Input) variables() map[string]interface{} {
+	now := in.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	annotations := in.Candidate.Annotations
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	version := map[string]interface{}{
+		"version":     in.Candidate.Version,
+		"semver":      false,
+		"prerelease":  "",
+		"annotations": annotations,
+		"deprecated":  in.Candidate.Deprecated,
+	}
+	if v, err := semver.NewVersion(in.Candidate.Version); err == nil {
+		version["semver"] = true
+		version["major"] = int64(v.Major())
+		version["minor"] = int64(v.Minor())
+		version["patch"] = int64(v.Patch())
+		version["prerelease"] = v.Prerelease()
+	}
+	if created := in.Candidate.Created; !created.IsZero() {
+		version["created"] = created
+		version["age"] = now.Sub(created)
+	}
+
+	labels := in.Application.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return map[string]interface{}{
+		"version": version,
+		"chart": map[string]interface{}{
+			"name":    in.Chart,
+			"repoURL": in.RepoURL,
+			"current": in.Current,
+		},
+		"app": map[string]interface{}{
+			"name":                 in.Application.Application,
+			"namespace":            in.Application.Namespace,
+			"project":              in.Application.Project,
+			"labels":               labels,
+			"cluster":              in.Cluster,
+			"destinationCluster":   in.Application.DestinationCluster,
+			"destinationNamespace": in.Application.DestinationNamespace,
+		},
+		"now": now,
+	}
+}
@@ -0,0 +1,44 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/caseyrobb/helm-version-check/pkg/repository"
+)
+
+func TestRuleAccepts(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    bool
+		wantErr string
+	}{
+		{name: "accepted", expr: `version.major < 2`, want: true},
+		{name: "rejected", expr: `version.major >= 2`, want: false},
+		{name: "missing label", expr: `app.labels["env"] == "prod"`, wantErr: "no such key"},
+		{
+			name:    "over cost limit",
+			expr:    `[1,2,3,4,5,6,7,8,9,10].all(a, [1,2,3,4,5,6,7,8,9,10].all(b, [1,2,3,4,5,6,7,8,9,10].all(c, [1,2,3,4,5,6,7,8,9,10].all(d, [1,2,3,4,5,6,7,8,9,10].all(e, [1,2,3,4,5,6,7,8,9,10].all(f, a + b + c + d + e + f > 0))))))`,
+			wantErr: "cost limit",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatalf("Compile() error = %v", err)
+			}
+			got, err := rule.Accepts(Input{Candidate: repository.Entry{Version: "1.2.3"}})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Accepts() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Accepts() = %t, %v, want %t", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	helmrepo "helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+// LoadIndex decodes the charts of an index.yaml with the types of the Helm
+// SDK, dropping entries without a name, version or URL and sorting versions
+// newest first. Versions are not validated as semver, so that the ordering
+// policies of the checker can apply to the others as well. Other charts are
+// skipped without decoding their versions, which keeps memory low with
+// indexes of thousands of charts. Unlike the helm CLI, unknown fields are
+// accepted.
+func LoadIndex(data []byte, source string, charts []string) (*helmrepo.IndexFile, error) {
+	if len(data) == 0 {
+		return nil, helmrepo.ErrEmptyIndexYaml
+	}
+	// JSON indexes are decoded as is; YAML in flow style may start with { too
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) || !json.Valid(data) {
+		if header, sections, ok := splitEntries(data); ok {
+			data = append(appendLines(nil, header), "entries:\n"...)
+			for _, name := range charts {
+				data = appendLines(data, sections[name])
+				delete(sections, name)
+			}
+		}
+		var err error
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			return nil, err
+		}
+	}
+	var doc struct {
+		APIVersion string                     `json:"apiVersion"`
+		Generated  time.Time                  `json:"generated"`
+		Entries    map[string]json.RawMessage `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.APIVersion == "" {
+		return nil, helmrepo.ErrNoAPIVersion
+	}
+	index := &helmrepo.IndexFile{APIVersion: doc.APIVersion, Generated: doc.Generated, Entries: make(map[string]helmrepo.ChartVersions, len(charts))}
+	for _, name := range charts {
+		raw, ok := doc.Entries[name]
+		if !ok {
+			continue
+		}
+		var versions helmrepo.ChartVersions
+		if err := json.Unmarshal(raw, &versions); err != nil {
+			return nil, fmt.Errorf("chart %s: %w", name, err)
+		}
+		valid := versions[:0]
+		for _, v := range versions {
+			if v == nil {
+				continue
+			}
+			if v.APIVersion == "" {
+				v.APIVersion = chart.APIVersionV1
+			}
+			if err := validateEntry(v); err != nil {
+				slog.Debug("Skipping invalid index entry", "repo_url", source, "chart", name, "version", v.Version, "error", err)
+				continue
+			}
+			valid = append(valid, v)
+		}
+		index.Entries[name] = valid
+	}
+	index.SortEntries()
+	return index, nil
+}
+
+// validateEntry checks what is needed to report and download a version
+func validateEntry(v *helmrepo.ChartVersion) error {
+	if v.Metadata == nil || v.Name == "" {
+		return errors.New("name is required")
+	}
+	if v.Version == "" {
+		return errors.New("version is required")
+	}
+	if len(v.URLs) == 0 {
+		return errors.New("urls are required")
+	}
+	return nil
+}
+
+// splitEntries cuts a block style index.yaml, as written by helm and
+// chart servers, into the lines outside entries and the lines of each chart,
+// its key included, so only the charts needed are parsed. It reports false
+// for layouts it does not recognize, which are parsed whole.
+func splitEntries(data []byte) ([]byte, map[string][]byte, bool) {
+	var header []byte
+	sections := make(map[string][]byte)
+	inEntries := false
+	indent := -1
+	current, start := "", 0
+	closeSection := func(end int) {
+		if current != "" {
+			sections[current] = data[start:end]
+			current = ""
+		}
+	}
+	for offset := 0; offset < len(data); {
+		next := len(data)
+		if i := bytes.IndexByte(data[offset:], '\n'); i >= 0 {
+			next = offset + i + 1
+		}
+		line := data[offset:next]
+		trimmed := bytes.TrimLeft(line, " ")
+		content := bytes.TrimSpace(trimmed)
+		lineIndent := len(line) - len(trimmed)
+		switch {
+		case len(content) == 0 || content[0] == '#':
+			if !inEntries {
+				header = append(header, line...)
+			}
+		case lineIndent == 0:
+			closeSection(offset)
+			inEntries = string(content) == "entries:"
+			if !inEntries {
+				if bytes.HasPrefix(content, []byte("entries:")) {
+					return nil, nil, false
+				}
+				header = append(header, line...)
+			}
+		case !inEntries:
+			header = append(header, line...)
+		case (indent < 0 || lineIndent == indent) && !bytes.HasPrefix(content, []byte("-")):
+			// A chart name, its versions following at the same indent or deeper
+			key, ok := bytes.CutSuffix(content, []byte(":"))
+			if !ok || bytes.ContainsAny(key, ":{[") {
+				return nil, nil, false
+			}
+			closeSection(offset)
+			indent = lineIndent
+			current, start = strings.Trim(string(key), `"'`), offset
+		case current == "" || lineIndent < indent:
+			return nil, nil, false
+		}
+		offset = next
+	}
+	closeSection(len(data))
+	return header, sections, inEntries || len(sections) > 0
+}
+
+// appendLines appends lines cut from an index to dst, ending them with a
+// newline when the index did not, so the next ones start a line of their own
+func appendLines(dst, lines []byte) []byte {
+	dst = append(dst, lines...)
+	if len(lines) > 0 && lines[len(lines)-1] != '\n' {
+		dst = append(dst, '\n')
+	}
+	return dst
+}
+
+// Entries converts the versions of a chart in an index
+func Entries(versions helmrepo.ChartVersions) []Entry {
+	entries := make([]Entry, 0, len(versions))
+	for _, v := range versions {
+		entries = append(entries, Entry{
+			Version:     v.Version,
+			URLs:        v.URLs,
+			Digest:      v.Digest,
+			Home:        v.Home,
+			Sources:     v.Sources,
+			Annotations: v.Annotations,
+			Created:     v.Created,
+			Deprecated:  v.Deprecated,
+		})
+	}
+	return entries
+}
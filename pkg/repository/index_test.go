@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	helmrepo "helm.sh/helm/v3/pkg/repo"
+)
+
+const testIndex = `apiVersion: v1
+generated: "2024-01-01T00:00:00Z"
+entries:
+  nginx:
+  - name: nginx
+    version: 1.2.0
+    urls: [https://charts.example.com/nginx-1.2.0.tgz]
+  - name: nginx
+    version: 1.10.0
+    urls: [https://charts.example.com/nginx-1.10.0.tgz]
+    unknownField: accepted
+  - name: nginx
+    version: nightly
+    urls: [https://charts.example.com/nginx-nightly.tgz]
+  - name: nginx
+    version: 1.11.0
+  - name: nginx
+    urls: [https://charts.example.com/nginx.tgz]
+  redis:
+  - name: redis
+    version: 7.0.0
+    urls: [https://charts.example.com/redis-7.0.0.tgz]
+`
+
+func TestLoadIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		charts  []string
+		want    map[string][]string
+		wantErr error
+	}{
+		{
+			name:   "selected chart",
+			data:   testIndex,
+			charts: []string{"nginx"},
+			want:   map[string][]string{"nginx": {"1.10.0", "1.2.0", "nightly"}},
+		},
+		{
+			name:   "several charts",
+			data:   testIndex,
+			charts: []string{"nginx", "redis"},
+			want:   map[string][]string{"nginx": {"1.10.0", "1.2.0", "nightly"}, "redis": {"7.0.0"}},
+		},
+		{
+			name:   "missing chart",
+			data:   testIndex,
+			charts: []string{"postgresql"},
+			want:   map[string][]string{},
+		},
+		{
+			name:   "header last without final newline",
+			data:   "entries:\n  redis:\n  - name: redis\n    version: 7.0.0\n    urls: [https://charts.example.com/redis-7.0.0.tgz]\napiVersion: v1",
+			charts: []string{"redis"},
+			want:   map[string][]string{"redis": {"7.0.0"}},
+		},
+		{
+			name:   "last chart without final newline",
+			data:   strings.TrimSuffix(testIndex, "\n"),
+			charts: []string{"redis", "nginx"},
+			want:   map[string][]string{"nginx": {"1.10.0", "1.2.0", "nightly"}, "redis": {"7.0.0"}},
+		},
+		{
+			name:   "flow style",
+			data:   `{apiVersion: v1, entries: {redis: [{name: redis, version: 7.0.0, urls: [https://charts.example.com/redis-7.0.0.tgz]}]}}`,
+			charts: []string{"redis"},
+			want:   map[string][]string{"redis": {"7.0.0"}},
+		},
+		{
+			name:   "json",
+			data:   `{"apiVersion": "v1", "entries": {"redis": [{"name": "redis", "version": "7.0.0", "urls": ["https://charts.example.com/redis-7.0.0.tgz"]}]}}`,
+			charts: []string{"redis"},
+			want:   map[string][]string{"redis": {"7.0.0"}},
+		},
+		{
+			name:    "empty",
+			data:    "",
+			charts:  []string{"nginx"},
+			wantErr: helmrepo.ErrEmptyIndexYaml,
+		},
+		{
+			name:    "no api version",
+			data:    "entries: {}\n",
+			charts:  []string{"nginx"},
+			wantErr: helmrepo.ErrNoAPIVersion,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := LoadIndex([]byte(tt.data), "https://charts.example.com", tt.charts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("LoadIndex() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadIndex() error = %v", err)
+			}
+			got := make(map[string][]string, len(index.Entries))
+			for name, versions := range index.Entries {
+				for _, v := range versions {
+					got[name] = append(got[name], v.Version)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("LoadIndex() charts = %v, want %v", got, tt.want)
+			}
+			for name, want := range tt.want {
+				if !slices.Equal(got[name], want) {
+					t.Errorf("LoadIndex() versions of %s = %v, want %v", name, got[name], want)
+				}
+			}
+		})
+	}
+}
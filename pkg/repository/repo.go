@@ -0,0 +1,56 @@
+// Package repository reads the versions of charts published in Helm repositories
+package repository
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Entry is a single chart version listed in a repository index.yaml
+type Entry struct {
+	Version     string            `yaml:"version"`
+	URLs        []string          `yaml:"urls"`
+	Digest      string            `yaml:"digest"`
+	Home        string            `yaml:"home"`
+	Sources     []string          `yaml:"sources"`
+	Annotations map[string]string `yaml:"annotations"`
+	Created     time.Time         `yaml:"created"`
+	Deprecated  bool              `yaml:"deprecated"`
+	// Scan is the vulnerability scan reported by registries such as Harbor
+	Scan *ScanSummary `yaml:"-"`
+}
+
+// ScanSummary is the vulnerability scan result Harbor reports for a version
+type ScanSummary struct {
+	Status   string `json:"status"`
+	Severity string `json:"severity,omitempty"`
+	Total    int    `json:"total"`
+	Fixable  int    `json:"fixable"`
+}
+
+// Resolver lists the published versions of a chart
+type Resolver interface {
+	Versions(ctx context.Context, repoURL, chart string) ([]Entry, error)
+}
+
+// ResolverFunc adapts a function to a Resolver
+type ResolverFunc func(ctx context.Context, repoURL, chart string) ([]Entry, error)
+
+// Versions calls f
+func (f ResolverFunc) Versions(ctx context.Context, repoURL, chart string) ([]Entry, error) {
+	return f(ctx, repoURL, chart)
+}
+
+// Authenticator sets the credentials of a request to a repository
+type Authenticator interface {
+	Authenticate(req *http.Request, repoURL string) error
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator
+type AuthenticatorFunc func(req *http.Request, repoURL string) error
+
+// Authenticate calls f
+func (f AuthenticatorFunc) Authenticate(req *http.Request, repoURL string) error {
+	return f(req, repoURL)
+}
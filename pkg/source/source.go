@@ -0,0 +1,98 @@
+// Package source defines the providers that list the charts deployed by
+// tools such as Argo CD, so more of them can be checked without changes to
+// the check loop
+package source
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Chart is a chart deployed by an application
+type Chart struct {
+	Application string
+	// Namespace, Labels and Project, such as the Argo CD project, are those
+	// of the application resource, if any
+	Namespace            string
+	Labels               map[string]string
+	Project              string
+	DestinationCluster   string
+	DestinationNamespace string
+	// Chart is empty when the source deploys a chart from a path, such as in
+	// a git repository
+	Chart   string
+	RepoURL string
+	// Version is the deployed version or a constraint such as 1.x
+	Version string
+	// Path locates the source in the application resource as a JSON
+	// pointer, such as /spec/sources/1, for updates of its version
+	Path string
+	// SourceID tells sources of an application apart, such as two deploying
+	// the same chart from different repositories; empty for applications
+	// with a single source
+	SourceID string
+	// Source is the source as the provider describes it, such as an Argo CD
+	// Application source with its Helm values; nil when it has no more
+	Source map[string]interface{}
+}
+
+// Provider lists the charts deployed by a tool, those of an application
+// following each other
+type Provider interface {
+	ListChartsInUse(ctx context.Context) ([]Chart, error)
+}
+
+// ApplicationWalker is implemented by providers that list applications in
+// pages, so the charts of one page are checked before the next is listed
+type ApplicationWalker interface {
+	Provider
+	// WalkApplications calls fn with the name and the charts of each
+	// application in turn, including those without charts
+	WalkApplications(ctx context.Context, fn func(name string, charts []Chart) error) error
+}
+
+// ProviderFunc adapts a function to a Provider
+type ProviderFunc func(ctx context.Context) ([]Chart, error)
+
+// ListChartsInUse calls f
+func (f ProviderFunc) ListChartsInUse(ctx context.Context) ([]Chart, error) {
+	return f(ctx)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{}
+)
+
+// Register adds a provider whose charts are checked every cycle along with
+// the Argo CD Applications. It panics when name is already registered.
+func Register(name string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := providers[name]; ok {
+		panic(fmt.Sprintf("source provider %s registered twice", name))
+	}
+	providers[name] = p
+}
+
+// Registered returns the names of the registered providers, sorted
+func Registered() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Lookup returns the provider registered as name
+func Lookup(name string) (Provider, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}